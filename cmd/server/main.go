@@ -11,6 +11,7 @@ import (
 	"worktime-service/internal/attendance"
 	"worktime-service/internal/attendance/usecase"
 	"worktime-service/internal/gateway"
+	"worktime-service/internal/holiday"
 	"worktime-service/internal/leave"
 	"worktime-service/internal/user"
 	"worktime-service/pkg/consul"
@@ -19,6 +20,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
@@ -74,23 +76,62 @@ func main() {
 	leaveRequestCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_requests")
 	dailyLeaveSlotsCollection := mongoClient.Database(cfg.MongoDB).Collection("daily_leave_slots")
 	leaveBalanceCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_balance")
+	leaveTransactionCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_transactions")
+	leaveTypeCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_types")
+	capacityRuleCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_capacity_rules")
+	blackoutCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_blackouts")
+	capacityPoolCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_capacity_pools")
+	calendarFeedCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_calendar_feeds")
+	entitlementCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_entitlement_tables")
+	employmentCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_employment_start_dates")
+	compOffCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_comp_off_claims")
+	auditCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_audit_logs")
+	closureCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_closures")
+	notificationCollection := mongoClient.Database(cfg.MongoDB).Collection("leave_notifications")
+
+	attachmentBucket, err := gridfs.NewBucket(mongoClient.Database(cfg.MongoDB), options.GridFSBucket().SetName("leave_attachments"))
+	if err != nil {
+		panic(err)
+	}
+	holidayCollection := mongoClient.Database(cfg.MongoDB).Collection("holidays")
+	holidaySettingCollection := mongoClient.Database(cfg.MongoDB).Collection("holiday_settings")
 	attendanceCollection := mongoClient.Database(cfg.MongoDB).Collection("attendance_logs")
 	attendanceDailyCollection := mongoClient.Database(cfg.MongoDB).Collection("attendances_daily")
 	attendanceDailyStudentCollection := mongoClient.Database(cfg.MongoDB).Collection("attendances_daily_students")
 	userService := user.NewUserService(consulClient)
+	holidayRepository := holiday.NewHolidayRepository(holidayCollection, holidaySettingCollection)
+	holidayService := holiday.NewHolidayService(holidayRepository, userService)
+	holidayHandler := holiday.NewHolidayHandler(holidayService)
+
 	attendanceRepository := attendance.NewAttendanceRepository(attendanceCollection, attendanceDailyCollection, attendanceDailyStudentCollection)
 	getStudentTemperatureChartUsecase := usecase.NewGetStudentTemperatureChartUsecase(attendanceRepository, userService, termGateway)
-	attendanceService := attendance.NewAttendanceService(attendanceRepository, userService, getStudentTemperatureChartUsecase)
+	attendanceService := attendance.NewAttendanceService(attendanceRepository, userService, getStudentTemperatureChartUsecase, holidayService)
 	attendanceHandler := attendance.NewAttendanceHandler(attendanceService)
 
-	leaveRepository := leave.NewLeaveRepository(leaveRequestCollection, settingCollection, dailyLeaveSlotsCollection, leaveBalanceCollection)
-	leaveService := leave.NewLeaveService(leaveRepository, userService)
+	leaveRepository := leave.NewLeaveRepository(leaveRequestCollection, settingCollection, dailyLeaveSlotsCollection, leaveBalanceCollection, leaveTransactionCollection, leaveTypeCollection, capacityRuleCollection, blackoutCollection, capacityPoolCollection, calendarFeedCollection, entitlementCollection, employmentCollection, compOffCollection, auditCollection, closureCollection, notificationCollection, attachmentBucket, holidayService)
+	// Leave data written before it was split by organization has no
+	// organization_id; it is handed to this organization on startup.
+	if organizationID := os.Getenv("LEAVE_LEGACY_ORGANIZATION_ID"); organizationID != "" {
+		if err := leaveRepository.AssignLegacyOrganization(context.Background(), organizationID); err != nil {
+			log.Printf("Warning: Error assigning legacy leave data: %v", err)
+		}
+	}
+	// Slot booking relies on the unique index on organization and date, so
+	// the service must not run without it.
+	if err := leaveRepository.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create leave indexes: %v", err)
+	}
+	leaveService := leave.NewLeaveService(leaveRepository, userService, holidayService, termGateway, attendanceRepository)
+	holidayService.SetHolidayListener(leaveService)
 	leaveHandler := leave.NewLeaveHandler(leaveService)
 
+	go leave.StartLeaveBalanceCron(context.Background(), leaveService, 24*time.Hour)
+
 	r := gin.Default()
 
 	leave.RegisterRoutes(r, leaveHandler)
 	attendance.RegisterRoutes(r, attendanceHandler)
+	holiday.RegisterRoutes(r, holidayHandler)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -7,6 +7,7 @@ import (
 const (
 	ErrInvalidOperation = "ERR_INVALID_OPERATION"
 	ErrInvalidRequest   = "ERR_INVALID_REQUEST"
+	ErrPolicyViolation  = "ERR_POLICY_VIOLATION"
 )
 
 type APIResponse struct {
@@ -31,4 +32,15 @@ func SendError( c* gin.Context, statusCode int, err error, errorCode string) {
 		Error: err.Error(),
 		ErrorCode: errorCode,
 	})
-}
\ No newline at end of file
+}
+
+// SendErrorWithData is SendError plus a payload, for errors the client needs
+// to act on rather than only display.
+func SendErrorWithData(c *gin.Context, statusCode int, err error, errorCode string, data interface{}) {
+	c.JSON(statusCode, APIResponse{
+		StatusCode: statusCode,
+		Error:      err.Error(),
+		ErrorCode:  errorCode,
+		Data:       data,
+	})
+}
@@ -25,6 +25,7 @@ type AttendanceRepository interface {
 	GetAllAttendances(c context.Context, userID string, date *time.Time, page int, limit int) ([]*DailyAttendance, int64, error)
 	GetStudentTemperature(c context.Context, studentID string) ([]*shared.AttendanceStudent, error)
 	GetStudentAttendanceInDateRange(c context.Context, studentID string, startDate time.Time, endDate time.Time) ([]*shared.AttendanceStudent, error)
+	GetOvertimeDays(c context.Context, userID string, startDate time.Time, endDate time.Time) ([]*shared.OvertimeDay, error)
 }
 
 type attendanceRepository struct {
@@ -297,3 +298,45 @@ func (r *attendanceRepository) GetStudentAttendanceInDateRange(c context.Context
 
 	return attendanceStudents, nil
 }
+
+// GetOvertimeDays returns the checked-out days in the range on which the
+// user worked more than a standard day.
+func (r *attendanceRepository) GetOvertimeDays(c context.Context, userID string, startDate time.Time, endDate time.Time) ([]*shared.OvertimeDay, error) {
+
+	var dailyAttendances []*DailyAttendance
+
+	filter := bson.M{
+		"user_id":             userID,
+		"date":                bson.M{"$gte": startDate, "$lte": endDate},
+		"check_out_time":      bson.M{"$ne": nil},
+		"total_working_hours": bson.M{"$gt": shared.StandardWorkingHours},
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"date": 1})
+
+	cursor, err := r.collectionDailyAttendance.Find(c, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(c)
+
+	err = cursor.All(c, &dailyAttendances)
+	if err != nil {
+		return nil, err
+	}
+
+	overtimeDays := make([]*shared.OvertimeDay, 0, len(dailyAttendances))
+	for _, dailyAttendance := range dailyAttendances {
+		overtimeDays = append(overtimeDays, &shared.OvertimeDay{
+			AttendanceID:      dailyAttendance.ID,
+			UserID:            dailyAttendance.UserID,
+			Date:              dailyAttendance.Date,
+			TotalWorkingHours: dailyAttendance.TotalWorkingHours,
+			OvertimeHours:     dailyAttendance.TotalWorkingHours - shared.StandardWorkingHours,
+		})
+	}
+
+	return overtimeDays, nil
+
+}
@@ -29,13 +29,15 @@ type attendanceService struct {
 	repo                              AttendanceRepository
 	userService                       user.UserService
 	getStudentTemperatureChartUsecase attendance.GetStudentTemperatureChartUsecase
+	holidayCalendar                   shared.HolidayCalendar
 }
 
-func NewAttendanceService(repo AttendanceRepository, userService user.UserService, getStudentTemperatureChartUsecase attendance.GetStudentTemperatureChartUsecase) AttendanceService {
+func NewAttendanceService(repo AttendanceRepository, userService user.UserService, getStudentTemperatureChartUsecase attendance.GetStudentTemperatureChartUsecase, holidayCalendar shared.HolidayCalendar) AttendanceService {
 	return &attendanceService{
 		repo:                              repo,
 		userService:                       userService,
 		getStudentTemperatureChartUsecase: getStudentTemperatureChartUsecase,
+		holidayCalendar:                   holidayCalendar,
 	}
 }
 
@@ -198,7 +200,9 @@ func (s *attendanceService) GetMyAttendance(c context.Context, userID string, mo
 		return nil, err
 	}
 
-	data, err := s.getMonthlyAttandance(dailyAttendances, monthInt, yearInt)
+	holidays := s.getHolidays(c, firstDay, lastDay)
+
+	data, err := s.getMonthlyAttandance(dailyAttendances, holidays, monthInt, yearInt)
 	if err != nil {
 		return nil, err
 	}
@@ -207,7 +211,31 @@ func (s *attendanceService) GetMyAttendance(c context.Context, userID string, mo
 
 }
 
-func (s *attendanceService) getMonthlyAttandance(myAttedances []*DailyAttendance, monthInt int, yearInt int) ([]*DailyAttendance, error) {
+// getHolidays looks up the public holidays of the caller's organization. The
+// monthly view still works without them, falling back to Sundays only.
+func (s *attendanceService) getHolidays(c context.Context, startDate time.Time, endDate time.Time) map[string]string {
+
+	if s.holidayCalendar == nil {
+		return nil
+	}
+
+	currentUser, err := s.userService.GetCurrentUser(c)
+	if err != nil {
+		log.Printf("get current user for holidays: %v", err)
+		return nil
+	}
+
+	holidays, err := s.holidayCalendar.GetHolidaysInRange(c, currentUser.OrganizationIdActive, startDate, endDate)
+	if err != nil {
+		log.Printf("get holidays: %v", err)
+		return nil
+	}
+
+	return holidays
+
+}
+
+func (s *attendanceService) getMonthlyAttandance(myAttedances []*DailyAttendance, holidays map[string]string, monthInt int, yearInt int) ([]*DailyAttendance, error) {
 
 	firstDay := time.Date(yearInt, time.Month(monthInt), 1, 0, 0, 0, 0, time.UTC)
 	lastDay := firstDay.AddDate(0, 1, -1)
@@ -227,7 +255,7 @@ func (s *attendanceService) getMonthlyAttandance(myAttedances []*DailyAttendance
 		if item, ok := attandanceMap[dataKey]; ok {
 			dataMonthly = append(dataMonthly, item)
 		} else {
-			if day.Weekday() == time.Sunday {
+			if _, ok := holidays[dataKey]; ok || day.Weekday() == time.Sunday {
 				status = "holiday"
 			} else {
 				status = "absent"
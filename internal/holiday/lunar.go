@@ -0,0 +1,223 @@
+package holiday
+
+import (
+	"math"
+	"time"
+)
+
+// Lunar dates follow the Vietnamese calendar, which is computed for the
+// UTC+7 meridian. The astronomy below is the usual new moon / solar term
+// approximation used by Vietnamese calendar converters and is accurate for
+// the years a school schedule cares about.
+const vietnamTimeZone = 7.0
+
+const (
+	lunarMonthDays = 29.530588853
+	lunarEpoch     = 2415021.076998695
+)
+
+// julianDay converts a UTC calendar date to its Julian day number.
+func julianDay(date time.Time) int {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	return int(day.Unix()/86400) + 2440588
+}
+
+func julianDayToDate(jd int) time.Time {
+	return time.Unix(int64(jd-2440588)*86400, 0).UTC()
+}
+
+func newMoon(k float64) float64 {
+
+	t := k / 1236.85
+	t2 := t * t
+	t3 := t2 * t
+	dr := math.Pi / 180
+
+	jd1 := 2415020.75933 + 29.53058868*k + 0.0001178*t2 - 0.000000155*t3
+	jd1 += 0.00033 * math.Sin((166.56+132.87*t-0.009173*t2)*dr)
+
+	m := 359.2242 + 29.10535608*k - 0.0000333*t2 - 0.00000347*t3
+	mpr := 306.0253 + 385.81691806*k + 0.0107306*t2 + 0.00001236*t3
+	f := 21.2964 + 390.67050646*k - 0.0016528*t2 - 0.00000239*t3
+
+	c1 := (0.1734-0.000393*t)*math.Sin(m*dr) + 0.0021*math.Sin(2*dr*m)
+	c1 = c1 - 0.4068*math.Sin(mpr*dr) + 0.0161*math.Sin(dr*2*mpr)
+	c1 = c1 - 0.0004*math.Sin(dr*3*mpr)
+	c1 = c1 + 0.0104*math.Sin(dr*2*f) - 0.0051*math.Sin(dr*(m+mpr))
+	c1 = c1 - 0.0074*math.Sin(dr*(m-mpr)) + 0.0004*math.Sin(dr*(2*f+m))
+	c1 = c1 - 0.0004*math.Sin(dr*(2*f-m)) - 0.0006*math.Sin(dr*(2*f+mpr))
+	c1 = c1 + 0.0010*math.Sin(dr*(2*f-mpr)) + 0.0005*math.Sin(dr*(2*mpr+m))
+
+	var deltaT float64
+	if t < -11 {
+		deltaT = 0.001 + 0.000839*t + 0.0002261*t2 - 0.00000845*t3 - 0.000000081*t*t3
+	} else {
+		deltaT = -0.000278 + 0.000265*t + 0.000262*t2
+	}
+
+	return jd1 + c1 - deltaT
+
+}
+
+// newMoonDay is the local Julian day of the k-th new moon after 1900-01-01.
+func newMoonDay(k int) int {
+	return int(math.Floor(newMoon(float64(k)) + 0.5 + vietnamTimeZone/24))
+}
+
+// sunLongitudeSector returns which of the twelve 30° solar sectors the sun
+// is in at the start of the given local day.
+func sunLongitudeSector(jd int) int {
+
+	t := (float64(jd) - 0.5 - vietnamTimeZone/24 - 2451545.0) / 36525
+	t2 := t * t
+	dr := math.Pi / 180
+
+	m := 357.52910 + 35999.05030*t - 0.0001559*t2 - 0.00000048*t*t2
+	l0 := 280.46645 + 36000.76983*t + 0.0003032*t2
+
+	dl := (1.914600 - 0.004817*t - 0.000014*t2) * math.Sin(dr*m)
+	dl += (0.019993-0.000101*t)*math.Sin(dr*2*m) + 0.000290*math.Sin(dr*3*m)
+
+	l := (l0 + dl) * dr
+	l = l - math.Pi*2*math.Floor(l/(math.Pi*2))
+
+	return int(math.Floor(l / math.Pi * 6))
+
+}
+
+// lunarMonth11 finds the first day of the lunar month containing the
+// winter solstice of the given year.
+func lunarMonth11(year int) int {
+
+	off := julianDay(time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC)) - 2415021
+	k := int(math.Floor(float64(off) / lunarMonthDays))
+
+	nm := newMoonDay(k)
+	if sunLongitudeSector(nm) >= 9 {
+		nm = newMoonDay(k - 1)
+	}
+
+	return nm
+
+}
+
+// leapMonthOffset finds the leap month of a 13-month lunar year: the first
+// month after month 11 without a solar term.
+func leapMonthOffset(a11 int) int {
+
+	k := int(math.Floor((float64(a11)-lunarEpoch)/lunarMonthDays + 0.5))
+
+	i := 1
+	arc := sunLongitudeSector(newMoonDay(k + i))
+
+	for {
+		last := arc
+		i++
+		arc = sunLongitudeSector(newMoonDay(k + i))
+		if arc == last || i >= 14 {
+			break
+		}
+	}
+
+	return i - 1
+
+}
+
+// lunarToSolar converts a day of a regular (non-leap) Vietnamese lunar
+// month to its solar date.
+func lunarToSolar(lunarDay int, lunarMonth int, lunarYear int) time.Time {
+
+	var a11, b11 int
+	if lunarMonth < 11 {
+		a11 = lunarMonth11(lunarYear - 1)
+		b11 = lunarMonth11(lunarYear)
+	} else {
+		a11 = lunarMonth11(lunarYear)
+		b11 = lunarMonth11(lunarYear + 1)
+	}
+
+	k := int(math.Floor(0.5 + (float64(a11)-lunarEpoch)/lunarMonthDays))
+
+	off := lunarMonth - 11
+	if off < 0 {
+		off += 12
+	}
+
+	if b11-a11 > 365 {
+		if off >= leapMonthOffset(a11) {
+			off++
+		}
+	}
+
+	monthStart := newMoonDay(k + off)
+
+	return julianDayToDate(monthStart + lunarDay - 1)
+
+}
+
+// lunarHolidays lists the public holidays of the given solar year that
+// follow the lunar calendar: Tết Nguyên Đán (from lunar New Year's Eve
+// through the fourth day of the first month) and Giỗ Tổ Hùng Vương on the
+// tenth day of the third month.
+func lunarHolidays(year int) []*HolidayDate {
+
+	var dates []*HolidayDate
+
+	newYear := lunarToSolar(1, 1, year)
+	for day := newYear.AddDate(0, 0, -1); !day.After(newYear.AddDate(0, 0, 3)); day = day.AddDate(0, 0, 1) {
+		dates = append(dates, &HolidayDate{
+			Date:      day,
+			Name:      "Tết Nguyên Đán",
+			Recurring: true,
+			BuiltIn:   true,
+		})
+	}
+
+	dates = append(dates, &HolidayDate{
+		Date:      lunarToSolar(10, 3, year),
+		Name:      "Giỗ Tổ Hùng Vương",
+		Recurring: true,
+		BuiltIn:   true,
+	})
+
+	return dates
+
+}
+
+// observedDays applies the Labor Code rule that a public holiday falling on
+// the weekly rest day is given back on the next working day. Each holiday
+// that lands on a weekend yields one substitute day after the weekend that
+// is not itself a holiday.
+func observedDays(holidays []*HolidayDate, taken map[string]bool) []*HolidayDate {
+
+	var observed []*HolidayDate
+
+	for _, item := range holidays {
+		if !isWeekend(item.Date) {
+			continue
+		}
+
+		day := item.Date.AddDate(0, 0, 1)
+		for isWeekend(day) || taken[day.Format("2006-01-02")] {
+			day = day.AddDate(0, 0, 1)
+		}
+
+		taken[day.Format("2006-01-02")] = true
+
+		observed = append(observed, &HolidayDate{
+			Date:      day,
+			Name:      item.Name + " (observed)",
+			HolidayID: item.HolidayID,
+			Recurring: item.Recurring,
+			BuiltIn:   item.BuiltIn,
+			Observed:  true,
+		})
+	}
+
+	return observed
+
+}
+
+func isWeekend(day time.Time) bool {
+	return day.Weekday() == time.Saturday || day.Weekday() == time.Sunday
+}
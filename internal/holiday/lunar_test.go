@@ -0,0 +1,134 @@
+package holiday
+
+import (
+	"testing"
+	"time"
+)
+
+func date(value string) time.Time {
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		panic(err)
+	}
+	return day
+}
+
+func TestLunarToSolar(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		day   int
+		month int
+		year  int
+		want  string
+	}{
+		{name: "Tết 2020", day: 1, month: 1, year: 2020, want: "2020-01-25"},
+		{name: "Hùng Vương 2020, before the leap fourth month", day: 10, month: 3, year: 2020, want: "2020-04-02"},
+		{name: "Tết 2023", day: 1, month: 1, year: 2023, want: "2023-01-22"},
+		{name: "Hùng Vương 2023, after the leap second month", day: 10, month: 3, year: 2023, want: "2023-04-29"},
+		{name: "Tết 2024", day: 1, month: 1, year: 2024, want: "2024-02-10"},
+		{name: "Hùng Vương 2024", day: 10, month: 3, year: 2024, want: "2024-04-18"},
+		{name: "Tết 2025", day: 1, month: 1, year: 2025, want: "2025-01-29"},
+		{name: "Mid-autumn 2025, after the leap sixth month", day: 15, month: 8, year: 2025, want: "2025-10-06"},
+		{name: "Tết 2026", day: 1, month: 1, year: 2026, want: "2026-02-17"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lunarToSolar(tt.day, tt.month, tt.year)
+			if got.Format("2006-01-02") != tt.want {
+				t.Fatalf("lunarToSolar(%d, %d, %d) = %s, want %s", tt.day, tt.month, tt.year, got.Format("2006-01-02"), tt.want)
+			}
+		})
+	}
+
+}
+
+func TestLunarHolidays(t *testing.T) {
+
+	dates := lunarHolidays(2024)
+
+	want := []string{"2024-02-09", "2024-02-10", "2024-02-11", "2024-02-12", "2024-02-13", "2024-04-18"}
+	if len(dates) != len(want) {
+		t.Fatalf("got %d holidays, want %d", len(dates), len(want))
+	}
+
+	for i, item := range dates {
+		if item.Date.Format("2006-01-02") != want[i] {
+			t.Errorf("holiday %d = %s, want %s", i, item.Date.Format("2006-01-02"), want[i])
+		}
+		if !item.BuiltIn {
+			t.Errorf("holiday %s is not marked built in", want[i])
+		}
+	}
+
+}
+
+func TestObservedDays(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		holidays []string
+		taken    []string
+		want     []string
+	}{
+		{
+			name:     "weekday holiday has no substitute",
+			holidays: []string{"2024-04-30"},
+			want:     nil,
+		},
+		{
+			name:     "saturday holiday moves to monday",
+			holidays: []string{"2026-05-30"},
+			want:     []string{"2026-06-01"},
+		},
+		{
+			name:     "sunday holiday moves to monday",
+			holidays: []string{"2025-08-31"},
+			want:     []string{"2025-09-01"},
+		},
+		{
+			name:     "org holidays on both weekend days get two substitutes",
+			holidays: []string{"2027-05-01", "2027-05-02"},
+			want:     []string{"2027-05-03", "2027-05-04"},
+		},
+		{
+			name:     "substitute skips a day that is already a holiday",
+			holidays: []string{"2023-04-29", "2023-04-30", "2023-05-01"},
+			taken:    []string{"2023-04-29", "2023-04-30", "2023-05-01"},
+			want:     []string{"2023-05-02", "2023-05-03"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			taken := make(map[string]bool)
+			for _, day := range tt.taken {
+				taken[day] = true
+			}
+
+			var holidays []*HolidayDate
+			for _, day := range tt.holidays {
+				holidays = append(holidays, &HolidayDate{Date: date(day), Name: "Holiday"})
+			}
+
+			observed := observedDays(holidays, taken)
+
+			if len(observed) != len(tt.want) {
+				t.Fatalf("got %d substitute days, want %d", len(observed), len(tt.want))
+			}
+
+			for i, item := range observed {
+				if item.Date.Format("2006-01-02") != tt.want[i] {
+					t.Errorf("substitute %d = %s, want %s", i, item.Date.Format("2006-01-02"), tt.want[i])
+				}
+				if !item.Observed || item.Name != "Holiday (observed)" {
+					t.Errorf("substitute %s = %+v, want an observed day", tt.want[i], item)
+				}
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,38 @@
+package holiday
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Holiday struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	Name           string             `bson:"name" json:"name"`
+	StartDate      time.Time          `bson:"start_date" json:"start_date"`
+	EndDate        time.Time          `bson:"end_date" json:"end_date"`
+	Recurring      bool               `bson:"recurring" json:"recurring"`
+	Description    *string            `bson:"description" json:"description"`
+	CreatedBy      string             `bson:"created_by" json:"created_by"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+type HolidayDate struct {
+	Date      time.Time          `json:"date"`
+	Name      string             `json:"name"`
+	HolidayID primitive.ObjectID `json:"holiday_id"`
+	Recurring bool               `json:"recurring"`
+	BuiltIn   bool               `json:"built_in"`
+	Observed  bool               `json:"observed"`
+}
+
+// HolidaySetting holds an organization's calendar preferences. The built-in
+// lunar holidays are on unless the organization turns them off, for example
+// to enter its own dates for Tết.
+type HolidaySetting struct {
+	OrganizationID  string    `bson:"organization_id" json:"organization_id"`
+	BuiltInHolidays bool      `bson:"built_in_holidays" json:"built_in_holidays"`
+	UpdatedAt       time.Time `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,175 @@
+package holiday
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type HolidayRepository interface {
+	CreateHoliday(ctx context.Context, holiday *Holiday) error
+	UpdateHoliday(ctx context.Context, holiday *Holiday, id primitive.ObjectID) (*Holiday, error)
+	DeleteHoliday(ctx context.Context, organizationID string, id primitive.ObjectID) error
+	GetHolidays(ctx context.Context, organizationID string) ([]*Holiday, error)
+	GetHolidaysInRange(ctx context.Context, organizationID string, startDate time.Time, endDate time.Time) ([]*Holiday, error)
+	GetSetting(ctx context.Context, organizationID string) (*HolidaySetting, error)
+	UpdateSetting(ctx context.Context, setting *HolidaySetting) error
+}
+
+type holidayRepository struct {
+	collectionHoliday *mongo.Collection
+	collectionSetting *mongo.Collection
+}
+
+func NewHolidayRepository(collectionHoliday *mongo.Collection, collectionSetting *mongo.Collection) HolidayRepository {
+	return &holidayRepository{
+		collectionHoliday: collectionHoliday,
+		collectionSetting: collectionSetting,
+	}
+}
+
+func (r *holidayRepository) CreateHoliday(ctx context.Context, holiday *Holiday) error {
+	_, err := r.collectionHoliday.InsertOne(ctx, holiday)
+	return err
+}
+
+func (r *holidayRepository) UpdateHoliday(ctx context.Context, holiday *Holiday, id primitive.ObjectID) (*Holiday, error) {
+
+	filter := bson.M{"_id": id, "organization_id": holiday.OrganizationID}
+
+	update := bson.M{
+		"$set": bson.M{
+			"name":        holiday.Name,
+			"start_date":  holiday.StartDate,
+			"end_date":    holiday.EndDate,
+			"recurring":   holiday.Recurring,
+			"description": holiday.Description,
+			"updated_at":  time.Now(),
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var holidayUpdated Holiday
+
+	err := r.collectionHoliday.FindOneAndUpdate(ctx, filter, update, opts).Decode(&holidayUpdated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &holidayUpdated, nil
+
+}
+
+func (r *holidayRepository) DeleteHoliday(ctx context.Context, organizationID string, id primitive.ObjectID) error {
+
+	result, err := r.collectionHoliday.DeleteOne(ctx, bson.M{"_id": id, "organization_id": organizationID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+
+}
+
+func (r *holidayRepository) GetHolidays(ctx context.Context, organizationID string) ([]*Holiday, error) {
+
+	var holidays []*Holiday
+
+	filter := bson.M{"organization_id": organizationID}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"start_date": 1})
+
+	cursor, err := r.collectionHoliday.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &holidays)
+	if err != nil {
+		return nil, err
+	}
+
+	if holidays == nil {
+		return []*Holiday{}, nil
+	}
+
+	return holidays, nil
+
+}
+
+func (r *holidayRepository) GetHolidaysInRange(ctx context.Context, organizationID string, startDate time.Time, endDate time.Time) ([]*Holiday, error) {
+
+	var holidays []*Holiday
+
+	// Recurring holidays repeat every year, so they are always candidates
+	// and get matched against the range in the service.
+	filter := bson.M{
+		"organization_id": organizationID,
+		"$or": []bson.M{
+			{"recurring": true},
+			{
+				"start_date": bson.M{"$lte": endDate},
+				"end_date":   bson.M{"$gte": startDate},
+			},
+		},
+	}
+
+	cursor, err := r.collectionHoliday.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &holidays)
+	if err != nil {
+		return nil, err
+	}
+
+	return holidays, nil
+
+}
+
+// GetSetting returns the organization's calendar settings, or the defaults
+// when it never saved any.
+func (r *holidayRepository) GetSetting(ctx context.Context, organizationID string) (*HolidaySetting, error) {
+
+	var setting HolidaySetting
+
+	err := r.collectionSetting.FindOne(ctx, bson.M{"organization_id": organizationID}).Decode(&setting)
+	if err == mongo.ErrNoDocuments {
+		return &HolidaySetting{
+			OrganizationID:  organizationID,
+			BuiltInHolidays: true,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &setting, nil
+
+}
+
+func (r *holidayRepository) UpdateSetting(ctx context.Context, setting *HolidaySetting) error {
+
+	_, err := r.collectionSetting.UpdateOne(ctx, bson.M{"organization_id": setting.OrganizationID}, bson.M{
+		"$set": bson.M{
+			"built_in_holidays": setting.BuiltInHolidays,
+			"updated_at":        setting.UpdatedAt,
+		},
+	}, options.Update().SetUpsert(true))
+
+	return err
+
+}
@@ -0,0 +1,14 @@
+package holiday
+
+type HolidayRequest struct {
+	Name        string  `bson:"name" json:"name"`
+	StartDate   string  `bson:"start_date" json:"start_date"`
+	EndDate     string  `bson:"end_date" json:"end_date"`
+	Recurring   bool    `bson:"recurring" json:"recurring"`
+	Description *string `bson:"description" json:"description"`
+	CreatedBy   string  `bson:"created_by" json:"created_by"`
+}
+
+type HolidaySettingRequest struct {
+	BuiltInHolidays *bool `bson:"built_in_holidays" json:"built_in_holidays"`
+}
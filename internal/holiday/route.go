@@ -0,0 +1,21 @@
+package holiday
+
+import (
+	"worktime-service/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.Engine, handler *HolidayHandler) {
+
+	holidayGroup := r.Group("/api/v1/holiday").Use(middleware.Secured())
+	{
+		holidayGroup.GET("", handler.GetHolidays)
+		holidayGroup.GET("/calendar", handler.GetHolidayCalendar)
+		holidayGroup.GET("/setting", handler.GetHolidaySetting)
+		holidayGroup.PUT("/setting", handler.UpdateHolidaySetting)
+		holidayGroup.POST("", handler.CreateHoliday)
+		holidayGroup.PUT("/:id", handler.UpdateHoliday)
+		holidayGroup.DELETE("/:id", handler.DeleteHoliday)
+	}
+}
@@ -0,0 +1,451 @@
+package holiday
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"worktime-service/internal/shared"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type HolidayService interface {
+	CreateHoliday(ctx context.Context, req *HolidayRequest) (*Holiday, error)
+	UpdateHoliday(ctx context.Context, req *HolidayRequest, id string) (*Holiday, error)
+	DeleteHoliday(ctx context.Context, id string) error
+	GetHolidays(ctx context.Context) ([]*Holiday, error)
+	GetHolidayCalendar(ctx context.Context, dateFrom string, dateTo string) ([]*HolidayDate, error)
+	GetHolidaysInRange(ctx context.Context, organizationID string, startDate time.Time, endDate time.Time) (map[string]string, error)
+	GetHolidaySetting(ctx context.Context) (*HolidaySetting, error)
+	UpdateHolidaySetting(ctx context.Context, req *HolidaySettingRequest) (*HolidaySetting, error)
+	SetHolidayListener(listener shared.HolidayListener)
+}
+
+const observedWindowDays = 14
+
+type holidayService struct {
+	holidayRepository HolidayRepository
+	userService       user.UserService
+	listener          shared.HolidayListener
+}
+
+func NewHolidayService(holidayRepository HolidayRepository, userService user.UserService) HolidayService {
+	return &holidayService{
+		holidayRepository: holidayRepository,
+		userService:       userService,
+	}
+}
+
+// SetHolidayListener registers the service told about holiday changes. Leave
+// is built on top of the holiday calendar, so it can only be wired in after
+// both services exist.
+func (s *holidayService) SetHolidayListener(listener shared.HolidayListener) {
+	s.listener = listener
+}
+
+func (s *holidayService) CreateHoliday(ctx context.Context, req *HolidayRequest) (*Holiday, error) {
+
+	startDate, endDate, err := s.validateRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID, err := s.getAdminOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	holiday := Holiday{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: organizationID,
+		Name:           req.Name,
+		StartDate:      startDate,
+		EndDate:        endDate,
+		Recurring:      req.Recurring,
+		Description:    req.Description,
+		CreatedBy:      req.CreatedBy,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+
+	err = s.holidayRepository.CreateHoliday(ctx, &holiday)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncHolidaySlots(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &holiday, nil
+
+}
+
+func (s *holidayService) UpdateHoliday(ctx context.Context, req *HolidayRequest, id string) (*Holiday, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	startDate, endDate, err := s.validateRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID, err := s.getAdminOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	holiday := Holiday{
+		OrganizationID: organizationID,
+		Name:           req.Name,
+		StartDate:      startDate,
+		EndDate:        endDate,
+		Recurring:      req.Recurring,
+		Description:    req.Description,
+	}
+
+	updated, err := s.holidayRepository.UpdateHoliday(ctx, &holiday, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncHolidaySlots(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+
+}
+
+func (s *holidayService) DeleteHoliday(ctx context.Context, id string) error {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	organizationID, err := s.getAdminOrganizationID(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = s.holidayRepository.DeleteHoliday(ctx, organizationID, objectID)
+	if err != nil {
+		return err
+	}
+
+	return s.syncHolidaySlots(ctx, organizationID)
+
+}
+
+func (s *holidayService) GetHolidays(ctx context.Context) ([]*Holiday, error) {
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.holidayRepository.GetHolidays(ctx, organizationID)
+
+}
+
+func (s *holidayService) GetHolidayCalendar(ctx context.Context, dateFrom string, dateTo string) ([]*HolidayDate, error) {
+
+	if dateFrom == "" {
+		return nil, fmt.Errorf("date from is empty")
+	}
+
+	if dateTo == "" {
+		return nil, fmt.Errorf("date to is empty")
+	}
+
+	startDate, err := time.Parse("2006-01-02", dateFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	endDate, err := time.Parse("2006-01-02", dateTo)
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getHolidayDates(ctx, organizationID, startDate, endDate)
+
+}
+
+func (s *holidayService) GetHolidaySetting(ctx context.Context) (*HolidaySetting, error) {
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.holidayRepository.GetSetting(ctx, organizationID)
+
+}
+
+// UpdateHolidaySetting changes the organization's calendar settings and
+// lets leave catch up, since turning the built-in holidays on or off opens
+// or closes their days.
+func (s *holidayService) UpdateHolidaySetting(ctx context.Context, req *HolidaySettingRequest) (*HolidaySetting, error) {
+
+	if req.BuiltInHolidays == nil {
+		return nil, errors.New("built_in_holidays is required")
+	}
+
+	organizationID, err := s.getAdminOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	setting := &HolidaySetting{
+		OrganizationID:  organizationID,
+		BuiltInHolidays: *req.BuiltInHolidays,
+		UpdatedAt:       time.Now(),
+	}
+
+	err = s.holidayRepository.UpdateSetting(ctx, setting)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.syncHolidaySlots(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return setting, nil
+
+}
+
+// GetHolidaysInRange returns the holiday name for every day between start
+// and end, inclusive, keyed by "2006-01-02". Other services use it to tell
+// public holidays apart from working days.
+func (s *holidayService) GetHolidaysInRange(ctx context.Context, organizationID string, startDate time.Time, endDate time.Time) (map[string]string, error) {
+
+	holidays := make(map[string]string)
+
+	if organizationID == "" {
+		return holidays, nil
+	}
+
+	dates, err := s.getHolidayDates(ctx, organizationID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range dates {
+		holidays[item.Date.Format("2006-01-02")] = item.Name
+	}
+
+	return holidays, nil
+
+}
+
+func (s *holidayService) getHolidayDates(ctx context.Context, organizationID string, startDate time.Time, endDate time.Time) ([]*HolidayDate, error) {
+
+	// Substitute days can land a week or so after the holiday itself, so
+	// the lookup is widened before being trimmed back to the range.
+	from := startDate.AddDate(0, 0, -observedWindowDays)
+	to := endDate.AddDate(0, 0, observedWindowDays)
+
+	holidays, err := s.holidayRepository.GetHolidaysInRange(ctx, organizationID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	setting, err := s.holidayRepository.GetSetting(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	taken := make(map[string]bool)
+
+	// A day two holidays share is listed once, so it is given back at most
+	// once when it falls on a weekend.
+	var dates []*HolidayDate
+	for _, item := range holidays {
+		for _, date := range expandHoliday(item, from, to) {
+			key := date.Date.Format("2006-01-02")
+			if taken[key] {
+				continue
+			}
+			taken[key] = true
+			dates = append(dates, date)
+		}
+	}
+
+	// Lunar holidays are generated rather than stored; a day the
+	// organization already entered by hand is not listed twice.
+	if setting.BuiltInHolidays {
+		for year := from.Year(); year <= to.Year(); year++ {
+			for _, date := range lunarHolidays(year) {
+				key := date.Date.Format("2006-01-02")
+				if taken[key] {
+					continue
+				}
+				taken[key] = true
+				dates = append(dates, date)
+			}
+		}
+	}
+
+	// The weekend substitute applies to every public holiday, the ones the
+	// organization entered as well as the built-in ones. Substitutes are
+	// handed out in date order, so the same calendar always gets the same
+	// days.
+	sort.Slice(dates, func(i, j int) bool {
+		return dates[i].Date.Before(dates[j].Date)
+	})
+	dates = append(dates, observedDays(dates, taken)...)
+
+	var result []*HolidayDate
+	for _, date := range dates {
+		if date.Date.Before(startDate) || date.Date.After(endDate) {
+			continue
+		}
+		result = append(result, date)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Date.Before(result[j].Date)
+	})
+
+	return result, nil
+
+}
+
+// expandHoliday lists the days of a holiday that fall inside the range. A
+// recurring holiday is shifted to every year the range touches, including
+// the year before so that a holiday spanning New Year is not cut off.
+func expandHoliday(holiday *Holiday, startDate time.Time, endDate time.Time) []*HolidayDate {
+
+	var dates []*HolidayDate
+
+	addDays := func(from time.Time, to time.Time) {
+		for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+			if day.Before(startDate) || day.After(endDate) {
+				continue
+			}
+			dates = append(dates, &HolidayDate{
+				Date:      day,
+				Name:      holiday.Name,
+				HolidayID: holiday.ID,
+				Recurring: holiday.Recurring,
+			})
+		}
+	}
+
+	if !holiday.Recurring {
+		addDays(holiday.StartDate, holiday.EndDate)
+		return dates
+	}
+
+	for year := startDate.Year() - 1; year <= endDate.Year(); year++ {
+		offset := year - holiday.StartDate.Year()
+		addDays(holiday.StartDate.AddDate(offset, 0, 0), holiday.EndDate.AddDate(offset, 0, 0))
+	}
+
+	return dates
+
+}
+
+func (s *holidayService) validateRequest(req *HolidayRequest) (time.Time, time.Time, error) {
+
+	if req.Name == "" {
+		return time.Time{}, time.Time{}, errors.New("name is required")
+	}
+
+	if req.StartDate == "" {
+		return time.Time{}, time.Time{}, errors.New("start date is required")
+	}
+
+	if req.EndDate == "" {
+		req.EndDate = req.StartDate
+	}
+
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	if endDate.Before(startDate) {
+		return time.Time{}, time.Time{}, errors.New("end date must not be before start date")
+	}
+
+	return startDate, endDate, nil
+
+}
+
+func (s *holidayService) getOrganizationID(ctx context.Context) (string, error) {
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if currentUser.OrganizationIdActive == "" {
+		return "", errors.New("user has no active organization")
+	}
+
+	return currentUser.OrganizationIdActive, nil
+
+}
+
+// getAdminOrganizationID is getOrganizationID for the calls that change the
+// calendar, which only the organization's admins may make.
+func (s *holidayService) getAdminOrganizationID(ctx context.Context) (string, error) {
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if currentUser.OrganizationIdActive == "" {
+		return "", errors.New("user has no active organization")
+	}
+
+	isAdmin := currentUser.IsSuperAdmin || (currentUser.OrganizationAdmin != nil && currentUser.OrganizationAdmin.ID == currentUser.OrganizationIdActive)
+	if !isAdmin {
+		return "", errors.New("only organization admins can manage holidays")
+	}
+
+	return currentUser.OrganizationIdActive, nil
+
+}
+
+// syncHolidaySlots lets leave catch up with a changed calendar. The holiday
+// itself is already saved, so a failure here is reported to the caller but
+// does not undo it.
+func (s *holidayService) syncHolidaySlots(ctx context.Context, organizationID string) error {
+
+	if s.listener == nil {
+		return nil
+	}
+
+	err := s.listener.SyncHolidaySlots(ctx, organizationID)
+	if err != nil {
+		return fmt.Errorf("holiday saved but leave calendar not updated: %w", err)
+	}
+
+	return nil
+
+}
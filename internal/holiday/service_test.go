@@ -0,0 +1,99 @@
+package holiday
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type fakeHolidayRepository struct {
+	HolidayRepository
+	holidays []*Holiday
+	setting  *HolidaySetting
+}
+
+func (r *fakeHolidayRepository) GetHolidaysInRange(ctx context.Context, organizationID string, startDate time.Time, endDate time.Time) ([]*Holiday, error) {
+	return r.holidays, nil
+}
+
+func (r *fakeHolidayRepository) GetSetting(ctx context.Context, organizationID string) (*HolidaySetting, error) {
+	return r.setting, nil
+}
+
+func TestGetHolidayDates(t *testing.T) {
+
+	// Reunification Day and Labour Day 2026 fall on Thursday and Friday; in
+	// 2027 they fall on Friday and Saturday.
+	reunification := &Holiday{
+		ID:        primitive.NewObjectID(),
+		Name:      "Reunification Day",
+		StartDate: date("2026-04-30"),
+		EndDate:   date("2026-05-01"),
+		Recurring: true,
+	}
+
+	tests := []struct {
+		name    string
+		builtIn bool
+		from    string
+		to      string
+		want    []string
+	}{
+		{
+			name:    "org holiday on a weekend gets a substitute",
+			builtIn: true,
+			from:    "2027-04-26",
+			to:      "2027-05-09",
+			want:    []string{"2027-04-30", "2027-05-01", "2027-05-03"},
+		},
+		{
+			name:    "built-in holidays are listed with their substitutes",
+			builtIn: true,
+			from:    "2024-02-01",
+			to:      "2024-02-29",
+			want:    []string{"2024-02-09", "2024-02-10", "2024-02-11", "2024-02-12", "2024-02-13", "2024-02-14", "2024-02-15"},
+		},
+		{
+			name:    "built-in holidays can be turned off",
+			builtIn: false,
+			from:    "2024-02-01",
+			to:      "2024-02-29",
+			want:    nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			service := &holidayService{
+				holidayRepository: &fakeHolidayRepository{
+					holidays: []*Holiday{reunification},
+					setting:  &HolidaySetting{BuiltInHolidays: tt.builtIn},
+				},
+			}
+
+			dates, err := service.getHolidayDates(context.Background(), "org", date(tt.from), date(tt.to))
+			if err != nil {
+				t.Fatalf("getHolidayDates: %v", err)
+			}
+
+			var got []string
+			for _, item := range dates {
+				got = append(got, item.Date.Format("2006-01-02"))
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("got %v, want %v", got, tt.want)
+				}
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,399 @@
+package leave
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const topRequestUsers = 10
+
+var analyticsGroupings = []string{GroupByDay, GroupByWeek, GroupByMonth, GroupByWeekday, GroupByType, GroupByUser, GroupByTeam}
+
+func parseAnalyticsRange(dateFrom string, dateTo string) (time.Time, time.Time, error) {
+
+	if dateFrom == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("date from is empty")
+	}
+
+	if dateTo == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("date to is empty")
+	}
+
+	dateFromParse, err := time.Parse("2006-01-02", dateFrom)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	dateToParse, err := time.Parse("2006-01-02", dateTo)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	if dateToParse.Before(dateFromParse) {
+		return time.Time{}, time.Time{}, fmt.Errorf("date to must not be before date from")
+	}
+
+	return dateFromParse, dateToParse, nil
+
+}
+
+// GetLeaveAnalytics breaks the leave of the range down by one dimension.
+// Buckets come back in date order for day, week, month and weekday, and
+// by request count otherwise or when top is set.
+func (s *leaveService) GetLeaveAnalytics(ctx context.Context, dateFrom string, dateTo string, groupBy string, top int) (*LeaveAnalytics, error) {
+
+	dateFromParse, dateToParse, err := parseAnalyticsRange(dateFrom, dateTo)
+	if err != nil {
+		return nil, err
+	}
+
+	if groupBy == "" {
+		groupBy = GroupByMonth
+	}
+
+	if !slices.Contains(analyticsGroupings, groupBy) {
+		return nil, fmt.Errorf("invalid group by: %s", groupBy)
+	}
+
+	if top < 0 {
+		return nil, fmt.Errorf("top must not be negative")
+	}
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err := s.leaveRepository.GetLeaveAnalytics(ctx, organizationID, dateFromParse, dateToParse, []AnalyticsGrouping{
+		{By: GroupByAll},
+		{By: groupBy, Top: top},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.labelBuckets(ctx, organizationID, groupBy, buckets[1])
+
+	analytics := &LeaveAnalytics{
+		GroupBy:  groupBy,
+		DateFrom: dateFrom,
+		DateTo:   dateTo,
+		Total:    &AnalyticsBucket{Key: "total"},
+		Buckets:  buckets[1],
+	}
+
+	if len(buckets[0]) > 0 {
+		analytics.Total = buckets[0][0]
+	}
+
+	if analytics.Buckets == nil {
+		analytics.Buckets = []*AnalyticsBucket{}
+	}
+
+	return analytics, nil
+
+}
+
+// labelBuckets fills in display names the pipeline does not know.
+func (s *leaveService) labelBuckets(ctx context.Context, organizationID string, groupBy string, buckets []*AnalyticsBucket) {
+
+	switch groupBy {
+	case GroupByWeekday:
+		for _, bucket := range buckets {
+			bucket.Name = isoWeekdayName(bucket.Key)
+		}
+	case GroupByType:
+		leaveTypes, err := s.leaveRepository.GetLeaveTypes(ctx, organizationID)
+		if err != nil {
+			return
+		}
+		for _, bucket := range buckets {
+			for _, leaveType := range leaveTypes {
+				if leaveType.Code == bucket.Key {
+					bucket.Name = leaveType.Name
+				}
+			}
+		}
+	case GroupByTeam:
+		for _, bucket := range buckets {
+			if bucket.Key == "" {
+				bucket.Name = "No team"
+			}
+		}
+	}
+
+}
+
+// isoWeekdayName turns Mongo's ISO day of week, 1 for Monday to 7 for
+// Sunday, into a weekday name.
+func isoWeekdayName(key string) string {
+
+	day, err := strconv.Atoi(key)
+	if err != nil {
+		return key
+	}
+
+	return time.Weekday(day % 7).String()
+
+}
+
+// caculateStatistical maps the aggregated buckets onto the statistics
+// response. Confirmed includes requests approved before the confirmed
+// status existed.
+func caculateStatistical(total []*AnalyticsBucket, months []*AnalyticsBucket, weekdays []*AnalyticsBucket, types []*AnalyticsBucket, users []*AnalyticsBucket) *LeaveStatistical {
+
+	stats := &LeaveStatistical{
+		RequestsByMonth:  []MonthlyStats{},
+		RequestByWeekDay: []WeeklyStats{},
+		RequestsByType:   []TypeStats{},
+		TopRequestUsers:  []UserStats{},
+	}
+
+	if len(total) > 0 {
+		stats.TotalRequested = total[0].Requests
+		stats.TotalConfirmed = total[0].Confirmed
+		stats.TotalPending = total[0].Pending
+		stats.TotalRejected = total[0].Rejected
+		stats.TotalCancelled = total[0].Cancelled
+		stats.ImmediateRequests = total[0].Immediate
+		stats.TotalLeaveDays = total[0].LeaveDays
+
+		if total[0].Requests > 0 {
+			stats.ApproveRate = float64(total[0].Confirmed) / float64(total[0].Requests) * 100
+		}
+
+		if total[0].Users > 0 {
+			stats.AverageRequestsPerUser = float64(total[0].Requests) / float64(total[0].Users)
+		}
+	}
+
+	for _, bucket := range months {
+		stats.RequestsByMonth = append(stats.RequestsByMonth, MonthlyStats{
+			Month:     bucket.Key,
+			Count:     bucket.Requests,
+			Approved:  bucket.Confirmed,
+			Rejected:  bucket.Rejected,
+			LeaveDays: bucket.LeaveDays,
+		})
+	}
+
+	for _, bucket := range weekdays {
+		stats.RequestByWeekDay = append(stats.RequestByWeekDay, WeeklyStats{
+			Weekday: isoWeekdayName(bucket.Key),
+			Count:   bucket.Requests,
+		})
+	}
+
+	for _, bucket := range types {
+		stats.RequestsByType = append(stats.RequestsByType, TypeStats{
+			LeaveType: bucket.Key,
+			Count:     bucket.Requests,
+			Confirmed: bucket.Confirmed,
+			Pending:   bucket.Pending,
+			Rejected:  bucket.Rejected,
+			LeaveDays: bucket.LeaveDays,
+		})
+	}
+
+	for _, bucket := range users {
+		userStat := UserStats{
+			UserID:           bucket.Key,
+			UserName:         bucket.Name,
+			TotalRequests:    bucket.Requests,
+			ApprovedRequests: bucket.Confirmed,
+			LeaveDays:        bucket.LeaveDays,
+		}
+		if bucket.Requests > 0 {
+			userStat.ApprovalRate = float64(bucket.Confirmed) / float64(bucket.Requests) * 100
+		}
+		stats.TopRequestUsers = append(stats.TopRequestUsers, userStat)
+	}
+
+	return stats
+
+}
+
+// GetLeaveAnalytics aggregates the leave days falling in the range once per
+// grouping, in a single $facet pass. A request is counted in every bucket
+// it has a day in; leave days only count confirmed requests.
+func (r *leaveRepository) GetLeaveAnalytics(ctx context.Context, organizationID string, dateFrom time.Time, dateTo time.Time, groupings []AnalyticsGrouping) ([][]*AnalyticsBucket, error) {
+
+	dateRange := bson.M{"$gte": dateFrom, "$lte": dateTo}
+
+	facets := bson.M{}
+	for i, grouping := range groupings {
+		stages, err := analyticsGroupStages(grouping, r.collectionCapacityPool.Name())
+		if err != nil {
+			return nil, err
+		}
+		facets[fmt.Sprintf("g%d", i)] = stages
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"organization_id": organizationID,
+			"$or": []bson.M{
+				{"leave_dates": dateRange},
+				{"leave_date": dateRange},
+			},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"user_id":      1,
+			"user_name":    1,
+			"pool_id":      1,
+			"request_type": 1,
+			// Requests approved before the confirmed status existed.
+			"status": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", "approved"}}, "confirmed", "$status"}},
+			"leave_type": bson.M{"$cond": bson.A{
+				bson.M{"$in": bson.A{bson.M{"$ifNull": bson.A{"$leave_type", ""}}, bson.A{""}}},
+				LeaveTypeAnnual,
+				"$leave_type",
+			}},
+			"weight": bson.M{"$cond": bson.A{bson.M{"$in": bson.A{"$portion", bson.A{PortionAM, PortionPM}}}, 0.5, 1}},
+			"date": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{bson.M{"$size": bson.M{"$ifNull": bson.A{"$leave_dates", bson.A{}}}}, 0}},
+				"$leave_dates",
+				bson.A{"$leave_date"},
+			}},
+		}}},
+		{{Key: "$unwind", Value: "$date"}},
+		{{Key: "$match", Value: bson.M{"date": dateRange}}},
+		{{Key: "$facet", Value: facets}},
+	}
+
+	cursor, err := r.collectionLeave.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var result map[string][]*AnalyticsBucket
+	if cursor.Next(ctx) {
+		err = cursor.Decode(&result)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	buckets := make([][]*AnalyticsBucket, len(groupings))
+	for i := range groupings {
+		buckets[i] = result[fmt.Sprintf("g%d", i)]
+	}
+
+	return buckets, nil
+
+}
+
+// analyticsGroupStages folds the unwound days into one row per request and
+// bucket, then into the buckets themselves, so a request spanning several
+// days of a bucket is counted once. Teams are named from the pool
+// collection.
+func analyticsGroupStages(grouping AnalyticsGrouping, poolCollection string) (bson.A, error) {
+
+	var key interface{}
+	chronological := true
+
+	switch grouping.By {
+	case GroupByAll:
+		key = "total"
+	case GroupByDay:
+		key = bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$date"}}
+	case GroupByWeek:
+		key = bson.M{"$dateToString": bson.M{"format": "%G-W%V", "date": "$date"}}
+	case GroupByMonth:
+		key = bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$date"}}
+	case GroupByWeekday:
+		key = bson.M{"$toString": bson.M{"$isoDayOfWeek": "$date"}}
+	case GroupByType:
+		key = "$leave_type"
+		chronological = false
+	case GroupByUser:
+		key = "$user_id"
+		chronological = false
+	case GroupByTeam:
+		key = bson.M{"$ifNull": bson.A{bson.M{"$toString": "$pool_id"}, ""}}
+		chronological = false
+	default:
+		return nil, fmt.Errorf("invalid group by: %s", grouping.By)
+	}
+
+	countStatus := func(status string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", status}}, 1, 0}}}
+	}
+
+	sumDays := func(status string) bson.M {
+		return bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$status", status}}, "$days", 0}}}
+	}
+
+	stages := bson.A{
+		bson.M{"$group": bson.M{
+			"_id":          bson.M{"key": key, "leave": "$_id"},
+			"status":       bson.M{"$first": "$status"},
+			"request_type": bson.M{"$first": "$request_type"},
+			"user_id":      bson.M{"$first": "$user_id"},
+			"user_name":    bson.M{"$first": "$user_name"},
+			"pool_id":      bson.M{"$first": "$pool_id"},
+			"days":         bson.M{"$sum": "$weight"},
+		}},
+		bson.M{"$group": bson.M{
+			"_id":          "$_id.key",
+			"user_name":    bson.M{"$first": "$user_name"},
+			"pool_id":      bson.M{"$first": "$pool_id"},
+			"requests":     bson.M{"$sum": 1},
+			"confirmed":    countStatus("confirmed"),
+			"pending":      countStatus("pending"),
+			"rejected":     countStatus("rejected"),
+			"cancelled":    countStatus("cancelled"),
+			"immediate":    bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$request_type", "immediate"}}, 1, 0}}},
+			"users":        bson.M{"$addToSet": "$user_id"},
+			"leave_days":   sumDays("confirmed"),
+			"pending_days": sumDays("pending"),
+		}},
+		bson.M{"$addFields": bson.M{"users": bson.M{"$size": "$users"}}},
+	}
+
+	switch grouping.By {
+	case GroupByUser:
+		stages = append(stages, bson.M{"$addFields": bson.M{"name": "$user_name"}})
+	case GroupByTeam:
+		stages = append(stages,
+			bson.M{"$lookup": bson.M{
+				"from":         poolCollection,
+				"localField":   "pool_id",
+				"foreignField": "_id",
+				"as":           "pool",
+			}},
+			bson.M{"$addFields": bson.M{"name": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$pool.name", 0}}, ""}}}},
+		)
+	}
+
+	// Time buckets read in order; the rest, and any top-N cut, rank by
+	// request count.
+	if chronological && grouping.Top == 0 {
+		stages = append(stages, bson.M{"$sort": bson.D{{Key: "_id", Value: 1}}})
+	} else {
+		stages = append(stages, bson.M{"$sort": bson.D{
+			{Key: "requests", Value: -1},
+			{Key: "leave_days", Value: -1},
+			{Key: "_id", Value: 1},
+		}})
+	}
+
+	if grouping.Top > 0 {
+		stages = append(stages, bson.M{"$limit": grouping.Top})
+	}
+
+	return stages, nil
+
+}
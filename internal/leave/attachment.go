@@ -0,0 +1,240 @@
+package leave
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"time"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	maxAttachments    = 5
+	maxAttachmentSize = 10 << 20
+)
+
+// allowedAttachmentTypes is the MIME whitelist. The type is sniffed from the
+// file content, so a renamed executable is refused whatever its extension.
+var allowedAttachmentTypes = []string{"application/pdf", "image/jpeg"}
+
+// canManageAttachments lets the owner and the organization's admins add or
+// remove documents.
+func canManageAttachments(currentUser *user.CurrentUser, leaveItem *LeaveRequests) bool {
+	return currentUser.ID == leaveItem.UserID || isOrganizationAdmin(currentUser)
+}
+
+// canReadAttachments also lets whoever reviewed the request, or its
+// cancellation, read the documents.
+func canReadAttachments(currentUser *user.CurrentUser, leaveItem *LeaveRequests) bool {
+
+	if canManageAttachments(currentUser, leaveItem) {
+		return true
+	}
+
+	if leaveItem.Review != nil && leaveItem.Review.ReviewerID == currentUser.ID {
+		return true
+	}
+
+	return leaveItem.Cancellation != nil && leaveItem.Cancellation.ReviewerID == currentUser.ID
+
+}
+
+func (s *leaveService) UploadAttachments(ctx context.Context, id string, files []*multipart.FileHeader, uploadedBy string) (*LeaveRequests, error) {
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file uploaded")
+	}
+
+	leaveItem, currentUser, err := s.getLeaveForUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !canManageAttachments(currentUser, leaveItem) {
+		return nil, fmt.Errorf("you are not allowed to add documents to this leave request")
+	}
+
+	if len(leaveItem.Attachments)+len(files) > maxAttachments {
+		return nil, fmt.Errorf("a leave request can have at most %d attachments", maxAttachments)
+	}
+
+	for _, file := range files {
+		err = s.uploadAttachment(ctx, leaveItem, file, uploadedBy)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.Filename, err)
+		}
+	}
+
+	return leaveItem, nil
+
+}
+
+func (s *leaveService) uploadAttachment(ctx context.Context, leaveItem *LeaveRequests, file *multipart.FileHeader, uploadedBy string) error {
+
+	if file.Size > maxAttachmentSize {
+		return fmt.Errorf("file is larger than %d MB", maxAttachmentSize>>20)
+	}
+
+	content, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	reader := bufio.NewReaderSize(content, 512)
+
+	head, err := reader.Peek(512)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	contentType := http.DetectContentType(head)
+	if !slices.Contains(allowedAttachmentTypes, contentType) {
+		return fmt.Errorf("file type %s is not allowed, only PDF and JPEG are accepted", contentType)
+	}
+
+	attachment := Attachment{
+		ID:          primitive.NewObjectID(),
+		FileName:    filepath.Base(file.Filename),
+		ContentType: contentType,
+		Size:        file.Size,
+		UploadedBy:  uploadedBy,
+		UploadedAt:  time.Now(),
+	}
+
+	// The declared size is the client's word; the reader stops at the limit
+	// either way.
+	return s.leaveRepository.AddAttachment(ctx, leaveItem, &attachment, io.LimitReader(reader, maxAttachmentSize))
+
+}
+
+// OpenAttachment returns a document of the request for download. The
+// caller closes the reader.
+func (s *leaveService) OpenAttachment(ctx context.Context, id string, attachmentID string) (*Attachment, io.ReadCloser, error) {
+
+	attachmentObjectID, err := primitive.ObjectIDFromHex(attachmentID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	leaveItem, currentUser, err := s.getLeaveForUser(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !canReadAttachments(currentUser, leaveItem) {
+		return nil, nil, fmt.Errorf("you are not allowed to read the documents of this leave request")
+	}
+
+	index := slices.IndexFunc(leaveItem.Attachments, func(attachment Attachment) bool {
+		return attachment.ID == attachmentObjectID
+	})
+	if index < 0 {
+		return nil, nil, fmt.Errorf("attachment not found")
+	}
+
+	content, err := s.leaveRepository.OpenAttachment(ctx, attachmentObjectID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &leaveItem.Attachments[index], content, nil
+
+}
+
+func (s *leaveService) DeleteAttachment(ctx context.Context, id string, attachmentID string) error {
+
+	attachmentObjectID, err := primitive.ObjectIDFromHex(attachmentID)
+	if err != nil {
+		return err
+	}
+
+	leaveItem, currentUser, err := s.getLeaveForUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if !canManageAttachments(currentUser, leaveItem) {
+		return fmt.Errorf("you are not allowed to remove documents from this leave request")
+	}
+
+	// Approved leave that needed a document keeps at least one; only an
+	// admin can take the last one away.
+	if leaveItem.Status == "confirmed" && leaveItem.LeavePolicy().RequiresAttachment && len(leaveItem.Attachments) <= 1 && !isOrganizationAdmin(currentUser) {
+		return fmt.Errorf("%s leave needs a supporting document, upload another before removing this one", leaveItem.LeaveType)
+	}
+
+	return s.leaveRepository.DeleteAttachment(ctx, leaveItem, attachmentObjectID)
+
+}
+
+// AddAttachment stores the file in GridFS and links it to the request. The
+// link is capped at maxAttachments per request; a file that cannot be
+// linked is removed again.
+func (r *leaveRepository) AddAttachment(ctx context.Context, leaveItem *LeaveRequests, attachment *Attachment, content io.Reader) error {
+
+	uploadOptions := options.GridFSUpload().SetMetadata(bson.M{
+		"organization_id": leaveItem.OrganizationID,
+		"leave_id":        leaveItem.ID,
+		"content_type":    attachment.ContentType,
+		"uploaded_by":     attachment.UploadedBy,
+	})
+
+	err := r.attachmentBucket.UploadFromStreamWithID(attachment.ID, attachment.FileName, content, uploadOptions)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":             leaveItem.ID,
+		"organization_id": leaveItem.OrganizationID,
+		fmt.Sprintf("attachments.%d", maxAttachments-1): bson.M{"$exists": false},
+	}, bson.M{
+		"$push": bson.M{"attachments": attachment},
+	})
+	if err == nil && result.ModifiedCount == 0 {
+		err = fmt.Errorf("a leave request can have at most %d attachments", maxAttachments)
+	}
+	if err != nil {
+		r.attachmentBucket.DeleteContext(ctx, attachment.ID)
+		return err
+	}
+
+	leaveItem.Attachments = append(leaveItem.Attachments, *attachment)
+
+	return nil
+
+}
+
+func (r *leaveRepository) OpenAttachment(ctx context.Context, attachmentID primitive.ObjectID) (io.ReadCloser, error) {
+	return r.attachmentBucket.OpenDownloadStream(attachmentID)
+}
+
+func (r *leaveRepository) DeleteAttachment(ctx context.Context, leaveItem *LeaveRequests, attachmentID primitive.ObjectID) error {
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":             leaveItem.ID,
+		"attachments._id": attachmentID,
+	}, bson.M{
+		"$pull": bson.M{"attachments": bson.M{"_id": attachmentID}},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return fmt.Errorf("attachment not found")
+	}
+
+	return r.attachmentBucket.DeleteContext(ctx, attachmentID)
+
+}
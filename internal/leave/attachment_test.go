@@ -0,0 +1,243 @@
+package leave
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"strings"
+	"testing"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAttachmentAccess(t *testing.T) {
+
+	leaveItem := &LeaveRequests{
+		UserID:       "owner",
+		Review:       &LeaveReview{ReviewerID: "reviewer"},
+		Cancellation: &LeaveCancellation{ReviewerID: "canceller"},
+	}
+
+	tests := []struct {
+		name       string
+		caller     *user.CurrentUser
+		wantManage bool
+		wantRead   bool
+	}{
+		{
+			name:       "owner",
+			caller:     &user.CurrentUser{ID: "owner", OrganizationIdActive: "org-1"},
+			wantManage: true,
+			wantRead:   true,
+		},
+		{
+			name:       "admin of the active organization",
+			caller:     &user.CurrentUser{ID: "admin", OrganizationIdActive: "org-1", OrganizationAdmin: &user.OrganizationAdmin{ID: "org-1"}},
+			wantManage: true,
+			wantRead:   true,
+		},
+		{
+			name:   "admin of another organization",
+			caller: &user.CurrentUser{ID: "admin", OrganizationIdActive: "org-1", OrganizationAdmin: &user.OrganizationAdmin{ID: "org-2"}},
+		},
+		{
+			name:       "super admin",
+			caller:     &user.CurrentUser{ID: "root", IsSuperAdmin: true},
+			wantManage: true,
+			wantRead:   true,
+		},
+		{
+			name:     "reviewer of the request",
+			caller:   &user.CurrentUser{ID: "reviewer", OrganizationIdActive: "org-1"},
+			wantRead: true,
+		},
+		{
+			name:     "reviewer of the cancellation",
+			caller:   &user.CurrentUser{ID: "canceller", OrganizationIdActive: "org-1"},
+			wantRead: true,
+		},
+		{
+			name:   "colleague",
+			caller: &user.CurrentUser{ID: "colleague", OrganizationIdActive: "org-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if got := canManageAttachments(tt.caller, leaveItem); got != tt.wantManage {
+				t.Errorf("canManageAttachments = %v, want %v", got, tt.wantManage)
+			}
+
+			if got := canReadAttachments(tt.caller, leaveItem); got != tt.wantRead {
+				t.Errorf("canReadAttachments = %v, want %v", got, tt.wantRead)
+			}
+
+		})
+	}
+
+}
+
+// formFile uploads content through a real multipart form, the way the
+// handler receives it.
+func formFile(t *testing.T, fileName string, content []byte) *multipart.FileHeader {
+
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	part, err := writer.CreateFormFile("files", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = part.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err = writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { form.RemoveAll() })
+
+	return form.File["files"][0]
+
+}
+
+func TestUploadAttachment(t *testing.T) {
+
+	pdf := []byte("%PDF-1.7\n1 0 obj\n<<>>\nendobj\n")
+	jpeg := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, []byte("JFIF")...)
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	executable := append([]byte("MZ\x90\x00"), make([]byte, 64)...)
+	oversized := append([]byte("%PDF-1.7\n"), make([]byte, maxAttachmentSize)...)
+
+	tests := []struct {
+		name     string
+		file     string
+		content  []byte
+		declared int64
+		wantErr  string
+		wantType string
+		wantSize int64
+	}{
+		{name: "pdf", file: "note.pdf", content: pdf, wantType: "application/pdf", wantSize: int64(len(pdf))},
+		{name: "jpeg", file: "scan.jpg", content: jpeg, wantType: "image/jpeg", wantSize: int64(len(jpeg))},
+		{name: "png is not whitelisted", file: "scan.png", content: png, wantErr: "image/png is not allowed"},
+		{name: "renamed executable", file: "note.pdf", content: executable, wantErr: "application/octet-stream is not allowed"},
+		{name: "declared too large", file: "big.pdf", content: oversized, wantErr: "larger than 10 MB"},
+		{
+			name:     "understated size stops at the limit",
+			file:     "big.pdf",
+			content:  oversized,
+			declared: 1024,
+			wantType: "application/pdf",
+			wantSize: maxAttachmentSize,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			file := formFile(t, tt.file, tt.content)
+			if tt.declared != 0 {
+				file.Size = tt.declared
+			}
+
+			repository := &fakeLeaveRepository{}
+			service := &leaveService{leaveRepository: repository}
+			leaveItem := &LeaveRequests{ID: primitive.NewObjectID(), UserID: "owner"}
+
+			err := service.uploadAttachment(context.Background(), leaveItem, file, "owner")
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("uploadAttachment error = %v, want %q", err, tt.wantErr)
+				}
+				if len(leaveItem.Attachments) != 0 {
+					t.Errorf("refused file was attached: %+v", leaveItem.Attachments)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("uploadAttachment: %v", err)
+			}
+
+			if len(leaveItem.Attachments) != 1 {
+				t.Fatalf("attachments = %d, want 1", len(leaveItem.Attachments))
+			}
+
+			attachment := leaveItem.Attachments[0]
+			if attachment.ContentType != tt.wantType || attachment.FileName != tt.file || attachment.UploadedBy != "owner" {
+				t.Errorf("attachment = %+v", attachment)
+			}
+
+			if got := repository.stored[tt.file]; got != tt.wantSize {
+				t.Errorf("stored %d bytes, want %d", got, tt.wantSize)
+			}
+
+		})
+	}
+
+}
+
+func TestUploadAttachmentsChecksTheCaller(t *testing.T) {
+
+	id := primitive.NewObjectID()
+
+	tests := []struct {
+		name     string
+		caller   string
+		existing int
+		wantErr  string
+	}{
+		{name: "owner", caller: "owner"},
+		{name: "colleague", caller: "colleague", wantErr: "not allowed"},
+		{name: "limit reached", caller: "owner", existing: maxAttachments, wantErr: "at most 5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			leaveItem := &LeaveRequests{
+				ID:             id,
+				OrganizationID: "org-1",
+				UserID:         "owner",
+				Attachments:    make([]Attachment, tt.existing),
+			}
+
+			service := &leaveService{
+				leaveRepository: &fakeLeaveRepository{leaves: map[primitive.ObjectID]*LeaveRequests{id: leaveItem}},
+				userService:     &fakeUserService{currentUser: &user.CurrentUser{ID: tt.caller, OrganizationIdActive: "org-1"}},
+			}
+
+			file := formFile(t, "note.pdf", []byte("%PDF-1.7\n"))
+			_, err := service.UploadAttachments(context.Background(), id.Hex(), []*multipart.FileHeader{file}, tt.caller)
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("UploadAttachments: %v", err)
+				}
+				if len(leaveItem.Attachments) != tt.existing+1 {
+					t.Errorf("attachments = %d, want %d", len(leaveItem.Attachments), tt.existing+1)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("UploadAttachments error = %v, want %q", err, tt.wantErr)
+			}
+			if len(leaveItem.Attachments) != tt.existing {
+				t.Errorf("attachments changed to %d", len(leaveItem.Attachments))
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,85 @@
+package leave
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *leaveService) getOrganizationID(ctx context.Context) (string, error) {
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if currentUser.OrganizationIdActive == "" {
+		return "", errors.New("user has no active organization")
+	}
+
+	return currentUser.OrganizationIdActive, nil
+
+}
+
+// getOrganizationAdmin returns the caller when they administer their active
+// organization; action completes the error a non-admin gets.
+func (s *leaveService) getOrganizationAdmin(ctx context.Context, action string) (*user.CurrentUser, error) {
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentUser.OrganizationIdActive == "" {
+		return nil, errors.New("user has no active organization")
+	}
+
+	if !isOrganizationAdmin(currentUser) {
+		return nil, fmt.Errorf("only organization admins can %s", action)
+	}
+
+	return currentUser, nil
+
+}
+
+// getLeaveForUser loads a request of the caller's organization together with
+// the caller, for the checks on who may read or change it.
+func (s *leaveService) getLeaveForUser(ctx context.Context, id string) (*LeaveRequests, *user.CurrentUser, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if currentUser.OrganizationIdActive == "" {
+		return nil, nil, fmt.Errorf("user has no active organization")
+	}
+
+	leaveItem, err := s.leaveRepository.GetLeaveByID(ctx, currentUser.OrganizationIdActive, objectID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return leaveItem, currentUser, nil
+
+}
+
+// isOrganizationAdmin reports whether the caller administers their active
+// organization. Super admins administer every organization.
+func isOrganizationAdmin(currentUser *user.CurrentUser) bool {
+
+	if currentUser.IsSuperAdmin {
+		return true
+	}
+
+	return currentUser.OrganizationAdmin != nil && currentUser.OrganizationAdmin.ID == currentUser.OrganizationIdActive
+
+}
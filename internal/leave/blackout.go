@@ -0,0 +1,424 @@
+package leave
+
+import (
+	"context"
+	"fmt"
+	"time"
+	"worktime-service/internal/gateway/dto/response"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// blackoutWindow is a blackout period pinned to concrete dates.
+type blackoutWindow struct {
+	period    *BlackoutPeriod
+	startDate time.Time
+	endDate   time.Time
+}
+
+func (w *blackoutWindow) contains(date time.Time) bool {
+	return !date.Before(w.startDate) && !date.After(w.endDate)
+}
+
+func (w *blackoutWindow) mark() *BlackoutMark {
+	return &BlackoutMark{
+		BlackoutID: w.period.ID,
+		Name:       w.period.Name,
+		Action:     w.period.Action,
+	}
+}
+
+// getBlackoutWindows resolves the organization's active blackout periods to
+// dates. A term-relative period pinned to a term follows that term; one
+// without a term follows the organization's current term, the only one the
+// term service looks up without an id. Terms are fetched once per call, and
+// a term that cannot be loaded fails the call rather than letting leave
+// through a blackout unchecked.
+func (s *leaveService) getBlackoutWindows(ctx context.Context, organizationID string) ([]*blackoutWindow, error) {
+
+	periods, err := s.leaveRepository.GetBlackoutPeriods(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	terms := make(map[string]*response.TermResponse)
+
+	var windows []*blackoutWindow
+
+	for _, period := range periods {
+
+		if !period.IsActive {
+			continue
+		}
+
+		if period.Mode != BlackoutTermRelative {
+			if period.StartDate == nil || period.EndDate == nil {
+				continue
+			}
+			windows = append(windows, &blackoutWindow{
+				period:    period,
+				startDate: *period.StartDate,
+				endDate:   *period.EndDate,
+			})
+			continue
+		}
+
+		// The current term is cached under the empty id.
+		term, ok := terms[period.TermID]
+		if !ok {
+			term, err = s.getTerm(ctx, organizationID, period.TermID)
+			if err != nil {
+				return nil, fmt.Errorf("load term of blackout %s: %w", period.Name, err)
+			}
+			terms[period.TermID] = term
+		}
+
+		anchor, err := termAnchorDate(term, period.Anchor)
+		if err != nil {
+			return nil, fmt.Errorf("read %s of term %s: %w", period.Anchor, term.ID, err)
+		}
+
+		windows = append(windows, &blackoutWindow{
+			period:    period,
+			startDate: anchor.AddDate(0, 0, period.StartOffsetDays),
+			endDate:   anchor.AddDate(0, 0, period.EndOffsetDays),
+		})
+	}
+
+	return windows, nil
+
+}
+
+func (s *leaveService) getTerm(ctx context.Context, organizationID string, termID string) (*response.TermResponse, error) {
+
+	if s.termGateway == nil {
+		return nil, fmt.Errorf("term service is not configured")
+	}
+
+	if termID == "" {
+		return s.termGateway.GetCurrentTermByOrgID(ctx, organizationID)
+	}
+
+	return s.termGateway.GetTermByID(ctx, termID)
+
+}
+
+// termAnchorDate reads the start or end of a term. The term service sends
+// plain dates, but anything longer is cut to its date part.
+func termAnchorDate(term *response.TermResponse, anchor string) (time.Time, error) {
+
+	value := term.StartDate
+	if anchor == BlackoutAnchorTermEnd {
+		value = term.EndDate
+	}
+
+	if len(value) > len("2006-01-02") {
+		value = value[:len("2006-01-02")]
+	}
+
+	return time.Parse("2006-01-02", value)
+
+}
+
+// checkBlackout finds the blackout windows a leave range touches. Rejecting
+// windows become policy violations; the first approval-only window is
+// returned so the request can be sent to review.
+func checkBlackout(windows []*blackoutWindow, leaveType string, leaveDates []time.Time) ([]PolicyViolation, *blackoutWindow) {
+
+	var violations []PolicyViolation
+	var approval *blackoutWindow
+
+	for _, window := range windows {
+		for _, date := range leaveDates {
+
+			if !window.contains(date) {
+				continue
+			}
+
+			if window.period.Action == BlackoutActionRequireApproval {
+				if approval == nil {
+					approval = window
+				}
+				break
+			}
+
+			violations = append(violations, PolicyViolation{
+				Code:      ViolationBlackout,
+				Message:   fmt.Sprintf("%s is in the blackout period %s", date.Format("2006-01-02"), window.period.Name),
+				LeaveType: leaveType,
+				Date:      date.Format("2006-01-02"),
+				Earliest:  window.startDate.Format("2006-01-02"),
+				Latest:    window.endDate.Format("2006-01-02"),
+			})
+			break
+		}
+	}
+
+	return violations, approval
+
+}
+
+func markBlackoutDays(windows []*blackoutWindow, dailyLeaveSlots []*DailyLeaveSolt) {
+
+	for _, dailyLeaveSlot := range dailyLeaveSlots {
+		for _, window := range windows {
+			if !window.contains(dailyLeaveSlot.Date) {
+				continue
+			}
+			// A rejecting window is the stronger mark.
+			if dailyLeaveSlot.Blackout == nil || window.period.Action == BlackoutActionReject {
+				dailyLeaveSlot.Blackout = window.mark()
+			}
+		}
+	}
+
+}
+
+func (s *leaveService) GetBlackoutPeriods(ctx context.Context) ([]*BlackoutPeriod, error) {
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetBlackoutPeriods(ctx, organizationID)
+
+}
+
+func (s *leaveService) CreateBlackoutPeriod(ctx context.Context, req *BlackoutPeriodRequest) (*BlackoutPeriod, error) {
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "manage blackout periods")
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	period, err := buildBlackoutPeriod(req)
+	if err != nil {
+		return nil, err
+	}
+
+	period.ID = primitive.NewObjectID()
+	period.OrganizationID = organizationID
+	period.CreatedBy = req.CreatedBy
+	period.CreatedAt = time.Now()
+	period.UpdatedAt = time.Now()
+
+	err = s.leaveRepository.CreateBlackoutPeriod(ctx, period)
+	if err != nil {
+		return nil, err
+	}
+
+	return period, nil
+
+}
+
+func (s *leaveService) UpdateBlackoutPeriod(ctx context.Context, req *BlackoutPeriodRequest, id string) (*BlackoutPeriod, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "manage blackout periods")
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	period, err := buildBlackoutPeriod(req)
+	if err != nil {
+		return nil, err
+	}
+
+	period.OrganizationID = organizationID
+
+	return s.leaveRepository.UpdateBlackoutPeriod(ctx, period, objectID)
+
+}
+
+func (s *leaveService) DeleteBlackoutPeriod(ctx context.Context, id string) error {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "manage blackout periods")
+	if err != nil {
+		return err
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	return s.leaveRepository.DeleteBlackoutPeriod(ctx, organizationID, objectID)
+
+}
+
+func buildBlackoutPeriod(req *BlackoutPeriodRequest) (*BlackoutPeriod, error) {
+
+	if req.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	if req.Mode == "" {
+		req.Mode = BlackoutAbsolute
+	}
+
+	if req.Action == "" {
+		req.Action = BlackoutActionReject
+	}
+
+	if req.Action != BlackoutActionReject && req.Action != BlackoutActionRequireApproval {
+		return nil, fmt.Errorf("invalid action, must be %s or %s", BlackoutActionReject, BlackoutActionRequireApproval)
+	}
+
+	period := BlackoutPeriod{
+		Name:     req.Name,
+		Mode:     req.Mode,
+		Action:   req.Action,
+		IsActive: true,
+	}
+
+	if req.IsActive != nil {
+		period.IsActive = *req.IsActive
+	}
+
+	switch req.Mode {
+	case BlackoutAbsolute:
+		if req.StartDate == "" {
+			return nil, fmt.Errorf("start date is required")
+		}
+
+		if req.EndDate == "" {
+			req.EndDate = req.StartDate
+		}
+
+		startDate, err := time.Parse("2006-01-02", req.StartDate)
+		if err != nil {
+			return nil, err
+		}
+
+		endDate, err := time.Parse("2006-01-02", req.EndDate)
+		if err != nil {
+			return nil, err
+		}
+
+		if endDate.Before(startDate) {
+			return nil, fmt.Errorf("end date must not be before start date")
+		}
+
+		period.StartDate = &startDate
+		period.EndDate = &endDate
+
+	case BlackoutTermRelative:
+		if req.Anchor != BlackoutAnchorTermStart && req.Anchor != BlackoutAnchorTermEnd {
+			return nil, fmt.Errorf("invalid anchor, must be %s or %s", BlackoutAnchorTermStart, BlackoutAnchorTermEnd)
+		}
+
+		if req.EndOffsetDays < req.StartOffsetDays {
+			return nil, fmt.Errorf("end offset must not be before start offset")
+		}
+
+		period.TermID = req.TermID
+		period.Anchor = req.Anchor
+		period.StartOffsetDays = req.StartOffsetDays
+		period.EndOffsetDays = req.EndOffsetDays
+
+	default:
+		return nil, fmt.Errorf("invalid mode, must be %s or %s", BlackoutAbsolute, BlackoutTermRelative)
+	}
+
+	return &period, nil
+
+}
+
+func (r *leaveRepository) GetBlackoutPeriods(ctx context.Context, organizationID string) ([]*BlackoutPeriod, error) {
+
+	var periods []*BlackoutPeriod
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "created_at", Value: 1}})
+
+	cursor, err := r.collectionBlackout.Find(ctx, bson.M{"organization_id": organizationID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &periods)
+	if err != nil {
+		return nil, err
+	}
+
+	if periods == nil {
+		return []*BlackoutPeriod{}, nil
+	}
+
+	return periods, nil
+
+}
+
+func (r *leaveRepository) CreateBlackoutPeriod(ctx context.Context, period *BlackoutPeriod) error {
+
+	_, err := r.collectionBlackout.InsertOne(ctx, period)
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) UpdateBlackoutPeriod(ctx context.Context, period *BlackoutPeriod, id primitive.ObjectID) (*BlackoutPeriod, error) {
+
+	filter := bson.M{"_id": id, "organization_id": period.OrganizationID}
+
+	update := bson.M{
+		"$set": bson.M{
+			"name":              period.Name,
+			"mode":              period.Mode,
+			"start_date":        period.StartDate,
+			"end_date":          period.EndDate,
+			"term_id":           period.TermID,
+			"anchor":            period.Anchor,
+			"start_offset_days": period.StartOffsetDays,
+			"end_offset_days":   period.EndOffsetDays,
+			"action":            period.Action,
+			"is_active":         period.IsActive,
+			"updated_at":        time.Now(),
+		},
+	}
+
+	var periodUpdated BlackoutPeriod
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := r.collectionBlackout.FindOneAndUpdate(ctx, filter, update, opts).Decode(&periodUpdated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &periodUpdated, nil
+
+}
+
+func (r *leaveRepository) DeleteBlackoutPeriod(ctx context.Context, organizationID string, id primitive.ObjectID) error {
+
+	result, err := r.collectionBlackout.DeleteOne(ctx, bson.M{"_id": id, "organization_id": organizationID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+
+}
@@ -0,0 +1,181 @@
+package leave
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+	"worktime-service/internal/gateway"
+	"worktime-service/internal/gateway/dto/response"
+)
+
+// termsByID answers the term service from a fixed set of terms; the
+// organization's current term is stored under the empty id.
+type termsByID struct {
+	gateway.TermGateway
+	terms map[string]*response.TermResponse
+}
+
+func (g *termsByID) GetTermByID(ctx context.Context, id string) (*response.TermResponse, error) {
+
+	term, ok := g.terms[id]
+	if !ok || id == "" {
+		return nil, fmt.Errorf("term %s not found", id)
+	}
+
+	return term, nil
+
+}
+
+func (g *termsByID) GetCurrentTermByOrgID(ctx context.Context, orgID string) (*response.TermResponse, error) {
+	return g.GetTermByID(ctx, "current")
+}
+
+func TestCheckBlackout(t *testing.T) {
+
+	exams := &blackoutWindow{
+		period:    &BlackoutPeriod{Name: "Exams", Action: BlackoutActionReject},
+		startDate: parseDay("2030-06-10"),
+		endDate:   parseDay("2030-06-14"),
+	}
+	firstWeek := &blackoutWindow{
+		period:    &BlackoutPeriod{Name: "First week", Action: BlackoutActionRequireApproval},
+		startDate: parseDay("2030-09-02"),
+		endDate:   parseDay("2030-09-06"),
+	}
+	windows := []*blackoutWindow{exams, firstWeek}
+
+	tests := []struct {
+		name         string
+		dates        []string
+		wantRejected []string
+		wantApproval *blackoutWindow
+	}{
+		{name: "clear of every window", dates: []string{"2030-06-07", "2030-06-17"}},
+		{name: "last day of the exams", dates: []string{"2030-06-14"}, wantRejected: []string{"2030-06-14"}},
+		{name: "range into the exams is reported once", dates: []string{"2030-06-06", "2030-06-10", "2030-06-11"}, wantRejected: []string{"2030-06-10"}},
+		{name: "first week goes to review", dates: []string{"2030-09-06"}, wantApproval: firstWeek},
+		{
+			name:         "range across both windows",
+			dates:        []string{"2030-06-14", "2030-09-02"},
+			wantRejected: []string{"2030-06-14"},
+			wantApproval: firstWeek,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var dates []time.Time
+			for _, date := range tt.dates {
+				dates = append(dates, parseDay(date))
+			}
+
+			violations, approval := checkBlackout(windows, LeaveTypeAnnual, dates)
+
+			var rejected []string
+			for _, violation := range violations {
+				if violation.Code != ViolationBlackout {
+					t.Errorf("violation code = %s, want %s", violation.Code, ViolationBlackout)
+				}
+				rejected = append(rejected, violation.Date)
+			}
+
+			if fmt.Sprint(rejected) != fmt.Sprint(tt.wantRejected) {
+				t.Errorf("rejected days = %v, want %v", rejected, tt.wantRejected)
+			}
+			if approval != tt.wantApproval {
+				t.Errorf("approval window = %v, want %v", approval, tt.wantApproval)
+			}
+
+		})
+	}
+
+}
+
+func TestGetBlackoutWindowsResolvesTerms(t *testing.T) {
+
+	absoluteStart, absoluteEnd := parseDay("2030-12-20"), parseDay("2031-01-03")
+
+	terms := &termsByID{terms: map[string]*response.TermResponse{
+		"current": {ID: "current", StartDate: "2030-09-02", EndDate: "2030-12-19T00:00:00Z"},
+		"spring":  {ID: "spring", StartDate: "2031-01-06", EndDate: "2031-05-30"},
+	}}
+
+	tests := []struct {
+		name      string
+		period    *BlackoutPeriod
+		wantStart string
+		wantEnd   string
+		wantNone  bool
+		wantErr   bool
+	}{
+		{
+			name:      "absolute dates are used as they are",
+			period:    &BlackoutPeriod{Mode: BlackoutAbsolute, StartDate: &absoluteStart, EndDate: &absoluteEnd},
+			wantStart: "2030-12-20",
+			wantEnd:   "2031-01-03",
+		},
+		{
+			name:      "unpinned period follows the current term",
+			period:    &BlackoutPeriod{Mode: BlackoutTermRelative, Anchor: BlackoutAnchorTermStart, EndOffsetDays: 4},
+			wantStart: "2030-09-02",
+			wantEnd:   "2030-09-06",
+		},
+		{
+			name:      "pinned period follows its term's end",
+			period:    &BlackoutPeriod{Mode: BlackoutTermRelative, TermID: "spring", Anchor: BlackoutAnchorTermEnd, StartOffsetDays: -11, EndOffsetDays: 0},
+			wantStart: "2031-05-19",
+			wantEnd:   "2031-05-30",
+		},
+		{
+			name:     "inactive period is skipped",
+			period:   &BlackoutPeriod{Mode: BlackoutAbsolute, StartDate: &absoluteStart, EndDate: &absoluteEnd},
+			wantNone: true,
+		},
+		{
+			name:    "missing term fails instead of letting leave through",
+			period:  &BlackoutPeriod{Mode: BlackoutTermRelative, TermID: "autumn", Anchor: BlackoutAnchorTermStart},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			tt.period.Name = tt.name
+			tt.period.IsActive = !tt.wantNone
+
+			service := &leaveService{
+				leaveRepository: &fakeLeaveRepository{blackouts: []*BlackoutPeriod{tt.period}},
+				termGateway:     terms,
+			}
+
+			windows, err := service.getBlackoutWindows(context.Background(), "org-1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getBlackoutWindows error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if tt.wantNone {
+				if len(windows) != 0 {
+					t.Errorf("windows = %d, want none", len(windows))
+				}
+				return
+			}
+
+			if len(windows) != 1 {
+				t.Fatalf("windows = %d, want 1", len(windows))
+			}
+
+			got := windows[0]
+			if got.startDate.Format("2006-01-02") != tt.wantStart || got.endDate.Format("2006-01-02") != tt.wantEnd {
+				t.Errorf("window = %s to %s, want %s to %s", got.startDate.Format("2006-01-02"), got.endDate.Format("2006-01-02"), tt.wantStart, tt.wantEnd)
+			}
+
+		})
+	}
+
+}
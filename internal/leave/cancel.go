@@ -0,0 +1,454 @@
+package leave
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (s *leaveService) CancelRequestLeave(ctx context.Context, req *CancelLeaveRequest, id string) (*LeaveRequests, error) {
+
+	if req.RequestedBy == "" {
+		return nil, fmt.Errorf("requested by is required")
+	}
+
+	leaveItem, err := s.getLeave(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.cancelLeave(ctx, leaveItem, req)
+
+}
+
+// cancelLeave cancels a pending request straight away. A confirmed leave is
+// cancelled straight away too, unless it has started or starts within the
+// organization's CancelNoticeDays; then a manager has to approve it first.
+func (s *leaveService) cancelLeave(ctx context.Context, leaveItem *LeaveRequests, req *CancelLeaveRequest) (*LeaveRequests, error) {
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentUser.ID != leaveItem.UserID && !isOrganizationAdmin(currentUser) {
+		return nil, fmt.Errorf("only the requester or an organization admin can cancel this leave")
+	}
+
+	if leaveItem.Status != "pending" && leaveItem.Status != "confirmed" {
+		return nil, fmt.Errorf("leave request is %s and cannot be cancelled", leaveItem.Status)
+	}
+
+	if leaveItem.Cancellation != nil && leaveItem.Cancellation.Status == CancellationPending {
+		return nil, fmt.Errorf("cancellation is already waiting for approval")
+	}
+
+	cancellation := LeaveCancellation{
+		Status:      CancellationApproved,
+		Reason:      req.Reason,
+		RequestedBy: req.RequestedBy,
+		RequestedAt: time.Now(),
+	}
+
+	if leaveItem.Status == "confirmed" {
+
+		setting, err := s.leaveRepository.GetSettings(ctx, leaveItem.OrganizationID)
+		if err != nil {
+			return nil, err
+		}
+
+		today := leaveToday(time.Now())
+		noticeEnd := today.AddDate(0, 0, setting.CancelNoticeDays)
+
+		dates := leaveItem.Dates()
+		if dates[len(dates)-1].Before(today) {
+			return nil, fmt.Errorf("every day of this leave has already been taken")
+		}
+
+		if dates[0].Before(noticeEnd) {
+			cancellation.Status = CancellationPending
+
+			err = s.leaveRepository.RequestCancellation(ctx, leaveItem, &cancellation)
+			if err != nil {
+				return nil, err
+			}
+
+			return leaveItem, nil
+		}
+	}
+
+	err = s.completeCancellation(ctx, leaveItem, &cancellation)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaveItem, nil
+
+}
+
+func (s *leaveService) ApproveCancellation(ctx context.Context, req *ReviewLeaveRequest, id string) (*LeaveRequests, error) {
+
+	leaveItem, err := s.getPendingCancellation(ctx, req, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	cancellation := *leaveItem.Cancellation
+	cancellation.Status = CancellationApproved
+	cancellation.ReviewerID = req.ReviewerID
+	cancellation.Comment = req.Comment
+	cancellation.ReviewedAt = &now
+
+	err = s.completeCancellation(ctx, leaveItem, &cancellation)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaveItem, nil
+
+}
+
+func (s *leaveService) RejectCancellation(ctx context.Context, req *ReviewLeaveRequest, id string) (*LeaveRequests, error) {
+
+	leaveItem, err := s.getPendingCancellation(ctx, req, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	cancellation := *leaveItem.Cancellation
+	cancellation.Status = CancellationRejected
+	cancellation.ReviewerID = req.ReviewerID
+	cancellation.Comment = req.Comment
+	cancellation.ReviewedAt = &now
+
+	err = s.leaveRepository.RejectCancellation(ctx, leaveItem, &cancellation)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaveItem, nil
+
+}
+
+// completeCancellation releases the days that have not been taken yet,
+// refunds them and lets the wish list into the freed slots. A leave already
+// under way is trimmed to the days taken and stays confirmed on them, still
+// charged; one that has not started is cancelled outright.
+func (s *leaveService) completeCancellation(ctx context.Context, leaveItem *LeaveRequests, cancellation *LeaveCancellation) error {
+
+	wasConfirmed := leaveItem.Status == "confirmed"
+	today := leaveToday(time.Now())
+
+	taken := false
+	for _, date := range leaveItem.Dates() {
+		if !wasConfirmed || !date.Before(today) {
+			cancellation.ReleasedDates = append(cancellation.ReleasedDates, date)
+		} else {
+			taken = true
+		}
+	}
+
+	if taken && len(cancellation.ReleasedDates) == 0 {
+		return fmt.Errorf("every day of this leave has already been taken")
+	}
+
+	var err error
+	if taken {
+		err = s.leaveRepository.TrimLeave(ctx, leaveItem, cancellation.ReleasedDates, cancellation)
+	} else {
+		err = s.leaveRepository.CancelLeave(ctx, leaveItem, cancellation)
+	}
+	if err != nil {
+		return err
+	}
+
+	if !wasConfirmed {
+		return nil
+	}
+
+	err = s.refundLeaveBalance(ctx, leaveItem, cancellation.ReleasedDates)
+	if err != nil {
+		return err
+	}
+
+	if leaveItem.LeavePolicy().ConsumesSlot {
+		return s.promoteWishlist(ctx, leaveItem.OrganizationID, cancellation.ReleasedDates)
+	}
+
+	return nil
+
+}
+
+// undoLeave cancels a request whose balance could not be charged, so the
+// caller gets the error without a booking left behind. Comp-off credits a
+// partial spend already took are handed back. It returns cause, joined with
+// anything that went wrong undoing it.
+func (s *leaveService) undoLeave(ctx context.Context, leaveItem *LeaveRequests, cause error) error {
+
+	now := time.Now()
+	reason := fmt.Sprintf("Balance could not be charged: %v", cause)
+
+	cancellation := LeaveCancellation{
+		Status:        CancellationApproved,
+		Reason:        &reason,
+		RequestedBy:   AuditActorSystem,
+		RequestedAt:   now,
+		ReviewerID:    AuditActorSystem,
+		ReviewedAt:    &now,
+		ReleasedDates: leaveItem.Dates(),
+	}
+
+	err := s.leaveRepository.CancelLeave(ctx, leaveItem, &cancellation)
+	if err != nil {
+		return errors.Join(cause, fmt.Errorf("cancel leave %s: %w", leaveItem.ID.Hex(), err))
+	}
+
+	if leaveItem.LeavePolicy().UsesCompOff {
+		err = s.refundCompOff(ctx, leaveItem, leaveItem.Dates())
+		if err != nil {
+			return errors.Join(cause, fmt.Errorf("refund comp-off of leave %s: %w", leaveItem.ID.Hex(), err))
+		}
+	}
+
+	if leaveItem.LeavePolicy().ConsumesSlot {
+		err = s.promoteWishlist(ctx, leaveItem.OrganizationID, cancellation.ReleasedDates)
+		if err != nil {
+			return errors.Join(cause, err)
+		}
+	}
+
+	return cause
+
+}
+
+func (s *leaveService) getPendingCancellation(ctx context.Context, req *ReviewLeaveRequest, id string) (*LeaveRequests, error) {
+
+	if req.ReviewerID == "" {
+		return nil, fmt.Errorf("reviewer id is required")
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "review cancellations")
+	if err != nil {
+		return nil, err
+	}
+
+	leaveItem, err := s.leaveRepository.GetLeaveByID(ctx, currentUser.OrganizationIdActive, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if leaveItem.Cancellation == nil || leaveItem.Cancellation.Status != CancellationPending {
+		return nil, fmt.Errorf("no cancellation is waiting for approval")
+	}
+
+	// A late cancellation needs someone other than the requester to agree.
+	reviewers := []string{req.ReviewerID, currentUser.ID}
+	for _, reviewerID := range reviewers {
+		if reviewerID == leaveItem.UserID || reviewerID == leaveItem.Cancellation.RequestedBy {
+			return nil, fmt.Errorf("you cannot review your own cancellation")
+		}
+	}
+
+	return leaveItem, nil
+
+}
+
+func (s *leaveService) getLeave(ctx context.Context, id string) (*LeaveRequests, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetLeaveByID(ctx, organizationID, objectID)
+
+}
+
+// CancelLeave marks the request cancelled and hands back its slots on the
+// released dates. The status condition makes a second cancel, or a cancel
+// racing an approval, fail instead of releasing the slots twice.
+func (r *leaveRepository) CancelLeave(ctx context.Context, leaveItem *LeaveRequests, cancellation *LeaveCancellation) error {
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":                 leaveItem.ID,
+		"status":              leaveItem.Status,
+		"cancellation.status": bson.M{"$ne": CancellationApproved},
+	}, bson.M{
+		"$set": bson.M{
+			"status":       "cancelled",
+			"cancellation": cancellation,
+		},
+		"$unset": bson.M{"reschedule": ""},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return errLeaveChanged
+	}
+
+	for _, date := range cancellation.ReleasedDates {
+		err = r.releaseDailyLeaveSlot(ctx, date, leaveItem)
+		if err != nil {
+			return err
+		}
+	}
+
+	if leaveItem.Reschedule != nil {
+		err = r.dropQueuedReschedule(ctx, leaveItem)
+		if err != nil {
+			return err
+		}
+		leaveItem.Reschedule = nil
+	}
+
+	leaveItem.Status = "cancelled"
+	leaveItem.Cancellation = cancellation
+
+	return nil
+
+}
+
+// TrimLeave takes the released days off a confirmed leave and gives back
+// their slots; the leave stays confirmed on the days it keeps. With a
+// cancellation, the trim is the cancellation of a leave already under way:
+// it is recorded on the leave and any move still queued is dropped.
+func (r *leaveRepository) TrimLeave(ctx context.Context, leaveItem *LeaveRequests, released []time.Time, cancellation *LeaveCancellation) error {
+
+	var kept []time.Time
+	for _, date := range leaveItem.Dates() {
+		if !slices.ContainsFunc(released, date.Equal) {
+			kept = append(kept, date)
+		}
+	}
+
+	if len(kept) == 0 {
+		return fmt.Errorf("trimming would leave no days on leave %s", leaveItem.ID.Hex())
+	}
+
+	totalDays := float64(len(kept)) * portionDays(leaveItem.Portion)
+
+	filter := bson.M{
+		"_id":    leaveItem.ID,
+		"status": "confirmed",
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"leave_date":  kept[0],
+			"start_date":  kept[0],
+			"end_date":    kept[len(kept)-1],
+			"leave_dates": kept,
+			"total_days":  totalDays,
+		},
+	}
+
+	if cancellation != nil {
+		filter["cancellation.status"] = bson.M{"$ne": CancellationApproved}
+		update["$set"].(bson.M)["cancellation"] = cancellation
+		update["$unset"] = bson.M{"reschedule": ""}
+	} else {
+		filter["reschedule"] = bson.M{"$exists": false}
+	}
+
+	result, err := r.collectionLeave.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return errLeaveChanged
+	}
+
+	for _, date := range released {
+		err = r.releaseDailyLeaveSlot(ctx, date, leaveItem)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cancellation != nil && leaveItem.Reschedule != nil {
+		err = r.dropQueuedReschedule(ctx, leaveItem)
+		if err != nil {
+			return err
+		}
+		leaveItem.Reschedule = nil
+	}
+
+	leaveItem.LeaveDate = kept[0]
+	leaveItem.StartDate = kept[0]
+	leaveItem.EndDate = kept[len(kept)-1]
+	leaveItem.LeaveDates = kept
+	leaveItem.TotalDays = totalDays
+	if cancellation != nil {
+		leaveItem.Cancellation = cancellation
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) RequestCancellation(ctx context.Context, leaveItem *LeaveRequests, cancellation *LeaveCancellation) error {
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":                 leaveItem.ID,
+		"status":              "confirmed",
+		"cancellation.status": bson.M{"$ne": CancellationPending},
+	}, bson.M{
+		"$set": bson.M{"cancellation": cancellation},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return fmt.Errorf("leave request can no longer be cancelled")
+	}
+
+	leaveItem.Cancellation = cancellation
+
+	return nil
+
+}
+
+func (r *leaveRepository) RejectCancellation(ctx context.Context, leaveItem *LeaveRequests, cancellation *LeaveCancellation) error {
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":                 leaveItem.ID,
+		"status":              "confirmed",
+		"cancellation.status": CancellationPending,
+	}, bson.M{
+		"$set": bson.M{"cancellation": cancellation},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return fmt.Errorf("no cancellation is waiting for approval")
+	}
+
+	leaveItem.Cancellation = cancellation
+
+	return nil
+
+}
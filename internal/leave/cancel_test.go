@@ -0,0 +1,136 @@
+package leave
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCompleteCancellation(t *testing.T) {
+
+	today := leaveToday(time.Now())
+	yesterday, tomorrow := today.AddDate(0, 0, -1), today.AddDate(0, 0, 1)
+
+	tests := []struct {
+		name         string
+		status       string
+		dates        []time.Time
+		wantStatus   string
+		wantKept     []time.Time
+		wantReleased []time.Time
+		wantRefund   float64
+	}{
+		{
+			name:         "pending request is cancelled outright",
+			status:       "pending",
+			dates:        []time.Time{yesterday, today},
+			wantStatus:   "cancelled",
+			wantKept:     []time.Time{yesterday, today},
+			wantReleased: []time.Time{yesterday, today},
+		},
+		{
+			name:         "confirmed leave not started is cancelled and refunded",
+			status:       "confirmed",
+			dates:        []time.Time{tomorrow},
+			wantStatus:   "cancelled",
+			wantKept:     []time.Time{tomorrow},
+			wantReleased: []time.Time{tomorrow},
+			wantRefund:   1,
+		},
+		{
+			name:         "started leave keeps the days taken",
+			status:       "confirmed",
+			dates:        []time.Time{yesterday, today, tomorrow},
+			wantStatus:   "confirmed",
+			wantKept:     []time.Time{yesterday},
+			wantReleased: []time.Time{today, tomorrow},
+			wantRefund:   2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			leaveItem := &LeaveRequests{
+				ID:             primitive.NewObjectID(),
+				OrganizationID: "org-1",
+				UserID:         "teacher-1",
+				StartDate:      tt.dates[0],
+				EndDate:        tt.dates[len(tt.dates)-1],
+				LeaveDates:     tt.dates,
+				Portion:        PortionFull,
+				LeaveType:      LeaveTypeAnnual,
+				Policy:         &LeavePolicy{DeductsBalance: true},
+				Status:         tt.status,
+			}
+
+			repository := &fakeLeaveRepository{}
+			service := &leaveService{leaveRepository: repository}
+
+			cancellation := &LeaveCancellation{Status: CancellationApproved, RequestedBy: "teacher-1"}
+
+			err := service.completeCancellation(context.Background(), leaveItem, cancellation)
+			if err != nil {
+				t.Fatalf("completeCancellation: %v", err)
+			}
+
+			if leaveItem.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", leaveItem.Status, tt.wantStatus)
+			}
+			if !slices.EqualFunc(leaveItem.Dates(), tt.wantKept, time.Time.Equal) {
+				t.Errorf("leave dates = %v, want %v", leaveItem.Dates(), tt.wantKept)
+			}
+			if !slices.EqualFunc(cancellation.ReleasedDates, tt.wantReleased, time.Time.Equal) {
+				t.Errorf("released = %v, want %v", cancellation.ReleasedDates, tt.wantReleased)
+			}
+			if leaveItem.Cancellation != cancellation {
+				t.Errorf("cancellation was not recorded on the leave")
+			}
+
+			var refunded float64
+			for _, transaction := range repository.transactions {
+				if transaction.TransactionType == TransactionRefund {
+					refunded += transaction.Amount
+				}
+			}
+			if refunded != tt.wantRefund {
+				t.Errorf("refunded = %v, want %v", refunded, tt.wantRefund)
+			}
+
+		})
+	}
+
+}
+
+func TestCancelLeaveRefusesLeaveAlreadyTaken(t *testing.T) {
+
+	yesterday := leaveToday(time.Now()).AddDate(0, 0, -1)
+
+	leaveItem := &LeaveRequests{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: "org-1",
+		UserID:         "teacher-1",
+		StartDate:      yesterday,
+		EndDate:        yesterday,
+		LeaveDates:     []time.Time{yesterday},
+		Portion:        PortionFull,
+		Status:         "confirmed",
+	}
+
+	service := &leaveService{
+		leaveRepository: &fakeLeaveRepository{},
+		userService: &fakeUserService{
+			currentUser: &user.CurrentUser{ID: "teacher-1", OrganizationIdActive: "org-1"},
+		},
+	}
+
+	_, err := service.cancelLeave(context.Background(), leaveItem, &CancelLeaveRequest{RequestedBy: "teacher-1"})
+	if err == nil {
+		t.Fatalf("cancelLeave accepted a leave that has already been taken")
+	}
+
+}
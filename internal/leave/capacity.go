@@ -0,0 +1,267 @@
+package leave
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// matches reports whether the rule applies to the day. An empty weekday
+// list means every weekday and a missing bound leaves that side open.
+func (rule *CapacityRule) matches(date time.Time) bool {
+
+	if !rule.IsActive {
+		return false
+	}
+
+	if len(rule.Weekdays) > 0 && !slices.Contains(rule.Weekdays, date.Weekday()) {
+		return false
+	}
+
+	if rule.StartDate != nil && date.Before(*rule.StartDate) {
+		return false
+	}
+
+	if rule.EndDate != nil && date.After(*rule.EndDate) {
+		return false
+	}
+
+	return true
+
+}
+
+// specificity ranks rules of equal priority: a date range is narrower than
+// a weekday pattern, and both together are narrower still.
+func (rule *CapacityRule) specificity() int {
+
+	score := 0
+
+	if rule.StartDate != nil || rule.EndDate != nil {
+		score += 2
+	}
+
+	if len(rule.Weekdays) > 0 {
+		score++
+	}
+
+	return score
+
+}
+
+// resolveCapacity picks the rule that sets the day's capacity, falling back
+// to the organization's MaxEmployeesPerDay when none matches.
+func resolveCapacity(setting *Setting, rules []*CapacityRule, date time.Time) (int, *CapacityRule) {
+
+	var chosen *CapacityRule
+
+	for _, rule := range rules {
+
+		if !rule.matches(date) {
+			continue
+		}
+
+		if chosen == nil ||
+			rule.Priority > chosen.Priority ||
+			rule.Priority == chosen.Priority && rule.specificity() > chosen.specificity() ||
+			rule.Priority == chosen.Priority && rule.specificity() == chosen.specificity() && rule.UpdatedAt.After(chosen.UpdatedAt) {
+			chosen = rule
+		}
+	}
+
+	if chosen == nil {
+		return setting.MaxEmployeesPerDay, nil
+	}
+
+	return chosen.MaxSlot, chosen
+
+}
+
+// resolveDailyCapacity applies the organization's capacity rules to a day
+// that is about to get its slot.
+func (r *leaveRepository) resolveDailyCapacity(ctx context.Context, organizationID string, date time.Time) (int, *primitive.ObjectID, error) {
+
+	setting, err := r.GetSettings(ctx, organizationID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rules, err := r.GetCapacityRules(ctx, organizationID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	maxSlot, rule := resolveCapacity(setting, rules, date)
+	if rule == nil {
+		return maxSlot, nil, nil
+	}
+
+	return maxSlot, &rule.ID, nil
+
+}
+
+func (r *leaveRepository) GetCapacityRules(ctx context.Context, organizationID string) ([]*CapacityRule, error) {
+
+	var rules []*CapacityRule
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "priority", Value: -1}, {Key: "created_at", Value: 1}})
+
+	cursor, err := r.collectionCapacityRule.Find(ctx, bson.M{"organization_id": organizationID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if rules == nil {
+		return []*CapacityRule{}, nil
+	}
+
+	return rules, nil
+
+}
+
+func (r *leaveRepository) CreateCapacityRule(ctx context.Context, rule *CapacityRule) error {
+
+	_, err := r.collectionCapacityRule.InsertOne(ctx, rule)
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) UpdateCapacityRule(ctx context.Context, rule *CapacityRule, id primitive.ObjectID) (*CapacityRule, error) {
+
+	filter := bson.M{"_id": id, "organization_id": rule.OrganizationID}
+
+	update := bson.M{
+		"$set": bson.M{
+			"name":       rule.Name,
+			"weekdays":   rule.Weekdays,
+			"start_date": rule.StartDate,
+			"end_date":   rule.EndDate,
+			"max_slot":   rule.MaxSlot,
+			"priority":   rule.Priority,
+			"is_active":  rule.IsActive,
+			"updated_at": time.Now(),
+		},
+	}
+
+	var ruleUpdated CapacityRule
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := r.collectionCapacityRule.FindOneAndUpdate(ctx, filter, update, opts).Decode(&ruleUpdated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ruleUpdated, nil
+
+}
+
+func (r *leaveRepository) DeleteCapacityRule(ctx context.Context, organizationID string, id primitive.ObjectID) error {
+
+	result, err := r.collectionCapacityRule.DeleteOne(ctx, bson.M{"_id": id, "organization_id": organizationID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+
+}
+
+// RecomputeDailyLeaveSlots re-applies the capacity rules to every slot from
+// the given day on. Days set by hand through EditMaxSlot and public
+// holidays keep their capacity. It returns the days that gained capacity,
+// so their wish lists can be promoted.
+func (r *leaveRepository) RecomputeDailyLeaveSlots(ctx context.Context, organizationID string, from time.Time) ([]time.Time, error) {
+
+	setting, err := r.GetSettings(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := r.GetCapacityRules(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.collectionDailyLeaveSlots.Find(ctx, bson.M{
+		"organization_id": organizationID,
+		"date":            bson.M{"$gte": from},
+		"manual_override": bson.M{"$ne": true},
+		"is_holiday":      bson.M{"$ne": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var dailyLeaveSlots []*DailyLeaveSolt
+	if err := cursor.All(ctx, &dailyLeaveSlots); err != nil {
+		return nil, err
+	}
+
+	var increased []time.Time
+
+	for _, dailyLeaveSlot := range dailyLeaveSlots {
+
+		maxSlot, rule := resolveCapacity(setting, rules, dailyLeaveSlot.Date)
+		if maxSlot == dailyLeaveSlot.MaxSlot {
+			continue
+		}
+
+		var capacityRuleID *primitive.ObjectID
+		if rule != nil {
+			capacityRuleID = &rule.ID
+		}
+
+		// The free counters are derived from the confirmed list inside the
+		// update itself, so a booking landing meanwhile is still counted.
+		// Pooled bookings are held by their pool and left out.
+		usedAM := sharedUsage(PortionAM)
+		usedPM := sharedUsage(PortionPM)
+
+		_, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, bson.M{
+			"_id":             dailyLeaveSlot.ID,
+			"manual_override": bson.M{"$ne": true},
+		}, mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"max_slot":          maxSlot,
+				"capacity_rule_id":  capacityRuleID,
+				"available_am_slot": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{maxSlot, usedAM}}}},
+				"available_pm_slot": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{maxSlot, usedPM}}}},
+				"updated_at":        time.Now(),
+			}}},
+			{{Key: "$set", Value: bson.M{
+				"available_slot": bson.M{"$min": bson.A{"$available_am_slot", "$available_pm_slot"}},
+			}}},
+		})
+		if err != nil {
+			return increased, err
+		}
+
+		if maxSlot > dailyLeaveSlot.MaxSlot {
+			increased = append(increased, dailyLeaveSlot.Date)
+		}
+	}
+
+	return increased, nil
+
+}
@@ -0,0 +1,54 @@
+package leave
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolveCapacity(t *testing.T) {
+
+	// 2030-07-01, the day being resolved, is a Monday.
+	july := time.Date(2030, time.July, 1, 0, 0, 0, 0, time.UTC)
+	julyEnd := time.Date(2030, time.July, 31, 0, 0, 0, 0, time.UTC)
+	earlier := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+	later := time.Date(2030, time.February, 1, 0, 0, 0, 0, time.UTC)
+
+	weekdays := &CapacityRule{Name: "mondays", Weekdays: []time.Weekday{time.Monday}, MaxSlot: 2, IsActive: true}
+	summer := &CapacityRule{Name: "summer", StartDate: &july, EndDate: &julyEnd, MaxSlot: 5, IsActive: true}
+	summerMondays := &CapacityRule{Name: "summer mondays", Weekdays: []time.Weekday{time.Monday}, StartDate: &july, EndDate: &julyEnd, MaxSlot: 4, IsActive: true}
+	urgent := &CapacityRule{Name: "urgent", Weekdays: []time.Weekday{time.Monday}, MaxSlot: 1, Priority: 10, IsActive: true}
+	inactive := &CapacityRule{Name: "inactive", MaxSlot: 9, Priority: 99}
+	fridays := &CapacityRule{Name: "fridays", Weekdays: []time.Weekday{time.Friday}, MaxSlot: 7, IsActive: true}
+	older := &CapacityRule{Name: "older", MaxSlot: 6, IsActive: true, UpdatedAt: earlier}
+	newer := &CapacityRule{Name: "newer", MaxSlot: 8, IsActive: true, UpdatedAt: later}
+
+	setting := &Setting{MaxEmployeesPerDay: 3}
+
+	tests := []struct {
+		name  string
+		rules []*CapacityRule
+		want  int
+		rule  *CapacityRule
+	}{
+		{name: "no rules falls back to the setting", want: 3},
+		{name: "rules that do not match fall back to the setting", rules: []*CapacityRule{fridays, inactive}, want: 3},
+		{name: "date range beats weekday at equal priority", rules: []*CapacityRule{weekdays, summer}, want: 5, rule: summer},
+		{name: "range and weekday beat a range alone", rules: []*CapacityRule{summer, summerMondays}, want: 4, rule: summerMondays},
+		{name: "higher priority beats a narrower rule", rules: []*CapacityRule{summerMondays, urgent}, want: 1, rule: urgent},
+		{name: "latest update breaks a full tie", rules: []*CapacityRule{newer, older}, want: 8, rule: newer},
+		{name: "order of the rules does not matter", rules: []*CapacityRule{older, newer}, want: 8, rule: newer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got, rule := resolveCapacity(setting, tt.rules, july)
+
+			if got != tt.want || rule != tt.rule {
+				t.Errorf("resolveCapacity = %d (%v), want %d (%v)", got, rule, tt.want, tt.rule)
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,321 @@
+package leave
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// leaveYear is the period a UserLeaveBalance covers. Year is the calendar
+// year the period starts in, so a school year from September 2025 to
+// August 2026 is 2025.
+type leaveYear struct {
+	Year  int
+	Start time.Time
+	End   time.Time
+}
+
+// leaveYearOf returns the leave year containing date under the setting's
+// boundary.
+func (setting *Setting) leaveYearOf(date time.Time) leaveYear {
+
+	month, day := time.January, 1
+	if setting.YearBoundary == YearBoundarySchool && setting.YearStartMonth > 0 && setting.YearStartDay > 0 {
+		month, day = time.Month(setting.YearStartMonth), setting.YearStartDay
+	}
+
+	start := time.Date(date.Year(), month, day, 0, 0, 0, 0, time.UTC)
+	if date.Before(start) {
+		start = start.AddDate(-1, 0, 0)
+	}
+
+	return leaveYear{
+		Year:  start.Year(),
+		Start: start,
+		End:   start.AddDate(1, 0, 0),
+	}
+
+}
+
+// leaveYearShare is the part of a leave's days that falls in one leave
+// year. Date is the leave's first day in that year.
+type leaveYearShare struct {
+	Year int
+	Date time.Time
+	Days float64
+}
+
+// splitByLeaveYear groups dates by the leave year they fall in, oldest year
+// first, each date counting perDay days. A leave across the boundary is
+// charged to both years rather than all to the year it starts in.
+func (setting *Setting) splitByLeaveYear(dates []time.Time, perDay float64) []leaveYearShare {
+
+	dates = slices.SortedFunc(slices.Values(dates), time.Time.Compare)
+
+	var shares []leaveYearShare
+	for _, date := range dates {
+		year := setting.leaveYearOf(date).Year
+		if len(shares) == 0 || shares[len(shares)-1].Year != year {
+			shares = append(shares, leaveYearShare{Year: year, Date: date})
+		}
+		shares[len(shares)-1].Days += perDay
+	}
+
+	for i := range shares {
+		shares[i].Days = math.Round(shares[i].Days*100) / 100
+	}
+
+	return shares
+
+}
+
+// capShares keeps the first amount days of shares, oldest year first, for
+// when fewer days moved than the leave asked for.
+func capShares(shares []leaveYearShare, amount float64) []leaveYearShare {
+
+	var capped []leaveYearShare
+	for _, share := range shares {
+		if amount <= 1e-9 {
+			break
+		}
+		share.Days = math.Round(min(share.Days, amount)*100) / 100
+		amount -= share.Days
+		capped = append(capped, share)
+	}
+
+	return capped
+
+}
+
+// month returns which month of the leave year date falls in, from 1 to 12.
+func (period leaveYear) month(date time.Time) int {
+
+	months := (date.Year()-period.Start.Year())*12 + int(date.Month()-period.Start.Month())
+	if date.Day() < period.Start.Day() {
+		months--
+	}
+
+	return min(max(months+1, 1), 12)
+
+}
+
+// balanceSetting returns the organization's setting for balance periods.
+// Balances without an organization follow the calendar year.
+func (s *leaveService) balanceSetting(ctx context.Context, organizationID string) (*Setting, error) {
+
+	if organizationID == "" {
+		return &Setting{}, nil
+	}
+
+	return s.leaveRepository.GetSettings(ctx, organizationID)
+
+}
+
+// schoolYearStart reads the first day of the school year from the term
+// service, from the given term or the organization's current one.
+func (s *leaveService) schoolYearStart(ctx context.Context, organizationID string, termID string) (time.Time, error) {
+
+	term, err := s.getTerm(ctx, organizationID, termID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("get school year from term service: %w", err)
+	}
+
+	return termAnchorDate(term, BlackoutAnchorTermStart)
+
+}
+
+// carryOverLeaveBalance rolls the previous leave year into period: the
+// unused balance, up to the organization's cap, is credited once as
+// CARRY_OVER, and what is left of it past the expiry is taken back as
+// EXPIRED. Both steps are safe to repeat.
+func (s *leaveService) carryOverLeaveBalance(ctx context.Context, userID string, setting *Setting, period leaveYear, now time.Time) error {
+
+	if setting.CarryOverMaxDays > 0 {
+		err := s.carryOverPreviousYear(ctx, userID, setting, period, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	balance, err := s.leaveRepository.ExpireCarryOver(ctx, setting.OrganizationID, userID, period.Year, now)
+	if err != nil {
+		return err
+	}
+
+	if balance == nil || balance.CarryOverExpired <= 0 {
+		return nil
+	}
+
+	reason := fmt.Sprintf("Carried-over leave expired - %s", balance.CarryOverExpiresAt.Format("2006-01-02"))
+
+	return s.leaveRepository.CreateLeaveTransaction(ctx, []*LeaveTransaction{
+		{
+			ID:              primitive.NewObjectID(),
+			OrganizationID:  setting.OrganizationID,
+			UserID:          userID,
+			Year:            period.Year,
+			TransactionType: TransactionExpire,
+			Amount:          balance.CarryOverExpired,
+			Date:            *balance.CarryOverExpiresAt,
+			Reason:          &reason,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+		},
+	})
+
+}
+
+func (s *leaveService) carryOverPreviousYear(ctx context.Context, userID string, setting *Setting, period leaveYear, now time.Time) error {
+
+	previousPeriod := setting.leaveYearOf(period.Start.AddDate(0, 0, -1))
+
+	previous, err := s.leaveRepository.FindLeaveBalance(ctx, setting.OrganizationID, userID, previousPeriod.Year)
+	if err != nil || previous == nil {
+		return err
+	}
+
+	// Credit any month of the old year the accrual has not reached yet,
+	// so the carry-over is worked out on the final balance.
+	previous, err = s.accrueLeaveYear(ctx, userID, setting, previousPeriod, previousPeriod.End.AddDate(0, 0, -1))
+	if err != nil {
+		return err
+	}
+
+	amount := math.Max(math.Min(previous.RemaindingLeave, setting.CarryOverMaxDays), 0)
+
+	var expiresAt *time.Time
+	if setting.CarryOverExpiryMonths > 0 {
+		expiry := period.Start.AddDate(0, setting.CarryOverExpiryMonths, 0)
+		expiresAt = &expiry
+	}
+
+	if _, err := s.leaveRepository.GetLeaveBalance(ctx, setting.OrganizationID, userID, period.Year); err != nil {
+		return err
+	}
+
+	carried, err := s.leaveRepository.CarryOverLeaveBalance(ctx, setting.OrganizationID, userID, period.Year, amount, expiresAt)
+	if err != nil || !carried || amount == 0 {
+		return err
+	}
+
+	reason := fmt.Sprintf("Carried over from %d (%.1f unused, cap %.1f)", previousPeriod.Year, previous.RemaindingLeave, setting.CarryOverMaxDays)
+
+	return s.leaveRepository.CreateLeaveTransaction(ctx, []*LeaveTransaction{
+		{
+			ID:              primitive.NewObjectID(),
+			OrganizationID:  setting.OrganizationID,
+			UserID:          userID,
+			Year:            period.Year,
+			TransactionType: TransactionCarry,
+			Amount:          amount,
+			Date:            period.Start,
+			Reason:          &reason,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+		},
+	})
+
+}
+
+// FindLeaveBalance is GetLeaveBalance without creating the year; it
+// returns nil when the user has no balance for it.
+func (r *leaveRepository) FindLeaveBalance(ctx context.Context, organizationID string, userID string, year int) (*UserLeaveBalance, error) {
+
+	var leaveBalance UserLeaveBalance
+
+	err := r.collectionLeaveBalance.FindOne(ctx, bson.M{"organization_id": organizationID, "user_id": userID, "year": year}).Decode(&leaveBalance)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &leaveBalance, nil
+
+}
+
+// CarryOverLeaveBalance credits the days brought forward from the previous
+// year. carried_over marks the year as rolled over, so a second run is a
+// no-op.
+func (r *leaveRepository) CarryOverLeaveBalance(ctx context.Context, organizationID string, userID string, year int, amount float64, expiresAt *time.Time) (bool, error) {
+
+	filter := bson.M{"organization_id": organizationID, "user_id": userID, "year": year, "carried_over": bson.M{"$exists": false}}
+
+	set := bson.M{
+		"carried_over": amount,
+		"last_updated": time.Now(),
+	}
+
+	if expiresAt != nil {
+		set["carry_over_expires_at"] = *expiresAt
+	}
+
+	update := bson.M{
+		"$set": set,
+		"$inc": bson.M{
+			"total_leave_banlance": amount,
+			"remainding_leave":     amount,
+		},
+	}
+
+	result, err := r.collectionLeaveBalance.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.ModifiedCount > 0, nil
+
+}
+
+// ExpireCarryOver removes what is left of the carried-over days once they
+// are past their expiry. Leave taken in the year is drawn from the
+// carried-over days first. The amount is worked out in the update itself,
+// so a debit landing at the same time cannot skew it. It returns nil when
+// nothing was due.
+func (r *leaveRepository) ExpireCarryOver(ctx context.Context, organizationID string, userID string, year int, now time.Time) (*UserLeaveBalance, error) {
+
+	filter := bson.M{
+		"organization_id":       organizationID,
+		"user_id":               userID,
+		"year":                  year,
+		"carry_over_expires_at": bson.M{"$lte": now},
+		"carry_over_expired":    bson.M{"$exists": false},
+	}
+
+	expired := bson.M{"$max": bson.A{0, bson.M{"$min": bson.A{
+		bson.M{"$subtract": bson.A{"$carried_over", "$used_leave"}},
+		"$remainding_leave",
+	}}}}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"carry_over_expired": expired}}},
+		{{Key: "$set", Value: bson.M{
+			"total_leave_banlance": bson.M{"$subtract": bson.A{"$total_leave_banlance", "$carry_over_expired"}},
+			"remainding_leave":     bson.M{"$subtract": bson.A{"$remainding_leave", "$carry_over_expired"}},
+			"last_updated":         now,
+		}}},
+	}
+
+	var leaveBalance UserLeaveBalance
+
+	err := r.collectionLeaveBalance.FindOneAndUpdate(ctx, filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&leaveBalance)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &leaveBalance, nil
+
+}
@@ -0,0 +1,198 @@
+package leave
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLeaveYearOf(t *testing.T) {
+
+	calendar := &Setting{YearBoundary: YearBoundaryCalendar}
+	school := &Setting{YearBoundary: YearBoundarySchool, YearStartMonth: 9, YearStartDay: 1}
+	unset := &Setting{YearBoundary: YearBoundarySchool}
+
+	tests := []struct {
+		name    string
+		setting *Setting
+		date    string
+		year    int
+		start   string
+	}{
+		{name: "calendar year", setting: calendar, date: "2030-03-15", year: 2030, start: "2030-01-01"},
+		{name: "school year before its start", setting: school, date: "2030-08-31", year: 2029, start: "2029-09-01"},
+		{name: "school year on its first day", setting: school, date: "2030-09-01", year: 2030, start: "2030-09-01"},
+		{name: "school year after new year", setting: school, date: "2030-01-15", year: 2029, start: "2029-09-01"},
+		{name: "school boundary without a start day", setting: unset, date: "2030-08-31", year: 2030, start: "2030-01-01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			period := tt.setting.leaveYearOf(parseDay(tt.date))
+
+			if period.Year != tt.year || period.Start.Format("2006-01-02") != tt.start {
+				t.Errorf("leaveYearOf(%s) = %d from %s, want %d from %s", tt.date, period.Year, period.Start.Format("2006-01-02"), tt.year, tt.start)
+			}
+
+			if !period.End.Equal(period.Start.AddDate(1, 0, 0)) {
+				t.Errorf("leave year ends %s, want a year after %s", period.End.Format("2006-01-02"), tt.start)
+			}
+
+		})
+	}
+
+}
+
+func TestLeaveYearMonth(t *testing.T) {
+
+	fromSeptember := leaveYear{Year: 2029, Start: parseDay("2029-09-01"), End: parseDay("2030-09-01")}
+	fromMidSeptember := leaveYear{Year: 2029, Start: parseDay("2029-09-15"), End: parseDay("2030-09-15")}
+
+	tests := []struct {
+		name   string
+		period leaveYear
+		date   string
+		want   int
+	}{
+		{name: "first day", period: fromSeptember, date: "2029-09-01", want: 1},
+		{name: "last day of the calendar year", period: fromSeptember, date: "2029-12-31", want: 4},
+		{name: "after new year", period: fromSeptember, date: "2030-01-15", want: 5},
+		{name: "last day", period: fromSeptember, date: "2030-08-31", want: 12},
+		{name: "before the month boundary of a mid-month start", period: fromMidSeptember, date: "2029-10-14", want: 1},
+		{name: "on the month boundary of a mid-month start", period: fromMidSeptember, date: "2029-10-15", want: 2},
+		{name: "before the year is clamped to the first month", period: fromSeptember, date: "2029-08-01", want: 1},
+		{name: "after the year is clamped to the last month", period: fromSeptember, date: "2030-10-01", want: 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.period.month(parseDay(tt.date)); got != tt.want {
+				t.Errorf("month(%s) = %d, want %d", tt.date, got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestSplitByLeaveYear(t *testing.T) {
+
+	school := &Setting{YearBoundary: YearBoundarySchool, YearStartMonth: 9, YearStartDay: 1}
+
+	tests := []struct {
+		name    string
+		setting *Setting
+		dates   []string
+		perDay  float64
+		amount  float64
+		want    []leaveYearShare
+	}{
+		{
+			name:    "leave inside one year",
+			setting: &Setting{},
+			dates:   []string{"2030-03-04", "2030-03-05"},
+			perDay:  1,
+			amount:  2,
+			want:    []leaveYearShare{{Year: 2030, Date: parseDay("2030-03-04"), Days: 2}},
+		},
+		{
+			name:    "leave across new year",
+			setting: &Setting{},
+			dates:   []string{"2030-12-31", "2031-01-02", "2031-01-03"},
+			perDay:  1,
+			amount:  3,
+			want: []leaveYearShare{
+				{Year: 2030, Date: parseDay("2030-12-31"), Days: 1},
+				{Year: 2031, Date: parseDay("2031-01-02"), Days: 2},
+			},
+		},
+		{
+			name:    "half days across the school year boundary",
+			setting: school,
+			dates:   []string{"2030-09-01", "2030-08-30"},
+			perDay:  0.5,
+			amount:  1,
+			want: []leaveYearShare{
+				{Year: 2029, Date: parseDay("2030-08-30"), Days: 0.5},
+				{Year: 2030, Date: parseDay("2030-09-01"), Days: 0.5},
+			},
+		},
+		{
+			name:    "short spend goes to the earliest year",
+			setting: &Setting{},
+			dates:   []string{"2030-12-30", "2030-12-31", "2031-01-02"},
+			perDay:  1,
+			amount:  2.5,
+			want: []leaveYearShare{
+				{Year: 2030, Date: parseDay("2030-12-30"), Days: 2},
+				{Year: 2031, Date: parseDay("2031-01-02"), Days: 0.5},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var dates []time.Time
+			for _, date := range tt.dates {
+				dates = append(dates, parseDay(date))
+			}
+
+			got := capShares(tt.setting.splitByLeaveYear(dates, tt.perDay), tt.amount)
+
+			if !slices.EqualFunc(got, tt.want, func(a, b leaveYearShare) bool {
+				return a.Year == b.Year && a.Date.Equal(b.Date) && a.Days == b.Days
+			}) {
+				t.Errorf("shares = %+v, want %+v", got, tt.want)
+			}
+
+		})
+	}
+
+}
+
+func TestDebitLeaveBalanceChargesEachLeaveYear(t *testing.T) {
+
+	dates := []time.Time{parseDay("2030-12-30"), parseDay("2030-12-31"), parseDay("2031-01-02")}
+
+	leaveItem := &LeaveRequests{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: "org-1",
+		UserID:         "teacher-1",
+		StartDate:      dates[0],
+		EndDate:        dates[2],
+		LeaveDates:     dates,
+		Portion:        PortionFull,
+		LeaveType:      LeaveTypeAnnual,
+		Policy:         &LeavePolicy{DeductsBalance: true},
+		Status:         "confirmed",
+	}
+
+	repository := &fakeLeaveRepository{}
+	service := &leaveService{leaveRepository: repository}
+
+	if err := service.debitLeaveBalance(context.Background(), leaveItem); err != nil {
+		t.Fatalf("debitLeaveBalance: %v", err)
+	}
+
+	charged := map[int]float64{}
+	for _, transaction := range repository.transactions {
+		charged[transaction.Year] += transaction.Amount
+	}
+
+	if len(charged) != 2 || charged[2030] != 2 || charged[2031] != 1 {
+		t.Errorf("charged per year = %v, want 2 days in 2030 and 1 in 2031", charged)
+	}
+
+}
+
+func parseDay(value string) time.Time {
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		panic(err)
+	}
+	return day
+}
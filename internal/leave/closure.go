@@ -0,0 +1,500 @@
+package leave
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CreateClosure closes a range for every member of the organization, that
+// is every user it has a start date or leave on record for. Each member
+// gets a confirmed request for the range's working days that never
+// takes a slot, since nobody is competing for one while the organization
+// is closed. Days a member already has leave on are left out of their
+// request, so a closure never charges anyone twice for the same day. When
+// the closure is a day off, leave already charged for its days is
+// refunded for them and the closure covers them instead. A pending request
+// that lies wholly inside the closure is rejected, since the closure
+// covers it; one that reaches past the closure stays pending for review
+// and its days inside the closure are left out as well.
+func (s *leaveService) CreateClosure(ctx context.Context, req *ClosureRequest) (*ClosureReport, error) {
+
+	if req.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	if req.EndDate == "" {
+		req.EndDate = req.StartDate
+	}
+
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return nil, err
+	}
+
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	if endDate.Before(startDate) {
+		return nil, fmt.Errorf("end date must not be before start date")
+	}
+
+	if req.Mode == "" {
+		req.Mode = ClosureModeOff
+	}
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.OrganizationID == "" {
+		req.OrganizationID = currentUser.OrganizationIdActive
+	}
+
+	if req.OrganizationID != currentUser.OrganizationIdActive && !currentUser.IsSuperAdmin {
+		return nil, fmt.Errorf("closures can only be created for your active organization")
+	}
+
+	if !isOrganizationAdmin(currentUser) {
+		return nil, fmt.Errorf("only organization admins can create closures")
+	}
+
+	organizationID := req.OrganizationID
+
+	var leaveType *LeaveType
+
+	switch req.Mode {
+	case ClosureModeOff:
+		leaveType, err = s.closureLeaveType(ctx, organizationID, LeaveTypeClosure)
+	case ClosureModeDeduct:
+		if req.LeaveType == "" {
+			req.LeaveType = LeaveTypeAnnual
+		}
+		leaveType, err = s.closureLeaveType(ctx, organizationID, req.LeaveType)
+	default:
+		return nil, fmt.Errorf("invalid mode, must be %s or %s", ClosureModeOff, ClosureModeDeduct)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	leaveDates, err := s.getWorkingDays(ctx, organizationID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(leaveDates) == 0 {
+		return nil, fmt.Errorf("closure range has no working days")
+	}
+
+	memberIDs, err := s.leaveRepository.GetOrganizationUserIDs(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(memberIDs) == 0 {
+		return nil, fmt.Errorf("no members found for organization %s", organizationID)
+	}
+
+	leaves, err := s.leaveRepository.GetLeavesOnDates(ctx, organizationID, leaveDates)
+	if err != nil {
+		return nil, err
+	}
+
+	closed := closureDays(leaveDates)
+	taken, superseded, charged := splitClosureLeaves(leaveDates, leaves, req.Mode == ClosureModeOff)
+
+	// The closure is the organization's decision, so its requests skip
+	// approval, the booking window and the daily cap; only the balance
+	// side of the type's policy still applies.
+	policy := leaveType.Policy
+	policy.ConsumesSlot = false
+	policy.RequiresApproval = false
+	policy.RequiresAttachment = false
+
+	closure := &LeaveClosure{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: organizationID,
+		Name:           req.Name,
+		StartDate:      startDate,
+		EndDate:        endDate,
+		LeaveDates:     leaveDates,
+		Mode:           req.Mode,
+		LeaveType:      leaveType.Code,
+		Results:        make([]ClosureResult, 0, len(memberIDs)),
+		CreatedBy:      currentUser.ID,
+		CreatedAt:      time.Now(),
+	}
+
+	reason := req.Reason
+	if reason == nil {
+		reason = &closure.Name
+	}
+
+	report := &ClosureReport{Closure: closure}
+
+	comment := fmt.Sprintf("Covered by the closure %s", closure.Name)
+
+	for _, memberID := range memberIDs {
+
+		result := ClosureResult{UserID: memberID}
+
+		// The main service names the member and confirms the account still
+		// exists; it answers no user for one it cannot find.
+		member, err := s.userService.GetUserInfor(ctx, memberID)
+		if err != nil || member == nil {
+			result.Status = ClosureFailed
+			result.Message = "user not found in the main service"
+			if err != nil {
+				result.Message = err.Error()
+			}
+			report.Failed++
+			closure.Results = append(closure.Results, result)
+			continue
+		}
+		result.UserName = member.UserName
+
+		memberTaken := taken[memberID]
+
+		var notes []string
+
+		rejected := 0
+		for _, leaveItem := range superseded[memberID] {
+			err := s.leaveRepository.RejectLeave(ctx, leaveItem, &LeaveReview{
+				Action:     ReviewRejected,
+				ReviewerID: currentUser.ID,
+				Comment:    &comment,
+				ReviewedAt: closure.CreatedAt,
+			})
+			if err != nil {
+				// The request is still pending, so its days stay taken.
+				if memberTaken == nil {
+					memberTaken = make(map[string]bool)
+				}
+				for _, date := range leaveItem.Dates() {
+					memberTaken[date.Format("2006-01-02")] = true
+				}
+				notes = append(notes, fmt.Sprintf("pending request %s could not be rejected: %v", leaveItem.ID.Hex(), err))
+				continue
+			}
+			rejected++
+		}
+
+		if rejected > 0 {
+			notes = append(notes, fmt.Sprintf("rejected %d pending request(s) the closure covers", rejected))
+		}
+
+		refunded := 0
+		for _, leaveItem := range charged[memberID] {
+			released, err := s.handOverToClosure(ctx, closure, leaveItem, closed)
+			if err != nil {
+				// The leave keeps its days and its charge.
+				if memberTaken == nil {
+					memberTaken = make(map[string]bool)
+				}
+				for _, date := range leaveItem.Dates() {
+					if closed[date.Format("2006-01-02")] {
+						memberTaken[date.Format("2006-01-02")] = true
+					}
+				}
+				notes = append(notes, fmt.Sprintf("leave %s could not be handed over to the closure: %v", leaveItem.ID.Hex(), err))
+				continue
+			}
+			err = s.refundLeaveBalance(ctx, leaveItem, released)
+			if err != nil {
+				notes = append(notes, fmt.Sprintf("closure days of leave %s released but not refunded: %v", leaveItem.ID.Hex(), err))
+				continue
+			}
+			refunded++
+		}
+
+		if refunded > 0 {
+			notes = append(notes, fmt.Sprintf("refunded the closure days of %d leave(s) already charged", refunded))
+		}
+
+		dates := make([]time.Time, 0, len(leaveDates))
+		for _, date := range leaveDates {
+			if !memberTaken[date.Format("2006-01-02")] {
+				dates = append(dates, date)
+			}
+		}
+
+		if skipped := len(leaveDates) - len(dates); skipped > 0 {
+			notes = append(notes, fmt.Sprintf("left out %d day(s) already on leave", skipped))
+		}
+
+		if len(dates) == 0 {
+			result.Status = ClosureSkipped
+		} else {
+			s.closeForMember(ctx, closure, dates, &policy, reason, &result)
+		}
+
+		if result.Message != "" {
+			notes = append(notes, result.Message)
+		}
+		result.Message = strings.Join(notes, "; ")
+
+		switch result.Status {
+		case ClosureCreated:
+			report.Created++
+		case ClosureSkipped:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+
+		closure.Results = append(closure.Results, result)
+
+	}
+
+	report.Members = len(closure.Results)
+
+	err = s.leaveRepository.CreateClosure(ctx, closure)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+
+}
+
+func closureDays(leaveDates []time.Time) map[string]bool {
+
+	closed := make(map[string]bool, len(leaveDates))
+	for _, date := range leaveDates {
+		closed[date.Format("2006-01-02")] = true
+	}
+
+	return closed
+
+}
+
+// splitClosureLeaves sorts the leave that already touches a closure by
+// member: the closure days each member has leave on, keyed "2006-01-02",
+// and the pending requests that lie wholly inside the closure, which it
+// replaces. With refundCharged set, confirmed leave that was charged to a
+// balance is returned apart instead of counting as taken, so the closure
+// can take its days over.
+func splitClosureLeaves(leaveDates []time.Time, leaves []*LeaveRequests, refundCharged bool) (map[string]map[string]bool, map[string][]*LeaveRequests, map[string][]*LeaveRequests) {
+
+	closed := closureDays(leaveDates)
+
+	taken := make(map[string]map[string]bool)
+	superseded := make(map[string][]*LeaveRequests)
+	charged := make(map[string][]*LeaveRequests)
+
+	for _, leaveItem := range leaves {
+
+		inside := true
+		for _, date := range leaveItem.Dates() {
+			if !closed[date.Format("2006-01-02")] {
+				inside = false
+				break
+			}
+		}
+
+		if leaveItem.Status == "pending" && inside {
+			superseded[leaveItem.UserID] = append(superseded[leaveItem.UserID], leaveItem)
+			continue
+		}
+
+		policy := leaveItem.LeavePolicy()
+		if refundCharged && leaveItem.Status == "confirmed" && !leaveItem.bookedBySystem() && (policy.DeductsBalance || policy.UsesCompOff) {
+			charged[leaveItem.UserID] = append(charged[leaveItem.UserID], leaveItem)
+			continue
+		}
+
+		if taken[leaveItem.UserID] == nil {
+			taken[leaveItem.UserID] = make(map[string]bool)
+		}
+
+		for _, date := range leaveItem.Dates() {
+			if closed[date.Format("2006-01-02")] {
+				taken[leaveItem.UserID][date.Format("2006-01-02")] = true
+			}
+		}
+	}
+
+	return taken, superseded, charged
+
+}
+
+// handOverToClosure takes the closure days off a leave that was charged
+// for them, cancelling a leave with no other days, and returns the days the
+// member is owed back.
+func (s *leaveService) handOverToClosure(ctx context.Context, closure *LeaveClosure, leaveItem *LeaveRequests, closed map[string]bool) ([]time.Time, error) {
+
+	var released []time.Time
+	for _, date := range leaveItem.Dates() {
+		if closed[date.Format("2006-01-02")] {
+			released = append(released, date)
+		}
+	}
+
+	if len(released) < len(leaveItem.Dates()) {
+		return released, s.leaveRepository.TrimLeave(ctx, leaveItem, released, nil)
+	}
+
+	reason := fmt.Sprintf("Covered by the closure %s", closure.Name)
+	reviewedAt := closure.CreatedAt
+
+	return released, s.leaveRepository.CancelLeave(ctx, leaveItem, &LeaveCancellation{
+		Status:        CancellationApproved,
+		Reason:        &reason,
+		RequestedBy:   closure.CreatedBy,
+		RequestedAt:   closure.CreatedAt,
+		ReviewerID:    closure.CreatedBy,
+		ReviewedAt:    &reviewedAt,
+		ReleasedDates: released,
+	})
+
+}
+
+// closeForMember books the closure's dates for one member and records the
+// outcome in result. A request whose balance could not be charged stays
+// booked and is reported as failed, so an admin can settle it by hand.
+func (s *leaveService) closeForMember(ctx context.Context, closure *LeaveClosure, dates []time.Time, policy *LeavePolicy, reason *string, result *ClosureResult) {
+
+	closureID := closure.ID
+
+	leaveItem := LeaveRequests{
+		OrganizationID: closure.OrganizationID,
+		StartDate:      dates[0],
+		EndDate:        dates[len(dates)-1],
+		LeaveDates:     dates,
+		Portion:        PortionFull,
+		LeaveType:      closure.LeaveType,
+		Policy:         policy,
+		UserID:         result.UserID,
+		UserName:       result.UserName,
+		Reason:         reason,
+		RequestedAt:    closure.CreatedAt,
+		ClosureID:      &closureID,
+	}
+
+	err := s.leaveRepository.CreateLeave(ctx, &leaveItem)
+	if err != nil {
+		result.Status = ClosureFailed
+		result.Message = err.Error()
+		return
+	}
+
+	leaveID := leaveItem.ID
+	result.LeaveID = &leaveID
+	result.Days = leaveItem.Days()
+	result.Status = ClosureCreated
+
+	if !policy.DeductsBalance && !policy.UsesCompOff {
+		return
+	}
+
+	err = s.debitLeaveBalance(ctx, &leaveItem)
+	if err != nil {
+		result.Status = ClosureFailed
+		result.Message = fmt.Sprintf("leave booked but balance not charged: %v", err)
+		return
+	}
+
+	result.Deducted = result.Days
+
+}
+
+func (s *leaveService) closureLeaveType(ctx context.Context, organizationID string, code string) (*LeaveType, error) {
+
+	// Seeds the default types, closure among them, for organizations that
+	// have not used leave types yet.
+	if _, err := s.leaveRepository.GetLeaveTypes(ctx, organizationID); err != nil {
+		return nil, err
+	}
+
+	leaveType, err := s.leaveRepository.GetLeaveTypeByCode(ctx, organizationID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if !leaveType.IsActive {
+		return nil, fmt.Errorf("leave type %s is not active", leaveType.Code)
+	}
+
+	return leaveType, nil
+
+}
+
+func (s *leaveService) GetClosures(ctx context.Context) ([]*LeaveClosure, error) {
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetClosures(ctx, organizationID)
+
+}
+
+// GetLeavesOnDates lists the pending and confirmed requests that touch any
+// of the dates, whether or not they hold a slot.
+func (r *leaveRepository) GetLeavesOnDates(ctx context.Context, organizationID string, dates []time.Time) ([]*LeaveRequests, error) {
+
+	var leaveRequests []*LeaveRequests
+
+	filter := bson.M{
+		"organization_id": organizationID,
+		"status":          bson.M{"$in": bson.A{"pending", "confirmed"}},
+		"$or": []bson.M{
+			{"leave_dates": bson.M{"$in": dates}},
+			{"leave_date": bson.M{"$in": dates}},
+		},
+	}
+
+	cursor, err := r.collectionLeave.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &leaveRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaveRequests, nil
+
+}
+
+func (r *leaveRepository) CreateClosure(ctx context.Context, closure *LeaveClosure) error {
+
+	_, err := r.collectionClosure.InsertOne(ctx, closure)
+
+	return err
+
+}
+
+func (r *leaveRepository) GetClosures(ctx context.Context, organizationID string) ([]*LeaveClosure, error) {
+
+	var closures []*LeaveClosure
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "start_date", Value: -1}})
+
+	cursor, err := r.collectionClosure.Find(ctx, bson.M{"organization_id": organizationID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &closures)
+	if err != nil {
+		return nil, err
+	}
+
+	return closures, nil
+
+}
@@ -0,0 +1,215 @@
+package leave
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSplitClosureLeaves(t *testing.T) {
+
+	day := func(d int) time.Time {
+		return time.Date(2030, time.April, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	closureDates := []time.Time{day(1), day(2), day(3)}
+
+	inside := &LeaveRequests{ID: primitive.NewObjectID(), UserID: "a", Status: "pending", LeaveDates: []time.Time{day(2), day(3)}}
+	reachingOut := &LeaveRequests{ID: primitive.NewObjectID(), UserID: "b", Status: "pending", LeaveDates: []time.Time{day(3), day(4)}}
+	confirmed := &LeaveRequests{ID: primitive.NewObjectID(), UserID: "c", Status: "confirmed", LeaveDates: []time.Time{day(1)}}
+	legacy := &LeaveRequests{ID: primitive.NewObjectID(), UserID: "d", Status: "confirmed", LeaveDate: day(2)}
+
+	annual := &LeaveRequests{ID: primitive.NewObjectID(), UserID: "f", Status: "confirmed", LeaveType: LeaveTypeAnnual, LeaveDates: []time.Time{day(2), day(4)}}
+	sick := &LeaveRequests{ID: primitive.NewObjectID(), UserID: "g", Status: "confirmed", Policy: &LeavePolicy{RequiresApproval: true}, LeaveDates: []time.Time{day(3)}}
+
+	taken, superseded, charged := splitClosureLeaves(closureDates, []*LeaveRequests{inside, reachingOut, confirmed, legacy, annual, sick}, true)
+
+	tests := []struct {
+		userID     string
+		taken      []string
+		superseded int
+		charged    int
+	}{
+		{userID: "a", superseded: 1},
+		{userID: "b", taken: []string{"2030-04-03"}},
+		{userID: "c", charged: 1},
+		{userID: "d", charged: 1},
+		{userID: "e"},
+		{userID: "f", charged: 1},
+		{userID: "g", taken: []string{"2030-04-03"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.userID, func(t *testing.T) {
+
+			if len(taken[tt.userID]) != len(tt.taken) {
+				t.Errorf("taken days = %v, want %v", taken[tt.userID], tt.taken)
+			}
+
+			for _, date := range tt.taken {
+				if !taken[tt.userID][date] {
+					t.Errorf("%s is not taken, want it taken", date)
+				}
+			}
+
+			if len(superseded[tt.userID]) != tt.superseded {
+				t.Errorf("superseded requests = %d, want %d", len(superseded[tt.userID]), tt.superseded)
+			}
+
+			if len(charged[tt.userID]) != tt.charged {
+				t.Errorf("charged leaves = %d, want %d", len(charged[tt.userID]), tt.charged)
+			}
+
+		})
+	}
+
+	// A closure that charges its days leaves charged leave where it is.
+	taken, _, charged = splitClosureLeaves(closureDates, []*LeaveRequests{annual}, false)
+	if len(charged["f"]) != 0 || !taken["f"]["2030-04-02"] || len(taken["f"]) != 1 {
+		t.Errorf("without refunds: taken = %v, charged = %d, want only 2030-04-02 taken", taken["f"], len(charged["f"]))
+	}
+
+}
+
+func TestCreateClosure(t *testing.T) {
+
+	day := func(d int) time.Time {
+		return time.Date(2030, time.April, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	admin := &user.CurrentUser{
+		ID:                   "admin",
+		OrganizationIdActive: "org-1",
+		OrganizationAdmin:    &user.OrganizationAdmin{ID: "org-1"},
+	}
+
+	tests := []struct {
+		name    string
+		members []string
+		missing []string
+		leaves  []*LeaveRequests
+		wantErr string
+		// wantDays is the length of each member's closure request.
+		wantDays     map[string]int
+		wantTrimmed  int
+		wantCanceled int
+		wantRefunded float64
+	}{
+		{
+			name:    "no members",
+			wantErr: "no members found",
+		},
+		{
+			name:     "members without leave",
+			members:  []string{"a", "b"},
+			wantDays: map[string]int{"a": 3, "b": 3},
+		},
+		{
+			name:     "member the main service does not know",
+			members:  []string{"a", "gone"},
+			missing:  []string{"gone"},
+			wantDays: map[string]int{"a": 3},
+		},
+		{
+			name:    "charged leave reaching past the closure is trimmed and refunded",
+			members: []string{"a"},
+			leaves: []*LeaveRequests{
+				{UserID: "a", Status: "confirmed", LeaveType: LeaveTypeAnnual, Portion: PortionFull, LeaveDates: []time.Time{day(3), day(4)}},
+			},
+			wantDays:     map[string]int{"a": 3},
+			wantTrimmed:  1,
+			wantRefunded: 1,
+		},
+		{
+			name:    "charged leave inside the closure is cancelled and refunded",
+			members: []string{"a"},
+			leaves: []*LeaveRequests{
+				{UserID: "a", Status: "confirmed", LeaveType: LeaveTypeAnnual, Portion: PortionAM, LeaveDates: []time.Time{day(1), day(2)}},
+			},
+			wantDays:     map[string]int{"a": 3},
+			wantCanceled: 1,
+			wantRefunded: 1,
+		},
+		{
+			name:    "uncharged leave keeps its days",
+			members: []string{"a"},
+			leaves: []*LeaveRequests{
+				{UserID: "a", Status: "confirmed", LeaveType: LeaveTypeSick, Policy: &LeavePolicy{RequiresApproval: true}, LeaveDates: []time.Time{day(2)}},
+			},
+			wantDays: map[string]int{"a": 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			repository := &fakeLeaveRepository{
+				userIDs: tt.members,
+				leaves:  make(map[primitive.ObjectID]*LeaveRequests),
+				leaveTypes: []*LeaveType{
+					{Code: LeaveTypeClosure, Name: "Organization closure", IsActive: true, System: true},
+				},
+			}
+			for _, leaveItem := range tt.leaves {
+				leaveItem.ID = primitive.NewObjectID()
+				leaveItem.OrganizationID = "org-1"
+				repository.leaves[leaveItem.ID] = leaveItem
+			}
+
+			service := &leaveService{
+				leaveRepository: repository,
+				userService:     &fakeUserService{currentUser: admin, missingUsers: tt.missing},
+			}
+
+			report, err := service.CreateClosure(context.Background(), &ClosureRequest{
+				Name:      "Spring break",
+				StartDate: "2030-04-01",
+				EndDate:   "2030-04-03",
+			})
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("CreateClosure error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateClosure: %v", err)
+			}
+
+			if len(report.Closure.Results) != len(tt.members) {
+				t.Errorf("results for %d members, want %d", len(report.Closure.Results), len(tt.members))
+			}
+
+			for _, result := range report.Closure.Results {
+				if slices.Contains(tt.missing, result.UserID) && result.Status != ClosureFailed {
+					t.Errorf("%s is %s, want %s", result.UserID, result.Status, ClosureFailed)
+				}
+				if want := tt.wantDays[result.UserID]; result.Days != float64(want) {
+					t.Errorf("%s's closure request covers %v days, want %d (%s)", result.UserID, result.Days, want, result.Message)
+				}
+			}
+
+			if len(repository.trimmed) != tt.wantTrimmed || len(repository.cancelled) != tt.wantCanceled {
+				t.Errorf("trimmed %d and cancelled %d leaves, want %d and %d", len(repository.trimmed), len(repository.cancelled), tt.wantTrimmed, tt.wantCanceled)
+			}
+
+			var refunded float64
+			for _, transaction := range repository.transactions {
+				if transaction.TransactionType == TransactionRefund {
+					refunded += transaction.Amount
+				}
+			}
+			if refunded != tt.wantRefunded {
+				t.Errorf("refunded %v days, want %v", refunded, tt.wantRefunded)
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,695 @@
+package leave
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+	"worktime-service/internal/shared"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// compOffDays converts overtime hours into comp-off days, one standard
+// working day of overtime earning one day off.
+func compOffDays(overtimeHours float64) float64 {
+	return math.Round(overtimeHours/shared.StandardWorkingHours*100) / 100
+}
+
+// ClaimCompOff raises a claim for every day in the range the user worked
+// past a standard day. Days that were already claimed are skipped, so the
+// same range can be claimed again after new attendance is recorded.
+func (s *leaveService) ClaimCompOff(ctx context.Context, req *CompOffClaimRequest) ([]*CompOffClaim, error) {
+
+	if req.UserID == "" {
+		return nil, fmt.Errorf("user id is required")
+	}
+
+	if req.DateTo == "" {
+		req.DateTo = req.DateFrom
+	}
+
+	dateFrom, err := time.Parse("2006-01-02", req.DateFrom)
+	if err != nil {
+		return nil, err
+	}
+
+	dateTo, err := time.Parse("2006-01-02", req.DateTo)
+	if err != nil {
+		return nil, err
+	}
+
+	if dateTo.Before(dateFrom) {
+		return nil, fmt.Errorf("date to must not be before date from")
+	}
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	userInfo, err := s.userService.GetUserInfor(ctx, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The range is inclusive on both ends, and attendance days are stored
+	// at midnight, so dateTo is passed as it is.
+	overtimeDays, err := s.overtimeSource.GetOvertimeDays(ctx, req.UserID, dateFrom, dateTo)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make([]*CompOffClaim, 0, len(overtimeDays))
+
+	for _, overtimeDay := range overtimeDays {
+
+		days := compOffDays(overtimeDay.OvertimeHours)
+		if days <= 0 {
+			continue
+		}
+
+		claim := &CompOffClaim{
+			ID:             primitive.NewObjectID(),
+			OrganizationID: organizationID,
+			UserID:         req.UserID,
+			UserName:       userInfo.UserName,
+			AttendanceID:   overtimeDay.AttendanceID,
+			Date:           overtimeDay.Date,
+			OvertimeHours:  overtimeDay.OvertimeHours,
+			Days:           days,
+			Status:         CompOffPending,
+			Reason:         req.Reason,
+			RequestedAt:    time.Now(),
+			Usages:         []CompOffUsage{},
+		}
+
+		created, err := s.leaveRepository.CreateCompOffClaim(ctx, claim)
+		if err != nil {
+			return nil, err
+		}
+
+		if created {
+			claims = append(claims, claim)
+		}
+
+	}
+
+	if len(claims) == 0 {
+		return nil, fmt.Errorf("no unclaimed overtime between %s and %s", req.DateFrom, req.DateTo)
+	}
+
+	return claims, nil
+
+}
+
+func (s *leaveService) GetCompOffClaims(ctx context.Context, userID string, status string) ([]*CompOffClaim, error) {
+
+	switch status {
+	case "", CompOffPending, CompOffApproved, CompOffRejected:
+	default:
+		return nil, fmt.Errorf("invalid status: %s", status)
+	}
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetCompOffClaims(ctx, organizationID, userID, status)
+
+}
+
+// ApproveCompOffClaim credits the claim's days. With CompOffExpiryDays set,
+// the credit lapses that many days after approval.
+func (s *leaveService) ApproveCompOffClaim(ctx context.Context, req *ReviewLeaveRequest, id string) (*CompOffClaim, error) {
+
+	claim, err := s.getPendingCompOffClaim(ctx, req, id)
+	if err != nil {
+		return nil, err
+	}
+
+	setting, err := s.leaveRepository.GetSettings(ctx, claim.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	var expiresAt *time.Time
+	if setting.CompOffExpiryDays > 0 {
+		expiry := now.AddDate(0, 0, setting.CompOffExpiryDays)
+		expiresAt = &expiry
+	}
+
+	review := LeaveReview{
+		Action:     ReviewApproved,
+		ReviewerID: req.ReviewerID,
+		Comment:    req.Comment,
+		ReviewedAt: now,
+	}
+
+	err = s.leaveRepository.ReviewCompOffClaim(ctx, claim, &review, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	reason := fmt.Sprintf("Comp-off earned - %.2f overtime hours on %s", claim.OvertimeHours, claim.Date.Format("2006-01-02"))
+
+	err = s.recordCompOffTransaction(ctx, claim.OrganizationID, claim.UserID, TransactionCompOffEarned, claim.Days, claim.Date, nil, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetCompOffClaimByID(ctx, claim.OrganizationID, claim.ID)
+
+}
+
+func (s *leaveService) RejectCompOffClaim(ctx context.Context, req *ReviewLeaveRequest, id string) (*CompOffClaim, error) {
+
+	claim, err := s.getPendingCompOffClaim(ctx, req, id)
+	if err != nil {
+		return nil, err
+	}
+
+	review := LeaveReview{
+		Action:     ReviewRejected,
+		ReviewerID: req.ReviewerID,
+		Comment:    req.Comment,
+		ReviewedAt: time.Now(),
+	}
+
+	err = s.leaveRepository.ReviewCompOffClaim(ctx, claim, &review, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetCompOffClaimByID(ctx, claim.OrganizationID, claim.ID)
+
+}
+
+func (s *leaveService) getPendingCompOffClaim(ctx context.Context, req *ReviewLeaveRequest, id string) (*CompOffClaim, error) {
+
+	if req.ReviewerID == "" {
+		return nil, fmt.Errorf("reviewer id is required")
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "review comp-off claims")
+	if err != nil {
+		return nil, err
+	}
+
+	claim, err := s.leaveRepository.GetCompOffClaimByID(ctx, currentUser.OrganizationIdActive, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if claim.Status != CompOffPending {
+		return nil, fmt.Errorf("comp-off claim is %s, only pending claims can be reviewed", claim.Status)
+	}
+
+	// Overtime is approved by a manager, never by the person who worked it.
+	if claim.UserID == req.ReviewerID || claim.UserID == currentUser.ID {
+		return nil, fmt.Errorf("you cannot review your own comp-off claim")
+	}
+
+	return claim, nil
+
+}
+
+func (s *leaveService) GetCompOffBalance(ctx context.Context, userID string) (*CompOffBalance, error) {
+
+	if userID == "" {
+		return nil, fmt.Errorf("user id is required")
+	}
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	credits, err := s.leaveRepository.GetCompOffCredits(ctx, organizationID, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	available := 0.0
+	for _, credit := range credits {
+		available += credit.Remaining
+	}
+
+	return &CompOffBalance{
+		UserID:    userID,
+		Available: math.Round(available*100) / 100,
+		Credits:   credits,
+	}, nil
+
+}
+
+// ExpireCompOffCredits lapses the credits past their expiry and writes
+// what was lost to the ledger. It needs no user session, so the balance
+// cron can run it.
+func (s *leaveService) ExpireCompOffCredits(ctx context.Context) error {
+
+	now := time.Now()
+
+	expired, err := s.leaveRepository.ExpireCompOffClaims(ctx, now)
+	if err != nil {
+		return err
+	}
+
+	for _, claim := range expired {
+
+		if claim.ExpiredDays <= 0 {
+			continue
+		}
+
+		reason := fmt.Sprintf("Comp-off expired - earned on %s", claim.Date.Format("2006-01-02"))
+
+		err := s.recordCompOffTransaction(ctx, claim.OrganizationID, claim.UserID, TransactionCompOffExpired, claim.ExpiredDays, now, nil, reason)
+		if err != nil {
+			return fmt.Errorf("record comp-off expiry for claim %s: %w", claim.ID.Hex(), err)
+		}
+
+	}
+
+	return nil
+
+}
+
+// checkCompOff reports a violation when the user has fewer unexpired
+// credits than the request needs.
+func (s *leaveService) checkCompOff(ctx context.Context, organizationID string, userID string, leaveType string, days float64) (*PolicyViolation, error) {
+
+	credits, err := s.leaveRepository.GetCompOffCredits(ctx, organizationID, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	available := 0.0
+	for _, credit := range credits {
+		available += credit.Remaining
+	}
+
+	if available+1e-9 >= days {
+		return nil, nil
+	}
+
+	return &PolicyViolation{
+		Code:      ViolationCompOff,
+		Message:   fmt.Sprintf("%s leave needs %.2f comp-off days but only %.2f are available", leaveType, days, available),
+		LeaveType: leaveType,
+	}, nil
+
+}
+
+// spendCompOff takes a confirmed leave's days from the user's credits. The
+// balance was checked when the leave was requested, so a shortfall means
+// the credits were spent or expired in between.
+func (s *leaveService) spendCompOff(ctx context.Context, leaveItem *LeaveRequests) error {
+
+	days := leaveItem.Days()
+
+	spent, err := s.leaveRepository.SpendCompOff(ctx, leaveItem.OrganizationID, leaveItem.UserID, leaveItem.ID, days, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if spent > 0 {
+		reason := fmt.Sprintf("Comp-off used - %s", formatLeaveRange(leaveItem))
+		err = s.recordCompOffShares(ctx, leaveItem, TransactionCompOffUsed, leaveItem.Dates(), spent, reason)
+		if err != nil {
+			return err
+		}
+	}
+
+	if spent+1e-9 < days {
+		return fmt.Errorf("only %.2f of %.2f comp-off days were available", spent, days)
+	}
+
+	return nil
+
+}
+
+func (s *leaveService) refundCompOff(ctx context.Context, leaveItem *LeaveRequests, dates []time.Time) error {
+
+	days := float64(len(dates)) * leaveItem.dayLength()
+
+	refunded, err := s.leaveRepository.RefundCompOff(ctx, leaveItem.OrganizationID, leaveItem.UserID, leaveItem.ID, days, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if refunded <= 0 {
+		return nil
+	}
+
+	reason := fmt.Sprintf("Comp-off refunded - %s", formatLeaveRange(leaveItem))
+
+	return s.recordCompOffShares(ctx, leaveItem, TransactionCompOffRefund, dates, refunded, reason)
+
+}
+
+// recordCompOffShares writes amount days of a leave's comp-off movement to
+// the ledger, split across the leave years of dates. When fewer days moved
+// than the dates cover, the earliest years take them.
+func (s *leaveService) recordCompOffShares(ctx context.Context, leaveItem *LeaveRequests, transactionType string, dates []time.Time, amount float64, reason string) error {
+
+	setting, err := s.balanceSetting(ctx, leaveItem.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	leaveID := leaveItem.ID
+
+	for _, share := range capShares(setting.splitByLeaveYear(dates, leaveItem.dayLength()), amount) {
+		err = s.recordCompOffTransaction(ctx, leaveItem.OrganizationID, leaveItem.UserID, transactionType, share.Days, share.Date, &leaveID, reason)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// recordCompOffTransaction writes a comp-off movement to the user's ledger
+// for the leave year of date. Comp-off is tracked on its claims, so the
+// annual balance totals are left alone.
+func (s *leaveService) recordCompOffTransaction(ctx context.Context, organizationID string, userID string, transactionType string, amount float64, date time.Time, leaveID *primitive.ObjectID, reason string) error {
+
+	setting, err := s.balanceSetting(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	return s.leaveRepository.CreateLeaveTransaction(ctx, []*LeaveTransaction{
+		{
+			ID:              primitive.NewObjectID(),
+			OrganizationID:  organizationID,
+			UserID:          userID,
+			Year:            setting.leaveYearOf(date).Year,
+			TransactionType: transactionType,
+			Amount:          math.Round(amount*100) / 100,
+			LeaveID:         leaveID,
+			Date:            date,
+			Reason:          &reason,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+		},
+	})
+
+}
+
+// CreateCompOffClaim stores a claim unless its attendance day was already
+// claimed; it reports whether the claim was created.
+func (r *leaveRepository) CreateCompOffClaim(ctx context.Context, claim *CompOffClaim) (bool, error) {
+
+	_, err := r.collectionCompOff.InsertOne(ctx, claim)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+
+}
+
+func (r *leaveRepository) GetCompOffClaims(ctx context.Context, organizationID string, userID string, status string) ([]*CompOffClaim, error) {
+
+	var claims []*CompOffClaim
+
+	filter := bson.M{"organization_id": organizationID}
+
+	if userID != "" {
+		filter["user_id"] = userID
+	}
+
+	if status != "" {
+		filter["status"] = status
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "date", Value: -1}})
+
+	cursor, err := r.collectionCompOff.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+
+}
+
+func (r *leaveRepository) GetCompOffClaimByID(ctx context.Context, organizationID string, id primitive.ObjectID) (*CompOffClaim, error) {
+
+	var claim CompOffClaim
+
+	err := r.collectionCompOff.FindOne(ctx, bson.M{"_id": id, "organization_id": organizationID}).Decode(&claim)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("comp-off claim not found")
+		}
+		return nil, err
+	}
+
+	return &claim, nil
+
+}
+
+// ReviewCompOffClaim settles a pending claim. An approved claim becomes
+// spendable credit for its full days.
+func (r *leaveRepository) ReviewCompOffClaim(ctx context.Context, claim *CompOffClaim, review *LeaveReview, expiresAt *time.Time) error {
+
+	filter := bson.M{"_id": claim.ID, "status": CompOffPending}
+
+	set := bson.M{
+		"status": review.Action,
+		"review": review,
+	}
+
+	if review.Action == CompOffApproved {
+		set["remaining"] = claim.Days
+		if expiresAt != nil {
+			set["expires_at"] = *expiresAt
+		}
+	}
+
+	result, err := r.collectionCompOff.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("comp-off claim was already reviewed")
+	}
+
+	return nil
+
+}
+
+// GetCompOffCredits returns the approved claims that can still be spent,
+// soonest to expire first and those that never expire last.
+func (r *leaveRepository) GetCompOffCredits(ctx context.Context, organizationID string, userID string, now time.Time) ([]*CompOffClaim, error) {
+
+	var claims []*CompOffClaim
+
+	filter := bson.M{
+		"organization_id": organizationID,
+		"user_id":         userID,
+		"status":          CompOffApproved,
+		"remaining":       bson.M{"$gt": 0},
+		"$or": []bson.M{
+			{"expires_at": bson.M{"$exists": false}},
+			{"expires_at": bson.M{"$gt": now}},
+		},
+	}
+
+	cursor, err := r.collectionCompOff.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &claims)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(claims, func(i, j int) bool {
+		a, b := claims[i].ExpiresAt, claims[j].ExpiresAt
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return a.Before(*b)
+	})
+
+	return claims, nil
+
+}
+
+// SpendCompOff takes days from the user's credits, soonest to expire
+// first, and returns how much it could take. Each take is conditional on
+// the credit still being there, so concurrent spends cannot overdraw it.
+func (r *leaveRepository) SpendCompOff(ctx context.Context, organizationID string, userID string, leaveID primitive.ObjectID, days float64, now time.Time) (float64, error) {
+
+	credits, err := r.GetCompOffCredits(ctx, organizationID, userID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	spent := 0.0
+
+	for _, credit := range credits {
+
+		take := math.Min(credit.Remaining, days-spent)
+		if take <= 0 {
+			break
+		}
+
+		result, err := r.collectionCompOff.UpdateOne(ctx,
+			bson.M{"_id": credit.ID, "remaining": bson.M{"$gte": take}},
+			bson.M{
+				"$inc":  bson.M{"remaining": -take},
+				"$push": bson.M{"usages": CompOffUsage{LeaveID: leaveID, Days: take, UsedAt: now}},
+			},
+		)
+		if err != nil {
+			return spent, err
+		}
+
+		if result.ModifiedCount > 0 {
+			spent += take
+		}
+
+	}
+
+	return spent, nil
+
+}
+
+// RefundCompOff gives days a leave request took back to the credits it
+// took them from, latest to expire first. Days returned to a credit that
+// has already expired stay unusable.
+func (r *leaveRepository) RefundCompOff(ctx context.Context, organizationID string, userID string, leaveID primitive.ObjectID, days float64, now time.Time) (float64, error) {
+
+	var claims []*CompOffClaim
+
+	cursor, err := r.collectionCompOff.Find(ctx, bson.M{
+		"organization_id": organizationID,
+		"user_id":         userID,
+		"usages.leave_id": leaveID,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &claims)
+	if err != nil {
+		return 0, err
+	}
+
+	refunded := 0.0
+
+	for i := len(claims) - 1; i >= 0; i-- {
+
+		used := 0.0
+		for _, usage := range claims[i].Usages {
+			if usage.LeaveID == leaveID {
+				used += usage.Days
+			}
+		}
+
+		give := math.Min(used, days-refunded)
+		if give <= 0 {
+			continue
+		}
+
+		_, err := r.collectionCompOff.UpdateOne(ctx,
+			bson.M{"_id": claims[i].ID},
+			bson.M{
+				"$inc":  bson.M{"remaining": give},
+				"$push": bson.M{"usages": CompOffUsage{LeaveID: leaveID, Days: -give, UsedAt: now}},
+			},
+		)
+		if err != nil {
+			return refunded, err
+		}
+
+		refunded += give
+
+	}
+
+	return refunded, nil
+
+}
+
+// ExpireCompOffClaims zeroes the credits past their expiry and returns
+// them with the days that lapsed. expired_at marks a claim as done, so a
+// claim is only expired once.
+func (r *leaveRepository) ExpireCompOffClaims(ctx context.Context, now time.Time) ([]*CompOffClaim, error) {
+
+	filter := bson.M{
+		"status":     CompOffApproved,
+		"expires_at": bson.M{"$lte": now},
+		"expired_at": bson.M{"$exists": false},
+	}
+
+	ids, err := r.collectionCompOff.Distinct(ctx, "_id", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"expired_days": "$remaining",
+			"remaining":    0,
+			"expired_at":   now,
+		}}},
+	}
+
+	var expired []*CompOffClaim
+
+	for _, id := range ids {
+
+		var claim CompOffClaim
+
+		err := r.collectionCompOff.FindOneAndUpdate(ctx,
+			bson.M{"_id": id, "expired_at": bson.M{"$exists": false}},
+			update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&claim)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+
+		expired = append(expired, &claim)
+
+	}
+
+	return expired, nil
+
+}
@@ -0,0 +1,350 @@
+package leave
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCompOffDays(t *testing.T) {
+
+	tests := []struct {
+		hours float64
+		want  float64
+	}{
+		{hours: 0, want: 0},
+		{hours: 1, want: 0.13},
+		{hours: 4, want: 0.5},
+		{hours: 8, want: 1},
+		{hours: 10, want: 1.25},
+		{hours: 2.5, want: 0.31},
+	}
+
+	for _, tt := range tests {
+		if got := compOffDays(tt.hours); got != tt.want {
+			t.Errorf("compOffDays(%v) = %v, want %v", tt.hours, got, tt.want)
+		}
+	}
+
+}
+
+func TestClaimCompOff(t *testing.T) {
+
+	claimed := primitive.NewObjectID()
+
+	overtime := fakeOvertimeSource{
+		{AttendanceID: primitive.NewObjectID(), UserID: "teacher-1", Date: parseDay("2030-05-06"), OvertimeHours: 4},
+		{AttendanceID: primitive.NewObjectID(), UserID: "teacher-1", Date: parseDay("2030-05-07"), OvertimeHours: 0},
+		{AttendanceID: primitive.NewObjectID(), UserID: "teacher-1", Date: parseDay("2030-05-08"), OvertimeHours: 8},
+		{AttendanceID: claimed, UserID: "teacher-1", Date: parseDay("2030-05-09"), OvertimeHours: 2},
+		{AttendanceID: primitive.NewObjectID(), UserID: "teacher-2", Date: parseDay("2030-05-06"), OvertimeHours: 6},
+	}
+
+	tests := []struct {
+		name     string
+		request  CompOffClaimRequest
+		wantDays map[string]float64
+		wantErr  string
+	}{
+		{
+			name:     "skips days without overtime and days already claimed",
+			request:  CompOffClaimRequest{UserID: "teacher-1", DateFrom: "2030-05-01", DateTo: "2030-05-31"},
+			wantDays: map[string]float64{"2030-05-06": 0.5, "2030-05-08": 1},
+		},
+		{
+			name:     "a single day when date to is left out",
+			request:  CompOffClaimRequest{UserID: "teacher-1", DateFrom: "2030-05-08"},
+			wantDays: map[string]float64{"2030-05-08": 1},
+		},
+		{
+			name:    "nothing left to claim",
+			request: CompOffClaimRequest{UserID: "teacher-1", DateFrom: "2030-05-09", DateTo: "2030-05-12"},
+			wantErr: "no unclaimed overtime",
+		},
+		{
+			name:    "reversed range",
+			request: CompOffClaimRequest{UserID: "teacher-1", DateFrom: "2030-05-09", DateTo: "2030-05-01"},
+			wantErr: "must not be before",
+		},
+		{
+			name:    "user id is required",
+			request: CompOffClaimRequest{DateFrom: "2030-05-06"},
+			wantErr: "user id is required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			previous := &CompOffClaim{ID: primitive.NewObjectID(), OrganizationID: "org-1", UserID: "teacher-1", AttendanceID: claimed}
+			repository := &fakeLeaveRepository{compOffClaims: map[primitive.ObjectID]*CompOffClaim{previous.ID: previous}}
+
+			service := &leaveService{
+				leaveRepository: repository,
+				userService:     &fakeUserService{currentUser: &user.CurrentUser{ID: "teacher-1", OrganizationIdActive: "org-1"}},
+				overtimeSource:  overtime,
+			}
+
+			claims, err := service.ClaimCompOff(context.Background(), &tt.request)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ClaimCompOff: %v", err)
+			}
+
+			got := map[string]float64{}
+			for _, claim := range claims {
+				if claim.Status != CompOffPending || claim.OrganizationID != "org-1" || claim.UserID != "teacher-1" {
+					t.Errorf("claim = %+v", claim)
+				}
+				got[claim.Date.Format("2006-01-02")] = claim.Days
+			}
+
+			if len(got) != len(tt.wantDays) {
+				t.Fatalf("claimed %v, want %v", got, tt.wantDays)
+			}
+			for day, days := range tt.wantDays {
+				if got[day] != days {
+					t.Errorf("claimed %v, want %v", got, tt.wantDays)
+				}
+			}
+
+		})
+	}
+
+}
+
+func TestApproveCompOffClaimSetsExpiry(t *testing.T) {
+
+	tests := []struct {
+		name       string
+		expiryDays int
+	}{
+		{name: "credits that never expire", expiryDays: 0},
+		{name: "credits that lapse after 90 days", expiryDays: 90},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			claim := &CompOffClaim{
+				ID:             primitive.NewObjectID(),
+				OrganizationID: "org-1",
+				UserID:         "teacher-1",
+				Date:           parseDay("2030-05-06"),
+				OvertimeHours:  4,
+				Days:           0.5,
+				Status:         CompOffPending,
+			}
+
+			repository := &fakeLeaveRepository{
+				compOffClaims: map[primitive.ObjectID]*CompOffClaim{claim.ID: claim},
+				setting:       &Setting{OrganizationID: "org-1", YearBoundary: YearBoundaryCalendar, CompOffExpiryDays: tt.expiryDays},
+			}
+			service := &leaveService{
+				leaveRepository: repository,
+				userService:     &fakeUserService{currentUser: &user.CurrentUser{ID: "admin-1", OrganizationIdActive: "org-1", IsSuperAdmin: true}},
+			}
+
+			before := time.Now()
+
+			approved, err := service.ApproveCompOffClaim(context.Background(), &ReviewLeaveRequest{ReviewerID: "admin-1"}, claim.ID.Hex())
+			if err != nil {
+				t.Fatalf("ApproveCompOffClaim: %v", err)
+			}
+
+			if approved.Status != CompOffApproved || approved.Remaining != 0.5 {
+				t.Errorf("claim = %+v, want approved with 0.5 days left", approved)
+			}
+
+			if tt.expiryDays == 0 {
+				if approved.ExpiresAt != nil {
+					t.Errorf("expires at %v, want never", approved.ExpiresAt)
+				}
+			} else if approved.ExpiresAt == nil || approved.ExpiresAt.Before(before.AddDate(0, 0, tt.expiryDays)) {
+				t.Errorf("expires at %v, want %d days after approval", approved.ExpiresAt, tt.expiryDays)
+			}
+
+			if len(repository.transactions) != 1 {
+				t.Fatalf("transactions = %d, want 1", len(repository.transactions))
+			}
+			earned := repository.transactions[0]
+			if earned.TransactionType != TransactionCompOffEarned || earned.Amount != 0.5 || earned.Year != 2030 {
+				t.Errorf("transaction = %+v", earned)
+			}
+
+		})
+	}
+
+}
+
+func TestSpendCompOff(t *testing.T) {
+
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	yesterday := time.Now().AddDate(0, 0, -1)
+
+	tests := []struct {
+		name    string
+		credits []CompOffClaim
+		dates   []string
+		portion string
+
+		wantSpent float64
+		wantErr   string
+	}{
+		{
+			name:      "one credit covers the day",
+			credits:   []CompOffClaim{{Remaining: 1}},
+			dates:     []string{"2030-06-03"},
+			portion:   PortionFull,
+			wantSpent: 1,
+		},
+		{
+			name:      "a half day across two credits",
+			credits:   []CompOffClaim{{Remaining: 0.25, ExpiresAt: &tomorrow}, {Remaining: 0.25}},
+			dates:     []string{"2030-06-03"},
+			portion:   PortionAM,
+			wantSpent: 0.5,
+		},
+		{
+			name:      "an expired credit is not spent",
+			credits:   []CompOffClaim{{Remaining: 1, ExpiresAt: &yesterday}, {Remaining: 0.5}},
+			dates:     []string{"2030-06-03"},
+			portion:   PortionFull,
+			wantSpent: 0.5,
+			wantErr:   "only 0.50 of 1.00",
+		},
+		{
+			name:      "credits spent in between",
+			credits:   []CompOffClaim{{Remaining: 1}},
+			dates:     []string{"2030-06-03", "2030-06-04"},
+			portion:   PortionFull,
+			wantSpent: 1,
+			wantErr:   "only 1.00 of 2.00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			repository := &fakeLeaveRepository{compOffClaims: map[primitive.ObjectID]*CompOffClaim{}}
+			for _, credit := range tt.credits {
+				credit.ID = primitive.NewObjectID()
+				credit.OrganizationID = "org-1"
+				credit.UserID = "teacher-1"
+				credit.Status = CompOffApproved
+				repository.compOffClaims[credit.ID] = &credit
+			}
+
+			var dates []time.Time
+			for _, date := range tt.dates {
+				dates = append(dates, parseDay(date))
+			}
+
+			leaveItem := &LeaveRequests{
+				ID:             primitive.NewObjectID(),
+				OrganizationID: "org-1",
+				UserID:         "teacher-1",
+				StartDate:      dates[0],
+				EndDate:        dates[len(dates)-1],
+				LeaveDates:     dates,
+				Portion:        tt.portion,
+				LeaveType:      LeaveTypeCompOff,
+				Policy:         &LeavePolicy{ConsumesSlot: true, UsesCompOff: true},
+			}
+
+			service := &leaveService{leaveRepository: repository}
+
+			err := service.debitLeaveBalance(context.Background(), leaveItem)
+
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("debitLeaveBalance: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+
+			var used float64
+			for _, transaction := range repository.transactions {
+				if transaction.TransactionType != TransactionCompOffUsed || transaction.LeaveID == nil || *transaction.LeaveID != leaveItem.ID {
+					t.Errorf("transaction = %+v", transaction)
+				}
+				used += transaction.Amount
+			}
+
+			if used != tt.wantSpent {
+				t.Errorf("ledger shows %v days used, want %v", used, tt.wantSpent)
+			}
+
+		})
+	}
+
+}
+
+func TestExpireCompOffCredits(t *testing.T) {
+
+	lapsed := time.Now().Add(-time.Hour)
+	later := time.Now().AddDate(0, 1, 0)
+
+	tests := []struct {
+		name        string
+		claim       CompOffClaim
+		wantExpired float64
+	}{
+		{name: "unspent credit lapses", claim: CompOffClaim{Days: 1, Remaining: 1, ExpiresAt: &lapsed}, wantExpired: 1},
+		{name: "what is left of a spent credit lapses", claim: CompOffClaim{Days: 1, Remaining: 0.25, ExpiresAt: &lapsed}, wantExpired: 0.25},
+		{name: "a fully spent credit writes nothing", claim: CompOffClaim{Days: 1, Remaining: 0, ExpiresAt: &lapsed}},
+		{name: "a credit not yet due stays", claim: CompOffClaim{Days: 1, Remaining: 1, ExpiresAt: &later}},
+		{name: "a credit without expiry stays", claim: CompOffClaim{Days: 1, Remaining: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			claim := tt.claim
+			claim.ID = primitive.NewObjectID()
+			claim.OrganizationID = "org-1"
+			claim.UserID = "teacher-1"
+			claim.Date = parseDay("2030-05-06")
+			claim.Status = CompOffApproved
+
+			repository := &fakeLeaveRepository{compOffClaims: map[primitive.ObjectID]*CompOffClaim{claim.ID: &claim}}
+			service := &leaveService{leaveRepository: repository}
+
+			// A second run must not expire the same credit again.
+			for range 2 {
+				if err := service.ExpireCompOffCredits(context.Background()); err != nil {
+					t.Fatalf("ExpireCompOffCredits: %v", err)
+				}
+			}
+
+			var expired float64
+			for _, transaction := range repository.transactions {
+				if transaction.TransactionType != TransactionCompOffExpired || transaction.UserID != "teacher-1" {
+					t.Errorf("transaction = %+v", transaction)
+				}
+				expired += transaction.Amount
+			}
+
+			if expired != tt.wantExpired {
+				t.Errorf("ledger shows %v days expired, want %v", expired, tt.wantExpired)
+			}
+
+			if tt.wantExpired > 0 && claim.Remaining != 0 {
+				t.Errorf("remaining = %v after expiry", claim.Remaining)
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,40 @@
+package leave
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// StartLeaveBalanceCron runs the monthly accrual, comp-off expiry and the
+// expiry of stale pending requests once at startup and then on every tick.
+// Each is idempotent, so a short interval only catches up what has not been
+// applied yet.
+func StartLeaveBalanceCron(ctx context.Context, leaveService LeaveService, interval time.Duration) {
+
+	run := func() {
+		if err := leaveService.AddCronLeavesBalance(ctx); err != nil {
+			log.Printf("[leaveCron] add leaves balance error: %v", err)
+		}
+		if err := leaveService.ExpireCompOffCredits(ctx); err != nil {
+			log.Printf("[leaveCron] expire comp-off error: %v", err)
+		}
+		if err := leaveService.ExpireStalePendingRequests(ctx); err != nil {
+			log.Printf("[leaveCron] expire pending requests error: %v", err)
+		}
+	}
+
+	run()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			run()
+		}
+	}
+}
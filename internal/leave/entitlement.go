@@ -0,0 +1,390 @@
+package leave
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// entitlementFor works out the annual leave of period for an employee who
+// started on startDate. Tenure is counted in completed years at the end of
+// the leave year, so the extra day is granted in the year it is earned.
+func (table *EntitlementTable) entitlementFor(startDate *time.Time, period leaveYear) Entitlement {
+
+	entitlement := Entitlement{
+		Year:           period.Year,
+		StartDate:      startDate,
+		BaseDays:       table.BaseDays,
+		EmployedMonths: 12,
+		firstMonth:     1,
+	}
+
+	if startDate != nil {
+
+		if !startDate.Before(period.End) {
+			entitlement.EmployedMonths = 0
+			entitlement.firstMonth = 13
+			return entitlement
+		}
+
+		entitlement.TenureYears = completedYears(*startDate, period.End.AddDate(0, 0, -1))
+
+		for _, tier := range table.Tiers {
+			if entitlement.TenureYears >= tier.MinYears {
+				entitlement.BaseDays = tier.Days
+			}
+		}
+
+		if table.SeniorityYears > 0 {
+			entitlement.SeniorityDays = float64(entitlement.TenureYears/table.SeniorityYears) * table.SeniorityDays
+		}
+
+		if table.ProrateFirstYear && startDate.After(period.Start) {
+			entitlement.firstMonth = period.month(*startDate)
+			entitlement.EmployedMonths = 12 - entitlement.firstMonth + 1
+		}
+
+	}
+
+	entitlement.Days = entitlement.earnedThrough(12)
+
+	return entitlement
+
+}
+
+// earnedThrough is the leave earned from the start of the leave year to the
+// end of the given month, one twelfth of the yearly rate per month worked.
+func (entitlement *Entitlement) earnedThrough(month int) float64 {
+
+	months := month - entitlement.firstMonth + 1
+	if months <= 0 {
+		return 0
+	}
+
+	yearly := entitlement.BaseDays + entitlement.SeniorityDays
+
+	return math.Round(yearly*float64(months)/12*100) / 100
+
+}
+
+func completedYears(from time.Time, to time.Time) int {
+
+	years := to.Year() - from.Year()
+	if to.Month() < from.Month() || to.Month() == from.Month() && to.Day() < from.Day() {
+		years--
+	}
+
+	return max(years, 0)
+
+}
+
+// userEntitlement looks up the table and start date that apply to the user
+// and works out the entitlement for period.
+func (s *leaveService) userEntitlement(ctx context.Context, userID string, setting *Setting, period leaveYear) (*Entitlement, error) {
+
+	table, err := s.leaveRepository.GetEntitlementTable(ctx, setting.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	var startDate *time.Time
+
+	if setting.OrganizationID != "" {
+		employment, err := s.leaveRepository.GetEmploymentStartDate(ctx, setting.OrganizationID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if employment != nil {
+			startDate = &employment.StartDate
+		}
+	}
+
+	entitlement := table.entitlementFor(startDate, period)
+
+	return &entitlement, nil
+
+}
+
+// rememberStartDate records the current user's account creation date as
+// their start date, unless one is already known. Only the session's own
+// account carries a creation date, so nobody else's start date can be
+// learned this way; the balance cron picks those up from the member list.
+func (s *leaveService) rememberStartDate(ctx context.Context, currentUser *user.CurrentUser) {
+
+	if currentUser.OrganizationIdActive == "" {
+		return
+	}
+
+	s.saveAccountStartDate(ctx, currentUser.OrganizationIdActive, currentUser.ID, currentUser.CreatedAt)
+
+}
+
+// saveAccountStartDate stores an account creation date as the user's start
+// date; a missing or unreadable date is ignored.
+func (s *leaveService) saveAccountStartDate(ctx context.Context, organizationID string, userID string, createdAt string) {
+
+	startDate, err := parseAccountDate(createdAt)
+	if err != nil {
+		return
+	}
+
+	s.leaveRepository.SaveEmploymentStartDate(ctx, &EmploymentStartDate{
+		OrganizationID: organizationID,
+		UserID:         userID,
+		StartDate:      startDate,
+		Source:         StartDateSourceAccount,
+		UpdatedAt:      time.Now(),
+	})
+
+}
+
+// hasStartDate reports whether the user's start date is known. Balances
+// without an organization have no start dates and count as known.
+func (s *leaveService) hasStartDate(ctx context.Context, organizationID string, userID string) (bool, error) {
+
+	if organizationID == "" {
+		return true, nil
+	}
+
+	employment, err := s.leaveRepository.GetEmploymentStartDate(ctx, organizationID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	return employment != nil, nil
+
+}
+
+// parseAccountDate reads the date part of the main service's timestamps,
+// whatever their time and zone format.
+func parseAccountDate(value string) (time.Time, error) {
+
+	if len(value) < len("2006-01-02") {
+		return time.Time{}, fmt.Errorf("invalid date: %q", value)
+	}
+
+	return time.Parse("2006-01-02", value[:len("2006-01-02")])
+
+}
+
+func (s *leaveService) GetEntitlementTable(ctx context.Context) (*EntitlementTable, error) {
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetEntitlementTable(ctx, organizationID)
+
+}
+
+func (s *leaveService) UpdateEntitlementTable(ctx context.Context, req *EntitlementTableRequest) (*EntitlementTable, error) {
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isOrganizationAdmin(currentUser) {
+		return nil, fmt.Errorf("only organization admins can change leave entitlements")
+	}
+
+	if req.BaseDays < 0 || req.SeniorityDays < 0 || req.SeniorityYears < 0 {
+		return nil, fmt.Errorf("entitlement days and years must not be negative")
+	}
+
+	tiers := slices.Clone(req.Tiers)
+	slices.SortFunc(tiers, func(a, b EntitlementTier) int {
+		return a.MinYears - b.MinYears
+	})
+
+	for i, tier := range tiers {
+		if tier.MinYears < 0 || tier.Days < 0 {
+			return nil, fmt.Errorf("entitlement tier years and days must not be negative")
+		}
+		if i > 0 && tiers[i-1].MinYears == tier.MinYears {
+			return nil, fmt.Errorf("more than one entitlement tier for %d years", tier.MinYears)
+		}
+	}
+
+	if tiers == nil {
+		tiers = []EntitlementTier{}
+	}
+
+	prorate := true
+	if req.ProrateFirstYear != nil {
+		prorate = *req.ProrateFirstYear
+	}
+
+	table := &EntitlementTable{
+		OrganizationID:   currentUser.OrganizationIdActive,
+		BaseDays:         req.BaseDays,
+		Tiers:            tiers,
+		SeniorityYears:   req.SeniorityYears,
+		SeniorityDays:    req.SeniorityDays,
+		ProrateFirstYear: prorate,
+		UpdatedAt:        time.Now(),
+	}
+
+	return s.leaveRepository.UpdateEntitlementTable(ctx, table)
+
+}
+
+// SetEmploymentStartDate lets HR set a user's start date, replacing the one
+// read from their account.
+func (s *leaveService) SetEmploymentStartDate(ctx context.Context, req *EmploymentStartDateRequest, userID string) (*EmploymentStartDate, error) {
+
+	if userID == "" {
+		return nil, fmt.Errorf("user id is required")
+	}
+
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return nil, err
+	}
+
+	if startDate.After(time.Now()) {
+		return nil, fmt.Errorf("start date must not be in the future")
+	}
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isOrganizationAdmin(currentUser) {
+		return nil, fmt.Errorf("only organization admins can set start dates")
+	}
+
+	updatedBy := req.UpdatedBy
+	if updatedBy == "" {
+		updatedBy = currentUser.ID
+	}
+
+	return s.leaveRepository.SaveEmploymentStartDate(ctx, &EmploymentStartDate{
+		OrganizationID: currentUser.OrganizationIdActive,
+		UserID:         userID,
+		StartDate:      startDate,
+		Source:         StartDateSourceHR,
+		UpdatedBy:      updatedBy,
+		UpdatedAt:      time.Now(),
+	})
+
+}
+
+// GetEntitlementTable returns the organization's table, or the statutory
+// default when it has not set one: 12 days, one more per five years of
+// service, prorated in the first year.
+func (r *leaveRepository) GetEntitlementTable(ctx context.Context, organizationID string) (*EntitlementTable, error) {
+
+	var table EntitlementTable
+
+	err := r.collectionEntitlement.FindOne(ctx, bson.M{"organization_id": organizationID}).Decode(&table)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return &EntitlementTable{
+				OrganizationID:   organizationID,
+				BaseDays:         12,
+				SeniorityYears:   5,
+				SeniorityDays:    1,
+				ProrateFirstYear: true,
+			}, nil
+		}
+		return nil, err
+	}
+
+	return &table, nil
+
+}
+
+func (r *leaveRepository) UpdateEntitlementTable(ctx context.Context, table *EntitlementTable) (*EntitlementTable, error) {
+
+	filter := bson.M{"organization_id": table.OrganizationID}
+
+	update := bson.M{
+		"$set": bson.M{
+			"base_days":          table.BaseDays,
+			"tiers":              table.Tiers,
+			"seniority_years":    table.SeniorityYears,
+			"seniority_days":     table.SeniorityDays,
+			"prorate_first_year": table.ProrateFirstYear,
+			"updated_at":         table.UpdatedAt,
+		},
+		"$setOnInsert": bson.M{
+			"_id":        primitive.NewObjectID(),
+			"created_at": table.UpdatedAt,
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var updated EntitlementTable
+
+	err := r.collectionEntitlement.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+
+}
+
+func (r *leaveRepository) GetEmploymentStartDate(ctx context.Context, organizationID string, userID string) (*EmploymentStartDate, error) {
+
+	var startDate EmploymentStartDate
+
+	err := r.collectionEmployment.FindOne(ctx, bson.M{"organization_id": organizationID, "user_id": userID}).Decode(&startDate)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &startDate, nil
+
+}
+
+// SaveEmploymentStartDate stores a start date. A date set by HR replaces
+// whatever is there; one read from the account is only kept when there is
+// nothing yet, so it never overrides HR.
+func (r *leaveRepository) SaveEmploymentStartDate(ctx context.Context, startDate *EmploymentStartDate) (*EmploymentStartDate, error) {
+
+	filter := bson.M{"organization_id": startDate.OrganizationID, "user_id": startDate.UserID}
+
+	fields := bson.M{
+		"start_date": startDate.StartDate,
+		"source":     startDate.Source,
+		"updated_by": startDate.UpdatedBy,
+		"updated_at": startDate.UpdatedAt,
+	}
+
+	update := bson.M{"$set": fields, "$setOnInsert": bson.M{"_id": primitive.NewObjectID()}}
+	if startDate.Source != StartDateSourceHR {
+		fields["_id"] = primitive.NewObjectID()
+		update = bson.M{"$setOnInsert": fields}
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var saved EmploymentStartDate
+
+	err := r.collectionEmployment.FindOneAndUpdate(ctx, filter, update, opts).Decode(&saved)
+	if mongo.IsDuplicateKeyError(err) {
+		err = r.collectionEmployment.FindOne(ctx, filter).Decode(&saved)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &saved, nil
+
+}
@@ -0,0 +1,75 @@
+package leave
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEntitlementFor(t *testing.T) {
+
+	period := leaveYear{Year: 2030, Start: parseDay("2030-01-01"), End: parseDay("2031-01-01")}
+
+	standard := &EntitlementTable{BaseDays: 12, SeniorityYears: 5, SeniorityDays: 1, ProrateFirstYear: true}
+	tiered := &EntitlementTable{BaseDays: 12, Tiers: []EntitlementTier{{MinYears: 3, Days: 14}, {MinYears: 10, Days: 16}}}
+	flat := &EntitlementTable{BaseDays: 12}
+
+	day := func(value string) *time.Time {
+		date := parseDay(value)
+		return &date
+	}
+
+	tests := []struct {
+		name      string
+		table     *EntitlementTable
+		startDate *time.Time
+		days      float64
+		months    int
+		tenure    int
+	}{
+		{name: "unknown start date gets the full base", table: standard, days: 12, months: 12},
+		{name: "two seniority steps", table: standard, startDate: day("2020-03-01"), days: 14, months: 12, tenure: 10},
+		{name: "five years completed on the last day", table: standard, startDate: day("2025-12-31"), days: 13, months: 12, tenure: 5},
+		{name: "five years completed next year", table: standard, startDate: day("2026-01-01"), days: 12, months: 12, tenure: 4},
+		{name: "started on the first day is not prorated", table: standard, startDate: day("2030-01-01"), days: 12, months: 12},
+		{name: "first year is prorated from the start month", table: standard, startDate: day("2030-04-10"), days: 9, months: 9},
+		{name: "start after the leave year earns nothing", table: standard, startDate: day("2031-01-01"), days: 0, months: 0},
+		{name: "highest tier reached", table: tiered, startDate: day("2020-06-01"), days: 16, months: 12, tenure: 10},
+		{name: "middle tier", table: tiered, startDate: day("2027-06-01"), days: 14, months: 12, tenure: 3},
+		{name: "proration off", table: flat, startDate: day("2030-07-01"), days: 12, months: 12},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got := tt.table.entitlementFor(tt.startDate, period)
+
+			if got.Days != tt.days || got.EmployedMonths != tt.months || got.TenureYears != tt.tenure {
+				t.Errorf("entitlementFor = %v days, %d months, %d years, want %v days, %d months, %d years",
+					got.Days, got.EmployedMonths, got.TenureYears, tt.days, tt.months, tt.tenure)
+			}
+
+		})
+	}
+
+}
+
+func TestEarnedThrough(t *testing.T) {
+
+	entitlement := Entitlement{BaseDays: 12, SeniorityDays: 1, firstMonth: 4}
+
+	tests := []struct {
+		month int
+		want  float64
+	}{
+		{month: 3, want: 0},
+		{month: 4, want: 1.08},
+		{month: 12, want: 9.75},
+	}
+
+	for _, tt := range tests {
+		if got := entitlement.earnedThrough(tt.month); got != tt.want {
+			t.Errorf("earnedThrough(%d) = %v, want %v", tt.month, got, tt.want)
+		}
+	}
+
+}
@@ -0,0 +1,407 @@
+package leave
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const notificationLeaveExpired = "LEAVE_REQUEST_EXPIRED"
+
+// pendingExpiryCutoff returns the first day a still pending request may
+// start on and stay open. PendingExpiryDays expires requests that many days
+// before their first day; with none set, a request stays open through it.
+func pendingExpiryCutoff(setting *Setting, today time.Time) time.Time {
+
+	if setting.PendingExpiryDays <= 0 {
+		return today
+	}
+
+	return today.AddDate(0, 0, setting.PendingExpiryDays+1)
+
+}
+
+// ExpireStalePendingRequests expires the requests of every organization
+// that are still pending too close to, or after, their first day. A request
+// that fails to expire is left for the next run. A notice that fails to
+// post does not undo the expiry; the request stays flagged and the next run
+// posts it again.
+func (s *leaveService) ExpireStalePendingRequests(ctx context.Context) error {
+
+	var errs []error
+
+	unnotified, err := s.leaveRepository.GetExpiryNotificationsPending(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("get unsent expiry notifications: %w", err))
+	}
+
+	for _, leaveItem := range unnotified {
+		if err := s.notifyLeaveExpired(ctx, leaveItem); err != nil {
+			errs = append(errs, fmt.Errorf("notify expiry of leave %s: %w", leaveItem.ID.Hex(), err))
+		}
+	}
+
+	organizationIDs, err := s.leaveRepository.GetPendingOrganizationIDs(ctx)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	now := time.Now()
+	today := leaveToday(now)
+
+	for _, organizationID := range organizationIDs {
+
+		setting, err := s.leaveRepository.GetSettings(ctx, organizationID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("get settings for organization %s: %w", organizationID, err))
+			continue
+		}
+
+		leaveRequests, err := s.leaveRepository.GetStalePendingLeaves(ctx, organizationID, pendingExpiryCutoff(setting, today))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("get pending requests for organization %s: %w", organizationID, err))
+			continue
+		}
+
+		for _, leaveItem := range leaveRequests {
+			if err := s.expireLeave(ctx, leaveItem, now); err != nil {
+				errs = append(errs, fmt.Errorf("expire leave %s: %w", leaveItem.ID.Hex(), err))
+			}
+		}
+
+	}
+
+	return errors.Join(errs...)
+
+}
+
+func (s *leaveService) expireLeave(ctx context.Context, leaveItem *LeaveRequests, now time.Time) error {
+
+	err := s.leaveRepository.ExpireLeave(ctx, leaveItem, now)
+	if err != nil {
+		return err
+	}
+
+	detail := fmt.Sprintf("Pending request for %s expired without being confirmed", formatLeaveRange(leaveItem))
+
+	err = s.leaveRepository.CreateLeaveAudit(ctx, &LeaveAudit{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: leaveItem.OrganizationID,
+		LeaveID:        leaveItem.ID,
+		UserID:         leaveItem.UserID,
+		Action:         AuditActionExpired,
+		FromStatus:     "pending",
+		ToStatus:       "expired",
+		ActorID:        AuditActorSystem,
+		Detail:         detail,
+		CreatedAt:      now,
+	})
+	if err != nil {
+		return fmt.Errorf("write audit entry: %w", err)
+	}
+
+	err = s.notifyLeaveExpired(ctx, leaveItem)
+	if err != nil {
+		return fmt.Errorf("notify requester: %w", err)
+	}
+
+	return nil
+
+}
+
+// notifyLeaveExpired posts the requester a notice that their request
+// expired and clears the request's pending notification flag once it is
+// stored.
+func (s *leaveService) notifyLeaveExpired(ctx context.Context, leaveItem *LeaveRequests) error {
+
+	err := s.leaveRepository.CreateLeaveNotification(ctx, &LeaveNotification{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: leaveItem.OrganizationID,
+		UserID:         leaveItem.UserID,
+		LeaveID:        leaveItem.ID,
+		Type:           notificationLeaveExpired,
+		Title:          "Leave request expired",
+		Message:        fmt.Sprintf("Your %s leave request for %s was not confirmed in time and has expired.", leaveItem.LeaveType, formatLeaveRange(leaveItem)),
+		CreatedAt:      time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.leaveRepository.ClearExpiryNotificationPending(ctx, leaveItem.ID)
+
+}
+
+// GetMyNotifications returns the current user's notices in their active
+// organization, newest first.
+func (s *leaveService) GetMyNotifications(ctx context.Context) ([]*LeaveNotification, error) {
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentUser.OrganizationIdActive == "" {
+		return nil, fmt.Errorf("user has no active organization")
+	}
+
+	return s.leaveRepository.GetLeaveNotifications(ctx, currentUser.OrganizationIdActive, currentUser.ID)
+
+}
+
+func (s *leaveService) MarkNotificationRead(ctx context.Context, id string) error {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	if currentUser.OrganizationIdActive == "" {
+		return fmt.Errorf("user has no active organization")
+	}
+
+	err = s.leaveRepository.MarkLeaveNotificationRead(ctx, currentUser.OrganizationIdActive, currentUser.ID, objectID, time.Now())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("notification not found")
+	}
+
+	return err
+
+}
+
+// GetLeaveAudits returns the audit trail of a request to its owner and the
+// organization's admins.
+func (s *leaveService) GetLeaveAudits(ctx context.Context, id string) ([]*LeaveAudit, error) {
+
+	leaveItem, currentUser, err := s.getLeaveForUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentUser.ID != leaveItem.UserID && !isOrganizationAdmin(currentUser) {
+		return nil, fmt.Errorf("you are not allowed to view this leave request's audit trail")
+	}
+
+	return s.leaveRepository.GetLeaveAudits(ctx, leaveItem.OrganizationID, leaveItem.ID)
+
+}
+
+// GetPendingOrganizationIDs lists the organizations that have requests
+// waiting, for jobs that run without a user session.
+func (r *leaveRepository) GetPendingOrganizationIDs(ctx context.Context) ([]string, error) {
+
+	values, err := r.collectionLeave.Distinct(ctx, "organization_id", bson.M{"status": "pending"})
+	if err != nil {
+		return nil, err
+	}
+
+	organizationIDs := make([]string, 0, len(values))
+	for _, value := range values {
+		if organizationID, ok := value.(string); ok && organizationID != "" {
+			organizationIDs = append(organizationIDs, organizationID)
+		}
+	}
+
+	return organizationIDs, nil
+
+}
+
+// GetStalePendingLeaves returns the pending requests whose first day is
+// before the given date. Requests from before ranges only have leave_date.
+func (r *leaveRepository) GetStalePendingLeaves(ctx context.Context, organizationID string, before time.Time) ([]*LeaveRequests, error) {
+
+	var leaveRequests []*LeaveRequests
+
+	filter := bson.M{
+		"organization_id": organizationID,
+		"status":          "pending",
+		"$or": []bson.M{
+			{"start_date": bson.M{"$lt": before}},
+			{"start_date": bson.M{"$exists": false}, "leave_date": bson.M{"$lt": before}},
+		},
+	}
+
+	cursor, err := r.collectionLeave.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &leaveRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaveRequests, nil
+
+}
+
+// ExpireLeave closes a request that was still pending and takes it off the
+// days it was waiting on.
+func (r *leaveRepository) ExpireLeave(ctx context.Context, leaveItem *LeaveRequests, expiredAt time.Time) error {
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":    leaveItem.ID,
+		"status": "pending",
+	}, bson.M{
+		"$set": bson.M{
+			"status":               "expired",
+			"expired_at":           expiredAt,
+			"notification_pending": true,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return fmt.Errorf("leave request is no longer pending")
+	}
+
+	for _, date := range leaveItem.Dates() {
+		err = r.releaseDailyLeaveSlot(ctx, date, leaveItem)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+	}
+
+	leaveItem.Status = "expired"
+	leaveItem.ExpiredAt = &expiredAt
+	leaveItem.NotificationPending = true
+
+	return nil
+
+}
+
+// GetExpiryNotificationsPending returns the expired requests whose owner
+// has not been told yet.
+func (r *leaveRepository) GetExpiryNotificationsPending(ctx context.Context) ([]*LeaveRequests, error) {
+
+	var leaveRequests []*LeaveRequests
+
+	cursor, err := r.collectionLeave.Find(ctx, bson.M{
+		"status":               "expired",
+		"notification_pending": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &leaveRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaveRequests, nil
+
+}
+
+func (r *leaveRepository) ClearExpiryNotificationPending(ctx context.Context, id primitive.ObjectID) error {
+
+	_, err := r.collectionLeave.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$unset": bson.M{"notification_pending": ""},
+	})
+
+	return err
+
+}
+
+// CreateLeaveNotification stores a notice once per request and type, so a
+// run retrying after a failed flag update does not post it twice.
+func (r *leaveRepository) CreateLeaveNotification(ctx context.Context, notification *LeaveNotification) error {
+
+	_, err := r.collectionNotification.UpdateOne(ctx, bson.M{
+		"leave_id": notification.LeaveID,
+		"type":     notification.Type,
+	}, bson.M{
+		"$setOnInsert": notification,
+	}, options.Update().SetUpsert(true))
+
+	return err
+
+}
+
+func (r *leaveRepository) GetLeaveNotifications(ctx context.Context, organizationID string, userID string) ([]*LeaveNotification, error) {
+
+	var notifications []*LeaveNotification
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := r.collectionNotification.Find(ctx, bson.M{"organization_id": organizationID, "user_id": userID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &notifications)
+	if err != nil {
+		return nil, err
+	}
+
+	return notifications, nil
+
+}
+
+func (r *leaveRepository) MarkLeaveNotificationRead(ctx context.Context, organizationID string, userID string, id primitive.ObjectID, readAt time.Time) error {
+
+	result, err := r.collectionNotification.UpdateOne(ctx, bson.M{
+		"_id":             id,
+		"organization_id": organizationID,
+		"user_id":         userID,
+	}, bson.M{
+		"$min": bson.M{"read_at": readAt},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) CreateLeaveAudit(ctx context.Context, audit *LeaveAudit) error {
+
+	_, err := r.collectionAudit.InsertOne(ctx, audit)
+
+	return err
+
+}
+
+func (r *leaveRepository) GetLeaveAudits(ctx context.Context, organizationID string, leaveID primitive.ObjectID) ([]*LeaveAudit, error) {
+
+	var audits []*LeaveAudit
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "created_at", Value: 1}})
+
+	cursor, err := r.collectionAudit.Find(ctx, bson.M{"organization_id": organizationID, "leave_id": leaveID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &audits)
+	if err != nil {
+		return nil, err
+	}
+
+	return audits, nil
+
+}
@@ -0,0 +1,91 @@
+package leave
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPendingExpiryCutoff(t *testing.T) {
+
+	today := parseDay("2025-03-10")
+
+	tests := []struct {
+		name string
+		days int
+		want time.Time
+	}{
+		{"no lead time keeps requests open through their first day", 0, today},
+		{"lead time expires requests that many days early", 3, parseDay("2025-03-14")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := pendingExpiryCutoff(&Setting{PendingExpiryDays: tt.days}, today)
+			if !got.Equal(tt.want) {
+				t.Errorf("cutoff = %s, want %s", got.Format("2006-01-02"), tt.want.Format("2006-01-02"))
+			}
+		})
+	}
+
+}
+
+// A notice that could not be stored leaves the request flagged, so the next
+// run posts it instead of losing it.
+func TestExpireStalePendingRequestsRetriesNotices(t *testing.T) {
+
+	tests := []struct {
+		name            string
+		notificationErr error
+		wantNotices     int
+		wantFlagged     int
+		wantErr         bool
+	}{
+		{"stored notice clears the flag", nil, 1, 0, false},
+		{"failed notice keeps the flag", errors.New("write failed"), 0, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			expired := &LeaveRequests{
+				ID:             primitive.NewObjectID(),
+				OrganizationID: "org-1",
+				UserID:         "teacher-1",
+				LeaveType:      "annual",
+				Status:         "expired",
+				StartDate:      parseDay("2025-03-12"),
+				EndDate:        parseDay("2025-03-12"),
+			}
+
+			repository := &fakeLeaveRepository{
+				unnotified:      []*LeaveRequests{expired},
+				notificationErr: tt.notificationErr,
+			}
+			service := &leaveService{leaveRepository: repository}
+
+			err := service.ExpireStalePendingRequests(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+
+			if len(repository.notifications) != tt.wantNotices {
+				t.Fatalf("notices = %d, want %d", len(repository.notifications), tt.wantNotices)
+			}
+			if len(repository.unnotified) != tt.wantFlagged {
+				t.Errorf("flagged requests = %d, want %d", len(repository.unnotified), tt.wantFlagged)
+			}
+
+			for _, notice := range repository.notifications {
+				if notice.UserID != expired.UserID || notice.LeaveID != expired.ID || notice.Type != notificationLeaveExpired {
+					t.Errorf("notice = %+v, want one for %s on leave %s", notice, expired.UserID, expired.ID.Hex())
+				}
+			}
+
+		})
+	}
+
+}
@@ -0,0 +1,474 @@
+package leave
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+	"worktime-service/internal/shared"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeLeaveRepository keeps requests and leave types in memory for service
+// tests. Methods it does not implement go to the nil embedded interface and
+// panic, so a test that reaches further into the repository than it means
+// to fails loudly.
+type fakeLeaveRepository struct {
+	LeaveRepository
+	leaves     map[primitive.ObjectID]*LeaveRequests
+	leaveTypes []*LeaveType
+
+	// waitlist is what the next PromoteWishlist confirms, in order.
+	waitlist      []*LeaveRequests
+	returned      []*LeaveRequests
+	balanceErrors map[string]error
+	transactions  []*LeaveTransaction
+
+	trimmed   []*LeaveRequests
+	cancelled []*LeaveRequests
+	closures  []*LeaveClosure
+	pools     []*CapacityPool
+	userIDs   []string
+
+	// unnotified are the expired requests still flagged for a notice.
+	unnotified      []*LeaveRequests
+	notifications   []*LeaveNotification
+	notificationErr error
+
+	blackouts []*BlackoutPeriod
+
+	setting *Setting
+
+	// compOffClaims are spent in no particular order; the repository's
+	// soonest-to-expire order is covered by its own tests.
+	compOffClaims map[primitive.ObjectID]*CompOffClaim
+
+	// full makes every approval that does not override capacity fail.
+	full bool
+
+	// stored is how many bytes each attachment upload read, by file name.
+	stored map[string]int64
+}
+
+func (r *fakeLeaveRepository) GetLeaveByID(ctx context.Context, organizationID string, id primitive.ObjectID) (*LeaveRequests, error) {
+
+	leaveItem, ok := r.leaves[id]
+	if !ok || leaveItem.OrganizationID != organizationID {
+		return nil, fmt.Errorf("leave request not found")
+	}
+
+	return leaveItem, nil
+
+}
+
+func (r *fakeLeaveRepository) GetLeaveTypes(ctx context.Context, organizationID string) ([]*LeaveType, error) {
+	return r.leaveTypes, nil
+}
+
+func (r *fakeLeaveRepository) GetLeavesOnDates(ctx context.Context, organizationID string, dates []time.Time) ([]*LeaveRequests, error) {
+
+	var leaves []*LeaveRequests
+
+	for _, leaveItem := range r.leaves {
+		if leaveItem.OrganizationID != organizationID || leaveItem.Status == "cancelled" {
+			continue
+		}
+		for _, date := range leaveItem.Dates() {
+			if slices.ContainsFunc(dates, date.Equal) {
+				leaves = append(leaves, leaveItem)
+				break
+			}
+		}
+	}
+
+	return leaves, nil
+
+}
+
+func (r *fakeLeaveRepository) CreateLeave(ctx context.Context, leaveItem *LeaveRequests) error {
+
+	leaveItem.ID = primitive.NewObjectID()
+	leaveItem.Status = "confirmed"
+	leaveItem.RequestType = "immediate"
+
+	if r.leaves == nil {
+		r.leaves = make(map[primitive.ObjectID]*LeaveRequests)
+	}
+	r.leaves[leaveItem.ID] = leaveItem
+
+	return nil
+
+}
+
+func (r *fakeLeaveRepository) CancelLeave(ctx context.Context, leaveItem *LeaveRequests, cancellation *LeaveCancellation) error {
+
+	leaveItem.Status = "cancelled"
+	leaveItem.Cancellation = cancellation
+	r.cancelled = append(r.cancelled, leaveItem)
+
+	return nil
+
+}
+
+func (r *fakeLeaveRepository) TrimLeave(ctx context.Context, leaveItem *LeaveRequests, released []time.Time, cancellation *LeaveCancellation) error {
+
+	var kept []time.Time
+	for _, date := range leaveItem.Dates() {
+		if !slices.ContainsFunc(released, date.Equal) {
+			kept = append(kept, date)
+		}
+	}
+
+	leaveItem.LeaveDates = kept
+	leaveItem.TotalDays = float64(len(kept)) * portionDays(leaveItem.Portion)
+	if cancellation != nil {
+		leaveItem.Cancellation = cancellation
+	}
+	r.trimmed = append(r.trimmed, leaveItem)
+
+	return nil
+
+}
+
+func (r *fakeLeaveRepository) GetCapacityPools(ctx context.Context, organizationID string) ([]*CapacityPool, error) {
+	return r.pools, nil
+}
+
+func (r *fakeLeaveRepository) GetOrganizationUserIDs(ctx context.Context, organizationID string) ([]string, error) {
+	return r.userIDs, nil
+}
+
+func (r *fakeLeaveRepository) CreateClosure(ctx context.Context, closure *LeaveClosure) error {
+	r.closures = append(r.closures, closure)
+	return nil
+}
+
+func (r *fakeLeaveRepository) GetLeaveTypeByCode(ctx context.Context, organizationID string, code string) (*LeaveType, error) {
+
+	for _, leaveType := range r.leaveTypes {
+		if leaveType.Code == code {
+			return leaveType, nil
+		}
+	}
+
+	return nil, fmt.Errorf("leave type %s not found", code)
+
+}
+
+func (r *fakeLeaveRepository) GetSettings(ctx context.Context, organizationID string) (*Setting, error) {
+
+	if r.setting != nil {
+		return r.setting, nil
+	}
+
+	return &Setting{OrganizationID: organizationID}, nil
+
+}
+
+func (r *fakeLeaveRepository) PromoteReschedules(ctx context.Context, organizationID string, date time.Time) ([]*LeaveRequests, error) {
+	return nil, nil
+}
+
+func (r *fakeLeaveRepository) PromoteWishlist(ctx context.Context, organizationID string, date time.Time) ([]*LeaveRequests, error) {
+
+	promoted := r.waitlist
+	r.waitlist = nil
+
+	for _, leaveItem := range promoted {
+		leaveItem.Status = "confirmed"
+		leaveItem.RequestType = "immediate"
+	}
+
+	return promoted, nil
+
+}
+
+func (r *fakeLeaveRepository) ReturnToWishlist(ctx context.Context, leaveItem *LeaveRequests) error {
+
+	leaveItem.Status = "pending"
+	leaveItem.RequestType = "wishlist"
+	r.returned = append(r.returned, leaveItem)
+
+	return nil
+
+}
+
+func (r *fakeLeaveRepository) GetLeaveBalance(ctx context.Context, organizationID string, userID string, year int) (*UserLeaveBalance, error) {
+
+	if err := r.balanceErrors[userID]; err != nil {
+		return nil, err
+	}
+
+	return &UserLeaveBalance{OrganizationID: organizationID, UserID: userID, Year: year}, nil
+
+}
+
+func (r *fakeLeaveRepository) UpdateLeaveBalance(ctx context.Context, organizationID string, userID string, year int, earned float64, used float64) error {
+	return nil
+}
+
+func (r *fakeLeaveRepository) CreateLeaveTransaction(ctx context.Context, transactions []*LeaveTransaction) error {
+	r.transactions = append(r.transactions, transactions...)
+	return nil
+}
+
+func (r *fakeLeaveRepository) GetExpiryNotificationsPending(ctx context.Context) ([]*LeaveRequests, error) {
+	return r.unnotified, nil
+}
+
+func (r *fakeLeaveRepository) ClearExpiryNotificationPending(ctx context.Context, id primitive.ObjectID) error {
+
+	r.unnotified = slices.DeleteFunc(r.unnotified, func(leaveItem *LeaveRequests) bool {
+		return leaveItem.ID == id
+	})
+
+	return nil
+
+}
+
+func (r *fakeLeaveRepository) CreateLeaveNotification(ctx context.Context, notification *LeaveNotification) error {
+
+	if r.notificationErr != nil {
+		return r.notificationErr
+	}
+
+	r.notifications = append(r.notifications, notification)
+
+	return nil
+
+}
+
+func (r *fakeLeaveRepository) GetPendingOrganizationIDs(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (r *fakeLeaveRepository) GetBlackoutPeriods(ctx context.Context, organizationID string) ([]*BlackoutPeriod, error) {
+	return r.blackouts, nil
+}
+
+func (r *fakeLeaveRepository) GetLeaveByDate(ctx context.Context, organizationID string, date *time.Time, userID string) (*LeaveRequests, error) {
+
+	for _, leaveItem := range r.leaves {
+		if leaveItem.OrganizationID != organizationID || leaveItem.UserID != userID {
+			continue
+		}
+		if leaveItem.Status != "pending" && leaveItem.Status != "confirmed" {
+			continue
+		}
+		if slices.ContainsFunc(leaveItem.Dates(), date.Equal) {
+			return leaveItem, nil
+		}
+	}
+
+	return nil, mongo.ErrNoDocuments
+
+}
+
+// AddSubstitute refuses a teacher who already covers any stored leave that
+// day, as the repository does across documents.
+func (r *fakeLeaveRepository) AddSubstitute(ctx context.Context, leaveItem *LeaveRequests, substitute *Substitute) error {
+
+	for _, other := range r.leaves {
+		for _, assigned := range other.Substitutes {
+			if assigned.TeacherID == substitute.TeacherID && assigned.Date.Equal(substitute.Date) && assigned.Status != SubstituteDeclined {
+				return fmt.Errorf("teacher is already covering another leave on %s", substitute.Date.Format("2006-01-02"))
+			}
+		}
+	}
+
+	leaveItem.Substitutes = append(leaveItem.Substitutes, *substitute)
+
+	return nil
+
+}
+
+func (r *fakeLeaveRepository) AddAttachment(ctx context.Context, leaveItem *LeaveRequests, attachment *Attachment, content io.Reader) error {
+
+	size, err := io.Copy(io.Discard, content)
+	if err != nil {
+		return err
+	}
+
+	if r.stored == nil {
+		r.stored = map[string]int64{}
+	}
+	r.stored[attachment.FileName] = size
+	leaveItem.Attachments = append(leaveItem.Attachments, *attachment)
+
+	return nil
+
+}
+
+func (r *fakeLeaveRepository) ApproveLeave(ctx context.Context, leaveItem *LeaveRequests, review *LeaveReview) error {
+
+	if leaveItem.LeavePolicy().ConsumesSlot && r.full && !review.CapacityOverridden {
+		return fmt.Errorf("no capacity left for %s", formatLeaveRange(leaveItem))
+	}
+
+	leaveItem.Status = "confirmed"
+	leaveItem.RequestType = "immediate"
+	leaveItem.Review = review
+
+	return nil
+
+}
+
+func (r *fakeLeaveRepository) RejectLeave(ctx context.Context, leaveItem *LeaveRequests, review *LeaveReview) error {
+
+	leaveItem.Status = "rejected"
+	leaveItem.Review = review
+
+	return nil
+
+}
+
+// CreateCompOffClaim skips an attendance day that was already claimed, as
+// the repository's unique index does.
+func (r *fakeLeaveRepository) CreateCompOffClaim(ctx context.Context, claim *CompOffClaim) (bool, error) {
+
+	for _, existing := range r.compOffClaims {
+		if existing.AttendanceID == claim.AttendanceID {
+			return false, nil
+		}
+	}
+
+	if r.compOffClaims == nil {
+		r.compOffClaims = map[primitive.ObjectID]*CompOffClaim{}
+	}
+	r.compOffClaims[claim.ID] = claim
+
+	return true, nil
+
+}
+
+func (r *fakeLeaveRepository) GetCompOffClaimByID(ctx context.Context, organizationID string, id primitive.ObjectID) (*CompOffClaim, error) {
+
+	claim, ok := r.compOffClaims[id]
+	if !ok || claim.OrganizationID != organizationID {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	return claim, nil
+
+}
+
+func (r *fakeLeaveRepository) ReviewCompOffClaim(ctx context.Context, claim *CompOffClaim, review *LeaveReview, expiresAt *time.Time) error {
+
+	claim.Status = review.Action
+	claim.Review = review
+
+	if review.Action == CompOffApproved {
+		claim.Remaining = claim.Days
+		claim.ExpiresAt = expiresAt
+	}
+
+	return nil
+
+}
+
+func (r *fakeLeaveRepository) SpendCompOff(ctx context.Context, organizationID string, userID string, leaveID primitive.ObjectID, days float64, now time.Time) (float64, error) {
+
+	spent := 0.0
+
+	for _, claim := range r.compOffClaims {
+
+		if claim.OrganizationID != organizationID || claim.UserID != userID || claim.Status != CompOffApproved {
+			continue
+		}
+		if claim.ExpiresAt != nil && !claim.ExpiresAt.After(now) {
+			continue
+		}
+
+		take := math.Min(claim.Remaining, days-spent)
+		if take <= 0 {
+			continue
+		}
+
+		claim.Remaining -= take
+		claim.Usages = append(claim.Usages, CompOffUsage{LeaveID: leaveID, Days: take, UsedAt: now})
+		spent += take
+
+	}
+
+	return spent, nil
+
+}
+
+func (r *fakeLeaveRepository) ExpireCompOffClaims(ctx context.Context, now time.Time) ([]*CompOffClaim, error) {
+
+	var expired []*CompOffClaim
+
+	for _, claim := range r.compOffClaims {
+
+		if claim.Status != CompOffApproved || claim.ExpiresAt == nil || claim.ExpiresAt.After(now) || claim.ExpiredAt != nil {
+			continue
+		}
+
+		claim.ExpiredDays = claim.Remaining
+		claim.Remaining = 0
+		claim.ExpiredAt = &now
+		expired = append(expired, claim)
+
+	}
+
+	return expired, nil
+
+}
+
+// fakeOvertimeSource serves fixed attendance days, filtered to the range.
+type fakeOvertimeSource []*shared.OvertimeDay
+
+func (o fakeOvertimeSource) GetOvertimeDays(ctx context.Context, userID string, startDate time.Time, endDate time.Time) ([]*shared.OvertimeDay, error) {
+
+	var days []*shared.OvertimeDay
+
+	for _, day := range o {
+		if day.UserID == userID && !day.Date.Before(startDate) && !day.Date.After(endDate) {
+			days = append(days, day)
+		}
+	}
+
+	return days, nil
+
+}
+
+// fakeUserService answers for a fixed caller.
+type fakeUserService struct {
+	user.UserService
+	currentUser  *user.CurrentUser
+	missingUsers []string
+	nonTeachers  []string
+}
+
+func (u *fakeUserService) GetCurrentUser(ctx context.Context) (*user.CurrentUser, error) {
+	return u.currentUser, nil
+}
+
+// GetUserInfor answers like the main service does for an unknown user:
+// no user and no error.
+func (u *fakeUserService) GetUserInfor(ctx context.Context, userID string) (*user.UserInfor, error) {
+
+	if slices.Contains(u.missingUsers, userID) {
+		return nil, nil
+	}
+
+	return &user.UserInfor{UserID: userID, UserName: userID}, nil
+
+}
+
+// GetTeacherInforByOrg answers with no teacher, and no error, for users
+// who are not teachers.
+func (u *fakeUserService) GetTeacherInforByOrg(ctx context.Context, teacherID string, orgID string) (*user.UserInfor, error) {
+
+	if slices.Contains(u.nonTeachers, teacherID) {
+		return nil, nil
+	}
+
+	return &user.UserInfor{UserID: teacherID, UserName: teacherID, OrganizationID: orgID}, nil
+
+}
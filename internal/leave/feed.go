@@ -0,0 +1,518 @@
+package leave
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	feedPastDays   = 90
+	feedFutureDays = 365
+	feedURLPrefix  = "/api/v1/leave/ics/"
+	icsDateFormat  = "20060102"
+)
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newFeedToken() (string, error) {
+
+	buf := make([]byte, 32)
+
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+
+}
+
+func (s *leaveService) CreateCalendarFeed(ctx context.Context, req *CalendarFeedRequest) (*CalendarFeedResponse, error) {
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentUser.OrganizationIdActive == "" {
+		return nil, fmt.Errorf("user has no active organization")
+	}
+
+	if req.Scope == "" {
+		req.Scope = FeedScopeUser
+	}
+
+	switch req.Scope {
+	case FeedScopeUser:
+	case FeedScopeOrganization:
+		// The organization feed lists everyone's leave, so it is kept to
+		// the people who already see the whole calendar.
+		if !isOrganizationAdmin(currentUser) {
+			return nil, fmt.Errorf("only organization admins can subscribe to the organization calendar")
+		}
+	default:
+		return nil, fmt.Errorf("invalid scope: %s", req.Scope)
+	}
+
+	token, err := newFeedToken()
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &CalendarFeed{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: currentUser.OrganizationIdActive,
+		UserID:         currentUser.ID,
+		Scope:          req.Scope,
+		Name:           req.Name,
+		TokenHash:      hashFeedToken(token),
+		CreatedAt:      time.Now(),
+	}
+
+	err = s.leaveRepository.CreateCalendarFeed(ctx, feed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CalendarFeedResponse{
+		Feed: feed,
+		URL:  feedURLPrefix + token + ".ics",
+	}, nil
+
+}
+
+func (s *leaveService) GetCalendarFeeds(ctx context.Context) ([]*CalendarFeed, error) {
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetCalendarFeeds(ctx, currentUser.OrganizationIdActive, currentUser.ID)
+
+}
+
+func (s *leaveService) RevokeCalendarFeed(ctx context.Context, id string) error {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	return s.leaveRepository.RevokeCalendarFeed(ctx, currentUser.OrganizationIdActive, currentUser.ID, objectID)
+
+}
+
+// RenderCalendarFeed builds the ICS document for a feed token. It runs
+// without a user session, so everything is scoped by the feed itself.
+func (s *leaveService) RenderCalendarFeed(ctx context.Context, token string) (string, error) {
+
+	feed, err := s.leaveRepository.UseCalendarFeed(ctx, hashFeedToken(strings.TrimSuffix(token, ".ics")))
+	if err != nil {
+		return "", err
+	}
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	dateFrom := today.AddDate(0, 0, -feedPastDays)
+	dateTo := today.AddDate(0, 0, feedFutureDays)
+
+	userID := feed.UserID
+	if feed.Scope == FeedScopeOrganization {
+		userID = ""
+	}
+
+	leaves, err := s.leaveRepository.GetFeedLeaves(ctx, feed.OrganizationID, userID, dateFrom, dateTo)
+	if err != nil {
+		return "", err
+	}
+
+	typeNames := make(map[string]string)
+	leaveTypes, err := s.leaveRepository.GetLeaveTypes(ctx, feed.OrganizationID)
+	if err == nil {
+		for _, leaveType := range leaveTypes {
+			typeNames[leaveType.Code] = leaveType.Name
+		}
+	}
+
+	name := feed.Name
+	if name == "" {
+		name = "My leave"
+		if feed.Scope == FeedScopeOrganization {
+			name = "Leave calendar"
+		}
+	}
+
+	cal := newICSWriter(name)
+	stamp := time.Now().UTC()
+
+	for _, leaveItem := range leaves {
+
+		dates := slices.DeleteFunc(slices.Clone(leaveItem.Dates()), func(date time.Time) bool {
+			return date.Before(dateFrom) || date.After(dateTo)
+		})
+
+		for _, run := range consecutiveRuns(dates) {
+			cal.event(leaveEvent(feed, leaveItem, typeNames, run, stamp))
+		}
+
+	}
+
+	if feed.Scope == FeedScopeOrganization {
+
+		slots, err := s.leaveRepository.GetFullDailyLeaveSlots(ctx, feed.OrganizationID, dateFrom, dateTo)
+		if err != nil {
+			return "", err
+		}
+
+		for _, slot := range slots {
+			cal.event(icsEvent{
+				UID:         fmt.Sprintf("slot-%s@worktime-service", slot.ID.Hex()),
+				Stamp:       stamp,
+				Start:       slot.Date,
+				End:         slot.Date.AddDate(0, 0, 1),
+				Summary:     "Leave calendar full",
+				Description: fmt.Sprintf("All %d leave slots are taken.", slot.MaxSlot),
+				Status:      "CONFIRMED",
+				Transparent: true,
+			})
+		}
+
+	}
+
+	return cal.String(), nil
+
+}
+
+// leaveEvent turns one run of consecutive days of a request into an all-day
+// event. Pending requests are tentative and do not block time.
+func leaveEvent(feed *CalendarFeed, leaveItem *LeaveRequests, typeNames map[string]string, run []time.Time, stamp time.Time) icsEvent {
+
+	typeName := typeNames[leaveItem.LeaveType]
+	if typeName == "" {
+		typeName = "Leave"
+	}
+
+	summary := typeName
+	switch leaveItem.Portion {
+	case PortionAM:
+		summary += " (morning)"
+	case PortionPM:
+		summary += " (afternoon)"
+	}
+
+	if feed.Scope == FeedScopeOrganization {
+		summary = leaveItem.UserName + " - " + summary
+	}
+
+	event := icsEvent{
+		UID:      fmt.Sprintf("%s-%s@worktime-service", leaveItem.ID.Hex(), run[0].Format(icsDateFormat)),
+		Stamp:    stamp,
+		Start:    run[0],
+		End:      run[len(run)-1].AddDate(0, 0, 1),
+		Summary:  summary,
+		Status:   "CONFIRMED",
+		Sequence: len(leaveItem.Revisions),
+	}
+
+	if leaveItem.Status == "pending" {
+		event.Summary += " (pending)"
+		event.Status = "TENTATIVE"
+		event.Transparent = true
+	}
+
+	// Reasons can be private, so they only go to the requester's own feed.
+	if feed.Scope == FeedScopeUser && leaveItem.Reason != nil {
+		event.Description = *leaveItem.Reason
+	}
+
+	return event
+
+}
+
+// consecutiveRuns splits dates into runs of back-to-back days.
+func consecutiveRuns(dates []time.Time) [][]time.Time {
+
+	slices.SortFunc(dates, func(a, b time.Time) int {
+		return a.Compare(b)
+	})
+
+	var runs [][]time.Time
+
+	for _, date := range dates {
+		last := len(runs) - 1
+		if last >= 0 && runs[last][len(runs[last])-1].AddDate(0, 0, 1).Equal(date) {
+			runs[last] = append(runs[last], date)
+			continue
+		}
+		runs = append(runs, []time.Time{date})
+	}
+
+	return runs
+
+}
+
+type icsEvent struct {
+	UID         string
+	Stamp       time.Time
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Status      string
+	Sequence    int
+	Transparent bool
+}
+
+// icsWriter writes an RFC 5545 calendar: CRLF line endings, escaped text
+// and lines folded at 75 octets.
+type icsWriter struct {
+	b strings.Builder
+}
+
+func newICSWriter(name string) *icsWriter {
+
+	w := &icsWriter{}
+	w.line("BEGIN:VCALENDAR")
+	w.line("VERSION:2.0")
+	w.line("PRODID:-//worktime-service//leave//EN")
+	w.line("CALSCALE:GREGORIAN")
+	w.line("METHOD:PUBLISH")
+	w.line("X-WR-CALNAME:" + icsEscape(name))
+	w.line("REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	w.line("X-PUBLISHED-TTL:PT1H")
+
+	return w
+
+}
+
+func (w *icsWriter) event(e icsEvent) {
+
+	w.line("BEGIN:VEVENT")
+	w.line("UID:" + e.UID)
+	w.line("DTSTAMP:" + e.Stamp.Format("20060102T150405Z"))
+	w.line("DTSTART;VALUE=DATE:" + e.Start.Format(icsDateFormat))
+	w.line("DTEND;VALUE=DATE:" + e.End.Format(icsDateFormat))
+	w.line("SUMMARY:" + icsEscape(e.Summary))
+	if e.Description != "" {
+		w.line("DESCRIPTION:" + icsEscape(e.Description))
+	}
+	w.line("STATUS:" + e.Status)
+	w.line(fmt.Sprintf("SEQUENCE:%d", e.Sequence))
+	if e.Transparent {
+		w.line("TRANSP:TRANSPARENT")
+	} else {
+		w.line("TRANSP:OPAQUE")
+	}
+	w.line("END:VEVENT")
+
+}
+
+func (w *icsWriter) String() string {
+	w.line("END:VCALENDAR")
+	return w.b.String()
+}
+
+func (w *icsWriter) line(content string) {
+
+	// Fold without splitting a UTF-8 sequence; continuation lines start
+	// with a space, which counts towards their 75 octets.
+	limit := 75
+	for len(content) > limit {
+		cut := limit
+		for cut > 0 && content[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.b.WriteString(content[:cut])
+		w.b.WriteString("\r\n ")
+		content = content[cut:]
+		limit = 74
+	}
+
+	w.b.WriteString(content)
+	w.b.WriteString("\r\n")
+
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+
+func icsEscape(text string) string {
+	return icsEscaper.Replace(text)
+}
+
+func (r *leaveRepository) CreateCalendarFeed(ctx context.Context, feed *CalendarFeed) error {
+
+	_, err := r.collectionCalendarFeed.InsertOne(ctx, feed)
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) GetCalendarFeeds(ctx context.Context, organizationID string, userID string) ([]*CalendarFeed, error) {
+
+	var feeds []*CalendarFeed
+
+	filter := bson.M{
+		"organization_id": organizationID,
+		"user_id":         userID,
+		"revoked_at":      bson.M{"$exists": false},
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "created_at", Value: -1}})
+
+	cursor, err := r.collectionCalendarFeed.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &feeds)
+	if err != nil {
+		return nil, err
+	}
+
+	return feeds, nil
+
+}
+
+func (r *leaveRepository) RevokeCalendarFeed(ctx context.Context, organizationID string, userID string, id primitive.ObjectID) error {
+
+	filter := bson.M{
+		"_id":             id,
+		"organization_id": organizationID,
+		"user_id":         userID,
+		"revoked_at":      bson.M{"$exists": false},
+	}
+
+	result, err := r.collectionCalendarFeed.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"revoked_at": time.Now()}})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("calendar feed not found")
+	}
+
+	return nil
+
+}
+
+// UseCalendarFeed looks up a live feed by its token hash and records the
+// access, so owners can see which subscriptions are still polled.
+func (r *leaveRepository) UseCalendarFeed(ctx context.Context, tokenHash string) (*CalendarFeed, error) {
+
+	var feed CalendarFeed
+
+	filter := bson.M{
+		"token_hash": tokenHash,
+		"revoked_at": bson.M{"$exists": false},
+	}
+
+	err := r.collectionCalendarFeed.FindOneAndUpdate(ctx, filter,
+		bson.M{"$set": bson.M{"last_accessed_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&feed)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, fmt.Errorf("calendar feed not found")
+		}
+		return nil, err
+	}
+
+	return &feed, nil
+
+}
+
+// GetFeedLeaves returns the confirmed and pending requests touching the
+// range, for one user or, with an empty userID, the whole organization.
+func (r *leaveRepository) GetFeedLeaves(ctx context.Context, organizationID string, userID string, dateFrom time.Time, dateTo time.Time) ([]*LeaveRequests, error) {
+
+	var leaveRequests []*LeaveRequests
+
+	dateRange := bson.M{"$gte": dateFrom, "$lte": dateTo}
+
+	filter := bson.M{
+		"organization_id": organizationID,
+		"status":          bson.M{"$in": []string{"confirmed", "pending"}},
+		"$or": []bson.M{
+			{"leave_dates": dateRange},
+			{"leave_date": dateRange},
+		},
+	}
+
+	if userID != "" {
+		filter["user_id"] = userID
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "leave_date", Value: 1}})
+
+	cursor, err := r.collectionLeave.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &leaveRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaveRequests, nil
+
+}
+
+// GetFullDailyLeaveSlots returns the working days in the range that have no
+// slot left.
+func (r *leaveRepository) GetFullDailyLeaveSlots(ctx context.Context, organizationID string, dateFrom time.Time, dateTo time.Time) ([]*DailyLeaveSolt, error) {
+
+	var slots []*DailyLeaveSolt
+
+	filter := bson.M{
+		"organization_id": organizationID,
+		"date":            bson.M{"$gte": dateFrom, "$lte": dateTo},
+		"is_holiday":      false,
+		"max_slot":        bson.M{"$gt": 0},
+		"available_slot":  bson.M{"$lte": 0},
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "date", Value: 1}})
+
+	cursor, err := r.collectionDailyLeaveSlots.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &slots)
+	if err != nil {
+		return nil, err
+	}
+
+	return slots, nil
+
+}
@@ -0,0 +1,83 @@
+package leave
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestICSEscape(t *testing.T) {
+
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "plain text", text: "Annual leave", want: "Annual leave"},
+		{name: "comma and semicolon", text: "Trip; Hue, Hoi An", want: `Trip\; Hue\, Hoi An`},
+		{name: "backslash is escaped first", text: `C:\path;x`, want: `C:\\path\;x`},
+		{name: "line breaks of every kind", text: "a\r\nb\nc\rd", want: `a\nb\nc\nd`},
+		{name: "colon is left alone", text: "Note: back at 9", want: "Note: back at 9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := icsEscape(tt.text); got != tt.want {
+				t.Errorf("icsEscape(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestICSWriterFoldsLongLines(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		content string
+		lines   int
+	}{
+		{name: "short line is not folded", content: "SUMMARY:Annual leave", lines: 1},
+		{name: "exactly 75 octets", content: "SUMMARY:" + strings.Repeat("a", 67), lines: 1},
+		{name: "76 octets", content: "SUMMARY:" + strings.Repeat("a", 68), lines: 2},
+		{name: "long ascii text", content: "DESCRIPTION:" + strings.Repeat("x", 200), lines: 3},
+		{name: "multibyte text is not split", content: "SUMMARY:" + strings.Repeat("Nghỉ phép năm ", 12), lines: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			w := &icsWriter{}
+			w.line(tt.content)
+			out := w.b.String()
+
+			if !strings.HasSuffix(out, "\r\n") {
+				t.Fatalf("output %q does not end with CRLF", out)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n")
+			if len(lines) != tt.lines {
+				t.Errorf("folded into %d lines, want %d", len(lines), tt.lines)
+			}
+
+			for i, line := range lines {
+				if len(line) > 75 {
+					t.Errorf("line %d is %d octets, want at most 75", i, len(line))
+				}
+				if !utf8.ValidString(line) {
+					t.Errorf("line %d splits a UTF-8 sequence: %q", i, line)
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation line %d does not start with a space: %q", i, line)
+				}
+			}
+
+			// Unfolding removes each CRLF and the space after it.
+			if unfolded := strings.ReplaceAll(strings.TrimSuffix(out, "\r\n"), "\r\n ", ""); unfolded != tt.content {
+				t.Errorf("unfolded = %q, want %q", unfolded, tt.content)
+			}
+
+		})
+	}
+
+}
@@ -2,8 +2,10 @@ package leave
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"worktime-service/helper"
 	"worktime-service/pkg/constants"
 
@@ -52,7 +54,15 @@ func (h *LeaveHandler) GetAllLeaveCalendar(c *gin.Context) {
 
 	date := c.Query("date")
 
-	data, err := h.leaveService.GetAllLeaveCalendar(c, date)
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetAllLeaveCalendar(ctx, date)
 	
 	if err != nil {
 		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
@@ -66,7 +76,15 @@ func (h *LeaveHandler) GetDetailLeaveCalendar(c *gin.Context) {
 
 	id := c.Param("id")
 
-	data, err := h.leaveService.GetDetailLeaveCalendar(c, id)
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetDetailLeaveCalendar(ctx, id)
 	
 	if err != nil {
 		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
@@ -102,6 +120,11 @@ func (h *LeaveHandler) CreateRequestLeave(c *gin.Context) {
 
 	err := h.leaveService.CreateRequestLeave(ctx, &req)
 	if err != nil {
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			helper.SendErrorWithData(c, 400, err, helper.ErrPolicyViolation, policyErr)
+			return
+		}
 		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
 		return
 	}
@@ -112,7 +135,19 @@ func (h *LeaveHandler) CreateRequestLeave(c *gin.Context) {
 
 func (h *LeaveHandler) GetSetting(c *gin.Context) {
 
-	setting := h.leaveService.GetSettings(c)
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	setting, err := h.leaveService.GetSettings(ctx)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
 
 	helper.SendSuccess(c, 200, "Success", setting)
 
@@ -120,8 +155,6 @@ func (h *LeaveHandler) GetSetting(c *gin.Context) {
 
 func (h *LeaveHandler) UpdateSetting(c *gin.Context) {
 
-	id := c.Param("id")
-
 	var req SettingRequest
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -129,7 +162,15 @@ func (h *LeaveHandler) UpdateSetting(c *gin.Context) {
 		return
 	}
 
-	data, err := h.leaveService.UpdateSetting(c, &req, id)
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.UpdateSetting(ctx, &req)
 
 	if err != nil {
 		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
@@ -151,7 +192,15 @@ func (h *LeaveHandler) EditMaxSlot(c *gin.Context) {
 		return
 	}
 
-	err := h.leaveService.EditMaxSlot(c, &req, id)
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.leaveService.EditMaxSlot(ctx, &req, id)
 
 	if err != nil {
 		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
@@ -164,7 +213,15 @@ func (h *LeaveHandler) EditMaxSlot(c *gin.Context) {
 
 func (h *LeaveHandler) GetPendingRequest(c *gin.Context) {
 
-	data, err := h.leaveService.GetPendingRequest(c)
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetPendingRequest(ctx)
 	if err != nil {
 		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
 		return
@@ -219,7 +276,97 @@ func (h *LeaveHandler) UpdateRequestLeave(c *gin.Context) {
 		return
 	}
 
-	err := h.leaveService.UpdateRequestLeave(c, &req, id)
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.ReviewerID = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.leaveService.UpdateRequestLeave(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", nil)
+
+}
+
+func (h *LeaveHandler) ApproveRequestLeave(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req ReviewLeaveRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.ReviewerID = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.leaveService.ApproveRequestLeave(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", nil)
+
+}
+
+func (h *LeaveHandler) RejectRequestLeave(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req ReviewLeaveRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.ReviewerID = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.leaveService.RejectRequestLeave(ctx, &req, id)
 	if err != nil {
 		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
 		return
@@ -234,25 +381,1198 @@ func (h *LeaveHandler) GetStatistical (c *gin.Context) {
 	dateFrom := c.Query("date_from")
 	dateTo := c.Query("date_to")
 
-	data, err := h.leaveService.GetStatistical(c, dateFrom, dateTo)
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetStatistical(ctx, dateFrom, dateTo)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+
+}
+
+func (h *LeaveHandler) GetLeaveAnalytics(c *gin.Context) {
+
+	dateFrom := c.Query("date_from")
+	dateTo := c.Query("date_to")
+	groupBy := c.Query("group_by")
+
+	var top int
+	if value := c.Query("top"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			helper.SendError(c, http.StatusBadRequest, fmt.Errorf("invalid top: %s", value), helper.ErrInvalidRequest)
+			return
+		}
+		top = parsed
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetLeaveAnalytics(ctx, dateFrom, dateTo, groupBy, top)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+
+}
+
+func (h *LeaveHandler) GetLeaveBalanceUser(c *gin.Context) {
+
+	id := c.Param("user-id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetLeaveBalanceUser(ctx, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) GetLeaveTypes(c *gin.Context) {
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetLeaveTypes(ctx)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) CreateLeaveType(c *gin.Context) {
+
+	var req LeaveTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.CreateLeaveType(ctx, &req)
 	if err != nil {
 		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
 		return
 	}
 
 	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
 
+func (h *LeaveHandler) UpdateLeaveType(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req LeaveTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.UpdateLeaveType(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
 }
 
-// func (h *LeaveHandler) GetLeaveBalanceUser (c *gin.Context) {
+func (h *LeaveHandler) GetCapacityRules(c *gin.Context) {
 
-// 	id := c.Param("user-id")
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
 
-// 	data, err := h.leaveService.GetLeaveBalanceUser(c, id)
-// 	if err != nil {
-// 		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
-// 		return
-// 	}
+	ctx := context.WithValue(c, constants.TokenKey, token)
 
-// 	helper.SendSuccess(c, http.StatusOK, "Success", data)
-// }
\ No newline at end of file
+	data, err := h.leaveService.GetCapacityRules(ctx)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) CreateCapacityRule(c *gin.Context) {
+
+	var req CapacityRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.CreateCapacityRule(ctx, &req)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) UpdateCapacityRule(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req CapacityRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.UpdateCapacityRule(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) DeleteCapacityRule(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.leaveService.DeleteCapacityRule(ctx, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", nil)
+}
+
+func (h *LeaveHandler) GetCapacityPools(c *gin.Context) {
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetCapacityPools(ctx)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) CreateCapacityPool(c *gin.Context) {
+
+	var req CapacityPoolRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.CreateCapacityPool(ctx, &req)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) UpdateCapacityPool(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req CapacityPoolRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.UpdateCapacityPool(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) DeleteCapacityPool(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.leaveService.DeleteCapacityPool(ctx, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", nil)
+}
+
+func (h *LeaveHandler) GetBlackoutPeriods(c *gin.Context) {
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetBlackoutPeriods(ctx)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) CreateBlackoutPeriod(c *gin.Context) {
+
+	var req BlackoutPeriodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.CreatedBy = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.CreateBlackoutPeriod(ctx, &req)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) UpdateBlackoutPeriod(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req BlackoutPeriodRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.UpdateBlackoutPeriod(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) DeleteBlackoutPeriod(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.leaveService.DeleteBlackoutPeriod(ctx, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", nil)
+}
+
+func (h *LeaveHandler) CancelRequestLeave(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req CancelLeaveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.RequestedBy = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.CancelRequestLeave(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) ApproveCancellation(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req ReviewLeaveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.ReviewerID = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.ApproveCancellation(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) RejectCancellation(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req ReviewLeaveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.ReviewerID = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.RejectCancellation(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) RescheduleLeave(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req RescheduleLeaveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.RequestedBy = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.RescheduleLeave(ctx, &req, id)
+	if err != nil {
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			helper.SendErrorWithData(c, 400, err, helper.ErrPolicyViolation, policyErr)
+			return
+		}
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) GetLeaveDetail(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetLeaveDetail(ctx, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) GetLeaveAudits(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetLeaveAudits(ctx, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) GetMyNotifications(c *gin.Context) {
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetMyNotifications(ctx)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) MarkNotificationRead(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.leaveService.MarkNotificationRead(ctx, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", nil)
+}
+
+func (h *LeaveHandler) ProposeSubstitute(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req SubstituteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.AssignedBy = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.ProposeSubstitute(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) UpdateSubstituteStatus(c *gin.Context) {
+
+	id := c.Param("id")
+	substituteID := c.Param("substitute-id")
+
+	var req SubstituteStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.RespondedBy = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.UpdateSubstituteStatus(ctx, &req, id, substituteID)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) RemoveSubstitute(c *gin.Context) {
+
+	id := c.Param("id")
+	substituteID := c.Param("substitute-id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.RemoveSubstitute(ctx, id, substituteID)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) GetUncoveredLeaves(c *gin.Context) {
+
+	dateFrom := c.Query("date_from")
+	dateTo := c.Query("date_to")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetUncoveredLeaves(ctx, dateFrom, dateTo)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) UploadAttachments(c *gin.Context) {
+
+	id := c.Param("id")
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.UploadAttachments(ctx, id, form.File["files"], userID.(string))
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) DownloadAttachment(c *gin.Context) {
+
+	id := c.Param("id")
+	attachmentID := c.Param("attachment-id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	attachment, content, err := h.leaveService.OpenAttachment(ctx, id, attachmentID)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+	defer content.Close()
+
+	headers := map[string]string{
+		"Content-Disposition":    fmt.Sprintf("attachment; filename=%q", attachment.FileName),
+		"X-Content-Type-Options": "nosniff",
+	}
+
+	c.DataFromReader(http.StatusOK, attachment.Size, attachment.ContentType, content, headers)
+}
+
+func (h *LeaveHandler) DeleteAttachment(c *gin.Context) {
+
+	id := c.Param("id")
+	attachmentID := c.Param("attachment-id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.leaveService.DeleteAttachment(ctx, id, attachmentID)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", nil)
+}
+
+func (h *LeaveHandler) GetCalendarFeeds(c *gin.Context) {
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetCalendarFeeds(ctx)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) CreateCalendarFeed(c *gin.Context) {
+
+	var req CalendarFeedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.CreateCalendarFeed(ctx, &req)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) RevokeCalendarFeed(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.leaveService.RevokeCalendarFeed(ctx, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", nil)
+}
+
+// GetCalendarFeedICS serves a subscription feed. It sits outside the
+// secured group; the token in the path is the credential.
+func (h *LeaveHandler) GetCalendarFeedICS(c *gin.Context) {
+
+	data, err := h.leaveService.RenderCalendarFeed(c, c.Param("token"))
+	if err != nil {
+		c.String(http.StatusNotFound, "calendar feed not found")
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=300")
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(data))
+}
+
+func (h *LeaveHandler) GetEntitlementTable(c *gin.Context) {
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetEntitlementTable(ctx)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) UpdateEntitlementTable(c *gin.Context) {
+
+	var req EntitlementTableRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.UpdateEntitlementTable(ctx, &req)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) SetEmploymentStartDate(c *gin.Context) {
+
+	userID := c.Param("user-id")
+
+	var req EmploymentStartDateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.SetEmploymentStartDate(ctx, &req, userID)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+}
+
+func (h *LeaveHandler) ClaimCompOff(c *gin.Context) {
+
+	var req CompOffClaimRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.ClaimCompOff(ctx, &req)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+
+}
+
+func (h *LeaveHandler) GetCompOffClaims(c *gin.Context) {
+
+	userID := c.Query("user_id")
+	status := c.Query("status")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetCompOffClaims(ctx, userID, status)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+
+}
+
+func (h *LeaveHandler) ApproveCompOffClaim(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req ReviewLeaveRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.ReviewerID = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.ApproveCompOffClaim(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+
+}
+
+func (h *LeaveHandler) RejectCompOffClaim(c *gin.Context) {
+
+	id := c.Param("id")
+
+	var req ReviewLeaveRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	req.ReviewerID = userID.(string)
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.RejectCompOffClaim(ctx, &req, id)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+
+}
+
+func (h *LeaveHandler) GetCompOffBalance(c *gin.Context) {
+
+	userID := c.Param("user-id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetCompOffBalance(ctx, userID)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+
+}
+
+func (h *LeaveHandler) CreateClosure(c *gin.Context) {
+
+	var req ClosureRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.CreateClosure(ctx, &req)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+
+}
+
+func (h *LeaveHandler) GetClosures(c *gin.Context) {
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	data, err := h.leaveService.GetClosures(ctx)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Success", data)
+
+}
@@ -8,64 +8,640 @@ import (
 
 type Setting struct {
 	ID                 primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID     string             `bson:"organization_id" json:"organization_id"`
 	MaxEmployeesPerDay int                `bson:"max_employees_per_day" json:"max_employees_per_day"`
 	AdvanceBookingDays int                `bson:"advance_booking_days" json:"advance_booking_days"`
-	CreatedAt          time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt          time.Time          `bson:"updated_at" json:"updated_at"`
+	MaxBookingDays     int                `bson:"max_booking_days" json:"max_booking_days"`
+	CancelNoticeDays   int                `bson:"cancel_notice_days" json:"cancel_notice_days"`
+	// YearBoundary picks when balances roll over: the calendar year, or the
+	// school year starting on YearStartMonth/YearStartDay. Switching it
+	// moves the period boundaries, so it is best done at year end.
+	YearBoundary          string    `bson:"year_boundary" json:"year_boundary"`
+	YearStartMonth        int       `bson:"year_start_month" json:"year_start_month"`
+	YearStartDay          int       `bson:"year_start_day" json:"year_start_day"`
+	CarryOverMaxDays      float64   `bson:"carry_over_max_days" json:"carry_over_max_days"`
+	CarryOverExpiryMonths int       `bson:"carry_over_expiry_months" json:"carry_over_expiry_months"`
+	CompOffExpiryDays     int       `bson:"comp_off_expiry_days" json:"comp_off_expiry_days"`
+	PendingExpiryDays     int       `bson:"pending_expiry_days" json:"pending_expiry_days"`
+	CreatedAt             time.Time `bson:"created_at" json:"created_at"`
+	UpdatedAt             time.Time `bson:"updated_at" json:"updated_at"`
 }
 
+const (
+	YearBoundaryCalendar = "calendar"
+	YearBoundarySchool   = "school"
+)
+
+const (
+	PortionFull = "full"
+	PortionAM   = "am"
+	PortionPM   = "pm"
+)
+
+// DailyLeaveSolt tracks morning and afternoon capacity separately.
+// AvailableSlot is the number of full days still free, the lower of the two.
 type DailyLeaveSolt struct {
+	ID              primitive.ObjectID  `bson:"_id" json:"id"`
+	OrganizationID  string              `bson:"organization_id" json:"organization_id"`
+	Date            time.Time           `bson:"date" json:"date"`
+	MaxSlot         int                 `bson:"max_slot" json:"max_slot"`
+	AvailableSlot   int                 `bson:"available_slot" json:"available_slot"`
+	AvailableAMSlot int                 `bson:"available_am_slot" json:"available_am_slot"`
+	AvailablePMSlot int                 `bson:"available_pm_slot" json:"available_pm_slot"`
+	IsHoliday       bool                `bson:"is_holiday" json:"is_holiday"`
+	HolidayName     string              `bson:"holiday_name,omitempty" json:"holiday_name,omitempty"`
+	ManualOverride  bool                `bson:"manual_override" json:"manual_override"`
+	CapacityRuleID  *primitive.ObjectID `bson:"capacity_rule_id,omitempty" json:"capacity_rule_id,omitempty"`
+	Blackout        *BlackoutMark       `bson:"-" json:"blackout,omitempty"`
+	Pools           []PoolAvailability  `bson:"-" json:"pools,omitempty"`
+	ConfirmedLeaves []ConfirmedLeave    `bson:"confirmed_leaves" json:"confirmed_leaves"`
+	PendingRequests []PendingRequest    `bson:"pending_requests" json:"pending_requests"`
+	Promotions      []Promotion         `bson:"promotions" json:"promotions"`
+	CreatedAt       time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time           `bson:"updated_at" json:"updated_at"`
+}
+
+// CapacityRule sets the daily capacity for the days it matches: the given
+// weekdays, the given date range, or both. When several rules match a day
+// the highest priority wins.
+type CapacityRule struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	Name           string             `bson:"name" json:"name"`
+	Weekdays       []time.Weekday     `bson:"weekdays" json:"weekdays"`
+	StartDate      *time.Time         `bson:"start_date,omitempty" json:"start_date,omitempty"`
+	EndDate        *time.Time         `bson:"end_date,omitempty" json:"end_date,omitempty"`
+	MaxSlot        int                `bson:"max_slot" json:"max_slot"`
+	Priority       int                `bson:"priority" json:"priority"`
+	IsActive       bool               `bson:"is_active" json:"is_active"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// CapacityPool caps how many members of a team or department may be off on
+// the same day. Members book against the pool instead of the
+// organization-wide capacity, which is left to users outside any pool.
+// Members are listed by user ID and checked against the main service when
+// the pool is saved.
+type CapacityPool struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	Name           string             `bson:"name" json:"name"`
+	UserIDs        []string           `bson:"user_ids" json:"user_ids"`
+	MaxSlot        int                `bson:"max_slot" json:"max_slot"`
+	IsActive       bool               `bson:"is_active" json:"is_active"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// EntitlementTable sets an organization's yearly annual leave: BaseDays,
+// replaced by the highest tier the employee's tenure reaches, plus
+// SeniorityDays for every SeniorityYears of service. A first year that
+// starts after the leave year does is prorated by month.
+type EntitlementTable struct {
+	ID               primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID   string             `bson:"organization_id" json:"organization_id"`
+	BaseDays         float64            `bson:"base_days" json:"base_days"`
+	Tiers            []EntitlementTier  `bson:"tiers" json:"tiers"`
+	SeniorityYears   int                `bson:"seniority_years" json:"seniority_years"`
+	SeniorityDays    float64            `bson:"seniority_days" json:"seniority_days"`
+	ProrateFirstYear bool               `bson:"prorate_first_year" json:"prorate_first_year"`
+	CreatedAt        time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt        time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+type EntitlementTier struct {
+	MinYears int     `bson:"min_years" json:"min_years"`
+	Days     float64 `bson:"days" json:"days"`
+}
+
+// EmploymentStartDate is when a user started with the organization. It is
+// taken from the account's creation date unless HR has set it.
+type EmploymentStartDate struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	UserID         string             `bson:"user_id" json:"user_id"`
+	StartDate      time.Time          `bson:"start_date" json:"start_date"`
+	Source         string             `bson:"source" json:"source"`
+	UpdatedBy      string             `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+const (
+	StartDateSourceAccount = "account"
+	StartDateSourceHR      = "hr"
+)
+
+// PoolAvailability is one pool's share of a day, worked out from the
+// confirmed leaves booked against the pool.
+type PoolAvailability struct {
+	PoolID          primitive.ObjectID `json:"pool_id"`
+	Name            string             `json:"name"`
+	MaxSlot         int                `json:"max_slot"`
+	AvailableSlot   int                `json:"available_slot"`
+	AvailableAMSlot int                `json:"available_am_slot"`
+	AvailablePMSlot int                `json:"available_pm_slot"`
+}
+
+const (
+	BlackoutAbsolute     = "absolute"
+	BlackoutTermRelative = "term_relative"
+
+	BlackoutAnchorTermStart = "term_start"
+	BlackoutAnchorTermEnd   = "term_end"
+
+	BlackoutActionReject          = "reject"
+	BlackoutActionRequireApproval = "require_approval"
+)
+
+// BlackoutPeriod closes a window to leave. An absolute period has fixed
+// dates; a term-relative one is counted in days from the start or end of a
+// term (the organization's current term when TermID is empty), so "first
+// week of term" is anchor term_start with offsets 0 to 6.
+type BlackoutPeriod struct {
 	ID              primitive.ObjectID `bson:"_id" json:"id"`
-	Date            time.Time          `bson:"date" json:"date"`
-	MaxSlot         int                `bson:"max_slot" json:"max_slot"`
-	AvailableSlot   int                `bson:"available_slot" json:"available_slot"`
-	ConfirmedLeaves []ConfirmedLeave   `bson:"confirmed_leaves" json:"confirmed_leaves"`
-	PendingRequests []PendingRequest   `bson:"pending_requests" json:"pending_requests"`
+	OrganizationID  string             `bson:"organization_id" json:"organization_id"`
+	Name            string             `bson:"name" json:"name"`
+	Mode            string             `bson:"mode" json:"mode"`
+	StartDate       *time.Time         `bson:"start_date,omitempty" json:"start_date,omitempty"`
+	EndDate         *time.Time         `bson:"end_date,omitempty" json:"end_date,omitempty"`
+	TermID          string             `bson:"term_id,omitempty" json:"term_id,omitempty"`
+	Anchor          string             `bson:"anchor,omitempty" json:"anchor,omitempty"`
+	StartOffsetDays int                `bson:"start_offset_days" json:"start_offset_days"`
+	EndOffsetDays   int                `bson:"end_offset_days" json:"end_offset_days"`
+	Action          string             `bson:"action" json:"action"`
+	IsActive        bool               `bson:"is_active" json:"is_active"`
+	CreatedBy       string             `bson:"created_by" json:"created_by"`
 	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
 	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
 }
 
+// BlackoutMark flags a calendar day that falls in a blackout period.
+type BlackoutMark struct {
+	BlackoutID primitive.ObjectID `json:"blackout_id"`
+	Name       string             `json:"name"`
+	Action     string             `json:"action"`
+}
+
 type ConfirmedLeave struct {
-	UserID    string    `bson:"user_id" json:"user_id"`
-	UserName  string    `bson:"user_name" json:"user_name"`
-	ApproveAt time.Time `bson:"approve_at" json:"approve_at"`
+	LeaveID   primitive.ObjectID  `bson:"leave_id" json:"leave_id"`
+	UserID    string              `bson:"user_id" json:"user_id"`
+	UserName  string              `bson:"user_name" json:"user_name"`
+	Portion   string              `bson:"portion" json:"portion"`
+	PoolID    *primitive.ObjectID `bson:"pool_id,omitempty" json:"pool_id,omitempty"`
+	ApproveAt time.Time           `bson:"approve_at" json:"approve_at"`
 }
 
 type PendingRequest struct {
-	LeaveID   primitive.ObjectID `bson:"leave_id" json:"leave_id"`
-	UserID    string             `bson:"user_id" json:"user_id"`
-	UserName  string             `bson:"user_name" json:"user_name"`
-	Status    string             `bson:"status" json:"status"`
-	RequestAt time.Time          `bson:"request_at" json:"request_at"`
+	LeaveID   primitive.ObjectID  `bson:"leave_id" json:"leave_id"`
+	UserID    string              `bson:"user_id" json:"user_id"`
+	UserName  string              `bson:"user_name" json:"user_name"`
+	Portion   string              `bson:"portion" json:"portion"`
+	PoolID    *primitive.ObjectID `bson:"pool_id,omitempty" json:"pool_id,omitempty"`
+	Status    string              `bson:"status" json:"status"`
+	RequestAt time.Time           `bson:"request_at" json:"request_at"`
+}
+
+const (
+	LeaveTypeAnnual      = "annual"
+	LeaveTypeSick        = "sick"
+	LeaveTypeUnpaid      = "unpaid"
+	LeaveTypeMaternity   = "maternity"
+	LeaveTypeBereavement = "bereavement"
+	LeaveTypeCompOff     = "comp_off"
+	LeaveTypeClosure     = "closure"
+)
+
+type LeavePolicy struct {
+	ConsumesSlot       bool `bson:"consumes_slot" json:"consumes_slot"`
+	RequiresApproval   bool `bson:"requires_approval" json:"requires_approval"`
+	DeductsBalance     bool `bson:"deducts_balance" json:"deducts_balance"`
+	RequiresAttachment bool `bson:"requires_attachment" json:"requires_attachment"`
+	// UsesCompOff spends comp-off credits earned from overtime instead of
+	// the annual balance.
+	UsesCompOff bool `bson:"uses_comp_off" json:"uses_comp_off"`
+
+	// BookingWindow overrides the organization's notice and horizon for
+	// this type; nil keeps the organization's settings.
+	BookingWindow *BookingWindowException `bson:"booking_window,omitempty" json:"booking_window,omitempty"`
+}
+
+type BookingWindowException struct {
+	AdvanceBookingDays *int `bson:"advance_booking_days,omitempty" json:"advance_booking_days,omitempty"`
+	MaxBookingDays     *int `bson:"max_booking_days,omitempty" json:"max_booking_days,omitempty"`
+	AllowPastDates     bool `bson:"allow_past_dates" json:"allow_past_dates"`
+}
+
+// LeaveType is one kind of leave an organization offers. System types are
+// only booked by the service itself, closures among them; members can
+// neither request nor move them.
+type LeaveType struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	Code           string             `bson:"code" json:"code"`
+	Name           string             `bson:"name" json:"name"`
+	Policy         LeavePolicy        `bson:"policy" json:"policy"`
+	IsActive       bool               `bson:"is_active" json:"is_active"`
+	System         bool               `bson:"system" json:"system"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt      time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// requestable reports whether members may book the type themselves.
+// Closure types seeded before the system flag existed are caught by code.
+func (leaveType *LeaveType) requestable() bool {
+	return !leaveType.System && leaveType.Code != LeaveTypeClosure
+}
+
+type Promotion struct {
+	LeaveID    primitive.ObjectID `bson:"leave_id" json:"leave_id"`
+	UserID     string             `bson:"user_id" json:"user_id"`
+	UserName   string             `bson:"user_name" json:"user_name"`
+	RequestAt  time.Time          `bson:"request_at" json:"request_at"`
+	PromotedAt time.Time          `bson:"promoted_at" json:"promoted_at"`
 }
 
 type LeaveRequests struct {
+	ID             primitive.ObjectID  `bson:"_id" json:"id"`
+	OrganizationID string              `bson:"organization_id" json:"organization_id"`
+	LeaveDate      time.Time           `bson:"leave_date" json:"leave_date"`
+	StartDate      time.Time           `bson:"start_date" json:"start_date"`
+	EndDate        time.Time           `bson:"end_date" json:"end_date"`
+	LeaveDates     []time.Time         `bson:"leave_dates" json:"leave_dates"`
+	Portion        string              `bson:"portion" json:"portion"`
+	TotalDays      float64             `bson:"total_days" json:"total_days"`
+	LeaveType      string              `bson:"leave_type" json:"leave_type"`
+	Policy         *LeavePolicy        `bson:"policy" json:"policy"`
+	UserID         string              `bson:"user_id" json:"user_id"`
+	PoolID         *primitive.ObjectID `bson:"pool_id,omitempty" json:"pool_id,omitempty"`
+	RequestType    string              `bson:"request_type" json:"request_type"`
+	UserName       string              `bson:"user_name" json:"user_name"`
+	Reason         *string             `bson:"reason" json:"reason"`
+	RequestedAt    time.Time           `bson:"requested_at" json:"requested_at"`
+	Status         string              `bson:"status" json:"status"`
+	PromotedAt     *time.Time          `bson:"promoted_at,omitempty" json:"promoted_at,omitempty"`
+	ExpiredAt      *time.Time          `bson:"expired_at,omitempty" json:"expired_at,omitempty"`
+	ClosureID      *primitive.ObjectID `bson:"closure_id,omitempty" json:"closure_id,omitempty"`
+	Review         *LeaveReview        `bson:"review,omitempty" json:"review,omitempty"`
+	BlackoutID     *primitive.ObjectID `bson:"blackout_id,omitempty" json:"blackout_id,omitempty"`
+	Cancellation   *LeaveCancellation  `bson:"cancellation,omitempty" json:"cancellation,omitempty"`
+	Reschedule     *LeaveReschedule    `bson:"reschedule,omitempty" json:"reschedule,omitempty"`
+	Revisions      []LeaveRevision     `bson:"revisions,omitempty" json:"revisions,omitempty"`
+	RequiresCover  bool                `bson:"requires_cover" json:"requires_cover"`
+	Substitutes    []Substitute        `bson:"substitutes,omitempty" json:"substitutes,omitempty"`
+	Attachments    []Attachment        `bson:"attachments,omitempty" json:"attachments,omitempty"`
+	Coverage       *LeaveCoverage      `bson:"-" json:"coverage,omitempty"`
+
+	// NotificationPending marks an expired request whose owner has not been
+	// told yet; the expiry cron retries until the notification goes out.
+	NotificationPending bool `bson:"notification_pending,omitempty" json:"-"`
+}
+
+const (
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+type LeaveReview struct {
+	Action             string    `bson:"action" json:"action"`
+	ReviewerID         string    `bson:"reviewer_id" json:"reviewer_id"`
+	Comment            *string   `bson:"comment" json:"comment"`
+	CapacityOverridden bool      `bson:"capacity_overridden" json:"capacity_overridden"`
+	ReviewedAt         time.Time `bson:"reviewed_at" json:"reviewed_at"`
+}
+
+const (
+	CancellationPending  = "pending"
+	CancellationApproved = "approved"
+	CancellationRejected = "rejected"
+)
+
+// LeaveCancellation records who cancelled a leave and why. Cancelling a
+// leave that has started or is close at hand waits for a manager, so the
+// request stays confirmed with a pending cancellation until reviewed.
+type LeaveCancellation struct {
+	Status        string      `bson:"status" json:"status"`
+	Reason        *string     `bson:"reason" json:"reason"`
+	RequestedBy   string      `bson:"requested_by" json:"requested_by"`
+	RequestedAt   time.Time   `bson:"requested_at" json:"requested_at"`
+	ReviewerID    string      `bson:"reviewer_id,omitempty" json:"reviewer_id,omitempty"`
+	Comment       *string     `bson:"comment,omitempty" json:"comment,omitempty"`
+	ReviewedAt    *time.Time  `bson:"reviewed_at,omitempty" json:"reviewed_at,omitempty"`
+	ReleasedDates []time.Time `bson:"released_dates,omitempty" json:"released_dates,omitempty"`
+}
+
+// LeaveReschedule is a move to dates that were full when it was asked for.
+// The request keeps its current booking and queues on the new dates; the
+// move happens when a slot frees up there.
+type LeaveReschedule struct {
+	StartDate   time.Time   `bson:"start_date" json:"start_date"`
+	EndDate     time.Time   `bson:"end_date" json:"end_date"`
+	LeaveDates  []time.Time `bson:"leave_dates" json:"leave_dates"`
+	Reason      *string     `bson:"reason" json:"reason"`
+	RequestedBy string      `bson:"requested_by" json:"requested_by"`
+	RequestedAt time.Time   `bson:"requested_at" json:"requested_at"`
+}
+
+// LeaveClosure is a range an admin closed for the whole organization, such
+// as the week between terms. Every member gets a leave request for it;
+// Mode decides whether those requests cost them anything.
+type LeaveClosure struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	Name           string             `bson:"name" json:"name"`
+	StartDate      time.Time          `bson:"start_date" json:"start_date"`
+	EndDate        time.Time          `bson:"end_date" json:"end_date"`
+	LeaveDates     []time.Time        `bson:"leave_dates" json:"leave_dates"`
+	Mode           string             `bson:"mode" json:"mode"`
+	LeaveType      string             `bson:"leave_type" json:"leave_type"`
+	Results        []ClosureResult    `bson:"results" json:"results"`
+	CreatedBy      string             `bson:"created_by" json:"created_by"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// ClosureResult is what a closure did for one member.
+type ClosureResult struct {
+	UserID   string              `bson:"user_id" json:"user_id"`
+	UserName string              `bson:"user_name" json:"user_name"`
+	Status   string              `bson:"status" json:"status"`
+	LeaveID  *primitive.ObjectID `bson:"leave_id,omitempty" json:"leave_id,omitempty"`
+	Days     float64             `bson:"days" json:"days"`
+	Deducted float64             `bson:"deducted" json:"deducted"`
+	Message  string              `bson:"message,omitempty" json:"message,omitempty"`
+}
+
+const (
+	// ClosureModeOff marks members off at no cost to their slots or balance.
+	ClosureModeOff = "off"
+	// ClosureModeDeduct books the days as leave of the closure's type,
+	// deducting them the way that type's policy does.
+	ClosureModeDeduct = "deduct"
+)
+
+const (
+	ClosureCreated = "created"
+	ClosureSkipped = "skipped"
+	ClosureFailed  = "failed"
+)
+
+// LeaveAudit records a change made to a request, including those made by
+// background jobs rather than a user.
+type LeaveAudit struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	LeaveID        primitive.ObjectID `bson:"leave_id" json:"leave_id"`
+	UserID         string             `bson:"user_id" json:"user_id"`
+	Action         string             `bson:"action" json:"action"`
+	FromStatus     string             `bson:"from_status" json:"from_status"`
+	ToStatus       string             `bson:"to_status" json:"to_status"`
+	ActorID        string             `bson:"actor_id" json:"actor_id"`
+	Detail         string             `bson:"detail" json:"detail"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+}
+
+const (
+	AuditActionExpired = "expired"
+
+	// AuditActorSystem marks changes no user made.
+	AuditActorSystem = "system"
+)
+
+// LeaveNotification is a notice for a requester about a change they did
+// not make themselves, such as a request expiring. The cron has no user
+// session to call another service with, so notices are kept here and read
+// by the requester.
+type LeaveNotification struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	UserID         string             `bson:"user_id" json:"user_id"`
+	LeaveID        primitive.ObjectID `bson:"leave_id" json:"leave_id"`
+	Type           string             `bson:"type" json:"type"`
+	Title          string             `bson:"title" json:"title"`
+	Message        string             `bson:"message" json:"message"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	ReadAt         *time.Time         `bson:"read_at,omitempty" json:"read_at,omitempty"`
+}
+
+// LeaveRevision is a booking the request held before it was moved.
+type LeaveRevision struct {
+	StartDate   time.Time   `bson:"start_date" json:"start_date"`
+	EndDate     time.Time   `bson:"end_date" json:"end_date"`
+	LeaveDates  []time.Time `bson:"leave_dates" json:"leave_dates"`
+	Portion     string      `bson:"portion" json:"portion"`
+	TotalDays   float64     `bson:"total_days" json:"total_days"`
+	Status      string      `bson:"status" json:"status"`
+	RequestType string      `bson:"request_type" json:"request_type"`
+	Reason      *string     `bson:"reason" json:"reason"`
+	ChangedBy   string      `bson:"changed_by" json:"changed_by"`
+	ChangedAt   time.Time   `bson:"changed_at" json:"changed_at"`
+}
+
+// Attachment is a supporting document kept in GridFS; ID is the GridFS
+// file ID.
+type Attachment struct {
 	ID          primitive.ObjectID `bson:"_id" json:"id"`
-	LeaveDate   time.Time          `bson:"leave_date" json:"leave_date"`
-	UserID      string             `bson:"user_id" json:"user_id"`
-	RequestType string             `bson:"request_type" json:"request_type"`
-	UserName    string             `bson:"user_name" json:"user_name"`
-	Reason      *string            `bson:"reason" json:"reason"`
-	RequestedAt time.Time          `bson:"requested_at" json:"requested_at"`
+	FileName    string             `bson:"file_name" json:"file_name"`
+	ContentType string             `bson:"content_type" json:"content_type"`
+	Size        int64              `bson:"size" json:"size"`
+	UploadedBy  string             `bson:"uploaded_by" json:"uploaded_by"`
+	UploadedAt  time.Time          `bson:"uploaded_at" json:"uploaded_at"`
+}
+
+// CalendarFeed is an ICS subscription. Calendar clients cannot send a
+// Bearer token, so the feed is reached through a secret URL token; only
+// its hash is stored and revoking the feed disables the URL.
+type CalendarFeed struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	UserID         string             `bson:"user_id" json:"user_id"`
+	Scope          string             `bson:"scope" json:"scope"`
+	Name           string             `bson:"name" json:"name"`
+	TokenHash      string             `bson:"token_hash" json:"-"`
+	CreatedAt      time.Time          `bson:"created_at" json:"created_at"`
+	LastAccessedAt *time.Time         `bson:"last_accessed_at,omitempty" json:"last_accessed_at,omitempty"`
+	RevokedAt      *time.Time         `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// CompOffClaim turns one day of overtime into comp-off credit once a
+// manager approves it. Remaining is what is left to spend; Usages records
+// what each leave request took from it, refunds as negative entries.
+type CompOffClaim struct {
+	ID             primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID string             `bson:"organization_id" json:"organization_id"`
+	UserID         string             `bson:"user_id" json:"user_id"`
+	UserName       string             `bson:"user_name" json:"user_name"`
+	AttendanceID   primitive.ObjectID `bson:"attendance_id" json:"attendance_id"`
+	Date           time.Time          `bson:"date" json:"date"`
+	OvertimeHours  float64            `bson:"overtime_hours" json:"overtime_hours"`
+	Days           float64            `bson:"days" json:"days"`
+	Remaining      float64            `bson:"remaining" json:"remaining"`
+	Status         string             `bson:"status" json:"status"`
+	Reason         *string            `bson:"reason" json:"reason"`
+	RequestedAt    time.Time          `bson:"requested_at" json:"requested_at"`
+	Review         *LeaveReview       `bson:"review,omitempty" json:"review,omitempty"`
+	ExpiresAt      *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	ExpiredAt      *time.Time         `bson:"expired_at,omitempty" json:"expired_at,omitempty"`
+	ExpiredDays    float64            `bson:"expired_days,omitempty" json:"expired_days,omitempty"`
+	Usages         []CompOffUsage     `bson:"usages" json:"usages"`
+}
+
+type CompOffUsage struct {
+	LeaveID primitive.ObjectID `bson:"leave_id" json:"leave_id"`
+	Days    float64            `bson:"days" json:"days"`
+	UsedAt  time.Time          `bson:"used_at" json:"used_at"`
+}
+
+const (
+	CompOffPending  = "pending"
+	CompOffApproved = "approved"
+	CompOffRejected = "rejected"
+)
+
+const (
+	GroupByAll     = "all"
+	GroupByDay     = "day"
+	GroupByWeek    = "week"
+	GroupByMonth   = "month"
+	GroupByWeekday = "weekday"
+	GroupByType    = "type"
+	GroupByUser    = "user"
+	GroupByTeam    = "team"
+)
+
+// AnalyticsGrouping is one breakdown of the leave analytics. Top keeps only
+// the N buckets with the most requests.
+type AnalyticsGrouping struct {
+	By  string
+	Top int
+}
+
+const (
+	FeedScopeUser         = "user"
+	FeedScopeOrganization = "organization"
+)
+
+const (
+	SubstituteProposed = "proposed"
+	SubstituteAccepted = "accepted"
+	SubstituteDeclined = "declined"
+)
+
+// Substitute is a teacher asked to cover one day of a teacher's leave.
+type Substitute struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Date        time.Time          `bson:"date" json:"date"`
+	TeacherID   string             `bson:"teacher_id" json:"teacher_id"`
+	TeacherName string             `bson:"teacher_name" json:"teacher_name"`
 	Status      string             `bson:"status" json:"status"`
+	Note        *string            `bson:"note" json:"note"`
+	AssignedBy  string             `bson:"assigned_by" json:"assigned_by"`
+	AssignedAt  time.Time          `bson:"assigned_at" json:"assigned_at"`
+	RespondedBy string             `bson:"responded_by,omitempty" json:"responded_by,omitempty"`
+	RespondedAt *time.Time         `bson:"responded_at,omitempty" json:"responded_at,omitempty"`
+}
+
+const (
+	CoverageNotRequired = "not_required"
+	CoverageCovered     = "covered"
+	CoveragePartial     = "partial"
+	CoverageUncovered   = "uncovered"
+)
+
+// LeaveCoverage summarises which days of a leave have a substitute who has
+// not declined.
+type LeaveCoverage struct {
+	Status         string      `json:"status"`
+	CoveredDates   []time.Time `json:"covered_dates"`
+	UncoveredDates []time.Time `json:"uncovered_dates"`
+}
+
+const (
+	TransactionEarned = "EARNED"
+	TransactionUsed   = "USED"
+	TransactionRefund = "REFUND"
+	TransactionCarry  = "CARRY_OVER"
+	TransactionExpire = "EXPIRED"
+
+	TransactionCompOffEarned  = "COMP_OFF_EARNED"
+	TransactionCompOffUsed    = "COMP_OFF_USED"
+	TransactionCompOffRefund  = "COMP_OFF_REFUND"
+	TransactionCompOffExpired = "COMP_OFF_EXPIRED"
+)
+
+// Dates returns every day booked by the request. Requests created before
+// ranges were supported only carry LeaveDate.
+func (l *LeaveRequests) Dates() []time.Time {
+	if len(l.LeaveDates) > 0 {
+		return l.LeaveDates
+	}
+	return []time.Time{l.LeaveDate}
+}
+
+// Days returns how many leave days the request consumes, counting a
+// morning or afternoon as half a day.
+func (l *LeaveRequests) Days() float64 {
+	if l.TotalDays > 0 {
+		return l.TotalDays
+	}
+	return float64(len(l.Dates())) * portionDays(l.Portion)
+}
+
+// dayLength is how many days each of the request's dates counts for.
+func (l *LeaveRequests) dayLength() float64 {
+	dates := l.Dates()
+	if len(dates) == 0 {
+		return 0
+	}
+	return l.Days() / float64(len(dates))
+}
+
+// bookedBySystem reports whether the service, not the member, booked the
+// request, as it does for closures.
+func (l *LeaveRequests) bookedBySystem() bool {
+	return l.ClosureID != nil || l.LeaveType == LeaveTypeClosure
+}
+
+// LeavePolicy returns the policy the request was booked under. Requests
+// created before leave types existed behave like annual leave.
+func (l *LeaveRequests) LeavePolicy() LeavePolicy {
+	if l.Policy != nil {
+		return *l.Policy
+	}
+	return LeavePolicy{ConsumesSlot: true, DeductsBalance: true}
+}
+
+func portionDays(portion string) float64 {
+	if portion == PortionAM || portion == PortionPM {
+		return 0.5
+	}
+	return 1
 }
 
 type UserLeaveBalance struct {
 	ID                 primitive.ObjectID `bson:"_id" json:"id"`
+	OrganizationID     string             `bson:"organization_id" json:"organization_id"`
 	UserID             string             `bson:"user_id" json:"user_id"`
 	Year               int                `bson:"year" json:"year"`
-	TotalLeaveBanlance int                `bson:"total_leave_banlance" json:"total_leave_banlance"`
-	UsedLeave          int                `bson:"used_leave" json:"used_leave"`
-	RemaindingLeave    int                `bson:"remainding_leave" json:"remainding_leave"`
+	TotalLeaveBanlance float64            `bson:"total_leave_banlance" json:"total_leave_banlance"`
+	UsedLeave          float64            `bson:"used_leave" json:"used_leave"`
+	RemaindingLeave    float64            `bson:"remainding_leave" json:"remainding_leave"`
+	AccruedMonths      int                `bson:"accrued_months" json:"accrued_months"`
+	CarriedOver        float64            `bson:"carried_over,omitempty" json:"carried_over,omitempty"`
+	CarryOverExpiresAt *time.Time         `bson:"carry_over_expires_at,omitempty" json:"carry_over_expires_at,omitempty"`
+	CarryOverExpired   float64            `bson:"carry_over_expired,omitempty" json:"carry_over_expired,omitempty"`
 	LastUpdated        time.Time          `bson:"last_updated" json:"last_updated"`
 }
 
 type LeaveTransaction struct {
-	ID              primitive.ObjectID `bson:"_id" json:"id"`
-	UserID          string             `bson:"user_id" json:"user_id"`
-	TransactionType string             `bson:"transaction_type" json:"transaction_type"`
-	Date            time.Time          `bson:"date" json:"date"`
-	Reason          *string            `bson:"reason" json:"reason"`
-	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt       time.Time          `bson:"updated_at" json:"updated_at"`
+	ID              primitive.ObjectID  `bson:"_id" json:"id"`
+	OrganizationID  string              `bson:"organization_id" json:"organization_id"`
+	UserID          string              `bson:"user_id" json:"user_id"`
+	Year            int                 `bson:"year" json:"year"`
+	TransactionType string              `bson:"transaction_type" json:"transaction_type"`
+	Amount          float64             `bson:"amount" json:"amount"`
+	LeaveID         *primitive.ObjectID `bson:"leave_id,omitempty" json:"leave_id,omitempty"`
+	Date            time.Time           `bson:"date" json:"date"`
+	Reason          *string             `bson:"reason" json:"reason"`
+	CreatedAt       time.Time           `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time           `bson:"updated_at" json:"updated_at"`
 }
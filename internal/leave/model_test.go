@@ -0,0 +1,55 @@
+package leave
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPortionDays(t *testing.T) {
+
+	tests := []struct {
+		portion string
+		want    float64
+	}{
+		{portion: PortionFull, want: 1},
+		{portion: PortionAM, want: 0.5},
+		{portion: PortionPM, want: 0.5},
+		{portion: "", want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.portion, func(t *testing.T) {
+			if got := portionDays(tt.portion); got != tt.want {
+				t.Errorf("portionDays(%q) = %v, want %v", tt.portion, got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestLeaveRequestsDays(t *testing.T) {
+
+	day := func(d int) time.Time {
+		return time.Date(2030, time.May, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		leave LeaveRequests
+		want  float64
+	}{
+		{name: "stored total wins", leave: LeaveRequests{TotalDays: 2.5, LeaveDates: []time.Time{day(1), day(2)}}, want: 2.5},
+		{name: "range of full days", leave: LeaveRequests{Portion: PortionFull, LeaveDates: []time.Time{day(1), day(2), day(3)}}, want: 3},
+		{name: "afternoon", leave: LeaveRequests{Portion: PortionPM, LeaveDates: []time.Time{day(1)}}, want: 0.5},
+		{name: "legacy single day", leave: LeaveRequests{LeaveDate: day(1)}, want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.leave.Days(); got != tt.want {
+				t.Errorf("Days() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+}
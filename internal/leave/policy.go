@@ -0,0 +1,190 @@
+package leave
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata"
+)
+
+const (
+	ViolationPastDate       = "PAST_DATE"
+	ViolationAdvanceBooking = "ADVANCE_BOOKING"
+	ViolationMaxBooking     = "MAX_BOOKING"
+	ViolationBlackout       = "BLACKOUT"
+	ViolationCompOff        = "COMP_OFF_BALANCE"
+)
+
+// leaveLocation is the zone calendar days are counted in. It is set per
+// deployment, not per organization: every organization the service runs
+// for shares it. It is Vietnam unless LEAVE_TIMEZONE names another, read on
+// first use after the environment file is loaded; tzdata is embedded
+// because the runtime image has none.
+var leaveLocation = sync.OnceValue(func() *time.Location {
+
+	name := os.Getenv("LEAVE_TIMEZONE")
+	if name == "" {
+		name = "Asia/Ho_Chi_Minh"
+	}
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("[leave] unknown timezone %q, using UTC+7: %v", name, err)
+		return time.FixedZone("UTC+7", 7*60*60)
+	}
+
+	return location
+
+})
+
+// leaveToday returns the current date in the deployment's zone, at the UTC
+// midnight leave dates are stored at. The start of the UTC day would still be
+// yesterday until 07:00 in Vietnam.
+func leaveToday(now time.Time) time.Time {
+
+	local := now.In(leaveLocation())
+
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+
+}
+
+// PolicyViolation is one reason a request was refused, in a form the web
+// calendar can render without parsing the message.
+type PolicyViolation struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	LeaveType string `json:"leave_type"`
+	Date      string `json:"date,omitempty"`
+	Limit     int    `json:"limit"`
+	Earliest  string `json:"earliest,omitempty"`
+	Latest    string `json:"latest,omitempty"`
+}
+
+// PolicyError carries every violation found for a request, so the client
+// can show all of them at once.
+type PolicyError struct {
+	Violations []PolicyViolation `json:"violations"`
+}
+
+func (e *PolicyError) Error() string {
+
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+
+	return strings.Join(messages, "; ")
+
+}
+
+// maxLeaveRangeDays caps how many calendar days one request may span. It is
+// long enough for six months of maternity leave, which the default type
+// exempts from the booking horizon; anything longer is booked as several
+// requests.
+const maxLeaveRangeDays = 185
+
+// checkLeaveRange rejects a range that ends before it starts or spans more
+// than maxLeaveRangeDays.
+func checkLeaveRange(startDate time.Time, endDate time.Time) error {
+
+	if endDate.Before(startDate) {
+		return fmt.Errorf("end date must not be before start date")
+	}
+
+	if span := int(endDate.Sub(startDate).Hours()/24) + 1; span > maxLeaveRangeDays {
+		return fmt.Errorf("a leave request can span at most %d days, this one spans %d", maxLeaveRangeDays, span)
+	}
+
+	return nil
+
+}
+
+// BookingWindow is the effective notice and horizon for one leave type. A
+// MaxBookingDays of zero means there is no horizon.
+type BookingWindow struct {
+	AdvanceBookingDays int
+	MaxBookingDays     int
+	AllowPastDates     bool
+}
+
+func bookingWindow(setting *Setting, policy LeavePolicy) BookingWindow {
+
+	window := BookingWindow{
+		AdvanceBookingDays: setting.AdvanceBookingDays,
+		MaxBookingDays:     setting.MaxBookingDays,
+	}
+
+	exception := policy.BookingWindow
+	if exception == nil {
+		return window
+	}
+
+	if exception.AdvanceBookingDays != nil {
+		window.AdvanceBookingDays = *exception.AdvanceBookingDays
+	}
+
+	if exception.MaxBookingDays != nil {
+		window.MaxBookingDays = *exception.MaxBookingDays
+	}
+
+	window.AllowPastDates = exception.AllowPastDates
+
+	return window
+
+}
+
+// evaluateBookingWindow checks a leave range against the window, counting
+// in whole days from today. Past dates are reported on their own; the
+// notice rule only applies to requests that start today or later.
+func evaluateBookingWindow(window BookingWindow, leaveType string, startDate time.Time, endDate time.Time, today time.Time) *PolicyError {
+
+	var violations []PolicyViolation
+
+	if startDate.Before(today) {
+		if !window.AllowPastDates {
+			violations = append(violations, PolicyViolation{
+				Code:      ViolationPastDate,
+				Message:   fmt.Sprintf("%s is in the past", startDate.Format("2006-01-02")),
+				LeaveType: leaveType,
+				Date:      startDate.Format("2006-01-02"),
+				Earliest:  today.Format("2006-01-02"),
+			})
+		}
+	} else if window.AdvanceBookingDays > 0 {
+		earliest := today.AddDate(0, 0, window.AdvanceBookingDays)
+		if startDate.Before(earliest) {
+			violations = append(violations, PolicyViolation{
+				Code:      ViolationAdvanceBooking,
+				Message:   fmt.Sprintf("%s leave must be booked at least %d days in advance", leaveType, window.AdvanceBookingDays),
+				LeaveType: leaveType,
+				Date:      startDate.Format("2006-01-02"),
+				Limit:     window.AdvanceBookingDays,
+				Earliest:  earliest.Format("2006-01-02"),
+			})
+		}
+	}
+
+	if window.MaxBookingDays > 0 {
+		latest := today.AddDate(0, 0, window.MaxBookingDays)
+		if endDate.After(latest) {
+			violations = append(violations, PolicyViolation{
+				Code:      ViolationMaxBooking,
+				Message:   fmt.Sprintf("%s leave can be booked at most %d days ahead", leaveType, window.MaxBookingDays),
+				LeaveType: leaveType,
+				Date:      endDate.Format("2006-01-02"),
+				Limit:     window.MaxBookingDays,
+				Latest:    latest.Format("2006-01-02"),
+			})
+		}
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+
+	return &PolicyError{Violations: violations}
+
+}
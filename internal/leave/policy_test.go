@@ -0,0 +1,168 @@
+package leave
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLeaveToday(t *testing.T) {
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{
+			name: "early morning in Vietnam is already the next day",
+			now:  time.Date(2030, time.March, 4, 18, 30, 0, 0, time.UTC),
+			want: time.Date(2030, time.March, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "afternoon in Vietnam",
+			now:  time.Date(2030, time.March, 5, 9, 0, 0, 0, time.UTC),
+			want: time.Date(2030, time.March, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "just before local midnight",
+			now:  time.Date(2030, time.March, 5, 16, 59, 0, 0, time.UTC),
+			want: time.Date(2030, time.March, 5, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := leaveToday(tt.now); !got.Equal(tt.want) {
+				t.Errorf("leaveToday(%s) = %s, want %s", tt.now, got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestCheckLeaveRange(t *testing.T) {
+
+	start := time.Date(2030, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		end     time.Time
+		wantErr bool
+	}{
+		{name: "single day", end: start},
+		{name: "longest allowed span", end: start.AddDate(0, 0, maxLeaveRangeDays-1)},
+		{name: "one day too long", end: start.AddDate(0, 0, maxLeaveRangeDays), wantErr: true},
+		{name: "end before start", end: start.AddDate(0, 0, -1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLeaveRange(start, tt.end)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkLeaveRange(%s, %s) error = %v, want error %v", start, tt.end, err, tt.wantErr)
+			}
+		})
+	}
+
+}
+
+func TestEvaluateBookingWindow(t *testing.T) {
+
+	today := time.Date(2030, time.June, 10, 0, 0, 0, 0, time.UTC)
+	day := func(offset int) time.Time {
+		return today.AddDate(0, 0, offset)
+	}
+
+	tests := []struct {
+		name   string
+		window BookingWindow
+		start  time.Time
+		end    time.Time
+		want   []string
+	}{
+		{name: "today with no rules", start: day(0), end: day(0)},
+		{name: "past date", start: day(-1), end: day(0), want: []string{ViolationPastDate}},
+		{name: "past date allowed by the type", window: BookingWindow{AllowPastDates: true}, start: day(-3), end: day(-1)},
+		{name: "notice met on the day", window: BookingWindow{AdvanceBookingDays: 3}, start: day(3), end: day(3)},
+		{name: "notice one day short", window: BookingWindow{AdvanceBookingDays: 3}, start: day(2), end: day(4), want: []string{ViolationAdvanceBooking}},
+		{name: "past date is not also short notice", window: BookingWindow{AdvanceBookingDays: 3}, start: day(-1), end: day(0), want: []string{ViolationPastDate}},
+		{name: "range ends on the horizon", window: BookingWindow{MaxBookingDays: 30}, start: day(28), end: day(30)},
+		{name: "range ends past the horizon", window: BookingWindow{MaxBookingDays: 30}, start: day(28), end: day(31), want: []string{ViolationMaxBooking}},
+		{
+			name:   "short notice and past the horizon",
+			window: BookingWindow{AdvanceBookingDays: 7, MaxBookingDays: 5},
+			start:  day(1),
+			end:    day(6),
+			want:   []string{ViolationAdvanceBooking, ViolationMaxBooking},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			policyErr := evaluateBookingWindow(tt.window, LeaveTypeAnnual, tt.start, tt.end, today)
+
+			var got []string
+			if policyErr != nil {
+				for _, violation := range policyErr.Violations {
+					got = append(got, violation.Code)
+				}
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("violations = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("violations = %v, want %v", got, tt.want)
+				}
+			}
+
+		})
+	}
+
+}
+
+func TestMaternityDefaultFitsTheLongestRange(t *testing.T) {
+
+	today := time.Date(2030, time.June, 10, 0, 0, 0, 0, time.UTC)
+	start := today.AddDate(0, 0, 14)
+	end := start.AddDate(0, 0, maxLeaveRangeDays-1)
+
+	var maternity *LeaveType
+	for _, leaveType := range defaultLeaveTypes("org-1") {
+		if leaveType.Code == LeaveTypeMaternity {
+			maternity = leaveType
+		}
+	}
+	if maternity == nil {
+		t.Fatalf("no default maternity leave type")
+	}
+
+	window := bookingWindow(&Setting{AdvanceBookingDays: 7, MaxBookingDays: 90}, maternity.Policy)
+
+	if err := checkLeaveRange(start, end); err != nil {
+		t.Fatalf("checkLeaveRange: %v", err)
+	}
+	if policyErr := evaluateBookingWindow(window, maternity.Code, start, end, today); policyErr != nil {
+		t.Errorf("maternity leave of %d days refused: %v", maxLeaveRangeDays, policyErr)
+	}
+
+}
+
+// Leave types that draw on no balance are only bounded by a manager's
+// approval.
+func TestDefaultUnchargedLeaveTypesNeedApproval(t *testing.T) {
+
+	for _, leaveType := range defaultLeaveTypes("org-1") {
+
+		policy := leaveType.Policy
+		if leaveType.System || policy.DeductsBalance || policy.UsesCompOff {
+			continue
+		}
+
+		if !policy.RequiresApproval {
+			t.Errorf("%s leave takes no balance and needs no approval", leaveType.Code)
+		}
+	}
+
+}
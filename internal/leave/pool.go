@@ -0,0 +1,486 @@
+package leave
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// halfUsage counts the confirmed leaves matching cond that take the given
+// half of the day; a full day takes both halves.
+func halfUsage(half string, cond bson.M) bson.M {
+
+	other := PortionPM
+	if half == PortionPM {
+		other = PortionAM
+	}
+
+	return bson.M{"$size": bson.M{"$filter": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$confirmed_leaves", bson.A{}}},
+		"cond": bson.M{"$and": bson.A{
+			cond,
+			bson.M{"$ne": bson.A{"$$this.portion", other}},
+		}},
+	}}}
+
+}
+
+// poolUsage counts the confirmed leaves booked against the pool.
+func poolUsage(poolID primitive.ObjectID, half string) bson.M {
+	return halfUsage(half, bson.M{"$eq": bson.A{"$$this.pool_id", poolID}})
+}
+
+// sharedUsage counts the confirmed leaves held by the day's own capacity,
+// i.e. those not booked against a pool.
+func sharedUsage(half string) bson.M {
+	return halfUsage(half, bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$$this.pool_id", nil}}, nil}})
+}
+
+// sharedSlotUsage is sharedUsage for a slot already loaded.
+func sharedSlotUsage(confirmedLeaves []ConfirmedLeave) (int, int) {
+
+	usedAM, usedPM := 0, 0
+
+	for _, confirmed := range confirmedLeaves {
+		if confirmed.PoolID != nil {
+			continue
+		}
+		if confirmed.Portion != PortionPM {
+			usedAM++
+		}
+		if confirmed.Portion != PortionAM {
+			usedPM++
+		}
+	}
+
+	return usedAM, usedPM
+
+}
+
+// poolCapacityExpr matches the day only while the pool still has room for
+// the portion. It is checked in the same update that takes the slot, so
+// two members of a pool cannot both take its last place.
+func poolCapacityExpr(pool *CapacityPool, portion string) bson.M {
+
+	var conditions bson.A
+
+	if portion != PortionPM {
+		conditions = append(conditions, bson.M{"$lt": bson.A{poolUsage(pool.ID, PortionAM), pool.MaxSlot}})
+	}
+
+	if portion != PortionAM {
+		conditions = append(conditions, bson.M{"$lt": bson.A{poolUsage(pool.ID, PortionPM), pool.MaxSlot}})
+	}
+
+	return bson.M{"$and": conditions}
+
+}
+
+func poolAvailability(dailyLeaveSlot *DailyLeaveSolt, pool *CapacityPool) PoolAvailability {
+
+	usedAM, usedPM := 0, 0
+
+	for _, confirmed := range dailyLeaveSlot.ConfirmedLeaves {
+		if confirmed.PoolID == nil || *confirmed.PoolID != pool.ID {
+			continue
+		}
+		if confirmed.Portion != PortionPM {
+			usedAM++
+		}
+		if confirmed.Portion != PortionAM {
+			usedPM++
+		}
+	}
+
+	availableAMSlot := max(pool.MaxSlot-usedAM, 0)
+	availablePMSlot := max(pool.MaxSlot-usedPM, 0)
+
+	return PoolAvailability{
+		PoolID:          pool.ID,
+		Name:            pool.Name,
+		MaxSlot:         pool.MaxSlot,
+		AvailableSlot:   min(availableAMSlot, availablePMSlot),
+		AvailableAMSlot: availableAMSlot,
+		AvailablePMSlot: availablePMSlot,
+	}
+
+}
+
+func poolHasCapacity(dailyLeaveSlot *DailyLeaveSolt, pool *CapacityPool, portion string) bool {
+
+	availability := poolAvailability(dailyLeaveSlot, pool)
+
+	switch portion {
+	case PortionAM:
+		return availability.AvailableAMSlot > 0
+	case PortionPM:
+		return availability.AvailablePMSlot > 0
+	default:
+		return availability.AvailableAMSlot > 0 && availability.AvailablePMSlot > 0
+	}
+
+}
+
+func markPoolAvailability(pools []*CapacityPool, dailyLeaveSlots []*DailyLeaveSolt) {
+
+	for _, dailyLeaveSlot := range dailyLeaveSlots {
+		for _, pool := range pools {
+			if !pool.IsActive {
+				continue
+			}
+			dailyLeaveSlot.Pools = append(dailyLeaveSlot.Pools, poolAvailability(dailyLeaveSlot, pool))
+		}
+	}
+
+}
+
+// resolvePool finds the pool the user books against: the active pool that
+// lists the user.
+func (s *leaveService) resolvePool(ctx context.Context, organizationID string, userID string) (*CapacityPool, error) {
+
+	pools, err := s.leaveRepository.GetCapacityPools(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pool := range pools {
+		if pool.IsActive && slices.Contains(pool.UserIDs, userID) {
+			return pool, nil
+		}
+	}
+
+	return nil, nil
+
+}
+
+func (s *leaveService) GetCapacityPools(ctx context.Context) ([]*CapacityPool, error) {
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetCapacityPools(ctx, organizationID)
+
+}
+
+func (s *leaveService) CreateCapacityPool(ctx context.Context, req *CapacityPoolRequest) (*CapacityPool, error) {
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "manage capacity pools")
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	pool, err := buildCapacityPool(req)
+	if err != nil {
+		return nil, err
+	}
+
+	pool.ID = primitive.NewObjectID()
+	pool.OrganizationID = organizationID
+	pool.CreatedAt = time.Now()
+	pool.UpdatedAt = time.Now()
+
+	err = s.checkPoolMembers(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.leaveRepository.CreateCapacityPool(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	return pool, nil
+
+}
+
+func (s *leaveService) UpdateCapacityPool(ctx context.Context, req *CapacityPoolRequest, id string) (*CapacityPool, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "manage capacity pools")
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	pool, err := buildCapacityPool(req)
+	if err != nil {
+		return nil, err
+	}
+
+	pool.ID = objectID
+	pool.OrganizationID = organizationID
+
+	err = s.checkPoolMembers(ctx, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	poolUpdated, err := s.leaveRepository.UpdateCapacityPool(ctx, pool, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	return poolUpdated, s.promotePoolWishlist(ctx, organizationID, objectID)
+
+}
+
+func (s *leaveService) DeleteCapacityPool(ctx context.Context, id string) error {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "manage capacity pools")
+	if err != nil {
+		return err
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	err = s.leaveRepository.DeleteCapacityPool(ctx, organizationID, objectID)
+	if err != nil {
+		return err
+	}
+
+	return s.promotePoolWishlist(ctx, organizationID, objectID)
+
+}
+
+// checkPoolMembers makes sure the main service knows every member and
+// keeps each user in at most one active pool, so every user books against
+// a single pool.
+func (s *leaveService) checkPoolMembers(ctx context.Context, pool *CapacityPool) error {
+
+	for _, userID := range pool.UserIDs {
+		userInfor, err := s.userService.GetUserInfor(ctx, userID)
+		if err != nil {
+			return err
+		}
+		if userInfor == nil {
+			return fmt.Errorf("user %s not found", userID)
+		}
+	}
+
+	if !pool.IsActive {
+		return nil
+	}
+
+	pools, err := s.leaveRepository.GetCapacityPools(ctx, pool.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	for _, other := range pools {
+		if other.ID == pool.ID || !other.IsActive {
+			continue
+		}
+		for _, userID := range pool.UserIDs {
+			if slices.Contains(other.UserIDs, userID) {
+				return fmt.Errorf("user %s is already in pool %s", userID, other.Name)
+			}
+		}
+	}
+
+	return nil
+
+}
+
+// promotePoolWishlist lets waiting members of a pool into any room its
+// change opened up.
+func (s *leaveService) promotePoolWishlist(ctx context.Context, organizationID string, poolID primitive.ObjectID) error {
+
+	dates, err := s.leaveRepository.GetPoolWaitingDates(ctx, organizationID, poolID, leaveToday(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	return s.promoteWishlist(ctx, organizationID, dates)
+
+}
+
+func buildCapacityPool(req *CapacityPoolRequest) (*CapacityPool, error) {
+
+	if req.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	if len(req.UserIDs) == 0 {
+		return nil, fmt.Errorf("user ids are required")
+	}
+
+	if req.MaxSlot < 0 {
+		return nil, fmt.Errorf("max slot must not be negative")
+	}
+
+	pool := CapacityPool{
+		Name:     req.Name,
+		UserIDs:  req.UserIDs,
+		MaxSlot:  req.MaxSlot,
+		IsActive: true,
+	}
+
+	if req.IsActive != nil {
+		pool.IsActive = *req.IsActive
+	}
+
+	return &pool, nil
+
+}
+
+func (r *leaveRepository) GetCapacityPools(ctx context.Context, organizationID string) ([]*CapacityPool, error) {
+
+	var pools []*CapacityPool
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "created_at", Value: 1}})
+
+	cursor, err := r.collectionCapacityPool.Find(ctx, bson.M{"organization_id": organizationID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &pools)
+	if err != nil {
+		return nil, err
+	}
+
+	if pools == nil {
+		return []*CapacityPool{}, nil
+	}
+
+	return pools, nil
+
+}
+
+// getActivePool loads the pool the request was booked against. A pool that
+// has been removed or switched off no longer limits anyone.
+func (r *leaveRepository) getActivePool(ctx context.Context, leaveItem *LeaveRequests) (*CapacityPool, error) {
+
+	if leaveItem.PoolID == nil {
+		return nil, nil
+	}
+
+	var pool CapacityPool
+
+	err := r.collectionCapacityPool.FindOne(ctx, bson.M{
+		"_id":             *leaveItem.PoolID,
+		"organization_id": leaveItem.OrganizationID,
+		"is_active":       true,
+	}).Decode(&pool)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pool, nil
+
+}
+
+func (r *leaveRepository) CreateCapacityPool(ctx context.Context, pool *CapacityPool) error {
+
+	_, err := r.collectionCapacityPool.InsertOne(ctx, pool)
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) UpdateCapacityPool(ctx context.Context, pool *CapacityPool, id primitive.ObjectID) (*CapacityPool, error) {
+
+	filter := bson.M{"_id": id, "organization_id": pool.OrganizationID}
+
+	update := bson.M{
+		"$set": bson.M{
+			"name":       pool.Name,
+			"user_ids":   pool.UserIDs,
+			"max_slot":   pool.MaxSlot,
+			"is_active":  pool.IsActive,
+			"updated_at": time.Now(),
+		},
+	}
+
+	var poolUpdated CapacityPool
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := r.collectionCapacityPool.FindOneAndUpdate(ctx, filter, update, opts).Decode(&poolUpdated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &poolUpdated, nil
+
+}
+
+func (r *leaveRepository) DeleteCapacityPool(ctx context.Context, organizationID string, id primitive.ObjectID) error {
+
+	result, err := r.collectionCapacityPool.DeleteOne(ctx, bson.M{"_id": id, "organization_id": organizationID})
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+
+}
+
+// GetPoolWaitingDates lists the days from the given one on where members of
+// the pool are on the wish list, so they can be promoted after the pool
+// grows or goes away.
+func (r *leaveRepository) GetPoolWaitingDates(ctx context.Context, organizationID string, poolID primitive.ObjectID, from time.Time) ([]time.Time, error) {
+
+	findOptions := options.Find()
+	findOptions.SetProjection(bson.M{"date": 1})
+	findOptions.SetSort(bson.D{{Key: "date", Value: 1}})
+
+	cursor, err := r.collectionDailyLeaveSlots.Find(ctx, bson.M{
+		"organization_id":          organizationID,
+		"date":                     bson.M{"$gte": from},
+		"pending_requests.pool_id": poolID,
+	}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var slots []struct {
+		Date time.Time `bson:"date"`
+	}
+
+	err = cursor.All(ctx, &slots)
+	if err != nil {
+		return nil, err
+	}
+
+	dates := make([]time.Time, 0, len(slots))
+	for _, slot := range slots {
+		dates = append(dates, slot.Date)
+	}
+
+	return dates, nil
+
+}
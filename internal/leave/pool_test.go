@@ -0,0 +1,227 @@
+package leave
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSharedSlotUsage(t *testing.T) {
+
+	poolID := primitive.NewObjectID()
+
+	tests := []struct {
+		name           string
+		confirmed      []ConfirmedLeave
+		wantAM, wantPM int
+	}{
+		{name: "empty day", wantAM: 0, wantPM: 0},
+		{
+			name:      "unpooled full day and morning",
+			confirmed: []ConfirmedLeave{{Portion: PortionFull}, {Portion: PortionAM}},
+			wantAM:    2, wantPM: 1,
+		},
+		{
+			name:      "pooled leaves are held by their pool",
+			confirmed: []ConfirmedLeave{{Portion: PortionFull, PoolID: &poolID}, {Portion: PortionPM}},
+			wantAM:    0, wantPM: 1,
+		},
+		{
+			name:      "legacy entry without a portion counts as a full day",
+			confirmed: []ConfirmedLeave{{}},
+			wantAM:    1, wantPM: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usedAM, usedPM := sharedSlotUsage(tt.confirmed)
+			if usedAM != tt.wantAM || usedPM != tt.wantPM {
+				t.Errorf("sharedSlotUsage = %d/%d, want %d/%d", usedAM, usedPM, tt.wantAM, tt.wantPM)
+			}
+		})
+	}
+
+}
+
+func TestPoolAndSharedCapacityAreSeparate(t *testing.T) {
+
+	repository, db := newTestRepository(t)
+
+	ctx := context.Background()
+
+	if err := repository.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ensure indexes: %v", err)
+	}
+
+	const organizationID = "org-pools"
+
+	_, err := repository.UpdateSetting(ctx, &Setting{MaxEmployeesPerDay: 1}, organizationID)
+	if err != nil {
+		t.Fatalf("update setting: %v", err)
+	}
+
+	pool := &CapacityPool{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: organizationID,
+		Name:           "Kitchen",
+		UserIDs:        []string{"cook-1", "cook-2", "cook-3"},
+		MaxSlot:        1,
+		IsActive:       true,
+	}
+	if err := repository.CreateCapacityPool(ctx, pool); err != nil {
+		t.Fatalf("create pool: %v", err)
+	}
+
+	book := func(userID string, date time.Time, pooled bool) *LeaveRequests {
+		leaveItem := &LeaveRequests{
+			OrganizationID: organizationID,
+			StartDate:      date,
+			EndDate:        date,
+			LeaveDates:     []time.Time{date},
+			Portion:        PortionFull,
+			LeaveType:      LeaveTypeAnnual,
+			Policy:         &LeavePolicy{ConsumesSlot: true},
+			UserID:         userID,
+			UserName:       userID,
+			RequestedAt:    time.Now(),
+		}
+		if pooled {
+			leaveItem.PoolID = &pool.ID
+		}
+		if err := repository.CreateLeave(ctx, leaveItem); err != nil {
+			t.Fatalf("book %s: %v", userID, err)
+		}
+		return leaveItem
+	}
+
+	poolFull := time.Date(2030, time.March, 4, 0, 0, 0, 0, time.UTC)
+	sharedFull := time.Date(2030, time.March, 5, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		userID string
+		date   time.Time
+		pooled bool
+		want   string
+	}{
+		{name: "pooled user takes the pool's place", userID: "cook-1", date: poolFull, pooled: true, want: "confirmed"},
+		{name: "second pooled user waits", userID: "cook-2", date: poolFull, pooled: true, want: "pending"},
+		{name: "full pool does not block an unpooled user", userID: "teacher-1", date: poolFull, want: "confirmed"},
+		{name: "unpooled user takes the shared place", userID: "teacher-2", date: sharedFull, want: "confirmed"},
+		{name: "second unpooled user waits", userID: "teacher-3", date: sharedFull, want: "pending"},
+		{name: "full shared capacity does not block a pooled user", userID: "cook-3", date: sharedFull, pooled: true, want: "confirmed"},
+	}
+
+	booked := map[string]*LeaveRequests{}
+
+	for _, tt := range tests {
+		leaveItem := book(tt.userID, tt.date, tt.pooled)
+		booked[tt.userID] = leaveItem
+		if leaveItem.Status != tt.want {
+			t.Errorf("%s: status = %s, want %s", tt.name, leaveItem.Status, tt.want)
+		}
+	}
+
+	slots := db.Collection("daily_leave_slots")
+
+	var slot DailyLeaveSolt
+	if err := slots.FindOne(ctx, slotFilter(organizationID, sharedFull)).Decode(&slot); err != nil {
+		t.Fatalf("read slot: %v", err)
+	}
+	if slot.AvailableAMSlot != 0 || slot.AvailablePMSlot != 0 {
+		t.Errorf("shared counters with one unpooled leave = %d/%d, want 0/0", slot.AvailableAMSlot, slot.AvailablePMSlot)
+	}
+
+	// Releasing the pooled leave gives back the pool's place, not a shared one.
+	if err := repository.releaseDailyLeaveSlot(ctx, sharedFull, booked["cook-3"]); err != nil {
+		t.Fatalf("release pooled leave: %v", err)
+	}
+
+	if err := slots.FindOne(ctx, slotFilter(organizationID, sharedFull)).Decode(&slot); err != nil {
+		t.Fatalf("read slot: %v", err)
+	}
+	if slot.AvailableAMSlot != 0 || slot.AvailablePMSlot != 0 || len(slot.ConfirmedLeaves) != 1 {
+		t.Errorf("after releasing the pooled leave: counters %d/%d with %d confirmed, want 0/0 with 1",
+			slot.AvailableAMSlot, slot.AvailablePMSlot, len(slot.ConfirmedLeaves))
+	}
+
+	if err := repository.releaseDailyLeaveSlot(ctx, sharedFull, booked["teacher-2"]); err != nil {
+		t.Fatalf("release unpooled leave: %v", err)
+	}
+
+	if err := slots.FindOne(ctx, slotFilter(organizationID, sharedFull)).Decode(&slot); err != nil {
+		t.Fatalf("read slot: %v", err)
+	}
+	if slot.AvailableAMSlot != 1 || slot.AvailablePMSlot != 1 || slot.AvailableSlot != 1 {
+		t.Errorf("after releasing the unpooled leave: available = %d/%d/%d, want 1/1/1",
+			slot.AvailableSlot, slot.AvailableAMSlot, slot.AvailablePMSlot)
+	}
+
+}
+
+func TestPoolMembership(t *testing.T) {
+
+	kitchen := &CapacityPool{ID: primitive.NewObjectID(), Name: "Kitchen", UserIDs: []string{"cook-1", "cook-2"}, IsActive: true}
+	retired := &CapacityPool{ID: primitive.NewObjectID(), Name: "Old kitchen", UserIDs: []string{"teacher-1"}}
+
+	service := &leaveService{
+		leaveRepository: &fakeLeaveRepository{pools: []*CapacityPool{kitchen, retired}},
+		userService:     &fakeUserService{missingUsers: []string{"ghost"}},
+	}
+
+	ctx := context.Background()
+
+	t.Run("resolve", func(t *testing.T) {
+
+		tests := []struct {
+			userID string
+			want   *CapacityPool
+		}{
+			{userID: "cook-2", want: kitchen},
+			{userID: "teacher-1"},
+			{userID: "stranger"},
+		}
+
+		for _, tt := range tests {
+			pool, err := service.resolvePool(ctx, "org-1", tt.userID)
+			if err != nil {
+				t.Fatalf("resolvePool(%s): %v", tt.userID, err)
+			}
+			if pool != tt.want {
+				t.Errorf("resolvePool(%s) = %v, want %v", tt.userID, pool, tt.want)
+			}
+		}
+
+	})
+
+	t.Run("check", func(t *testing.T) {
+
+		tests := []struct {
+			name    string
+			pool    *CapacityPool
+			wantErr string
+		}{
+			{name: "new members", pool: &CapacityPool{ID: primitive.NewObjectID(), UserIDs: []string{"teacher-1"}, IsActive: true}},
+			{name: "unknown user", pool: &CapacityPool{ID: primitive.NewObjectID(), UserIDs: []string{"ghost"}, IsActive: true}, wantErr: "user ghost not found"},
+			{name: "member of another active pool", pool: &CapacityPool{ID: primitive.NewObjectID(), UserIDs: []string{"cook-1"}, IsActive: true}, wantErr: "already in pool Kitchen"},
+			{name: "inactive pool may overlap", pool: &CapacityPool{ID: primitive.NewObjectID(), UserIDs: []string{"cook-1"}}},
+			{name: "pool keeps its own members", pool: kitchen},
+		}
+
+		for _, tt := range tests {
+			err := service.checkPoolMembers(ctx, tt.pool)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+		}
+
+	})
+
+}
@@ -2,475 +2,2219 @@ package leave
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"time"
+	"worktime-service/internal/shared"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type LeaveRepository interface {
+	EnsureIndexes(ctx context.Context) error
 	CreateLeave(ctx context.Context, leaveItem *LeaveRequests) error
-	GetSettings(ctx context.Context) *Setting
-	UpdateSetting(ctx context.Context, setting *Setting, id primitive.ObjectID) (*Setting, error)
-	GetDailyLeaveSlots(ctx context.Context, date *time.Time) ([]*DailyLeaveSolt, error)
-	GetDetailLeaveSlots(ctx context.Context, id primitive.ObjectID) (*DailyLeaveSolt, error)
-	GetMyRequest(ctx context.Context, userID string) ([]*LeaveRequests, error)
-	EditMaxSlot(ctx context.Context, maxSlot int, availableSlot int, id primitive.ObjectID) error
-	GetPendingRequest(ctx context.Context) ([]*LeaveRequests, error)
-	DeleteRequestLeave(ctx context.Context, date *time.Time, userID string) error
-	UpdateRequestLeave(ctx context.Context, types string, id primitive.ObjectID) error
-	GetAllLeaves(ctx context.Context, dateFrom *time.Time, dateTo *time.Time) ([]*LeaveRequests, error)
+	AssignLegacyOrganization(ctx context.Context, organizationID string) error
+	GetSettings(ctx context.Context, organizationID string) (*Setting, error)
+	UpdateSetting(ctx context.Context, setting *Setting, organizationID string) (*Setting, error)
+	GetDailyLeaveSlots(ctx context.Context, organizationID string, date *time.Time) ([]*DailyLeaveSolt, error)
+	GetDetailLeaveSlots(ctx context.Context, organizationID string, id primitive.ObjectID) (*DailyLeaveSolt, error)
+	GetMyRequest(ctx context.Context, organizationID string, userID string) ([]*LeaveRequests, error)
+	EditMaxSlot(ctx context.Context, maxSlot int, availableAMSlot int, availablePMSlot int, id primitive.ObjectID) error
+	GetPendingRequest(ctx context.Context, organizationID string) ([]*LeaveRequests, error)
+	GetLeaveByDate(ctx context.Context, organizationID string, date *time.Time, userID string) (*LeaveRequests, error)
+	GetLeavesByDate(ctx context.Context, organizationID string, date *time.Time, userID string) ([]*LeaveRequests, error)
+	CancelLeave(ctx context.Context, leaveItem *LeaveRequests, cancellation *LeaveCancellation) error
+	TrimLeave(ctx context.Context, leaveItem *LeaveRequests, released []time.Time, cancellation *LeaveCancellation) error
+	RequestCancellation(ctx context.Context, leaveItem *LeaveRequests, cancellation *LeaveCancellation) error
+	RejectCancellation(ctx context.Context, leaveItem *LeaveRequests, cancellation *LeaveCancellation) error
+	RescheduleLeave(ctx context.Context, leaveItem *LeaveRequests, reschedule *LeaveReschedule) error
+	PromoteReschedules(ctx context.Context, organizationID string, date time.Time) ([]*LeaveRequests, error)
+	GetLeaveByID(ctx context.Context, organizationID string, id primitive.ObjectID) (*LeaveRequests, error)
+	AddSubstitute(ctx context.Context, leaveItem *LeaveRequests, substitute *Substitute) error
+	UpdateSubstituteStatus(ctx context.Context, organizationID string, leaveID primitive.ObjectID, substituteID primitive.ObjectID, status string, respondedBy string) (*LeaveRequests, error)
+	RemoveSubstitute(ctx context.Context, organizationID string, leaveID primitive.ObjectID, substituteID primitive.ObjectID) (*LeaveRequests, error)
+	GetLeavesRequiringCover(ctx context.Context, organizationID string, dateFrom time.Time, dateTo time.Time) ([]*LeaveRequests, error)
+	AddAttachment(ctx context.Context, leaveItem *LeaveRequests, attachment *Attachment, content io.Reader) error
+	OpenAttachment(ctx context.Context, attachmentID primitive.ObjectID) (io.ReadCloser, error)
+	DeleteAttachment(ctx context.Context, leaveItem *LeaveRequests, attachmentID primitive.ObjectID) error
+	ApproveLeave(ctx context.Context, leaveItem *LeaveRequests, review *LeaveReview) error
+	RejectLeave(ctx context.Context, leaveItem *LeaveRequests, review *LeaveReview) error
+	GetPendingOrganizationIDs(ctx context.Context) ([]string, error)
+	GetStalePendingLeaves(ctx context.Context, organizationID string, before time.Time) ([]*LeaveRequests, error)
+	ExpireLeave(ctx context.Context, leaveItem *LeaveRequests, expiredAt time.Time) error
+	GetExpiryNotificationsPending(ctx context.Context) ([]*LeaveRequests, error)
+	ClearExpiryNotificationPending(ctx context.Context, id primitive.ObjectID) error
+	CreateLeaveNotification(ctx context.Context, notification *LeaveNotification) error
+	GetLeaveNotifications(ctx context.Context, organizationID string, userID string) ([]*LeaveNotification, error)
+	MarkLeaveNotificationRead(ctx context.Context, organizationID string, userID string, id primitive.ObjectID, readAt time.Time) error
+	CreateLeaveAudit(ctx context.Context, audit *LeaveAudit) error
+	GetLeaveAudits(ctx context.Context, organizationID string, leaveID primitive.ObjectID) ([]*LeaveAudit, error)
+	GetLeavesOnDates(ctx context.Context, organizationID string, dates []time.Time) ([]*LeaveRequests, error)
+	CreateClosure(ctx context.Context, closure *LeaveClosure) error
+	GetClosures(ctx context.Context, organizationID string) ([]*LeaveClosure, error)
+	GetLeaveAnalytics(ctx context.Context, organizationID string, dateFrom time.Time, dateTo time.Time, groupings []AnalyticsGrouping) ([][]*AnalyticsBucket, error)
 	GetAllLeaveBalance(ctx context.Context) ([]*UserLeaveBalance, error)
 	CreateLeaveBalance(ctx context.Context, leaveBalance []*UserLeaveBalance) error
+	GetLeaveBalance(ctx context.Context, organizationID string, userID string, year int) (*UserLeaveBalance, error)
+	FindLeaveBalance(ctx context.Context, organizationID string, userID string, year int) (*UserLeaveBalance, error)
+	CarryOverLeaveBalance(ctx context.Context, organizationID string, userID string, year int, amount float64, expiresAt *time.Time) (bool, error)
+	ExpireCarryOver(ctx context.Context, organizationID string, userID string, year int, now time.Time) (*UserLeaveBalance, error)
+	GetEntitlementTable(ctx context.Context, organizationID string) (*EntitlementTable, error)
+	UpdateEntitlementTable(ctx context.Context, table *EntitlementTable) (*EntitlementTable, error)
+	GetEmploymentStartDate(ctx context.Context, organizationID string, userID string) (*EmploymentStartDate, error)
+	SaveEmploymentStartDate(ctx context.Context, startDate *EmploymentStartDate) (*EmploymentStartDate, error)
+	CreateCompOffClaim(ctx context.Context, claim *CompOffClaim) (bool, error)
+	GetCompOffClaims(ctx context.Context, organizationID string, userID string, status string) ([]*CompOffClaim, error)
+	GetCompOffClaimByID(ctx context.Context, organizationID string, id primitive.ObjectID) (*CompOffClaim, error)
+	ReviewCompOffClaim(ctx context.Context, claim *CompOffClaim, review *LeaveReview, expiresAt *time.Time) error
+	GetCompOffCredits(ctx context.Context, organizationID string, userID string, now time.Time) ([]*CompOffClaim, error)
+	SpendCompOff(ctx context.Context, organizationID string, userID string, leaveID primitive.ObjectID, days float64, now time.Time) (float64, error)
+	RefundCompOff(ctx context.Context, organizationID string, userID string, leaveID primitive.ObjectID, days float64, now time.Time) (float64, error)
+	ExpireCompOffClaims(ctx context.Context, now time.Time) ([]*CompOffClaim, error)
+	AccrueLeaveBalance(ctx context.Context, organizationID string, userID string, year int, fromMonth int, toMonth int, amount float64) (bool, error)
+	UpdateLeaveBalance(ctx context.Context, organizationID string, userID string, year int, earned float64, used float64) error
+	CreateLeaveTransaction(ctx context.Context, transactions []*LeaveTransaction) error
+	GetLeaveTransactions(ctx context.Context, organizationID string, userID string, year int) ([]*LeaveTransaction, error)
+	GetOrganizationIDs(ctx context.Context) ([]string, error)
+	GetOrganizationUserIDs(ctx context.Context, organizationID string) ([]string, error)
+	PromoteWishlist(ctx context.Context, organizationID string, date time.Time) ([]*LeaveRequests, error)
+	ReturnToWishlist(ctx context.Context, leaveItem *LeaveRequests) error
+	GetLeaveTypes(ctx context.Context, organizationID string) ([]*LeaveType, error)
+	GetLeaveTypeByCode(ctx context.Context, organizationID string, code string) (*LeaveType, error)
+	CreateLeaveType(ctx context.Context, leaveType *LeaveType) error
+	UpdateLeaveType(ctx context.Context, leaveType *LeaveType, id primitive.ObjectID) (*LeaveType, error)
+	GetCapacityRules(ctx context.Context, organizationID string) ([]*CapacityRule, error)
+	CreateCapacityRule(ctx context.Context, rule *CapacityRule) error
+	UpdateCapacityRule(ctx context.Context, rule *CapacityRule, id primitive.ObjectID) (*CapacityRule, error)
+	DeleteCapacityRule(ctx context.Context, organizationID string, id primitive.ObjectID) error
+	GetCapacityPools(ctx context.Context, organizationID string) ([]*CapacityPool, error)
+	CreateCapacityPool(ctx context.Context, pool *CapacityPool) error
+	UpdateCapacityPool(ctx context.Context, pool *CapacityPool, id primitive.ObjectID) (*CapacityPool, error)
+	DeleteCapacityPool(ctx context.Context, organizationID string, id primitive.ObjectID) error
+	GetPoolWaitingDates(ctx context.Context, organizationID string, poolID primitive.ObjectID, from time.Time) ([]time.Time, error)
+	RecomputeDailyLeaveSlots(ctx context.Context, organizationID string, from time.Time) ([]time.Time, error)
+	SyncHolidaySlots(ctx context.Context, organizationID string, from time.Time) ([]time.Time, error)
+	GetBlackoutPeriods(ctx context.Context, organizationID string) ([]*BlackoutPeriod, error)
+	CreateBlackoutPeriod(ctx context.Context, period *BlackoutPeriod) error
+	UpdateBlackoutPeriod(ctx context.Context, period *BlackoutPeriod, id primitive.ObjectID) (*BlackoutPeriod, error)
+	DeleteBlackoutPeriod(ctx context.Context, organizationID string, id primitive.ObjectID) error
+	CreateCalendarFeed(ctx context.Context, feed *CalendarFeed) error
+	GetCalendarFeeds(ctx context.Context, organizationID string, userID string) ([]*CalendarFeed, error)
+	RevokeCalendarFeed(ctx context.Context, organizationID string, userID string, id primitive.ObjectID) error
+	UseCalendarFeed(ctx context.Context, tokenHash string) (*CalendarFeed, error)
+	GetFeedLeaves(ctx context.Context, organizationID string, userID string, dateFrom time.Time, dateTo time.Time) ([]*LeaveRequests, error)
+	GetFullDailyLeaveSlots(ctx context.Context, organizationID string, dateFrom time.Time, dateTo time.Time) ([]*DailyLeaveSolt, error)
 }
 
+var errLeaveChanged = errors.New("leave request was changed by someone else, please reload")
+
 type leaveRepository struct {
-	collectionLeave           *mongo.Collection
-	collectionLeaveSetting    *mongo.Collection
-	collectionDailyLeaveSlots *mongo.Collection
-	collectionLeaveBalance    *mongo.Collection
+	collectionLeave            *mongo.Collection
+	collectionLeaveSetting     *mongo.Collection
+	collectionDailyLeaveSlots  *mongo.Collection
+	collectionLeaveBalance     *mongo.Collection
+	collectionLeaveTransaction *mongo.Collection
+	collectionLeaveType        *mongo.Collection
+	collectionCapacityRule     *mongo.Collection
+	collectionBlackout         *mongo.Collection
+	collectionCapacityPool     *mongo.Collection
+	collectionCalendarFeed     *mongo.Collection
+	collectionEntitlement      *mongo.Collection
+	collectionEmployment       *mongo.Collection
+	collectionCompOff          *mongo.Collection
+	collectionAudit            *mongo.Collection
+	collectionClosure          *mongo.Collection
+	collectionNotification     *mongo.Collection
+	attachmentBucket           *gridfs.Bucket
+	holidayCalendar            shared.HolidayCalendar
 }
 
 func NewLeaveRepository(collectionLeave *mongo.Collection,
 	collectionLeaveSetting *mongo.Collection,
 	collectionDailyLeaveSlots *mongo.Collection,
-	collectionLeaveBalance *mongo.Collection) LeaveRepository {
+	collectionLeaveBalance *mongo.Collection,
+	collectionLeaveTransaction *mongo.Collection,
+	collectionLeaveType *mongo.Collection,
+	collectionCapacityRule *mongo.Collection,
+	collectionBlackout *mongo.Collection,
+	collectionCapacityPool *mongo.Collection,
+	collectionCalendarFeed *mongo.Collection,
+	collectionEntitlement *mongo.Collection,
+	collectionEmployment *mongo.Collection,
+	collectionCompOff *mongo.Collection,
+	collectionAudit *mongo.Collection,
+	collectionClosure *mongo.Collection,
+	collectionNotification *mongo.Collection,
+	attachmentBucket *gridfs.Bucket,
+	holidayCalendar shared.HolidayCalendar) LeaveRepository {
 	return &leaveRepository{
-		collectionLeave:           collectionLeave,
-		collectionLeaveSetting:    collectionLeaveSetting,
-		collectionDailyLeaveSlots: collectionDailyLeaveSlots,
-		collectionLeaveBalance:    collectionLeaveBalance,
+		collectionLeave:            collectionLeave,
+		collectionLeaveSetting:     collectionLeaveSetting,
+		collectionDailyLeaveSlots:  collectionDailyLeaveSlots,
+		collectionLeaveBalance:     collectionLeaveBalance,
+		collectionLeaveTransaction: collectionLeaveTransaction,
+		collectionLeaveType:        collectionLeaveType,
+		collectionCapacityRule:     collectionCapacityRule,
+		collectionBlackout:         collectionBlackout,
+		collectionCapacityPool:     collectionCapacityPool,
+		collectionCalendarFeed:     collectionCalendarFeed,
+		collectionEntitlement:      collectionEntitlement,
+		collectionEmployment:       collectionEmployment,
+		collectionCompOff:          collectionCompOff,
+		collectionAudit:            collectionAudit,
+		collectionClosure:          collectionClosure,
+		collectionNotification:     collectionNotification,
+		attachmentBucket:           attachmentBucket,
+		holidayCalendar:            holidayCalendar,
+	}
+}
+
+func (r *leaveRepository) EnsureIndexes(ctx context.Context) error {
+
+	// Slots used to be unique per date across all organizations; that index
+	// would stop two schools from booking the same day.
+	r.collectionDailyLeaveSlots.Indexes().DropOne(ctx, "date_1")
+
+	// Days booked concurrently before the unique index existed can have
+	// more than one slot document, which would stop the index from being
+	// built.
+	err := r.mergeDuplicateSlots(ctx)
+	if err != nil {
+		return fmt.Errorf("merge duplicate daily_leave_slots: %w", err)
+	}
+
+	err = r.migrateHalfDaySlots(ctx)
+	if err != nil {
+		return fmt.Errorf("migrate half-day daily_leave_slots: %w", err)
+	}
+
+	_, err = r.collectionDailyLeaveSlots.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "organization_id", Value: 1}, {Key: "date", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("create daily_leave_slots index: %w", err)
+	}
+
+	_, err = r.collectionLeaveSetting.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "organization_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"organization_id": bson.M{"$type": "string"}}),
+	})
+	if err != nil {
+		return fmt.Errorf("create settings index: %w", err)
+	}
+
+	// Balances used to be one per user and year; a member of two
+	// organizations now has one in each.
+	r.collectionLeaveBalance.Indexes().DropOne(ctx, "user_id_1_year_1")
+
+	_, err = r.collectionLeaveBalance.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "organization_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "year", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("create leave_balance index: %w", err)
+	}
+
+	_, err = r.collectionLeave.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "leave_dates", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("create leave_requests index: %w", err)
+	}
+
+	_, err = r.collectionLeave.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "status", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("create leave_requests organization index: %w", err)
+	}
+
+	// Analytics and calendar reads select an organization's requests by
+	// day, over ranges that can span years.
+	_, err = r.collectionLeave.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "leave_dates", Value: 1}}},
+		{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "leave_date", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("create leave_requests date indexes: %w", err)
+	}
+
+	_, err = r.collectionNotification.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "leave_id", Value: 1}, {Key: "type", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("create leave_notifications indexes: %w", err)
+	}
+
+	err = r.dropDuplicateLeaveTypes(ctx)
+	if err != nil {
+		return fmt.Errorf("drop duplicate leave_types: %w", err)
+	}
+
+	_, err = r.collectionLeaveType.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "organization_id", Value: 1}, {Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("create leave_types index: %w", err)
+	}
+
+	_, err = r.collectionCalendarFeed.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "token_hash", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("create leave_calendar_feeds index: %w", err)
+	}
+
+	_, err = r.collectionEntitlement.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "organization_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("create leave_entitlement_tables index: %w", err)
+	}
+
+	_, err = r.collectionEmployment.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "organization_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return fmt.Errorf("create leave_employment_start_dates index: %w", err)
+	}
+
+	// One claim per attendance day, so overtime is never credited twice.
+	_, err = r.collectionCompOff.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "attendance_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "organization_id", Value: 1}, {Key: "user_id", Value: 1}, {Key: "status", Value: 1}}},
+	})
+	if err != nil {
+		return fmt.Errorf("create leave_comp_off_claims indexes: %w", err)
+	}
+
+	_, err = r.collectionAudit.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "leave_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("create leave_audit_logs index: %w", err)
+	}
+
+	return nil
+
+}
+
+// mergeDuplicateSlots folds every group of slot documents sharing an
+// organization and date into the oldest of them.
+func (r *leaveRepository) mergeDuplicateSlots(ctx context.Context) error {
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"organization_id": "$organization_id", "date": "$date"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+
+	cursor, err := r.collectionDailyLeaveSlots.Aggregate(ctx, pipeline, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+
+	err = cursor.All(ctx, &groups)
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		err := r.mergeSlots(ctx, group.IDs)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// dropDuplicateLeaveTypes removes the extra copies of a code that
+// concurrent seeding could insert before the unique index existed. The
+// first one inserted is kept; requests refer to their type by code, so none
+// of them lose it.
+func (r *leaveRepository) dropDuplicateLeaveTypes(ctx context.Context) error {
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$sort", Value: bson.M{"_id": 1}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"organization_id": "$organization_id", "code": "$code"},
+			"ids":   bson.M{"$push": "$_id"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}
+
+	cursor, err := r.collectionLeaveType.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	var groups []struct {
+		IDs []primitive.ObjectID `bson:"ids"`
+	}
+
+	err = cursor.All(ctx, &groups)
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		_, err := r.collectionLeaveType.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": group.IDs[1:]}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) mergeSlots(ctx context.Context, ids []primitive.ObjectID) error {
+
+	var slots []*DailyLeaveSolt
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "created_at", Value: 1}, {Key: "_id", Value: 1}})
+
+	cursor, err := r.collectionDailyLeaveSlots.Find(ctx, bson.M{"_id": bson.M{"$in": ids}}, findOptions)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &slots)
+	if err != nil {
+		return err
+	}
+
+	if len(slots) < 2 {
+		return nil
+	}
+
+	merged := mergeSlotDocuments(slots)
+
+	_, err = r.collectionDailyLeaveSlots.UpdateOne(ctx, bson.M{"_id": merged.ID}, bson.M{
+		"$set": bson.M{
+			"max_slot":          merged.MaxSlot,
+			"available_slot":    merged.AvailableSlot,
+			"available_am_slot": merged.AvailableAMSlot,
+			"available_pm_slot": merged.AvailablePMSlot,
+			"is_holiday":        merged.IsHoliday,
+			"holiday_name":      merged.HolidayName,
+			"manual_override":   merged.ManualOverride,
+			"confirmed_leaves":  merged.ConfirmedLeaves,
+			"pending_requests":  merged.PendingRequests,
+			"promotions":        merged.Promotions,
+			"updated_at":        time.Now(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var duplicates []primitive.ObjectID
+	for _, slot := range slots[1:] {
+		duplicates = append(duplicates, slot.ID)
+	}
+
+	_, err = r.collectionDailyLeaveSlots.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": duplicates}})
+
+	return err
+
+}
+
+// mergeSlotDocuments combines the entries of duplicate slots into the
+// first one, counting each leave once, and recounts the free halves from
+// the confirmed leaves. A day that was overbooked keeps negative counters,
+// so it takes no more bookings until enough leave is released.
+func mergeSlotDocuments(slots []*DailyLeaveSolt) *DailyLeaveSolt {
+
+	merged := *slots[0]
+	merged.ConfirmedLeaves = []ConfirmedLeave{}
+	merged.PendingRequests = []PendingRequest{}
+	merged.Promotions = []Promotion{}
+
+	entryKey := func(leaveID primitive.ObjectID, userID string, portion string) string {
+		if leaveID.IsZero() {
+			return userID + "/" + portion
+		}
+		return leaveID.Hex()
+	}
+
+	confirmed := map[string]bool{}
+	pending := map[string]bool{}
+	promoted := map[string]bool{}
+
+	for _, slot := range slots {
+
+		merged.MaxSlot = max(merged.MaxSlot, slot.MaxSlot)
+		merged.ManualOverride = merged.ManualOverride || slot.ManualOverride
+		if slot.IsHoliday && !merged.IsHoliday {
+			merged.IsHoliday = true
+			merged.HolidayName = slot.HolidayName
+		}
+
+		for _, entry := range slot.ConfirmedLeaves {
+			key := entryKey(entry.LeaveID, entry.UserID, entry.Portion)
+			if !confirmed[key] {
+				confirmed[key] = true
+				merged.ConfirmedLeaves = append(merged.ConfirmedLeaves, entry)
+			}
+		}
+
+		for _, entry := range slot.Promotions {
+			key := entryKey(entry.LeaveID, entry.UserID, "")
+			if !promoted[key] {
+				promoted[key] = true
+				merged.Promotions = append(merged.Promotions, entry)
+			}
+		}
+
+	}
+
+	// A request confirmed on one copy no longer waits on the day.
+	for _, slot := range slots {
+		for _, entry := range slot.PendingRequests {
+			key := entryKey(entry.LeaveID, entry.UserID, entry.Portion)
+			if !confirmed[key] && !pending[key] {
+				pending[key] = true
+				merged.PendingRequests = append(merged.PendingRequests, entry)
+			}
+		}
+	}
+
+	usedAM, usedPM := sharedSlotUsage(merged.ConfirmedLeaves)
+	merged.AvailableAMSlot = merged.MaxSlot - usedAM
+	merged.AvailablePMSlot = merged.MaxSlot - usedPM
+	merged.AvailableSlot = min(merged.AvailableAMSlot, merged.AvailablePMSlot)
+
+	return &merged
+
+}
+
+// AssignLegacyOrganization moves settings, slots and requests written
+// before leave data was split by organization into the given organization.
+func (r *leaveRepository) AssignLegacyOrganization(ctx context.Context, organizationID string) error {
+
+	filter := bson.M{"organization_id": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"organization_id": organizationID}}
+
+	// Only claim the global setting when the organization has none yet.
+	count, err := r.collectionLeaveSetting.CountDocuments(ctx, bson.M{"organization_id": organizationID})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		_, err = r.collectionLeaveSetting.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, collection := range []*mongo.Collection{r.collectionDailyLeaveSlots, r.collectionLeave, r.collectionLeaveBalance, r.collectionLeaveTransaction} {
+		_, err := collection.UpdateMany(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) GetSettings(ctx context.Context, organizationID string) (*Setting, error) {
+
+	filter := bson.M{"organization_id": organizationID}
+
+	var setting Setting
+
+	err := r.collectionLeaveSetting.FindOne(ctx, filter).Decode(&setting)
+	if err == nil {
+		return &setting, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"_id":                   primitive.NewObjectID(),
+			"max_employees_per_day": 5,
+			"advance_booking_days":  7,
+			"max_booking_days":      180,
+			"cancel_notice_days":    2,
+			"created_at":            time.Now(),
+			"updated_at":            time.Now(),
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	err = r.collectionLeaveSetting.FindOneAndUpdate(ctx, filter, update, opts).Decode(&setting)
+	if mongo.IsDuplicateKeyError(err) {
+		err = r.collectionLeaveSetting.FindOne(ctx, filter).Decode(&setting)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &setting, nil
+}
+
+func (r *leaveRepository) UpdateSetting(ctx context.Context, setting *Setting, organizationID string) (*Setting, error) {
+
+	if _, err := r.GetSettings(ctx, organizationID); err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"organization_id": organizationID}
+
+	update := bson.M{
+		"$set": bson.M{
+			"max_employees_per_day":    setting.MaxEmployeesPerDay,
+			"advance_booking_days":     setting.AdvanceBookingDays,
+			"max_booking_days":         setting.MaxBookingDays,
+			"cancel_notice_days":       setting.CancelNoticeDays,
+			"year_boundary":            setting.YearBoundary,
+			"year_start_month":         setting.YearStartMonth,
+			"year_start_day":           setting.YearStartDay,
+			"carry_over_max_days":      setting.CarryOverMaxDays,
+			"carry_over_expiry_months": setting.CarryOverExpiryMonths,
+			"comp_off_expiry_days":     setting.CompOffExpiryDays,
+			"pending_expiry_days":      setting.PendingExpiryDays,
+			"updated_at":               time.Now(),
+		},
+	}
+
+	_, err := r.collectionLeaveSetting.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+
+	var settingUpdated Setting
+
+	err = r.collectionLeaveSetting.FindOne(ctx, filter).Decode(&settingUpdated)
+	if err != nil {
+		return nil, err
+	}
+
+	return &settingUpdated, nil
+
+}
+
+// slotFilter identifies one organization's slot for a day.
+func slotFilter(organizationID string, date time.Time) bson.M {
+	return bson.M{"organization_id": organizationID, "date": date}
+}
+
+func (r *leaveRepository) getDailyLeaveSlots(ctx context.Context, organizationID string, date string) (*DailyLeaveSolt, error) {
+
+	dateParse, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := slotFilter(organizationID, dateParse)
+
+	var dailyLeaveSlot DailyLeaveSolt
+
+	err = r.collectionDailyLeaveSlots.FindOne(ctx, filter).Decode(&dailyLeaveSlot)
+	if err == nil {
+		return &dailyLeaveSlot, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	maxSlot, capacityRuleID, err := r.resolveDailyCapacity(ctx, organizationID, dateParse)
+	if err != nil {
+		return nil, err
+	}
+
+	// A public holiday is never bookable, so its slot starts closed.
+	holidayName, isHoliday, err := r.getHolidayName(ctx, organizationID, dateParse)
+	if err != nil {
+		return nil, err
 	}
+	if isHoliday {
+		maxSlot = 0
+	}
+
+	// Upserting with $setOnInsert against the unique date index means
+	// concurrent first bookings of a day all end up on one document.
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"_id":               primitive.NewObjectID(),
+			"max_slot":          maxSlot,
+			"available_slot":    maxSlot,
+			"available_am_slot": maxSlot,
+			"available_pm_slot": maxSlot,
+			"is_holiday":        isHoliday,
+			"holiday_name":      holidayName,
+			"manual_override":   false,
+			"capacity_rule_id":  capacityRuleID,
+			"confirmed_leaves":  []ConfirmedLeave{},
+			"pending_requests":  []PendingRequest{},
+			"promotions":        []Promotion{},
+			"created_at":        time.Now(),
+			"updated_at":        time.Now(),
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	err = r.collectionDailyLeaveSlots.FindOneAndUpdate(ctx, filter, update, opts).Decode(&dailyLeaveSlot)
+	if mongo.IsDuplicateKeyError(err) {
+		err = r.collectionDailyLeaveSlots.FindOne(ctx, filter).Decode(&dailyLeaveSlot)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &dailyLeaveSlot, nil
+
 }
 
-func (r *leaveRepository) GetSettings(ctx context.Context) *Setting {
+// migrateHalfDaySlots fills the morning and afternoon counters of slots
+// created before half days existed, which only know available_slot.
+func (r *leaveRepository) migrateHalfDaySlots(ctx context.Context) error {
+
+	_, err := r.collectionDailyLeaveSlots.UpdateMany(ctx, bson.M{
+		"available_am_slot": bson.M{"$exists": false},
+	}, mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"available_am_slot": "$available_slot",
+			"available_pm_slot": "$available_slot",
+		}}},
+	})
+
+	return err
+
+}
+
+func (r *leaveRepository) getHolidayName(ctx context.Context, organizationID string, date time.Time) (string, bool, error) {
+
+	if r.holidayCalendar == nil || organizationID == "" {
+		return "", false, nil
+	}
+
+	holidays, err := r.holidayCalendar.GetHolidaysInRange(ctx, organizationID, date, date)
+	if err != nil {
+		return "", false, err
+	}
+
+	name, ok := holidays[date.Format("2006-01-02")]
+
+	return name, ok, nil
+
+}
+
+// hasCapacity reports whether the slot can still take a leave of the given
+// portion; a full day needs both halves free.
+func hasCapacity(dailyLeaveSlot *DailyLeaveSolt, portion string) bool {
+	switch portion {
+	case PortionAM:
+		return dailyLeaveSlot.AvailableAMSlot > 0
+	case PortionPM:
+		return dailyLeaveSlot.AvailablePMSlot > 0
+	default:
+		return dailyLeaveSlot.AvailableAMSlot > 0 && dailyLeaveSlot.AvailablePMSlot > 0
+	}
+}
+
+// portionSlotInc builds the $inc applied to the AM/PM counters when a leave
+// of the given portion takes (delta -1) or gives back (delta 1) its slot.
+func portionSlotInc(portion string, delta int) bson.M {
+	switch portion {
+	case PortionAM:
+		return bson.M{"available_am_slot": delta}
+	case PortionPM:
+		return bson.M{"available_pm_slot": delta}
+	default:
+		return bson.M{"available_am_slot": delta, "available_pm_slot": delta}
+	}
+}
+
+// capacityFilter matches the day only while it still has room for the
+// portion, so the decrement that follows can never overbook it.
+func capacityFilter(organizationID string, date time.Time, portion string) bson.M {
+	filter := slotFilter(organizationID, date)
+	switch portion {
+	case PortionAM:
+		filter["available_am_slot"] = bson.M{"$gt": 0}
+	case PortionPM:
+		filter["available_pm_slot"] = bson.M{"$gt": 0}
+	default:
+		filter["available_am_slot"] = bson.M{"$gt": 0}
+		filter["available_pm_slot"] = bson.M{"$gt": 0}
+	}
+	return filter
+}
+
+func confirmedEntry(leaveItem *LeaveRequests, approveAt time.Time) ConfirmedLeave {
+	return ConfirmedLeave{
+		LeaveID:   leaveItem.ID,
+		UserID:    leaveItem.UserID,
+		UserName:  leaveItem.UserName,
+		Portion:   leaveItem.Portion,
+		PoolID:    leaveItem.PoolID,
+		ApproveAt: approveAt,
+	}
+}
+
+func pendingEntry(leaveItem *LeaveRequests) PendingRequest {
+	return PendingRequest{
+		LeaveID:   leaveItem.ID,
+		UserID:    leaveItem.UserID,
+		UserName:  leaveItem.UserName,
+		Portion:   leaveItem.Portion,
+		PoolID:    leaveItem.PoolID,
+		Status:    "pending",
+		RequestAt: leaveItem.RequestedAt,
+	}
+}
+
+// syncAvailableSlot keeps available_slot equal to the number of full days
+// still free after the AM/PM counters change.
+func (r *leaveRepository) syncAvailableSlot(ctx context.Context, organizationID string, date time.Time) error {
+
+	_, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, slotFilter(organizationID, date), mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"available_slot": bson.M{"$min": bson.A{"$available_am_slot", "$available_pm_slot"}},
+			"updated_at":     time.Now(),
+		}}},
+	})
+
+	return err
+
+}
+
+func (r *leaveRepository) CreateLeave(ctx context.Context, leaveItem *LeaveRequests) error {
+
+	leaveDates := leaveItem.Dates()
+	policy := leaveItem.LeavePolicy()
+
+	*leaveItem = LeaveRequests{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: leaveItem.OrganizationID,
+		LeaveDate:      leaveDates[0],
+		StartDate:      leaveItem.StartDate,
+		EndDate:        leaveItem.EndDate,
+		LeaveDates:     leaveDates,
+		Portion:        leaveItem.Portion,
+		TotalDays:      float64(len(leaveDates)) * portionDays(leaveItem.Portion),
+		LeaveType:      leaveItem.LeaveType,
+		Policy:         &policy,
+		UserID:         leaveItem.UserID,
+		PoolID:         leaveItem.PoolID,
+		RequiresCover:  leaveItem.RequiresCover,
+		UserName:       leaveItem.UserName,
+		Reason:         leaveItem.Reason,
+		RequestedAt:    leaveItem.RequestedAt,
+		ClosureID:      leaveItem.ClosureID,
+		Status:         "confirmed",
+		RequestType:    "immediate",
+	}
+
+	if policy.ConsumesSlot {
+		for _, date := range leaveDates {
+			dailyLeaveSlot, err := r.getDailyLeaveSlots(ctx, leaveItem.OrganizationID, date.Format("2006-01-02"))
+			if err != nil {
+				return err
+			}
+			if dailyLeaveSlot.IsHoliday {
+				return fmt.Errorf("%s is a public holiday: %s", date.Format("2006-01-02"), dailyLeaveSlot.HolidayName)
+			}
+		}
+	}
+
+	check, msg := r.checkRequestExists(ctx, leaveItem)
+	if check {
+		return fmt.Errorf("leave request exists: %s", msg)
+	}
+
+	// The whole range shares one status: it is only immediate when every
+	// day could be reserved, otherwise every day is wait-listed. Types that
+	// need approval wait for it regardless of capacity, and types that do
+	// not consume a slot never queue behind the daily cap.
+	if policy.RequiresApproval {
+		leaveItem.RequestType = "approval"
+		leaveItem.Status = "pending"
+	} else if policy.ConsumesSlot {
+		reserved, err := r.reserveDailyLeaveSlots(ctx, leaveItem, false, nil)
+		if err != nil {
+			return err
+		}
+		if !reserved {
+			leaveItem.RequestType = "wishlist"
+			leaveItem.Status = "pending"
+		}
+	}
+
+	_, err := r.collectionLeave.InsertOne(ctx, leaveItem)
+	if err != nil {
+		if leaveItem.RequestType == "immediate" && policy.ConsumesSlot {
+			r.rollbackCreateLeave(ctx, leaveItem, leaveDates)
+		}
+		return err
+	}
+
+	if !policy.ConsumesSlot || leaveItem.RequestType == "immediate" {
+		return nil
+	}
+
+	for i, date := range leaveDates {
+		err = r.pushPendingRequest(ctx, date, leaveItem)
+		if err != nil {
+			r.rollbackCreateLeave(ctx, leaveItem, leaveDates[:i])
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// reserveDailyLeaveSlots takes one slot on every day of the request or on
+// none of them. Each day is claimed with a conditional update, so parallel
+// bookings of the last slot cannot both succeed; if any day is full the
+// days already claimed are handed back. When fromPending is set the
+// request's wait-list entries are moved into the confirmed list.
+func (r *leaveRepository) reserveDailyLeaveSlots(ctx context.Context, leaveItem *LeaveRequests, fromPending bool, promotion *Promotion) (bool, error) {
+
+	leaveDates := leaveItem.Dates()
+
+	for i, date := range leaveDates {
+
+		reserved, err := r.reserveDailyLeaveSlot(ctx, date, leaveItem, fromPending, promotion)
+		if err == nil && reserved {
+			continue
+		}
+
+		for _, reservedDate := range leaveDates[:i] {
+			r.unreserveDailyLeaveSlot(ctx, reservedDate, leaveItem, fromPending)
+		}
+
+		return false, err
+	}
+
+	return true, nil
+
+}
+
+func (r *leaveRepository) reserveDailyLeaveSlot(ctx context.Context, date time.Time, leaveItem *LeaveRequests, fromPending bool, promotion *Promotion) (bool, error) {
+
+	pool, err := r.getActivePool(ctx, leaveItem)
+	if err != nil {
+		return false, err
+	}
+
+	entry := confirmedEntry(leaveItem, time.Now())
+
+	push := bson.M{"confirmed_leaves": entry}
+	if promotion != nil {
+		push["promotions"] = *promotion
+	}
+
+	update := bson.M{
+		"$push": push,
+		"$set":  bson.M{"updated_at": time.Now()},
+	}
+
+	if fromPending {
+		update["$pull"] = bson.M{"pending_requests": bson.M{"leave_id": leaveItem.ID}}
+	}
+
+	// A pooled leave takes a place in its pool only; the day's own counters
+	// are left to users outside any pool. The entry keeps the pool ID only
+	// when the pool held it, so releasing it knows which place to give back.
+	var filter bson.M
+
+	if pool != nil {
+		filter = slotFilter(leaveItem.OrganizationID, date)
+		filter["is_holiday"] = bson.M{"$ne": true}
+		filter["$expr"] = poolCapacityExpr(pool, leaveItem.Portion)
+	} else {
+		entry.PoolID = nil
+		push["confirmed_leaves"] = entry
+		filter = capacityFilter(leaveItem.OrganizationID, date, leaveItem.Portion)
+		update["$inc"] = portionSlotInc(leaveItem.Portion, -1)
+	}
+
+	result, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	if result.MatchedCount == 0 {
+		return false, nil
+	}
+
+	if pool != nil {
+		return true, nil
+	}
+
+	return true, r.syncAvailableSlot(ctx, leaveItem.OrganizationID, date)
+
+}
+
+func (r *leaveRepository) unreserveDailyLeaveSlot(ctx context.Context, date time.Time, leaveItem *LeaveRequests, toPending bool) error {
+
+	update := bson.M{
+		"$pull": bson.M{
+			"promotions": bson.M{"leave_id": leaveItem.ID},
+		},
+	}
+
+	if toPending {
+		update["$push"] = bson.M{"pending_requests": pendingEntry(leaveItem)}
+	}
+
+	return r.pullConfirmedEntry(ctx, leaveItem, date, bson.M{"leave_id": leaveItem.ID}, update)
+
+}
+
+// pullConfirmedEntry takes the leave's entry off the day's confirmed list
+// together with the rest of update, and hands its place back to whatever
+// held it: its pool when the entry carries one, the day's own counters
+// otherwise. The counters are only raised when an unpooled entry was
+// actually removed.
+func (r *leaveRepository) pullConfirmedEntry(ctx context.Context, leaveItem *LeaveRequests, date time.Time, entryFilter bson.M, update bson.M) error {
+
+	withEntry := func(poolFilter bson.M) bson.M {
+		match := bson.M{}
+		for key, value := range entryFilter {
+			match[key] = value
+		}
+		for key, value := range poolFilter {
+			match[key] = value
+		}
+		filter := slotFilter(leaveItem.OrganizationID, date)
+		filter["confirmed_leaves"] = bson.M{"$elemMatch": match}
+		return filter
+	}
+
+	pull := bson.M{"confirmed_leaves": entryFilter}
+	if extra, ok := update["$pull"].(bson.M); ok {
+		for key, value := range extra {
+			pull[key] = value
+		}
+	}
+
+	pulled := bson.M{"$pull": pull}
+	for key, value := range update {
+		if key != "$pull" {
+			pulled[key] = value
+		}
+	}
+
+	result, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, withEntry(bson.M{"pool_id": bson.M{"$ne": nil}}), pulled)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount > 0 {
+		return nil
+	}
+
+	shared := bson.M{"$inc": portionSlotInc(leaveItem.Portion, 1)}
+	for key, value := range pulled {
+		shared[key] = value
+	}
+
+	result, err = r.collectionDailyLeaveSlots.UpdateOne(ctx, withEntry(bson.M{"pool_id": nil}), shared)
+	if err != nil {
+		return err
+	}
+
+	// Nothing was confirmed for the leave on this day; the rest of the
+	// update still applies.
+	if result.MatchedCount == 0 {
+		_, err = r.collectionDailyLeaveSlots.UpdateOne(ctx, slotFilter(leaveItem.OrganizationID, date), pulled)
+		if err != nil {
+			return err
+		}
+	}
+
+	return r.syncAvailableSlot(ctx, leaveItem.OrganizationID, date)
+
+}
+
+// rollbackCreateLeave undoes a partially booked range so a failure on one
+// day never leaves the other days reserved.
+func (r *leaveRepository) rollbackCreateLeave(ctx context.Context, leaveItem *LeaveRequests, bookedDates []time.Time) {
+
+	for _, date := range bookedDates {
+		r.releaseDailyLeaveSlot(ctx, date, leaveItem)
+	}
+
+	r.collectionLeave.DeleteOne(ctx, bson.M{"_id": leaveItem.ID})
+
+}
+
+func (r *leaveRepository) pushPendingRequest(ctx context.Context, date time.Time, leaveItem *LeaveRequests) error {
+	return r.pushPendingEntry(ctx, leaveItem.OrganizationID, date, pendingEntry(leaveItem))
+}
+
+func (r *leaveRepository) pushPendingEntry(ctx context.Context, organizationID string, date time.Time, entry PendingRequest) error {
+
+	filter := slotFilter(organizationID, date)
+	update := bson.M{
+		"$push": bson.M{"pending_requests": entry},
+	}
+
+	result, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	// The day may have been cleaned up after its last leave was released
+	// between creating the slot and queueing on it.
+	if result.MatchedCount == 0 {
+		_, err = r.getDailyLeaveSlots(ctx, organizationID, date.Format("2006-01-02"))
+		if err != nil {
+			return err
+		}
+
+		_, err = r.collectionDailyLeaveSlots.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) releaseDailyLeaveSlot(ctx context.Context, date time.Time, leaveItem *LeaveRequests) error {
+
+	if !leaveItem.LeavePolicy().ConsumesSlot {
+		return nil
+	}
+
+	filter := slotFilter(leaveItem.OrganizationID, date)
+
+	// A user can hold a morning and an afternoon on the same day, so entries
+	// are matched by leave ID; entries written before IDs were stored fall
+	// back to the user ID.
+	entryFilter := bson.M{
+		"$or": []bson.M{
+			{"leave_id": leaveItem.ID},
+			{"user_id": leaveItem.UserID, "leave_id": bson.M{"$exists": false}},
+		},
+	}
+
+	if leaveItem.RequestType == "immediate" {
+
+		err := r.pullConfirmedEntry(ctx, leaveItem, date, entryFilter, nil)
+		if err != nil {
+			return err
+		}
+
+	} else {
+
+		_, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, filter, bson.M{
+			"$pull": bson.M{
+				"pending_requests": entryFilter,
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Only an empty day is removed; the size conditions make the delete a
+	// no-op if another booking landed on it in the meantime. A day whose
+	// cap an admin set by hand is kept so the override survives.
+	_, err := r.collectionDailyLeaveSlots.DeleteOne(ctx, bson.M{
+		"organization_id":  leaveItem.OrganizationID,
+		"date":             date,
+		"confirmed_leaves": bson.M{"$size": 0},
+		"pending_requests": bson.M{"$size": 0},
+		"manual_override":  bson.M{"$ne": true},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) checkRequestExists(ctx context.Context, leaveItem *LeaveRequests) (bool, string) {
+
+	dates := bson.M{"$in": leaveItem.Dates()}
+
+	// A morning only clashes with another morning or a full day. Entries
+	// without a portion predate half days and count as full days.
+	overlapping := bson.A{PortionFull, "", nil}
+	switch leaveItem.Portion {
+	case PortionAM:
+		overlapping = append(overlapping, PortionAM)
+	case PortionPM:
+		overlapping = append(overlapping, PortionPM)
+	default:
+		overlapping = append(overlapping, PortionAM, PortionPM)
+	}
+
+	// The request never clashes with itself, which matters when it is
+	// moved onto days that overlap its current ones.
+	entry := bson.M{
+		"$elemMatch": bson.M{
+			"leave_id": bson.M{"$ne": leaveItem.ID},
+			"user_id":  leaveItem.UserID,
+			"portion":  bson.M{"$in": overlapping},
+		},
+	}
+
+	filterConfirmed := bson.M{
+		"organization_id":  leaveItem.OrganizationID,
+		"date":             dates,
+		"confirmed_leaves": entry,
+	}
+
+	filterPending := bson.M{
+		"organization_id":  leaveItem.OrganizationID,
+		"date":             dates,
+		"pending_requests": entry,
+	}
+
+	countConfirmed, err := r.collectionDailyLeaveSlots.CountDocuments(ctx, filterConfirmed)
+	if err != nil {
+		return false, ""
+	}
+	if countConfirmed > 0 {
+		return true, "User has successfully registered for leave"
+	}
+
+	countPending, err := r.collectionDailyLeaveSlots.CountDocuments(ctx, filterPending)
+	if err != nil {
+		return false, ""
+	}
+	if countPending > 0 {
+		return true, "User has pending leave request"
+	}
+
+	// Leave types that skip the daily slots are only recorded on the
+	// request itself.
+	filterUntracked := bson.M{
+		"_id":                  bson.M{"$ne": leaveItem.ID},
+		"organization_id":      leaveItem.OrganizationID,
+		"user_id":              leaveItem.UserID,
+		"leave_dates":          dates,
+		"portion":              bson.M{"$in": overlapping},
+		"policy.consumes_slot": false,
+		"status":               bson.M{"$in": bson.A{"confirmed", "pending"}},
+	}
+
+	countUntracked, err := r.collectionLeave.CountDocuments(ctx, filterUntracked)
+	if err != nil {
+		return false, ""
+	}
+	if countUntracked > 0 {
+		return true, "User already has leave on this day"
+	}
+
+	return false, ""
+}
+
+func (r *leaveRepository) GetDailyLeaveSlots(ctx context.Context, organizationID string, date *time.Time) ([]*DailyLeaveSolt, error) {
+
+	var dailyLeaveSlots []*DailyLeaveSolt
+
+	filter := bson.M{"organization_id": organizationID}
+	if date != nil {
+		start := date.AddDate(0, 0, -30)
+		end := date.AddDate(0, 0, 30)
+
+		filter["date"] = bson.M{
+			"$gte": start,
+			"$lte": end,
+		}
+
+	}
+
+	cursor, err := r.collectionDailyLeaveSlots.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &dailyLeaveSlots); err != nil {
+		return nil, err
+	}
+
+	return dailyLeaveSlots, nil
+
+}
+
+func (r *leaveRepository) GetDetailLeaveSlots(ctx context.Context, organizationID string, id primitive.ObjectID) (*DailyLeaveSolt, error) {
+
+	var dailyLeaveSlot *DailyLeaveSolt
+
+	filter := bson.M{"_id": id, "organization_id": organizationID}
+	err := r.collectionDailyLeaveSlots.FindOne(ctx, filter).Decode(&dailyLeaveSlot)
+	if err != nil {
+		return nil, err
+	}
+
+	return dailyLeaveSlot, nil
+
+}
+
+func (r *leaveRepository) GetMyRequest(ctx context.Context, organizationID string, userID string) ([]*LeaveRequests, error) {
+
+	var leaveRequests []*LeaveRequests
+
+	filter := bson.M{"organization_id": organizationID, "user_id": userID}
+	cursor, err := r.collectionLeave.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &leaveRequests); err != nil {
+		return nil, err
+	}
+
+	return leaveRequests, nil
+
+}
+
+func (r *leaveRepository) EditMaxSlot(ctx context.Context, maxSlot int, availableAMSlot int, availablePMSlot int, id primitive.ObjectID) error {
+
+	filter := bson.M{"_id": id}
+	update := bson.M{
+		"$set": bson.M{
+			"max_slot":          maxSlot,
+			"available_slot":    min(availableAMSlot, availablePMSlot),
+			"available_am_slot": availableAMSlot,
+			"available_pm_slot": availablePMSlot,
+			"manual_override":   true,
+		},
+	}
+
+	_, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) GetPendingRequest(ctx context.Context, organizationID string) ([]*LeaveRequests, error) {
+
+	var leaveRequests []*LeaveRequests
+
+	filter := bson.M{"organization_id": organizationID, "status": "pending"}
+
+	cursor, err := r.collectionLeave.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &leaveRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaveRequests, nil
+
+}
+
+func (r *leaveRepository) GetLeaveByDate(ctx context.Context, organizationID string, date *time.Time, userID string) (*LeaveRequests, error) {
+
+	var leaveItem LeaveRequests
+
+	// Any day of a range identifies the whole request.
+	leaveFilter := bson.M{
+		"organization_id": organizationID,
+		"user_id":         userID,
+		"status":          bson.M{"$in": bson.A{"pending", "confirmed"}},
+		"$or": []bson.M{
+			{"leave_date": date},
+			{"leave_dates": date},
+		},
+	}
+
+	err := r.collectionLeave.FindOne(ctx, leaveFilter).Decode(&leaveItem)
+	if err != nil {
+		return nil, err
+	}
+
+	return &leaveItem, nil
+
+}
+
+// GetLeavesByDate returns every open request of the user that covers the
+// date; a morning and an afternoon request can share one.
+func (r *leaveRepository) GetLeavesByDate(ctx context.Context, organizationID string, date *time.Time, userID string) ([]*LeaveRequests, error) {
+
+	var leaveRequests []*LeaveRequests
+
+	cursor, err := r.collectionLeave.Find(ctx, bson.M{
+		"organization_id": organizationID,
+		"user_id":         userID,
+		"status":          bson.M{"$in": bson.A{"pending", "confirmed"}},
+		"$or": []bson.M{
+			{"leave_date": date},
+			{"leave_dates": date},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &leaveRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaveRequests, nil
+
+}
+
+func (r *leaveRepository) GetLeaveByID(ctx context.Context, organizationID string, id primitive.ObjectID) (*LeaveRequests, error) {
+
+	var leaveItem LeaveRequests
+
+	err := r.collectionLeave.FindOne(ctx, bson.M{"_id": id, "organization_id": organizationID}).Decode(&leaveItem)
+	if err != nil {
+		return nil, err
+	}
+
+	return &leaveItem, nil
+
+}
+
+func (r *leaveRepository) ApproveLeave(ctx context.Context, leaveItem *LeaveRequests, review *LeaveReview) error {
+
+	policy := leaveItem.LeavePolicy()
+	previousRequestType := leaveItem.RequestType
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":    leaveItem.ID,
+		"status": "pending",
+	}, bson.M{
+		"$set": bson.M{
+			"status":       "confirmed",
+			"request_type": "immediate",
+			"review":       review,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return fmt.Errorf("leave request is no longer pending")
+	}
+
+	leaveItem.Status = "confirmed"
+	leaveItem.RequestType = "immediate"
+	leaveItem.Review = review
+
+	if !policy.ConsumesSlot {
+		return nil
+	}
+
+	if !review.CapacityOverridden {
+		reserved, err := r.reserveDailyLeaveSlots(ctx, leaveItem, true, nil)
+		if err != nil || !reserved {
+			r.collectionLeave.UpdateOne(ctx, bson.M{"_id": leaveItem.ID}, bson.M{
+				"$set":   bson.M{"status": "pending", "request_type": previousRequestType},
+				"$unset": bson.M{"review": ""},
+			})
+			if err != nil {
+				return err
+			}
+			return fmt.Errorf("no capacity left for %s", formatLeaveRange(leaveItem))
+		}
+		return nil
+	}
+
+	for _, date := range leaveItem.Dates() {
+
+		reserved, err := r.reserveDailyLeaveSlot(ctx, date, leaveItem, true, nil)
+		if err != nil {
+			return err
+		}
+
+		if reserved {
+			continue
+		}
+
+		// Overriding a full day raises its capacity by one person instead of
+		// driving the counters negative.
+		inc := bson.M{"max_slot": 1}
+		switch leaveItem.Portion {
+		case PortionAM:
+			inc["available_pm_slot"] = 1
+		case PortionPM:
+			inc["available_am_slot"] = 1
+		}
+
+		// The extra place is the day's own, so the entry is not pooled even
+		// when the requester is.
+		entry := confirmedEntry(leaveItem, review.ReviewedAt)
+		entry.PoolID = nil
+
+		update := bson.M{
+			"$inc":  inc,
+			"$pull": bson.M{"pending_requests": bson.M{"leave_id": leaveItem.ID}},
+			"$push": bson.M{"confirmed_leaves": entry},
+		}
+
+		_, err = r.collectionDailyLeaveSlots.UpdateOne(ctx, slotFilter(leaveItem.OrganizationID, date), update)
+		if err != nil {
+			return err
+		}
+
+		err = r.syncAvailableSlot(ctx, leaveItem.OrganizationID, date)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) RejectLeave(ctx context.Context, leaveItem *LeaveRequests, review *LeaveReview) error {
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":    leaveItem.ID,
+		"status": "pending",
+	}, bson.M{
+		"$set": bson.M{
+			"status": "rejected",
+			"review": review,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return fmt.Errorf("leave request is no longer pending")
+	}
+
+	for _, date := range leaveItem.Dates() {
+		err = r.releaseDailyLeaveSlot(ctx, date, leaveItem)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+	}
+
+	leaveItem.Status = "rejected"
+	leaveItem.Review = review
+
+	return nil
+
+}
+
+func (r *leaveRepository) GetAllLeaveBalance(ctx context.Context) ([]*UserLeaveBalance, error) {
+
+	var userLeaveBalances []*UserLeaveBalance
+
+	cursor, err := r.collectionLeaveBalance.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &userLeaveBalances)
+	if err != nil {
+		return nil, err
+	}
+
+	if userLeaveBalances == nil {
+		return []*UserLeaveBalance{}, nil
+	}
+
+	return userLeaveBalances, nil
+
+}
+
+func (r *leaveRepository) CreateLeaveBalance(ctx context.Context, leaveBalance []*UserLeaveBalance) error {
+
+	docs := make([]interface{}, len(leaveBalance))
+	for i, leave := range leaveBalance {
+		docs[i] = leave
+	}
+
+	_, err := r.collectionLeaveBalance.InsertMany(ctx, docs)
+	if err != nil {
+		return err
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) GetLeaveBalance(ctx context.Context, organizationID string, userID string, year int) (*UserLeaveBalance, error) {
+
+	filter := bson.M{"organization_id": organizationID, "user_id": userID, "year": year}
 
-	var setting Setting
+	update := bson.M{
+		"$setOnInsert": bson.M{
+			"_id":                  primitive.NewObjectID(),
+			"total_leave_banlance": 0.0,
+			"used_leave":           0.0,
+			"remainding_leave":     0.0,
+			"accrued_months":       0,
+			"last_updated":         time.Now(),
+		},
+	}
 
-	err := r.collectionLeaveSetting.FindOne(ctx, bson.M{}).Decode(&setting)
-	if err == mongo.ErrNoDocuments {
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
 
-		setting = Setting{
-			ID:                 primitive.NewObjectID(),
-			MaxEmployeesPerDay: 5,
-			AdvanceBookingDays: 7,
-			CreatedAt:          time.Now(),
-			UpdatedAt:          time.Now(),
-		}
+	var leaveBalance UserLeaveBalance
 
-		r.collectionLeaveSetting.InsertOne(ctx, setting)
+	err := r.collectionLeaveBalance.FindOneAndUpdate(ctx, filter, update, opts).Decode(&leaveBalance)
+	if err != nil {
+		return nil, err
 	}
 
-	return &setting
+	return &leaveBalance, nil
+
 }
 
-func (r *leaveRepository) UpdateSetting(ctx context.Context, setting *Setting, id primitive.ObjectID) (*Setting, error) {
+func (r *leaveRepository) AccrueLeaveBalance(ctx context.Context, organizationID string, userID string, year int, fromMonth int, toMonth int, amount float64) (bool, error) {
 
-	filter := bson.M{"_id": id}
+	// Matching on accrued_months makes concurrent accruals for the same
+	// months a no-op instead of crediting them twice.
+	filter := bson.M{"organization_id": organizationID, "user_id": userID, "year": year, "accrued_months": fromMonth}
 
 	update := bson.M{
 		"$set": bson.M{
-			"max_employees_per_day": setting.MaxEmployeesPerDay,
-			"advance_booking_days":  setting.AdvanceBookingDays,
-			"updated_at":            time.Now(),
+			"accrued_months": toMonth,
+			"last_updated":   time.Now(),
+		},
+		"$inc": bson.M{
+			"total_leave_banlance": amount,
+			"remainding_leave":     amount,
 		},
 	}
 
-	_, err := r.collectionLeaveSetting.UpdateOne(ctx, filter, update)
+	result, err := r.collectionLeaveBalance.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	var settingUpdated Setting
+	return result.ModifiedCount > 0, nil
 
-	err = r.collectionLeaveSetting.FindOne(ctx, filter).Decode(&settingUpdated)
-	if err != nil {
-		return nil, err
-	}
+}
 
-	return &settingUpdated, nil
+func (r *leaveRepository) UpdateLeaveBalance(ctx context.Context, organizationID string, userID string, year int, earned float64, used float64) error {
 
-}
+	filter := bson.M{"organization_id": organizationID, "user_id": userID, "year": year}
 
-func (r *leaveRepository) getDailyLeaveSlots(ctx context.Context, date string) *DailyLeaveSolt {
+	update := bson.M{
+		"$set": bson.M{
+			"last_updated": time.Now(),
+		},
+		"$inc": bson.M{
+			"total_leave_banlance": earned,
+			"used_leave":           used,
+			"remainding_leave":     earned - used,
+		},
+	}
 
-	dateParse, _ := time.Parse("2006-01-02", date)
+	_, err := r.collectionLeaveBalance.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
 
-	filter := bson.M{"date": dateParse}
+	return nil
 
-	var dailyLeaveSlot DailyLeaveSolt
+}
 
-	err := r.collectionDailyLeaveSlots.FindOne(ctx, filter).Decode(&dailyLeaveSlot)
+func (r *leaveRepository) CreateLeaveTransaction(ctx context.Context, transactions []*LeaveTransaction) error {
 
-	if mongo.ErrNoDocuments == err {
-		setting := r.GetSettings(ctx)
-		dailyLeaveSlot = DailyLeaveSolt{
-			ID:              primitive.NewObjectID(),
-			MaxSlot:         setting.MaxEmployeesPerDay,
-			AvailableSlot:   setting.MaxEmployeesPerDay,
-			Date:            dateParse,
-			ConfirmedLeaves: []ConfirmedLeave{},
-			PendingRequests: []PendingRequest{},
-			CreatedAt:       time.Now(),
-			UpdatedAt:       time.Now(),
-		}
+	docs := make([]interface{}, len(transactions))
+	for i, transaction := range transactions {
+		docs[i] = transaction
+	}
 
-		r.collectionDailyLeaveSlots.InsertOne(ctx, dailyLeaveSlot)
+	_, err := r.collectionLeaveTransaction.InsertMany(ctx, docs)
+	if err != nil {
+		return err
 	}
 
-	return &dailyLeaveSlot
-}
+	return nil
 
-func (r *leaveRepository) CreateLeave(ctx context.Context, leaveItem *LeaveRequests) error {
+}
 
-	dailyLeaveSlot := r.getDailyLeaveSlots(ctx, leaveItem.LeaveDate.Format("2006-01-02"))
+func (r *leaveRepository) GetLeaveTransactions(ctx context.Context, organizationID string, userID string, year int) ([]*LeaveTransaction, error) {
 
-	requestType := "immediate"
-	status := "confirmed"
+	var transactions []*LeaveTransaction
 
-	if dailyLeaveSlot.AvailableSlot <= 0 {
-		requestType = "wishlist"
-		status = "pending"
-	}
+	filter := bson.M{"organization_id": organizationID, "user_id": userID, "year": year}
 
-	leaveItem = &LeaveRequests{
-		ID:          primitive.NewObjectID(),
-		LeaveDate:   leaveItem.LeaveDate,
-		UserID:      leaveItem.UserID,
-		UserName:    leaveItem.UserName,
-		Reason:      leaveItem.Reason,
-		RequestedAt: leaveItem.RequestedAt,
-		Status:      status,
-		RequestType: requestType,
-	}
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "date", Value: 1}, {Key: "created_at", Value: 1}})
 
-	check, msg := r.checkRequestExists(ctx, leaveItem)
-	if check {
-		return fmt.Errorf("leave request exists: %s", msg)
+	cursor, err := r.collectionLeaveTransaction.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	_, err := r.collectionLeave.InsertOne(ctx, leaveItem)
+	err = cursor.All(ctx, &transactions)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = r.updateDailyLeaveSlots(ctx, leaveItem.LeaveDate.Format("2006-01-02"), leaveItem)
-	if err != nil {
-		return err
+	if transactions == nil {
+		return []*LeaveTransaction{}, nil
 	}
 
-	return nil
+	return transactions, nil
 
 }
 
-func (r *leaveRepository) updateDailyLeaveSlots(ctx context.Context, date string, leaveItem *LeaveRequests) error {
+// GetOrganizationIDs lists the organizations that have leave settings or
+// requests.
+func (r *leaveRepository) GetOrganizationIDs(ctx context.Context) ([]string, error) {
 
-	dateParse, _ := time.Parse("2006-01-02", date)
+	seen := make(map[string]bool)
+	var organizationIDs []string
 
-	if leaveItem.RequestType == "immediate" {
+	for _, collection := range []*mongo.Collection{r.collectionLeaveSetting, r.collectionLeave} {
 
-		confirmedLeave := ConfirmedLeave{
-			UserID:    leaveItem.UserID,
-			UserName:  leaveItem.UserName,
-			ApproveAt: time.Now(),
+		values, err := collection.Distinct(ctx, "organization_id", bson.M{})
+		if err != nil {
+			return nil, err
 		}
 
-		filter := bson.M{"date": dateParse}
-		update := bson.M{
-			"$inc":  bson.M{"available_slot": -1},
-			"$push": bson.M{"confirmed_leaves": confirmedLeave},
+		for _, value := range values {
+			organizationID, ok := value.(string)
+			if !ok || organizationID == "" || seen[organizationID] {
+				continue
+			}
+			seen[organizationID] = true
+			organizationIDs = append(organizationIDs, organizationID)
 		}
+	}
 
-		_, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, filter, update)
-		if err != nil {
-			return err
-		}
-	} else {
+	return organizationIDs, nil
 
-		pendingRequest := PendingRequest{
-			LeaveID:   leaveItem.ID,
-			UserID:    leaveItem.UserID,
-			UserName:  leaveItem.UserName,
-			Status:    leaveItem.Status,
-			RequestAt: leaveItem.RequestedAt,
-		}
+}
 
-		filter := bson.M{"date": dateParse}
-		update := bson.M{
-			"$push": bson.M{"pending_requests": pendingRequest},
-		}
+// GetOrganizationUserIDs lists the users the organization has a start date
+// or leave requests for.
+func (r *leaveRepository) GetOrganizationUserIDs(ctx context.Context, organizationID string) ([]string, error) {
 
-		_, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, filter, update)
+	seen := make(map[string]bool)
+	var userIDs []string
+
+	for _, collection := range []*mongo.Collection{r.collectionEmployment, r.collectionLeave} {
+
+		values, err := collection.Distinct(ctx, "user_id", bson.M{"organization_id": organizationID})
 		if err != nil {
-			return err
+			return nil, err
 		}
 
+		for _, value := range values {
+			userID, ok := value.(string)
+			if !ok || userID == "" || seen[userID] {
+				continue
+			}
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
 	}
 
-	return nil
+	return userIDs, nil
 
 }
 
-func (r *leaveRepository) checkRequestExists(ctx context.Context, leaveItem *LeaveRequests) (bool, string) {
+// PromoteWishlist confirms wait-listed requests on the given day, oldest
+// first, while capacity remains. A request that does not fit on every day of
+// its range is skipped so a later, smaller request can still take the slot.
+func (r *leaveRepository) PromoteWishlist(ctx context.Context, organizationID string, date time.Time) ([]*LeaveRequests, error) {
 
-	filterConfirmed := bson.M{
-		"date":                     leaveItem.LeaveDate,
-		"confirmed_leaves.user_id": leaveItem.UserID,
-	}
+	var dailyLeaveSlot DailyLeaveSolt
 
-	filterPending := bson.M{
-		"date":                     leaveItem.LeaveDate,
-		"pending_requests.user_id": leaveItem.UserID,
+	err := r.collectionDailyLeaveSlots.FindOne(ctx, slotFilter(organizationID, date)).Decode(&dailyLeaveSlot)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
 	}
-
-	countConfirmed, err := r.collectionDailyLeaveSlots.CountDocuments(ctx, filterConfirmed)
 	if err != nil {
-		return false, ""
-	}
-	if countConfirmed > 0 {
-		return true, "User has successfully registered for leave"
+		return nil, err
 	}
 
-	countPending, err := r.collectionDailyLeaveSlots.CountDocuments(ctx, filterPending)
-	if err != nil {
-		return false, ""
-	}
-	if countPending > 0 {
-		return true, "User has pending leave request"
-	}
+	pendingRequests := dailyLeaveSlot.PendingRequests
+	sort.SliceStable(pendingRequests, func(i, j int) bool {
+		return pendingRequests[i].RequestAt.Before(pendingRequests[j].RequestAt)
+	})
 
-	return false, ""
-}
+	var promoted []*LeaveRequests
 
-func (r *leaveRepository) GetDailyLeaveSlots(ctx context.Context, date *time.Time) ([]*DailyLeaveSolt, error) {
+	for _, pending := range pendingRequests {
 
-	var dailyLeaveSlots []*DailyLeaveSolt
+		var leaveItem LeaveRequests
 
-	filter := bson.M{}
-	if date != nil {
-		start := date.AddDate(0, 0, -30)
-		end := date.AddDate(0, 0, 30)
+		err := r.collectionLeave.FindOne(ctx, bson.M{"_id": pending.LeaveID}).Decode(&leaveItem)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return promoted, err
+		}
 
-		filter["date"] = bson.M{
-			"$gte": start,
-			"$lte": end,
+		if leaveItem.RequestType != "wishlist" || leaveItem.Status != "pending" {
+			continue
 		}
 
-	}
+		fits, err := r.fitsAllDates(ctx, &leaveItem)
+		if err != nil {
+			return promoted, err
+		}
+		if !fits {
+			continue
+		}
 
-	cursor, err := r.collectionDailyLeaveSlots.Find(ctx, filter)
-	if err != nil {
-		return nil, err
-	}
-	defer cursor.Close(ctx)
+		ok, err := r.promoteLeave(ctx, &leaveItem)
+		if err != nil {
+			return promoted, err
+		}
 
-	if err := cursor.All(ctx, &dailyLeaveSlots); err != nil {
-		return nil, err
+		if ok {
+			promoted = append(promoted, &leaveItem)
+		}
 	}
 
-	return dailyLeaveSlots, nil
+	return promoted, nil
 
 }
 
-func (r *leaveRepository) GetDetailLeaveSlots(ctx context.Context, id primitive.ObjectID) (*DailyLeaveSolt, error) {
+// ReturnToWishlist undoes a promotion whose follow-up failed. The request
+// waits on every day again and keeps its place, since its queue entries
+// carry the original request time.
+func (r *leaveRepository) ReturnToWishlist(ctx context.Context, leaveItem *LeaveRequests) error {
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":          leaveItem.ID,
+		"status":       "confirmed",
+		"request_type": "immediate",
+	}, bson.M{
+		"$set":   bson.M{"status": "pending", "request_type": "wishlist"},
+		"$unset": bson.M{"promoted_at": ""},
+	})
+	if err != nil {
+		return err
+	}
 
-	var dailyLeaveSlot *DailyLeaveSolt
+	if result.ModifiedCount == 0 {
+		return errLeaveChanged
+	}
 
-	filter := bson.M{"_id": id}
-	err := r.collectionDailyLeaveSlots.FindOne(ctx, filter).Decode(&dailyLeaveSlot)
-	if err != nil {
-		return nil, err
+	for _, date := range leaveItem.Dates() {
+		err = r.unreserveDailyLeaveSlot(ctx, date, leaveItem, true)
+		if err != nil {
+			return err
+		}
 	}
 
-	return dailyLeaveSlot, nil
+	leaveItem.Status = "pending"
+	leaveItem.RequestType = "wishlist"
+	leaveItem.PromotedAt = nil
 
-}
+	return nil
 
-func (r *leaveRepository) GetMyRequest(ctx context.Context, userID string) ([]*LeaveRequests, error) {
+}
 
-	var leaveRequests []*LeaveRequests
+func (r *leaveRepository) fitsAllDates(ctx context.Context, leaveItem *LeaveRequests) (bool, error) {
 
-	filter := bson.M{"user_id": userID}
-	cursor, err := r.collectionLeave.Find(ctx, filter)
+	pool, err := r.getActivePool(ctx, leaveItem)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	defer cursor.Close(ctx)
 
-	if err := cursor.All(ctx, &leaveRequests); err != nil {
-		return nil, err
+	for _, date := range leaveItem.Dates() {
+		dailyLeaveSlot, err := r.getDailyLeaveSlots(ctx, leaveItem.OrganizationID, date.Format("2006-01-02"))
+		if err != nil {
+			return false, err
+		}
+		if pool == nil && !hasCapacity(dailyLeaveSlot, leaveItem.Portion) {
+			return false, nil
+		}
+		if pool != nil && (dailyLeaveSlot.IsHoliday || !poolHasCapacity(dailyLeaveSlot, pool, leaveItem.Portion)) {
+			return false, nil
+		}
 	}
 
-	return leaveRequests, nil
+	return true, nil
 
 }
 
-func (r *leaveRepository) EditMaxSlot(ctx context.Context, maxSlot int, availableSlot int, id primitive.ObjectID) error {
+func (r *leaveRepository) promoteLeave(ctx context.Context, leaveItem *LeaveRequests) (bool, error) {
 
-	filter := bson.M{"_id": id}
-	update := bson.M{
+	now := time.Now()
+
+	// Claiming the request first means two concurrent promotions cannot
+	// both move it into the confirmed list.
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":          leaveItem.ID,
+		"status":       "pending",
+		"request_type": "wishlist",
+	}, bson.M{
 		"$set": bson.M{
-			"max_slot":       maxSlot,
-			"available_slot": availableSlot,
+			"status":       "confirmed",
+			"request_type": "immediate",
+			"promoted_at":  now,
 		},
+	})
+	if err != nil {
+		return false, err
 	}
 
-	_, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return err
+	if result.ModifiedCount == 0 {
+		return false, nil
 	}
 
-	return nil
+	promotion := Promotion{
+		LeaveID:    leaveItem.ID,
+		UserID:     leaveItem.UserID,
+		UserName:   leaveItem.UserName,
+		RequestAt:  leaveItem.RequestedAt,
+		PromotedAt: now,
+	}
 
-}
+	reserved, err := r.reserveDailyLeaveSlots(ctx, leaveItem, true, &promotion)
+	if err != nil || !reserved {
+		// Someone else took the capacity first; put the request back.
+		r.collectionLeave.UpdateOne(ctx, bson.M{"_id": leaveItem.ID}, bson.M{
+			"$set":   bson.M{"status": "pending", "request_type": "wishlist"},
+			"$unset": bson.M{"promoted_at": ""},
+		})
+		return false, err
+	}
 
-func (r *leaveRepository) GetPendingRequest(ctx context.Context) ([]*LeaveRequests, error) {
+	leaveItem.Status = "confirmed"
+	leaveItem.RequestType = "immediate"
+	leaveItem.PromotedAt = &now
 
-	var leaveRequests []*LeaveRequests
+	return true, nil
+
+}
 
-	filter := bson.M{"status": "pending"}
+func (r *leaveRepository) GetLeaveTypes(ctx context.Context, organizationID string) ([]*LeaveType, error) {
 
-	cursor, err := r.collectionLeave.Find(ctx, filter)
+	leaveTypes, err := r.findLeaveTypes(ctx, organizationID)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(ctx)
 
-	err = cursor.All(ctx, &leaveRequests)
-	if err != nil {
+	// Seed the defaults, including any added after the organization was
+	// first seeded.
+	existing := make(map[string]bool, len(leaveTypes))
+	for _, leaveType := range leaveTypes {
+		existing[leaveType.Code] = true
+	}
+
+	var models []mongo.WriteModel
+	for _, leaveType := range defaultLeaveTypes(organizationID) {
+		if !existing[leaveType.Code] {
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"organization_id": organizationID, "code": leaveType.Code}).
+				SetUpdate(bson.M{"$setOnInsert": leaveType}).
+				SetUpsert(true))
+		}
+	}
+
+	if len(models) == 0 {
+		return leaveTypes, nil
+	}
+
+	// Upserts let two first reads seed at the same time without either
+	// failing or adding a second copy; reading back returns whichever copy
+	// was stored.
+	_, err = r.collectionLeaveType.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
 		return nil, err
 	}
 
-	return leaveRequests, nil
+	return r.findLeaveTypes(ctx, organizationID)
 
 }
 
-func (r *leaveRepository) DeleteRequestLeave(ctx context.Context, date *time.Time, userID string) error {
+func (r *leaveRepository) findLeaveTypes(ctx context.Context, organizationID string) ([]*LeaveType, error) {
 
-	var leaveRequests LeaveRequests
-	var dailyLeaveSlot DailyLeaveSolt
-	leaveFilter := bson.M{"leave_date": date, "user_id": userID}
+	var leaveTypes []*LeaveType
 
-	err := r.collectionLeave.FindOneAndDelete(ctx, leaveFilter).Decode(&leaveRequests)
+	findOptions := options.Find()
+	findOptions.SetSort(bson.M{"code": 1})
+
+	cursor, err := r.collectionLeaveType.Find(ctx, bson.M{"organization_id": organizationID}, findOptions)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer cursor.Close(ctx)
 
-	slotFilter := bson.M{"date": date}
-	var update bson.M
+	err = cursor.All(ctx, &leaveTypes)
+	if err != nil {
+		return nil, err
+	}
 
-	if leaveRequests.RequestType == "immediate" {
+	return leaveTypes, nil
 
-		update = bson.M{
-			"$inc": bson.M{"available_slot": 1},
-			"$pull": bson.M{
-				"confirmed_leaves": bson.M{"user_id": userID},
-			},
-		}
+}
 
-	} else {
+func defaultLeaveTypes(organizationID string) []*LeaveType {
 
-		update = bson.M{
-			"$pull": bson.M{
-				"pending_requests": bson.M{"user_id": userID},
-			},
+	now := time.Now()
+
+	newLeaveType := func(code string, name string, policy LeavePolicy) *LeaveType {
+		return &LeaveType{
+			ID:             primitive.NewObjectID(),
+			OrganizationID: organizationID,
+			Code:           code,
+			Name:           name,
+			Policy:         policy,
+			IsActive:       true,
+			CreatedAt:      now,
+			UpdatedAt:      now,
 		}
+	}
 
+	systemType := func(leaveType *LeaveType) *LeaveType {
+		leaveType.System = true
+		return leaveType
 	}
 
-	_, err = r.collectionDailyLeaveSlots.UpdateOne(ctx, slotFilter, update)
-	if err != nil {
-		return err
+	// Sick and bereavement leave cannot be planned, so they skip the
+	// notice period; sick leave may also be recorded after the fact.
+	// Maternity leave runs for months, so it is not held to the booking
+	// horizon.
+	noNotice := 0
+	noHorizon := 0
+
+	return []*LeaveType{
+		newLeaveType(LeaveTypeAnnual, "Annual leave", LeavePolicy{ConsumesSlot: true, DeductsBalance: true}),
+		// It takes no balance, so a manager confirms each request instead.
+		newLeaveType(LeaveTypeBereavement, "Bereavement leave", LeavePolicy{
+			RequiresApproval: true,
+			BookingWindow:    &BookingWindowException{AdvanceBookingDays: &noNotice},
+		}),
+		systemType(newLeaveType(LeaveTypeClosure, "Organization closure", LeavePolicy{})),
+		newLeaveType(LeaveTypeCompOff, "Compensatory leave", LeavePolicy{ConsumesSlot: true, UsesCompOff: true}),
+		newLeaveType(LeaveTypeMaternity, "Maternity leave", LeavePolicy{
+			RequiresApproval:   true,
+			RequiresAttachment: true,
+			BookingWindow:      &BookingWindowException{MaxBookingDays: &noHorizon},
+		}),
+		newLeaveType(LeaveTypeSick, "Sick leave", LeavePolicy{
+			RequiresApproval:   true,
+			RequiresAttachment: true,
+			BookingWindow:      &BookingWindowException{AdvanceBookingDays: &noNotice, AllowPastDates: true},
+		}),
+		newLeaveType(LeaveTypeUnpaid, "Unpaid leave", LeavePolicy{ConsumesSlot: true, RequiresApproval: true}),
 	}
 
-	err = r.collectionDailyLeaveSlots.FindOne(ctx, slotFilter).Decode(&dailyLeaveSlot)
+}
+
+func (r *leaveRepository) GetLeaveTypeByCode(ctx context.Context, organizationID string, code string) (*LeaveType, error) {
+
+	leaveTypes, err := r.GetLeaveTypes(ctx, organizationID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if len(dailyLeaveSlot.ConfirmedLeaves) == 0 && len(dailyLeaveSlot.PendingRequests) == 0 {
-		_, err := r.collectionDailyLeaveSlots.DeleteOne(ctx, slotFilter)
-		if err != nil {
-			return err
+	for _, leaveType := range leaveTypes {
+		if leaveType.Code == code {
+			return leaveType, nil
 		}
 	}
 
-	return nil
+	return nil, fmt.Errorf("leave type %s not found", code)
 
 }
 
-func (r *leaveRepository) UpdateRequestLeave(ctx context.Context, types string, id primitive.ObjectID) error {
+func (r *leaveRepository) CreateLeaveType(ctx context.Context, leaveType *LeaveType) error {
 
-	filter := bson.M{"_id": id, "request_type": "wishlist"}
-	update := bson.M{"$set": bson.M{"status": types}}
-
-	_, err := r.collectionLeave.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return err
+	_, err := r.collectionLeaveType.InsertOne(ctx, leaveType)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("leave type %s already exists", leaveType.Code)
 	}
 
-	return nil
+	return err
 
 }
 
-func (r *leaveRepository) GetAllLeaves(ctx context.Context, dateFrom *time.Time, dateTo *time.Time) ([]*LeaveRequests, error) {
+func (r *leaveRepository) UpdateLeaveType(ctx context.Context, leaveType *LeaveType, id primitive.ObjectID) (*LeaveType, error) {
 
-	var leaveRequests []*LeaveRequests
-
-	filter := bson.M{"leave_date": bson.M{"$gte": *dateFrom, "$lte": *dateTo}}
+	filter := bson.M{"_id": id, "organization_id": leaveType.OrganizationID}
 
-	cursor, err := r.collectionLeave.Find(ctx, filter)
-	if err != nil {
-		return nil, err
+	update := bson.M{
+		"$set": bson.M{
+			"name":       leaveType.Name,
+			"policy":     leaveType.Policy,
+			"is_active":  leaveType.IsActive,
+			"updated_at": time.Now(),
+		},
 	}
-	defer cursor.Close(ctx)
 
-	err = cursor.All(ctx, &leaveRequests)
+	var leaveTypeUpdated LeaveType
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := r.collectionLeaveType.FindOneAndUpdate(ctx, filter, update, opts).Decode(&leaveTypeUpdated)
 	if err != nil {
 		return nil, err
 	}
 
-	return leaveRequests, nil
+	return &leaveTypeUpdated, nil
 
 }
 
-func (r *leaveRepository) GetAllLeaveBalance(ctx context.Context) ([]*UserLeaveBalance, error) {
+// SyncHolidaySlots brings the holiday flag of every slot from the given day
+// on in line with the holiday calendar. A day that became a holiday is
+// closed to new bookings; leave already on it stays for an admin to settle.
+// A day that is no longer a holiday gets its capacity back and is returned,
+// so its wish list can be promoted.
+func (r *leaveRepository) SyncHolidaySlots(ctx context.Context, organizationID string, from time.Time) ([]time.Time, error) {
 
-	var userLeaveBalances []*UserLeaveBalance
+	if r.holidayCalendar == nil {
+		return nil, nil
+	}
 
-	cursor, err := r.collectionLeaveBalance.Find(ctx, bson.M{})
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "date", Value: 1}})
+
+	cursor, err := r.collectionDailyLeaveSlots.Find(ctx, bson.M{
+		"organization_id": organizationID,
+		"date":            bson.M{"$gte": from},
+	}, findOptions)
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	err = cursor.All(ctx, &userLeaveBalances)
+	var dailyLeaveSlots []*DailyLeaveSolt
+	if err := cursor.All(ctx, &dailyLeaveSlots); err != nil {
+		return nil, err
+	}
+
+	if len(dailyLeaveSlots) == 0 {
+		return nil, nil
+	}
+
+	holidays, err := r.holidayCalendar.GetHolidaysInRange(ctx, organizationID, dailyLeaveSlots[0].Date, dailyLeaveSlots[len(dailyLeaveSlots)-1].Date)
 	if err != nil {
 		return nil, err
 	}
 
-	if userLeaveBalances == nil {
-		return []*UserLeaveBalance{}, nil
+	setting, err := r.GetSettings(ctx, organizationID)
+	if err != nil {
+		return nil, err
 	}
 
-	return userLeaveBalances, nil
+	rules, err := r.GetCapacityRules(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
 
-}
+	var reopened []time.Time
+
+	for _, dailyLeaveSlot := range dailyLeaveSlots {
+
+		holidayName, isHoliday := holidays[dailyLeaveSlot.Date.Format("2006-01-02")]
+
+		if isHoliday {
+			if dailyLeaveSlot.IsHoliday && dailyLeaveSlot.HolidayName == holidayName {
+				continue
+			}
+
+			// max_slot is kept so a cap set by hand comes back if the
+			// holiday is removed again.
+			_, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, bson.M{"_id": dailyLeaveSlot.ID}, bson.M{
+				"$set": bson.M{
+					"is_holiday":        true,
+					"holiday_name":      holidayName,
+					"available_slot":    0,
+					"available_am_slot": 0,
+					"available_pm_slot": 0,
+					"updated_at":        time.Now(),
+				},
+			})
+			if err != nil {
+				return reopened, err
+			}
+			continue
+		}
 
-func (r *leaveRepository) CreateLeaveBalance(ctx context.Context, leaveBalance []*UserLeaveBalance) error {
+		if !dailyLeaveSlot.IsHoliday {
+			continue
+		}
 
-	docs := make([]interface{}, len(leaveBalance))
-	for i, leave := range leaveBalance {
-		docs[i] = leave
-	}
+		maxSlot := dailyLeaveSlot.MaxSlot
+		capacityRuleID := dailyLeaveSlot.CapacityRuleID
+		if !dailyLeaveSlot.ManualOverride {
+			var rule *CapacityRule
+			maxSlot, rule = resolveCapacity(setting, rules, dailyLeaveSlot.Date)
+			capacityRuleID = nil
+			if rule != nil {
+				capacityRuleID = &rule.ID
+			}
+		}
 
-	_, err := r.collectionLeaveBalance.InsertMany(ctx, docs)
-	if err != nil {
-		return err
+		usedAM := sharedUsage(PortionAM)
+		usedPM := sharedUsage(PortionPM)
+
+		_, err := r.collectionDailyLeaveSlots.UpdateOne(ctx, bson.M{"_id": dailyLeaveSlot.ID}, mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"is_holiday":        false,
+				"holiday_name":      "",
+				"max_slot":          maxSlot,
+				"capacity_rule_id":  capacityRuleID,
+				"available_am_slot": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{maxSlot, usedAM}}}},
+				"available_pm_slot": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{maxSlot, usedPM}}}},
+				"updated_at":        time.Now(),
+			}}},
+			{{Key: "$set", Value: bson.M{
+				"available_slot": bson.M{"$min": bson.A{"$available_am_slot", "$available_pm_slot"}},
+			}}},
+		})
+		if err != nil {
+			return reopened, err
+		}
+
+		reopened = append(reopened, dailyLeaveSlot.Date)
 	}
 
-	return nil
-	
-}
\ No newline at end of file
+	return reopened, nil
+
+}
@@ -0,0 +1,726 @@
+package leave
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// newTestRepository connects to the MongoDB in LEAVE_TEST_MONGO_URI and
+// gives the test a database of its own, dropped when the test ends. Tests
+// that need it are skipped when the variable is not set.
+func newTestRepository(t *testing.T) (*leaveRepository, *mongo.Database) {
+
+	t.Helper()
+
+	uri := os.Getenv("LEAVE_TEST_MONGO_URI")
+	if uri == "" {
+		t.Skip("LEAVE_TEST_MONGO_URI is not set")
+	}
+
+	ctx := context.Background()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect to MongoDB: %v", err)
+	}
+
+	db := client.Database(fmt.Sprintf("leave_test_%d", time.Now().UnixNano()))
+
+	t.Cleanup(func() {
+		db.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+
+	bucket, err := gridfs.NewBucket(db, options.GridFSBucket().SetName("leave_attachments"))
+	if err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+
+	repository := NewLeaveRepository(
+		db.Collection("leave_requests"),
+		db.Collection("settings"),
+		db.Collection("daily_leave_slots"),
+		db.Collection("leave_balance"),
+		db.Collection("leave_transactions"),
+		db.Collection("leave_types"),
+		db.Collection("leave_capacity_rules"),
+		db.Collection("leave_blackouts"),
+		db.Collection("leave_capacity_pools"),
+		db.Collection("leave_calendar_feeds"),
+		db.Collection("leave_entitlement_tables"),
+		db.Collection("leave_employment_start_dates"),
+		db.Collection("leave_comp_off_claims"),
+		db.Collection("leave_audit_logs"),
+		db.Collection("leave_closures"),
+		db.Collection("leave_notifications"),
+		bucket,
+		nil,
+	).(*leaveRepository)
+
+	return repository, db
+
+}
+
+func TestReserveDailyLeaveSlotsHoldsCapUnderParallelBookings(t *testing.T) {
+
+	repository, db := newTestRepository(t)
+
+	ctx := context.Background()
+
+	if err := repository.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ensure indexes: %v", err)
+	}
+
+	const (
+		organizationID = "org-concurrency"
+		capacity       = 3
+		bookings       = 25
+	)
+
+	_, err := repository.UpdateSetting(ctx, &Setting{MaxEmployeesPerDay: capacity}, organizationID)
+	if err != nil {
+		t.Fatalf("update setting: %v", err)
+	}
+
+	date := time.Date(2030, time.January, 7, 0, 0, 0, 0, time.UTC)
+
+	start := make(chan struct{})
+	statuses := make([]string, bookings)
+	errs := make([]error, bookings)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < bookings; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			leaveItem := LeaveRequests{
+				OrganizationID: organizationID,
+				StartDate:      date,
+				EndDate:        date,
+				LeaveDates:     []time.Time{date},
+				Portion:        PortionFull,
+				LeaveType:      LeaveTypeAnnual,
+				Policy:         &LeavePolicy{ConsumesSlot: true, DeductsBalance: true},
+				UserID:         fmt.Sprintf("user-%d", i),
+				UserName:       fmt.Sprintf("User %d", i),
+				RequestedAt:    time.Now(),
+			}
+
+			<-start
+			errs[i] = repository.CreateLeave(ctx, &leaveItem)
+			statuses[i] = leaveItem.Status
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+
+	confirmed, pending := 0, 0
+	for i := range statuses {
+		if errs[i] != nil {
+			t.Fatalf("booking %d: %v", i, errs[i])
+		}
+		switch statuses[i] {
+		case "confirmed":
+			confirmed++
+		case "pending":
+			pending++
+		}
+	}
+
+	if confirmed != capacity {
+		t.Errorf("confirmed bookings = %d, want %d", confirmed, capacity)
+	}
+
+	if pending != bookings-capacity {
+		t.Errorf("pending bookings = %d, want %d", pending, bookings-capacity)
+	}
+
+	slots := db.Collection("daily_leave_slots")
+
+	count, err := slots.CountDocuments(ctx, slotFilter(organizationID, date))
+	if err != nil {
+		t.Fatalf("count slots: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("slot documents for the day = %d, want 1", count)
+	}
+
+	var slot DailyLeaveSolt
+	if err := slots.FindOne(ctx, slotFilter(organizationID, date)).Decode(&slot); err != nil {
+		t.Fatalf("read slot: %v", err)
+	}
+
+	if len(slot.ConfirmedLeaves) != capacity {
+		t.Errorf("confirmed_leaves = %d, want %d", len(slot.ConfirmedLeaves), capacity)
+	}
+
+	if slot.AvailableSlot != 0 || slot.AvailableAMSlot != 0 || slot.AvailablePMSlot != 0 {
+		t.Errorf("available slots = %d/%d/%d, want 0/0/0", slot.AvailableSlot, slot.AvailableAMSlot, slot.AvailablePMSlot)
+	}
+
+	if len(slot.PendingRequests) != bookings-capacity {
+		t.Errorf("pending_requests = %d, want %d", len(slot.PendingRequests), bookings-capacity)
+	}
+
+}
+
+func TestEnsureIndexesMergesDuplicateSlots(t *testing.T) {
+
+	repository, db := newTestRepository(t)
+
+	ctx := context.Background()
+
+	organizationID := "org-duplicates"
+	date := time.Date(2030, time.January, 8, 0, 0, 0, 0, time.UTC)
+	leaveA, leaveB := primitive.NewObjectID(), primitive.NewObjectID()
+
+	slots := db.Collection("daily_leave_slots")
+
+	_, err := slots.InsertMany(ctx, []interface{}{
+		bson.M{
+			"_id": primitive.NewObjectID(), "organization_id": organizationID, "date": date,
+			"max_slot": 2, "available_slot": 1, "available_am_slot": 1, "available_pm_slot": 1,
+			"confirmed_leaves": []ConfirmedLeave{{LeaveID: leaveA, UserID: "a", Portion: PortionFull}},
+			"pending_requests": []PendingRequest{}, "promotions": []Promotion{},
+			"created_at": date.Add(-time.Hour),
+		},
+		bson.M{
+			"_id": primitive.NewObjectID(), "organization_id": organizationID, "date": date,
+			"max_slot": 2, "available_slot": 1, "available_am_slot": 1, "available_pm_slot": 1,
+			"confirmed_leaves": []ConfirmedLeave{{LeaveID: leaveB, UserID: "b", Portion: PortionFull}},
+			"pending_requests": []PendingRequest{}, "promotions": []Promotion{},
+			"created_at": date,
+		},
+	})
+	if err != nil {
+		t.Fatalf("insert duplicate slots: %v", err)
+	}
+
+	if err := repository.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ensure indexes: %v", err)
+	}
+
+	var merged []DailyLeaveSolt
+	cursor, err := slots.Find(ctx, slotFilter(organizationID, date))
+	if err != nil {
+		t.Fatalf("find slots: %v", err)
+	}
+	if err := cursor.All(ctx, &merged); err != nil {
+		t.Fatalf("decode slots: %v", err)
+	}
+
+	if len(merged) != 1 {
+		t.Fatalf("slot documents = %d, want 1", len(merged))
+	}
+
+	if len(merged[0].ConfirmedLeaves) != 2 || merged[0].AvailableSlot != 0 {
+		t.Errorf("merged slot has %d confirmed and %d available, want 2 and 0", len(merged[0].ConfirmedLeaves), merged[0].AvailableSlot)
+	}
+
+}
+
+func TestMergeSlotDocuments(t *testing.T) {
+
+	leaveA, leaveB, leaveC := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+
+	first := &DailyLeaveSolt{
+		ID:      primitive.NewObjectID(),
+		MaxSlot: 2,
+		ConfirmedLeaves: []ConfirmedLeave{
+			{LeaveID: leaveA, UserID: "a", Portion: PortionFull},
+		},
+		PendingRequests: []PendingRequest{
+			{LeaveID: leaveB, UserID: "b", Portion: PortionAM},
+		},
+	}
+
+	second := &DailyLeaveSolt{
+		ID:             primitive.NewObjectID(),
+		MaxSlot:        3,
+		ManualOverride: true,
+		ConfirmedLeaves: []ConfirmedLeave{
+			{LeaveID: leaveA, UserID: "a", Portion: PortionFull},
+			{LeaveID: leaveB, UserID: "b", Portion: PortionAM},
+		},
+		PendingRequests: []PendingRequest{
+			{LeaveID: leaveC, UserID: "c", Portion: PortionFull},
+		},
+	}
+
+	merged := mergeSlotDocuments([]*DailyLeaveSolt{first, second})
+
+	if merged.ID != first.ID {
+		t.Errorf("merged into %s, want the first slot %s", merged.ID.Hex(), first.ID.Hex())
+	}
+
+	if merged.MaxSlot != 3 || !merged.ManualOverride {
+		t.Errorf("max slot = %d, manual override = %v, want 3 and true", merged.MaxSlot, merged.ManualOverride)
+	}
+
+	if len(merged.ConfirmedLeaves) != 2 {
+		t.Errorf("confirmed leaves = %d, want 2", len(merged.ConfirmedLeaves))
+	}
+
+	// leaveB was confirmed on the second copy, so only leaveC still waits.
+	if len(merged.PendingRequests) != 1 || merged.PendingRequests[0].LeaveID != leaveC {
+		t.Errorf("pending requests = %+v, want only %s", merged.PendingRequests, leaveC.Hex())
+	}
+
+	// A full day and a morning leave: AM has 3-2, PM has 3-1.
+	if merged.AvailableAMSlot != 1 || merged.AvailablePMSlot != 2 || merged.AvailableSlot != 1 {
+		t.Errorf("available = %d/%d/%d, want 1/2/1", merged.AvailableSlot, merged.AvailableAMSlot, merged.AvailablePMSlot)
+	}
+
+}
+
+func TestPromoteWishlistConfirmsOldestFirst(t *testing.T) {
+
+	repository, _ := newTestRepository(t)
+
+	ctx := context.Background()
+
+	if err := repository.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ensure indexes: %v", err)
+	}
+
+	const organizationID = "org-fifo"
+
+	_, err := repository.UpdateSetting(ctx, &Setting{MaxEmployeesPerDay: 1}, organizationID)
+	if err != nil {
+		t.Fatalf("update setting: %v", err)
+	}
+
+	date := time.Date(2030, time.April, 1, 0, 0, 0, 0, time.UTC)
+	requestedAt := time.Date(2030, time.March, 1, 9, 0, 0, 0, time.UTC)
+
+	book := func(userID string, requestedAt time.Time) *LeaveRequests {
+		leaveItem := &LeaveRequests{
+			OrganizationID: organizationID,
+			StartDate:      date,
+			EndDate:        date,
+			LeaveDates:     []time.Time{date},
+			Portion:        PortionFull,
+			LeaveType:      LeaveTypeAnnual,
+			Policy:         &LeavePolicy{ConsumesSlot: true, DeductsBalance: true},
+			UserID:         userID,
+			UserName:       userID,
+			RequestedAt:    requestedAt,
+		}
+		if err := repository.CreateLeave(ctx, leaveItem); err != nil {
+			t.Fatalf("book %s: %v", userID, err)
+		}
+		return leaveItem
+	}
+
+	holder := book("holder", requestedAt)
+
+	// Queued out of order: the earliest request is booked second.
+	book("late", requestedAt.Add(2*time.Hour))
+	early := book("early", requestedAt.Add(time.Hour))
+	book("middle", requestedAt.Add(90*time.Minute))
+
+	promote := func() string {
+		promoted, err := repository.PromoteWishlist(ctx, organizationID, date)
+		if err != nil {
+			t.Fatalf("promote wish list: %v", err)
+		}
+		if len(promoted) != 1 {
+			t.Fatalf("promoted %d requests, want 1", len(promoted))
+		}
+		return promoted[0].UserID
+	}
+
+	if err := repository.releaseDailyLeaveSlot(ctx, date, holder); err != nil {
+		t.Fatalf("release holder: %v", err)
+	}
+
+	first := promote()
+	if first != "early" {
+		t.Fatalf("promoted %s, want early", first)
+	}
+
+	// A request sent back keeps its place at the head of the queue.
+	if err := repository.ReturnToWishlist(ctx, early); err != nil {
+		t.Fatalf("return to wish list: %v", err)
+	}
+
+	if again := promote(); again != "early" {
+		t.Errorf("promoted %s after the return, want early", again)
+	}
+
+}
+
+func TestHasCapacity(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		am, pm  int
+		portion string
+		want    bool
+	}{
+		{name: "full day with both halves free", am: 1, pm: 1, portion: PortionFull, want: true},
+		{name: "full day with the morning taken", am: 0, pm: 1, portion: PortionFull, want: false},
+		{name: "full day with the afternoon taken", am: 1, pm: 0, portion: PortionFull, want: false},
+		{name: "morning free", am: 1, pm: 0, portion: PortionAM, want: true},
+		{name: "morning taken", am: 0, pm: 1, portion: PortionAM, want: false},
+		{name: "afternoon free", am: 0, pm: 1, portion: PortionPM, want: true},
+		{name: "afternoon taken", am: 1, pm: 0, portion: PortionPM, want: false},
+		{name: "legacy request counts as a full day", am: 1, pm: 0, portion: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			slot := &DailyLeaveSolt{AvailableAMSlot: tt.am, AvailablePMSlot: tt.pm}
+			if got := hasCapacity(slot, tt.portion); got != tt.want {
+				t.Errorf("hasCapacity(am %d, pm %d, %q) = %v, want %v", tt.am, tt.pm, tt.portion, got, tt.want)
+			}
+		})
+	}
+
+}
+
+type fakeHolidayCalendar map[string]string
+
+func (c fakeHolidayCalendar) GetHolidaysInRange(ctx context.Context, organizationID string, startDate time.Time, endDate time.Time) (map[string]string, error) {
+	return c, nil
+}
+
+func TestSyncHolidaySlotsClosesAndReopensExistingDays(t *testing.T) {
+
+	repository, db := newTestRepository(t)
+
+	ctx := context.Background()
+
+	if err := repository.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ensure indexes: %v", err)
+	}
+
+	organizationID := "org-holidays"
+	date := time.Date(2030, time.January, 9, 0, 0, 0, 0, time.UTC)
+
+	_, err := repository.UpdateSetting(ctx, &Setting{MaxEmployeesPerDay: 2}, organizationID)
+	if err != nil {
+		t.Fatalf("update setting: %v", err)
+	}
+
+	// The slot exists before the holiday is entered.
+	_, err = repository.getDailyLeaveSlots(ctx, organizationID, date.Format("2006-01-02"))
+	if err != nil {
+		t.Fatalf("get daily slot: %v", err)
+	}
+
+	slots := db.Collection("daily_leave_slots")
+
+	readSlot := func() DailyLeaveSolt {
+		var slot DailyLeaveSolt
+		if err := slots.FindOne(ctx, slotFilter(organizationID, date)).Decode(&slot); err != nil {
+			t.Fatalf("read slot: %v", err)
+		}
+		return slot
+	}
+
+	repository.holidayCalendar = fakeHolidayCalendar{"2030-01-09": "Founders' Day"}
+
+	if _, err := repository.SyncHolidaySlots(ctx, organizationID, date); err != nil {
+		t.Fatalf("sync holiday: %v", err)
+	}
+
+	if slot := readSlot(); !slot.IsHoliday || slot.AvailableSlot != 0 {
+		t.Errorf("after adding the holiday: is_holiday = %v, available = %d, want true and 0", slot.IsHoliday, slot.AvailableSlot)
+	}
+
+	repository.holidayCalendar = fakeHolidayCalendar{}
+
+	reopened, err := repository.SyncHolidaySlots(ctx, organizationID, date)
+	if err != nil {
+		t.Fatalf("sync removed holiday: %v", err)
+	}
+
+	if len(reopened) != 1 {
+		t.Errorf("reopened days = %d, want 1", len(reopened))
+	}
+
+	if slot := readSlot(); slot.IsHoliday || slot.AvailableSlot != 2 {
+		t.Errorf("after removing the holiday: is_holiday = %v, available = %d, want false and 2", slot.IsHoliday, slot.AvailableSlot)
+	}
+
+}
+
+func TestAnalyticsGroupStages(t *testing.T) {
+
+	byRequests := bson.D{{Key: "requests", Value: -1}, {Key: "leave_days", Value: -1}, {Key: "_id", Value: 1}}
+	byKey := bson.D{{Key: "_id", Value: 1}}
+	poolName := bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$pool.name", 0}}, ""}}
+
+	tests := []struct {
+		name     string
+		grouping AnalyticsGrouping
+		key      interface{}
+		named    interface{}
+		sort     bson.D
+		limit    interface{}
+	}{
+		{
+			name:     "whole range is one bucket",
+			grouping: AnalyticsGrouping{By: GroupByAll},
+			key:      "total",
+			sort:     byKey,
+		},
+		{
+			name:     "months read in order",
+			grouping: AnalyticsGrouping{By: GroupByMonth},
+			key:      bson.M{"$dateToString": bson.M{"format": "%Y-%m", "date": "$date"}},
+			sort:     byKey,
+		},
+		{
+			name:     "top weeks rank by requests",
+			grouping: AnalyticsGrouping{By: GroupByWeek, Top: 3},
+			key:      bson.M{"$dateToString": bson.M{"format": "%G-W%V", "date": "$date"}},
+			sort:     byRequests,
+			limit:    3,
+		},
+		{
+			name:     "types rank by requests",
+			grouping: AnalyticsGrouping{By: GroupByType},
+			key:      "$leave_type",
+			sort:     byRequests,
+		},
+		{
+			name:     "users are named from their requests",
+			grouping: AnalyticsGrouping{By: GroupByUser, Top: 5},
+			key:      "$user_id",
+			named:    "$user_name",
+			sort:     byRequests,
+			limit:    5,
+		},
+		{
+			name:     "teams are named from their pool",
+			grouping: AnalyticsGrouping{By: GroupByTeam},
+			key:      bson.M{"$ifNull": bson.A{bson.M{"$toString": "$pool_id"}, ""}},
+			named:    poolName,
+			sort:     byRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			stages, err := analyticsGroupStages(tt.grouping, "leave_capacity_pools")
+			if err != nil {
+				t.Fatalf("analyticsGroupStages: %v", err)
+			}
+
+			var key, named, limit, lookup interface{}
+			var sort bson.D
+			for i, stage := range stages {
+				for operator, value := range stage.(bson.M) {
+					switch operator {
+					case "$group":
+						if i == 0 {
+							key = value.(bson.M)["_id"].(bson.M)["key"]
+						}
+					case "$addFields":
+						if name, ok := value.(bson.M)["name"]; ok {
+							named = name
+						}
+					case "$lookup":
+						lookup = value.(bson.M)["from"]
+					case "$sort":
+						sort = value.(bson.D)
+					case "$limit":
+						limit = value
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(key, tt.key) {
+				t.Errorf("bucket key = %v, want %v", key, tt.key)
+			}
+			if !reflect.DeepEqual(named, tt.named) {
+				t.Errorf("name = %v, want %v", named, tt.named)
+			}
+			if !reflect.DeepEqual(sort, tt.sort) {
+				t.Errorf("sort = %v, want %v", sort, tt.sort)
+			}
+			if limit != tt.limit {
+				t.Errorf("limit = %v, want %v", limit, tt.limit)
+			}
+
+			wantLookup := interface{}(nil)
+			if tt.grouping.By == GroupByTeam {
+				wantLookup = "leave_capacity_pools"
+			}
+			if lookup != wantLookup {
+				t.Errorf("lookup from = %v, want %v", lookup, wantLookup)
+			}
+
+		})
+	}
+
+	if _, err := analyticsGroupStages(AnalyticsGrouping{By: "year"}, "leave_capacity_pools"); err == nil {
+		t.Error("analyticsGroupStages accepted an unknown grouping")
+	}
+
+}
+
+func TestLeaveNotificationsArePostedOnceAndReadByTheirOwner(t *testing.T) {
+
+	repository, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	err := repository.EnsureIndexes(ctx)
+	if err != nil {
+		t.Fatalf("ensure indexes: %v", err)
+	}
+
+	leaveID := primitive.NewObjectID()
+	notice := func() *LeaveNotification {
+		return &LeaveNotification{
+			ID:             primitive.NewObjectID(),
+			OrganizationID: "org-1",
+			UserID:         "teacher-1",
+			LeaveID:        leaveID,
+			Type:           notificationLeaveExpired,
+			Title:          "Leave request expired",
+			CreatedAt:      time.Now(),
+		}
+	}
+
+	for range 2 {
+		if err := repository.CreateLeaveNotification(ctx, notice()); err != nil {
+			t.Fatalf("create notification: %v", err)
+		}
+	}
+
+	notifications, err := repository.GetLeaveNotifications(ctx, "org-1", "teacher-1")
+	if err != nil {
+		t.Fatalf("get notifications: %v", err)
+	}
+	if len(notifications) != 1 {
+		t.Fatalf("notifications = %d, want 1", len(notifications))
+	}
+
+	err = repository.MarkLeaveNotificationRead(ctx, "org-1", "teacher-2", notifications[0].ID, time.Now())
+	if err != mongo.ErrNoDocuments {
+		t.Fatalf("another user marked the notice read: err = %v", err)
+	}
+
+	err = repository.MarkLeaveNotificationRead(ctx, "org-1", "teacher-1", notifications[0].ID, time.Now())
+	if err != nil {
+		t.Fatalf("mark read: %v", err)
+	}
+
+	notifications, err = repository.GetLeaveNotifications(ctx, "org-1", "teacher-1")
+	if err != nil {
+		t.Fatalf("get notifications: %v", err)
+	}
+	if notifications[0].ReadAt == nil {
+		t.Errorf("notice was not marked read")
+	}
+
+}
+
+func TestLeaveBalancesAreKeptPerOrganization(t *testing.T) {
+
+	repository, _ := newTestRepository(t)
+	ctx := context.Background()
+
+	err := repository.EnsureIndexes(ctx)
+	if err != nil {
+		t.Fatalf("ensure indexes: %v", err)
+	}
+
+	for _, organizationID := range []string{"org-1", "org-2"} {
+		if _, err := repository.GetLeaveBalance(ctx, organizationID, "teacher-1", 2030); err != nil {
+			t.Fatalf("create balance in %s: %v", organizationID, err)
+		}
+	}
+
+	err = repository.UpdateLeaveBalance(ctx, "org-1", "teacher-1", 2030, 10, 3)
+	if err != nil {
+		t.Fatalf("update balance: %v", err)
+	}
+
+	tests := []struct {
+		organizationID string
+		remaining      float64
+	}{
+		{"org-1", 7},
+		{"org-2", 0},
+	}
+
+	for _, tt := range tests {
+		balance, err := repository.FindLeaveBalance(ctx, tt.organizationID, "teacher-1", 2030)
+		if err != nil || balance == nil {
+			t.Fatalf("find balance in %s: %v, %v", tt.organizationID, balance, err)
+		}
+		if balance.RemaindingLeave != tt.remaining {
+			t.Errorf("%s remaining = %v, want %v", tt.organizationID, balance.RemaindingLeave, tt.remaining)
+		}
+	}
+
+}
+
+func TestLeaveTypesAreSeededOncePerCode(t *testing.T) {
+
+	repository, db := newTestRepository(t)
+	ctx := context.Background()
+
+	err := repository.EnsureIndexes(ctx)
+	if err != nil {
+		t.Fatalf("ensure indexes: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := repository.GetLeaveTypes(ctx, "org-1"); err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatalf("seed leave types: %v", err)
+	}
+
+	count, err := db.Collection("leave_types").CountDocuments(ctx, bson.M{"organization_id": "org-1"})
+	if err != nil {
+		t.Fatalf("count leave types: %v", err)
+	}
+	if want := len(defaultLeaveTypes("org-1")); int(count) != want {
+		t.Errorf("leave types = %d, want %d", count, want)
+	}
+
+	err = repository.CreateLeaveType(ctx, &LeaveType{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: "org-1",
+		Code:           LeaveTypeAnnual,
+		Name:           "Annual leave again",
+	})
+	if err == nil {
+		t.Errorf("a second %s leave type was created", LeaveTypeAnnual)
+	}
+
+}
@@ -4,19 +4,62 @@ import "time"
 
 type CreateLeaveRequest struct {
 	LeaveDate   string    `bson:"leave_date" json:"leave_date"`
+	StartDate   string    `bson:"start_date" json:"start_date"`
+	EndDate     string    `bson:"end_date" json:"end_date"`
+	Portion     string    `bson:"portion" json:"portion"`
+	LeaveType   string    `bson:"leave_type" json:"leave_type"`
 	UserID      string    `bson:"user_id" json:"user_id"`
 	Reason      *string   `bson:"reason" json:"reason"`
 	RequestedAt time.Time `bson:"requested_at" json:"requested_at"`
 }
 
+// DeleteLeaveRequest names the request to cancel by its ID or by one of
+// its days. A morning and an afternoon request can share a day, so the day
+// needs the portion whenever it carries more than one request.
 type DeleteLeaveRequest struct {
+	ID        string `bson:"id" json:"id"`
 	LeaveDate string `bson:"leave_date" json:"leave_date"`
+	Portion   string `bson:"portion" json:"portion"`
 	UserID    string `bson:"user_id" json:"user_id"`
 }
 
+// SettingRequest changes only the settings it carries; fields left out, and
+// an empty YearBoundary, keep their current value.
 type SettingRequest struct {
-	MaxEmployeesPerDay int `bson:"max_employees_per_day" json:"max_employees_per_day"`
-	AdvanceBookingDays int `bson:"advance_booking_days" json:"advance_booking_days"`
+	MaxEmployeesPerDay    *int     `bson:"max_employees_per_day" json:"max_employees_per_day"`
+	AdvanceBookingDays    *int     `bson:"advance_booking_days" json:"advance_booking_days"`
+	MaxBookingDays        *int     `bson:"max_booking_days" json:"max_booking_days"`
+	CancelNoticeDays      *int     `bson:"cancel_notice_days" json:"cancel_notice_days"`
+	YearBoundary          string   `bson:"year_boundary" json:"year_boundary"`
+	TermID                string   `bson:"term_id" json:"term_id"`
+	CarryOverMaxDays      *float64 `bson:"carry_over_max_days" json:"carry_over_max_days"`
+	CarryOverExpiryMonths *int     `bson:"carry_over_expiry_months" json:"carry_over_expiry_months"`
+	CompOffExpiryDays     *int     `bson:"comp_off_expiry_days" json:"comp_off_expiry_days"`
+	PendingExpiryDays     *int     `bson:"pending_expiry_days" json:"pending_expiry_days"`
+}
+
+type CancelLeaveRequest struct {
+	Reason      *string `bson:"reason" json:"reason"`
+	RequestedBy string  `bson:"requested_by" json:"requested_by"`
+}
+
+type RescheduleLeaveRequest struct {
+	StartDate   string  `bson:"start_date" json:"start_date"`
+	EndDate     string  `bson:"end_date" json:"end_date"`
+	Reason      *string `bson:"reason" json:"reason"`
+	RequestedBy string  `bson:"requested_by" json:"requested_by"`
+}
+
+type SubstituteRequest struct {
+	TeacherID  string  `bson:"teacher_id" json:"teacher_id"`
+	Date       string  `bson:"date" json:"date"`
+	Note       *string `bson:"note" json:"note"`
+	AssignedBy string  `bson:"assigned_by" json:"assigned_by"`
+}
+
+type SubstituteStatusRequest struct {
+	Status      string `bson:"status" json:"status"`
+	RespondedBy string `bson:"responded_by" json:"responded_by"`
 }
 
 type EditSlotRequest struct {
@@ -24,6 +67,92 @@ type EditSlotRequest struct {
 }
 
 type UpdateRequest struct {
-	Types string `bson:"types" json:"types"`
+	Types      string  `bson:"types" json:"types"`
+	Comment    *string `bson:"comment" json:"comment"`
+	ReviewerID string  `bson:"reviewer_id" json:"reviewer_id"`
+}
+
+type ReviewLeaveRequest struct {
+	Comment          *string `bson:"comment" json:"comment"`
+	OverrideCapacity bool    `bson:"override_capacity" json:"override_capacity"`
+	ReviewerID       string  `bson:"reviewer_id" json:"reviewer_id"`
+}
+
+type LeaveTypeRequest struct {
+	Code               string `bson:"code" json:"code"`
+	Name               string `bson:"name" json:"name"`
+	ConsumesSlot       bool   `bson:"consumes_slot" json:"consumes_slot"`
+	RequiresApproval   bool   `bson:"requires_approval" json:"requires_approval"`
+	DeductsBalance     bool   `bson:"deducts_balance" json:"deducts_balance"`
+	RequiresAttachment bool   `bson:"requires_attachment" json:"requires_attachment"`
+	UsesCompOff        bool   `bson:"uses_comp_off" json:"uses_comp_off"`
+	IsActive           *bool  `bson:"is_active" json:"is_active"`
+
+	BookingWindow *BookingWindowException `bson:"booking_window" json:"booking_window"`
+}
+
+type ClosureRequest struct {
+	OrganizationID string  `bson:"organization_id" json:"organization_id"`
+	Name           string  `bson:"name" json:"name"`
+	StartDate      string  `bson:"start_date" json:"start_date"`
+	EndDate        string  `bson:"end_date" json:"end_date"`
+	Mode           string  `bson:"mode" json:"mode"`
+	LeaveType      string  `bson:"leave_type" json:"leave_type"`
+	Reason         *string `bson:"reason" json:"reason"`
+}
+
+type CompOffClaimRequest struct {
+	UserID   string  `bson:"user_id" json:"user_id"`
+	DateFrom string  `bson:"date_from" json:"date_from"`
+	DateTo   string  `bson:"date_to" json:"date_to"`
+	Reason   *string `bson:"reason" json:"reason"`
+}
+
+type EntitlementTableRequest struct {
+	BaseDays         float64           `bson:"base_days" json:"base_days"`
+	Tiers            []EntitlementTier `bson:"tiers" json:"tiers"`
+	SeniorityYears   int               `bson:"seniority_years" json:"seniority_years"`
+	SeniorityDays    float64           `bson:"seniority_days" json:"seniority_days"`
+	ProrateFirstYear *bool             `bson:"prorate_first_year" json:"prorate_first_year"`
+}
+
+type EmploymentStartDateRequest struct {
+	StartDate string `bson:"start_date" json:"start_date"`
+	UpdatedBy string `bson:"updated_by" json:"updated_by"`
 }
 
+type CapacityRuleRequest struct {
+	Name      string         `bson:"name" json:"name"`
+	Weekdays  []time.Weekday `bson:"weekdays" json:"weekdays"`
+	StartDate string         `bson:"start_date" json:"start_date"`
+	EndDate   string         `bson:"end_date" json:"end_date"`
+	MaxSlot   int            `bson:"max_slot" json:"max_slot"`
+	Priority  int            `bson:"priority" json:"priority"`
+	IsActive  *bool          `bson:"is_active" json:"is_active"`
+}
+
+type CapacityPoolRequest struct {
+	Name     string   `bson:"name" json:"name"`
+	UserIDs  []string `bson:"user_ids" json:"user_ids"`
+	MaxSlot  int      `bson:"max_slot" json:"max_slot"`
+	IsActive *bool    `bson:"is_active" json:"is_active"`
+}
+
+type CalendarFeedRequest struct {
+	Scope string `bson:"scope" json:"scope"`
+	Name  string `bson:"name" json:"name"`
+}
+
+type BlackoutPeriodRequest struct {
+	Name            string `bson:"name" json:"name"`
+	Mode            string `bson:"mode" json:"mode"`
+	StartDate       string `bson:"start_date" json:"start_date"`
+	EndDate         string `bson:"end_date" json:"end_date"`
+	TermID          string `bson:"term_id" json:"term_id"`
+	Anchor          string `bson:"anchor" json:"anchor"`
+	StartOffsetDays int    `bson:"start_offset_days" json:"start_offset_days"`
+	EndOffsetDays   int    `bson:"end_offset_days" json:"end_offset_days"`
+	Action          string `bson:"action" json:"action"`
+	IsActive        *bool  `bson:"is_active" json:"is_active"`
+	CreatedBy       string `bson:"created_by" json:"created_by"`
+}
@@ -0,0 +1,640 @@
+package leave
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RescheduleLeave moves a leave request to other dates. The request keeps
+// its ID, its place in the queue and any approval it already has; the old
+// booking is recorded in its revisions.
+func (s *leaveService) RescheduleLeave(ctx context.Context, req *RescheduleLeaveRequest, id string) (*LeaveRequests, error) {
+
+	if req.RequestedBy == "" {
+		return nil, fmt.Errorf("requested by is required")
+	}
+
+	if req.StartDate == "" {
+		return nil, fmt.Errorf("start date is required")
+	}
+
+	if req.EndDate == "" {
+		req.EndDate = req.StartDate
+	}
+
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return nil, err
+	}
+
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkLeaveRange(startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	leaveItem, currentUser, err := s.getLeaveForUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentUser.ID != leaveItem.UserID && !isOrganizationAdmin(currentUser) {
+		return nil, fmt.Errorf("only the requester or an organization admin can reschedule this leave")
+	}
+
+	if leaveItem.bookedBySystem() {
+		return nil, fmt.Errorf("leave booked by a closure cannot be rescheduled")
+	}
+
+	if leaveItem.Status != "pending" && leaveItem.Status != "confirmed" {
+		return nil, fmt.Errorf("leave request is %s and cannot be rescheduled", leaveItem.Status)
+	}
+
+	if leaveItem.Cancellation != nil && leaveItem.Cancellation.Status == CancellationPending {
+		return nil, fmt.Errorf("leave request has a cancellation waiting for approval")
+	}
+
+	today := leaveToday(time.Now())
+
+	if leaveItem.Status == "confirmed" && leaveItem.Dates()[0].Before(today) {
+		return nil, fmt.Errorf("leave has already started and cannot be rescheduled")
+	}
+
+	if leaveItem.Portion == PortionAM || leaveItem.Portion == PortionPM {
+		if !endDate.Equal(startDate) {
+			return nil, fmt.Errorf("half-day leave must be a single day")
+		}
+	}
+
+	leaveDates, err := s.getWorkingDays(ctx, leaveItem.OrganizationID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(leaveDates) == 0 {
+		return nil, fmt.Errorf("leave range has no working days")
+	}
+
+	if len(diffDates(leaveDates, leaveItem.Dates())) == 0 && len(diffDates(leaveItem.Dates(), leaveDates)) == 0 {
+		return nil, fmt.Errorf("leave request is already booked on these dates")
+	}
+
+	err = s.checkReschedulePolicy(ctx, leaveItem, startDate, endDate, leaveDates, today)
+	if err != nil {
+		return nil, err
+	}
+
+	reschedule := LeaveReschedule{
+		StartDate:   startDate,
+		EndDate:     endDate,
+		LeaveDates:  leaveDates,
+		Reason:      req.Reason,
+		RequestedBy: req.RequestedBy,
+		RequestedAt: time.Now(),
+	}
+
+	previousStatus := leaveItem.Status
+
+	err = s.leaveRepository.RescheduleLeave(ctx, leaveItem, &reschedule)
+	if err != nil {
+		return nil, err
+	}
+
+	// The new dates were full, so the move waits on them.
+	if leaveItem.Reschedule != nil {
+		return leaveItem, nil
+	}
+
+	err = s.settleReschedule(ctx, leaveItem, previousStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaveItem, nil
+
+}
+
+// checkReschedulePolicy applies the booking window and blackout rules of
+// the request's leave type to the new dates. A reschedule keeps the
+// approval the request already has, so it cannot move into a window that
+// would need a new one.
+func (s *leaveService) checkReschedulePolicy(ctx context.Context, leaveItem *LeaveRequests, startDate time.Time, endDate time.Time, leaveDates []time.Time, today time.Time) error {
+
+	setting, err := s.leaveRepository.GetSettings(ctx, leaveItem.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	window := bookingWindow(setting, leaveItem.LeavePolicy())
+	policyErr := evaluateBookingWindow(window, leaveItem.LeaveType, startDate, endDate, today)
+	if policyErr == nil {
+		policyErr = &PolicyError{}
+	}
+
+	blackoutWindows, err := s.getBlackoutWindows(ctx, leaveItem.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	violations, approvalWindow := checkBlackout(blackoutWindows, leaveItem.LeaveType, leaveDates)
+	policyErr.Violations = append(policyErr.Violations, violations...)
+
+	if approvalWindow != nil && leaveItem.RequestType != "approval" {
+		for _, date := range leaveDates {
+			if !approvalWindow.contains(date) {
+				continue
+			}
+			policyErr.Violations = append(policyErr.Violations, PolicyViolation{
+				Code:      ViolationBlackout,
+				Message:   fmt.Sprintf("%s is in the blackout period %s, cancel and request it again for approval", date.Format("2006-01-02"), approvalWindow.period.Name),
+				LeaveType: leaveItem.LeaveType,
+				Date:      date.Format("2006-01-02"),
+				Earliest:  approvalWindow.startDate.Format("2006-01-02"),
+				Latest:    approvalWindow.endDate.Format("2006-01-02"),
+			})
+			break
+		}
+	}
+
+	if len(policyErr.Violations) > 0 {
+		return policyErr
+	}
+
+	return nil
+
+}
+
+// settleReschedule brings the balance in line with a move that has been
+// applied: the old days are refunded and the new ones charged. Days the
+// leave gave up are offered to the wish list.
+func (s *leaveService) settleReschedule(ctx context.Context, leaveItem *LeaveRequests, previousStatus string) error {
+
+	if leaveItem.Status != "confirmed" {
+		return nil
+	}
+
+	if previousStatus != "confirmed" {
+		return s.debitLeaveBalance(ctx, leaveItem)
+	}
+
+	previous := leaveItem.previousBooking()
+
+	err := s.refundLeaveBalance(ctx, previous, previous.Dates())
+	if err != nil {
+		return err
+	}
+
+	err = s.debitLeaveBalance(ctx, leaveItem)
+	if err != nil {
+		return err
+	}
+
+	if !leaveItem.LeavePolicy().ConsumesSlot {
+		return nil
+	}
+
+	return s.promoteWishlist(ctx, leaveItem.OrganizationID, diffDates(previous.Dates(), leaveItem.Dates()))
+
+}
+
+// previousBooking rebuilds the request as it was before its last move.
+func (l *LeaveRequests) previousBooking() *LeaveRequests {
+
+	revision := l.Revisions[len(l.Revisions)-1]
+
+	previous := *l
+	previous.LeaveDate = revision.LeaveDates[0]
+	previous.StartDate = revision.StartDate
+	previous.EndDate = revision.EndDate
+	previous.LeaveDates = revision.LeaveDates
+	previous.Portion = revision.Portion
+	previous.TotalDays = revision.TotalDays
+	previous.Status = revision.Status
+	previous.RequestType = revision.RequestType
+
+	return &previous
+
+}
+
+// diffDates returns the dates in a that are not in b.
+func diffDates(a []time.Time, b []time.Time) []time.Time {
+
+	var dates []time.Time
+
+	for _, date := range a {
+		found := false
+		for _, other := range b {
+			if date.Equal(other) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dates = append(dates, date)
+		}
+	}
+
+	return dates
+
+}
+
+// RescheduleLeave moves the request to the dates in reschedule. A confirmed
+// leave only gives up its old days once the new ones are reserved; when the
+// new days are full the move is queued on them and the old booking is kept.
+// A pending request moves its queue entries and keeps its place and any
+// approval it is still waiting for.
+func (r *leaveRepository) RescheduleLeave(ctx context.Context, leaveItem *LeaveRequests, reschedule *LeaveReschedule) error {
+
+	policy := leaveItem.LeavePolicy()
+
+	if policy.ConsumesSlot {
+		for _, date := range reschedule.LeaveDates {
+			dailyLeaveSlot, err := r.getDailyLeaveSlots(ctx, leaveItem.OrganizationID, date.Format("2006-01-02"))
+			if err != nil {
+				return err
+			}
+			if dailyLeaveSlot.IsHoliday {
+				return fmt.Errorf("%s is a public holiday: %s", date.Format("2006-01-02"), dailyLeaveSlot.HolidayName)
+			}
+		}
+	}
+
+	target := *leaveItem
+	target.LeaveDates = reschedule.LeaveDates
+
+	check, msg := r.checkRequestExists(ctx, &target)
+	if check {
+		return fmt.Errorf("leave request exists: %s", msg)
+	}
+
+	// A newer reschedule replaces one that is still waiting.
+	if leaveItem.Reschedule != nil {
+		err := r.withdrawReschedule(ctx, leaveItem)
+		if err != nil {
+			return err
+		}
+	}
+
+	if leaveItem.Status == "pending" || !policy.ConsumesSlot {
+		return r.moveLeave(ctx, leaveItem, reschedule)
+	}
+
+	moved, err := r.moveConfirmedLeave(ctx, leaveItem, reschedule, false)
+	if err != nil || moved {
+		return err
+	}
+
+	return r.queueReschedule(ctx, leaveItem, reschedule)
+
+}
+
+// bookingFilter matches the request only while it still holds the booking
+// it was read with; the revision count acts as its version.
+func bookingFilter(leaveItem *LeaveRequests) bson.M {
+
+	filter := bson.M{
+		"_id":          leaveItem.ID,
+		"status":       leaveItem.Status,
+		"request_type": leaveItem.RequestType,
+	}
+
+	if len(leaveItem.Revisions) == 0 {
+		filter["revisions"] = bson.M{"$exists": false}
+	} else {
+		filter["revisions"] = bson.M{"$size": len(leaveItem.Revisions)}
+	}
+
+	return filter
+
+}
+
+func bookingFields(reschedule *LeaveReschedule, portion string) bson.M {
+	return bson.M{
+		"leave_date":  reschedule.LeaveDates[0],
+		"start_date":  reschedule.StartDate,
+		"end_date":    reschedule.EndDate,
+		"leave_dates": reschedule.LeaveDates,
+		"total_days":  float64(len(reschedule.LeaveDates)) * portionDays(portion),
+	}
+}
+
+func revisionOf(leaveItem *LeaveRequests, reschedule *LeaveReschedule) LeaveRevision {
+	return LeaveRevision{
+		StartDate:   leaveItem.StartDate,
+		EndDate:     leaveItem.EndDate,
+		LeaveDates:  leaveItem.Dates(),
+		Portion:     leaveItem.Portion,
+		TotalDays:   leaveItem.Days(),
+		Status:      leaveItem.Status,
+		RequestType: leaveItem.RequestType,
+		Reason:      reschedule.Reason,
+		ChangedBy:   reschedule.RequestedBy,
+		ChangedAt:   time.Now(),
+	}
+}
+
+func applyReschedule(leaveItem *LeaveRequests, reschedule *LeaveReschedule, revision LeaveRevision) {
+	leaveItem.LeaveDate = reschedule.LeaveDates[0]
+	leaveItem.StartDate = reschedule.StartDate
+	leaveItem.EndDate = reschedule.EndDate
+	leaveItem.LeaveDates = reschedule.LeaveDates
+	leaveItem.TotalDays = float64(len(reschedule.LeaveDates)) * portionDays(leaveItem.Portion)
+	leaveItem.Revisions = append(leaveItem.Revisions, revision)
+	leaveItem.Reschedule = nil
+
+	var substitutes []Substitute
+	for _, substitute := range leaveItem.Substitutes {
+		for _, date := range reschedule.LeaveDates {
+			if substitute.Date.Equal(date) {
+				substitutes = append(substitutes, substitute)
+				break
+			}
+		}
+	}
+	leaveItem.Substitutes = substitutes
+}
+
+// substitutesOffDates drops cover arranged for days the leave no longer
+// takes, so those teachers are free again.
+func substitutesOffDates(leaveDates []time.Time) bson.M {
+	return bson.M{"substitutes": bson.M{"date": bson.M{"$nin": leaveDates}}}
+}
+
+// moveLeave reschedules a request that holds no slots: a pending request,
+// whose queue entries follow it, or a type that never uses the daily slots.
+// A wish-list request that fits on its new days is confirmed right away.
+func (r *leaveRepository) moveLeave(ctx context.Context, leaveItem *LeaveRequests, reschedule *LeaveReschedule) error {
+
+	revision := revisionOf(leaveItem, reschedule)
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bookingFilter(leaveItem), bson.M{
+		"$set":  bookingFields(reschedule, leaveItem.Portion),
+		"$push": bson.M{"revisions": revision},
+		"$pull": substitutesOffDates(reschedule.LeaveDates),
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return errLeaveChanged
+	}
+
+	previous := *leaveItem
+	applyReschedule(leaveItem, reschedule, revision)
+
+	if !leaveItem.LeavePolicy().ConsumesSlot {
+		return nil
+	}
+
+	for _, date := range diffDates(leaveItem.Dates(), previous.Dates()) {
+		err = r.pushPendingRequest(ctx, date, leaveItem)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, date := range diffDates(previous.Dates(), leaveItem.Dates()) {
+		err = r.releaseDailyLeaveSlot(ctx, date, &previous)
+		if err != nil {
+			return err
+		}
+	}
+
+	if leaveItem.RequestType != "wishlist" {
+		return nil
+	}
+
+	fits, err := r.fitsAllDates(ctx, leaveItem)
+	if err != nil || !fits {
+		return err
+	}
+
+	_, err = r.promoteLeave(ctx, leaveItem)
+	return err
+
+}
+
+// moveConfirmedLeave reserves the days the leave does not hold yet, then
+// switches the request to its new dates and hands back the days it no
+// longer needs. It reports false, with the old booking untouched, when a
+// new day is full. fromQueue is set when the move was waiting in the
+// days' pending lists.
+func (r *leaveRepository) moveConfirmedLeave(ctx context.Context, leaveItem *LeaveRequests, reschedule *LeaveReschedule, fromQueue bool) (bool, error) {
+
+	added := diffDates(reschedule.LeaveDates, leaveItem.Dates())
+	removed := diffDates(leaveItem.Dates(), reschedule.LeaveDates)
+
+	if len(added) > 0 {
+		target := *leaveItem
+		target.LeaveDates = added
+
+		reserved, err := r.reserveDailyLeaveSlots(ctx, &target, fromQueue, nil)
+		if err != nil || !reserved {
+			return false, err
+		}
+	}
+
+	revision := revisionOf(leaveItem, reschedule)
+
+	filter := bookingFilter(leaveItem)
+	filter["cancellation.status"] = bson.M{"$ne": CancellationPending}
+	if fromQueue {
+		filter["reschedule.requested_at"] = reschedule.RequestedAt
+	}
+
+	result, err := r.collectionLeave.UpdateOne(ctx, filter, bson.M{
+		"$set":   bookingFields(reschedule, leaveItem.Portion),
+		"$push":  bson.M{"revisions": revision},
+		"$pull":  substitutesOffDates(reschedule.LeaveDates),
+		"$unset": bson.M{"reschedule": ""},
+	})
+	if err == nil && result.ModifiedCount == 0 {
+		err = errLeaveChanged
+	}
+	if err != nil {
+		// The request changed under us, so the new days go back to the pool
+		// rather than to its queue.
+		for _, date := range added {
+			r.unreserveDailyLeaveSlot(ctx, date, leaveItem, false)
+		}
+		return false, err
+	}
+
+	previous := *leaveItem
+	applyReschedule(leaveItem, reschedule, revision)
+
+	for _, date := range removed {
+		err = r.releaseDailyLeaveSlot(ctx, date, &previous)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+
+}
+
+func (r *leaveRepository) queueReschedule(ctx context.Context, leaveItem *LeaveRequests, reschedule *LeaveReschedule) error {
+
+	filter := bookingFilter(leaveItem)
+	filter["reschedule"] = bson.M{"$exists": false}
+
+	result, err := r.collectionLeave.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"reschedule": reschedule},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return errLeaveChanged
+	}
+
+	leaveItem.Reschedule = reschedule
+
+	entry := PendingRequest{
+		LeaveID:   leaveItem.ID,
+		UserID:    leaveItem.UserID,
+		UserName:  leaveItem.UserName,
+		Portion:   leaveItem.Portion,
+		PoolID:    leaveItem.PoolID,
+		Status:    "reschedule",
+		RequestAt: reschedule.RequestedAt,
+	}
+
+	for _, date := range diffDates(reschedule.LeaveDates, leaveItem.Dates()) {
+		err = r.pushPendingEntry(ctx, leaveItem.OrganizationID, date, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+func (r *leaveRepository) withdrawReschedule(ctx context.Context, leaveItem *LeaveRequests) error {
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":                     leaveItem.ID,
+		"reschedule.requested_at": leaveItem.Reschedule.RequestedAt,
+	}, bson.M{
+		"$unset": bson.M{"reschedule": ""},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return errLeaveChanged
+	}
+
+	err = r.dropQueuedReschedule(ctx, leaveItem)
+	if err != nil {
+		return err
+	}
+
+	leaveItem.Reschedule = nil
+
+	return nil
+
+}
+
+// dropQueuedReschedule takes a waiting move off the pending lists of the
+// days it was queued on.
+func (r *leaveRepository) dropQueuedReschedule(ctx context.Context, leaveItem *LeaveRequests) error {
+
+	// Released like a wish-list entry, which only touches pending_requests.
+	queued := *leaveItem
+	queued.RequestType = "wishlist"
+
+	for _, date := range diffDates(leaveItem.Reschedule.LeaveDates, leaveItem.Dates()) {
+		err := r.releaseDailyLeaveSlot(ctx, date, &queued)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// PromoteReschedules applies moves that were waiting for capacity on the
+// given day, oldest first, once every new day has room. Each returned
+// request has already released its old days.
+func (r *leaveRepository) PromoteReschedules(ctx context.Context, organizationID string, date time.Time) ([]*LeaveRequests, error) {
+
+	var dailyLeaveSlot DailyLeaveSolt
+
+	err := r.collectionDailyLeaveSlots.FindOne(ctx, slotFilter(organizationID, date)).Decode(&dailyLeaveSlot)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pendingRequests := dailyLeaveSlot.PendingRequests
+	sort.SliceStable(pendingRequests, func(i, j int) bool {
+		return pendingRequests[i].RequestAt.Before(pendingRequests[j].RequestAt)
+	})
+
+	var moved []*LeaveRequests
+
+	for _, pending := range pendingRequests {
+
+		var leaveItem LeaveRequests
+
+		err := r.collectionLeave.FindOne(ctx, bson.M{
+			"_id":        pending.LeaveID,
+			"status":     "confirmed",
+			"reschedule": bson.M{"$exists": true},
+		}).Decode(&leaveItem)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+
+		queued := leaveItem
+		queued.LeaveDates = diffDates(leaveItem.Reschedule.LeaveDates, leaveItem.Dates())
+
+		if len(queued.LeaveDates) == 0 {
+			continue
+		}
+
+		fits, err := r.fitsAllDates(ctx, &queued)
+		if err != nil {
+			return moved, err
+		}
+		if !fits {
+			continue
+		}
+
+		ok, err := r.moveConfirmedLeave(ctx, &leaveItem, leaveItem.Reschedule, true)
+		if ok {
+			moved = append(moved, &leaveItem)
+		}
+		if errors.Is(err, errLeaveChanged) {
+			continue
+		}
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+
+}
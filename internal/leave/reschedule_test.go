@@ -0,0 +1,99 @@
+package leave
+
+import (
+	"context"
+	"testing"
+	"time"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRescheduleLeaveRefusals(t *testing.T) {
+
+	day := time.Date(2030, time.March, 4, 0, 0, 0, 0, time.UTC)
+	closureID := primitive.NewObjectID()
+
+	closureLeave := &LeaveRequests{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: "org",
+		UserID:         "member",
+		LeaveType:      LeaveTypeClosure,
+		LeaveDates:     []time.Time{day},
+		Status:         "confirmed",
+		ClosureID:      &closureID,
+	}
+
+	legacyClosureLeave := &LeaveRequests{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: "org",
+		UserID:         "member",
+		LeaveType:      LeaveTypeClosure,
+		LeaveDates:     []time.Time{day},
+		Status:         "confirmed",
+	}
+
+	confirmed := &LeaveRequests{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: "org",
+		UserID:         "member",
+		LeaveType:      LeaveTypeAnnual,
+		LeaveDates:     []time.Time{day},
+		Status:         "confirmed",
+	}
+
+	tests := []struct {
+		name      string
+		caller    *user.CurrentUser
+		leaveItem *LeaveRequests
+		want      string
+	}{
+		{
+			name:      "someone else's leave",
+			caller:    &user.CurrentUser{ID: "colleague", OrganizationIdActive: "org"},
+			leaveItem: confirmed,
+			want:      "only the requester or an organization admin can reschedule this leave",
+		},
+		{
+			name:      "admin of another organization",
+			caller:    &user.CurrentUser{ID: "admin", OrganizationIdActive: "org", OrganizationAdmin: &user.OrganizationAdmin{ID: "other-org"}},
+			leaveItem: confirmed,
+			want:      "only the requester or an organization admin can reschedule this leave",
+		},
+		{
+			name:      "closure leave",
+			caller:    &user.CurrentUser{ID: "member", OrganizationIdActive: "org"},
+			leaveItem: closureLeave,
+			want:      "leave booked by a closure cannot be rescheduled",
+		},
+		{
+			name:      "closure leave without its closure id",
+			caller:    &user.CurrentUser{ID: "member", OrganizationIdActive: "org"},
+			leaveItem: legacyClosureLeave,
+			want:      "leave booked by a closure cannot be rescheduled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			service := &leaveService{
+				leaveRepository: &fakeLeaveRepository{
+					leaves: map[primitive.ObjectID]*LeaveRequests{tt.leaveItem.ID: tt.leaveItem},
+				},
+				userService: &fakeUserService{currentUser: tt.caller},
+			}
+
+			_, err := service.RescheduleLeave(context.Background(), &RescheduleLeaveRequest{
+				RequestedBy: tt.caller.ID,
+				StartDate:   "2030-03-11",
+			}, tt.leaveItem.ID.Hex())
+
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("RescheduleLeave error = %v, want %q", err, tt.want)
+			}
+
+		})
+	}
+
+}
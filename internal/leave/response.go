@@ -1,23 +1,29 @@
 package leave
 
+import "time"
+
 type LeaveStatistical struct {
 	TotalRequested         int            `bson:"total_requested" json:"total_requested"`
 	TotalConfirmed         int            `bson:"total_confirmed" json:"total_confirmed"`
 	TotalPending           int            `bson:"total_pending" json:"total_pending"`
 	TotalRejected          int            `bson:"total_rejected" json:"total_rejected"`
+	TotalCancelled         int            `bson:"total_cancelled" json:"total_cancelled"`
 	ImmediateRequests      int            `bson:"immediate_requests" json:"immediate_requests"`
 	ApproveRate            float64        `bson:"approve_rate" json:"approve_rate"`
+	TotalLeaveDays         float64        `bson:"total_leave_days" json:"total_leave_days"`
 	RequestsByMonth        []MonthlyStats `bson:"requests_by_month" json:"requests_by_month"`
 	RequestByWeekDay       []WeeklyStats  `bson:"request_by_week_day" json:"request_by_week_day"`
+	RequestsByType         []TypeStats    `bson:"requests_by_type" json:"requests_by_type"`
 	TopRequestUsers        []UserStats    `bson:"top_request_users" json:"top_request_users"`
 	AverageRequestsPerUser float64        `bson:"average_requests_per_user" json:"average_requests_per_user"`
 }
 
 type MonthlyStats struct {
-	Month    string `json:"month"`
-	Count    int    `json:"count"`
-	Approved int    `json:"approved"`
-	Rejected int    `json:"rejected"`
+	Month     string  `json:"month"`
+	Count     int     `json:"count"`
+	Approved  int     `json:"approved"`
+	Rejected  int     `json:"rejected"`
+	LeaveDays float64 `json:"leave_days"`
 }
 
 type WeeklyStats struct {
@@ -25,10 +31,97 @@ type WeeklyStats struct {
 	Count   int    `json:"count"`
 }
 
+type TypeStats struct {
+	LeaveType string  `json:"leave_type"`
+	Count     int     `json:"count"`
+	Confirmed int     `json:"confirmed"`
+	Pending   int     `json:"pending"`
+	Rejected  int     `json:"rejected"`
+	LeaveDays float64 `json:"leave_days"`
+}
+
 type UserStats struct {
 	UserID           string  `json:"user_id"`
 	UserName         string  `json:"user_name"`
 	TotalRequests    int     `json:"total_requests"`
 	ApprovedRequests int     `json:"approved_requests"`
 	ApprovalRate     float64 `json:"approval_rate"`
-}
\ No newline at end of file
+	LeaveDays        float64 `json:"leave_days"`
+}
+
+// AnalyticsBucket counts the requests with at least one day in the bucket,
+// by status. LeaveDays sums confirmed leave only, PendingDays what is still
+// waiting; a half day counts as 0.5.
+type AnalyticsBucket struct {
+	Key         string  `bson:"_id" json:"key"`
+	Name        string  `bson:"name" json:"name,omitempty"`
+	Requests    int     `bson:"requests" json:"requests"`
+	Confirmed   int     `bson:"confirmed" json:"confirmed"`
+	Pending     int     `bson:"pending" json:"pending"`
+	Rejected    int     `bson:"rejected" json:"rejected"`
+	Cancelled   int     `bson:"cancelled" json:"cancelled"`
+	Immediate   int     `bson:"immediate" json:"immediate"`
+	Users       int     `bson:"users" json:"users"`
+	LeaveDays   float64 `bson:"leave_days" json:"leave_days"`
+	PendingDays float64 `bson:"pending_days" json:"pending_days"`
+}
+
+type LeaveAnalytics struct {
+	GroupBy  string             `json:"group_by"`
+	DateFrom string             `json:"date_from"`
+	DateTo   string             `json:"date_to"`
+	Total    *AnalyticsBucket   `json:"total"`
+	Buckets  []*AnalyticsBucket `json:"buckets"`
+}
+
+type LeaveBalanceResponse struct {
+	Balance      *UserLeaveBalance   `json:"balance"`
+	Entitlement  *Entitlement        `json:"entitlement"`
+	Transactions []*LeaveTransaction `json:"transactions"`
+}
+
+// ClosureReport sums up a closure's per-member results.
+type ClosureReport struct {
+	Closure *LeaveClosure `json:"closure"`
+	Members int           `json:"members"`
+	Created int           `json:"created"`
+	Skipped int           `json:"skipped"`
+	Failed  int           `json:"failed"`
+}
+
+// CompOffBalance is the comp-off a user can still spend, with the approved
+// credits it comes from, soonest to expire first.
+type CompOffBalance struct {
+	UserID    string          `json:"user_id"`
+	Available float64         `json:"available"`
+	Credits   []*CompOffClaim `json:"credits"`
+}
+
+// Entitlement explains how a leave year's annual leave was worked out.
+type Entitlement struct {
+	Year           int        `json:"year"`
+	StartDate      *time.Time `json:"start_date,omitempty"`
+	TenureYears    int        `json:"tenure_years"`
+	BaseDays       float64    `json:"base_days"`
+	SeniorityDays  float64    `json:"seniority_days"`
+	EmployedMonths int        `json:"employed_months"`
+	Days           float64    `json:"days"`
+
+	firstMonth int
+}
+
+type UncoveredDay struct {
+	Date      string `json:"date"`
+	LeaveID   string `json:"leave_id"`
+	UserID    string `json:"user_id"`
+	UserName  string `json:"user_name"`
+	Portion   string `json:"portion"`
+	LeaveType string `json:"leave_type"`
+}
+
+// CalendarFeedResponse carries the feed URL. The token in it is shown only
+// when the feed is created.
+type CalendarFeedResponse struct {
+	Feed *CalendarFeed `json:"feed"`
+	URL  string        `json:"url"`
+}
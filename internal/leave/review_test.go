@@ -0,0 +1,183 @@
+package leave
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestReviewRequestLeave(t *testing.T) {
+
+	admin := &user.CurrentUser{ID: "admin-1", OrganizationIdActive: "org-1", IsSuperAdmin: true}
+	teacher := &user.CurrentUser{ID: "teacher-2", OrganizationIdActive: "org-1"}
+	comment := "enjoy"
+
+	tests := []struct {
+		name    string
+		reject  bool
+		caller  *user.CurrentUser
+		request ReviewLeaveRequest
+		leave   LeaveRequests
+		full    bool
+
+		wantErr     string
+		wantStatus  string
+		wantCharged float64
+	}{
+		{
+			name:        "approve a pending annual leave",
+			caller:      admin,
+			request:     ReviewLeaveRequest{ReviewerID: "admin-1", Comment: &comment},
+			leave:       LeaveRequests{UserID: "teacher-1", Status: "pending", RequestType: "pending"},
+			wantStatus:  "confirmed",
+			wantCharged: 2,
+		},
+		{
+			name:       "reject a pending leave",
+			reject:     true,
+			caller:     admin,
+			request:    ReviewLeaveRequest{ReviewerID: "admin-1", Comment: &comment},
+			leave:      LeaveRequests{UserID: "teacher-1", Status: "pending", RequestType: "pending"},
+			wantStatus: "rejected",
+		},
+		{
+			name:    "a teacher cannot review",
+			caller:  teacher,
+			request: ReviewLeaveRequest{ReviewerID: "teacher-2"},
+			leave:   LeaveRequests{UserID: "teacher-1", Status: "pending"},
+			wantErr: "only organization admins can review leave requests",
+		},
+		{
+			name:    "the reviewer id is required",
+			caller:  admin,
+			leave:   LeaveRequests{UserID: "teacher-1", Status: "pending"},
+			wantErr: "reviewer id is required",
+		},
+		{
+			name:    "nobody reviews their own leave",
+			reject:  true,
+			caller:  admin,
+			request: ReviewLeaveRequest{ReviewerID: "admin-1"},
+			leave:   LeaveRequests{UserID: "admin-1", Status: "pending"},
+			wantErr: "your own leave request",
+		},
+		{
+			name:    "a reviewed leave stays reviewed",
+			caller:  admin,
+			request: ReviewLeaveRequest{ReviewerID: "admin-1"},
+			leave:   LeaveRequests{UserID: "teacher-1", Status: "rejected"},
+			wantErr: "leave request is rejected",
+		},
+		{
+			name:    "a sick leave waits for its certificate",
+			caller:  admin,
+			request: ReviewLeaveRequest{ReviewerID: "admin-1"},
+			leave: LeaveRequests{
+				UserID:    "teacher-1",
+				Status:    "pending",
+				LeaveType: LeaveTypeSick,
+				Policy:    &LeavePolicy{ConsumesSlot: true, RequiresAttachment: true},
+			},
+			wantErr: "needs a supporting document",
+		},
+		{
+			name:    "a full day is refused",
+			caller:  admin,
+			request: ReviewLeaveRequest{ReviewerID: "admin-1"},
+			leave:   LeaveRequests{UserID: "teacher-1", Status: "pending"},
+			full:    true,
+			wantErr: "no capacity left",
+		},
+		{
+			name:        "the reviewer can override a full day",
+			caller:      admin,
+			request:     ReviewLeaveRequest{ReviewerID: "admin-1", OverrideCapacity: true},
+			leave:       LeaveRequests{UserID: "teacher-1", Status: "pending"},
+			full:        true,
+			wantStatus:  "confirmed",
+			wantCharged: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			leaveItem := tt.leave
+			leaveItem.ID = primitive.NewObjectID()
+			leaveItem.OrganizationID = "org-1"
+			leaveItem.StartDate = parseDay("2030-03-04")
+			leaveItem.EndDate = parseDay("2030-03-05")
+			leaveItem.LeaveDates = []time.Time{leaveItem.StartDate, leaveItem.EndDate}
+			leaveItem.Portion = PortionFull
+			if leaveItem.LeaveType == "" {
+				leaveItem.LeaveType = LeaveTypeAnnual
+			}
+
+			repository := &fakeLeaveRepository{
+				leaves: map[primitive.ObjectID]*LeaveRequests{leaveItem.ID: &leaveItem},
+				full:   tt.full,
+			}
+			service := &leaveService{
+				leaveRepository: repository,
+				userService:     &fakeUserService{currentUser: tt.caller},
+			}
+
+			review := service.ApproveRequestLeave
+			if tt.reject {
+				review = service.RejectRequestLeave
+			}
+
+			err := review(context.Background(), &tt.request, leaveItem.ID.Hex())
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				if leaveItem.Status != tt.leave.Status || leaveItem.Review != nil {
+					t.Errorf("refused review changed the leave to %s with %+v", leaveItem.Status, leaveItem.Review)
+				}
+				if len(repository.transactions) != 0 {
+					t.Errorf("refused review charged %d transactions", len(repository.transactions))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("review: %v", err)
+			}
+
+			if leaveItem.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", leaveItem.Status, tt.wantStatus)
+			}
+
+			wantAction := ReviewApproved
+			if tt.reject {
+				wantAction = ReviewRejected
+			}
+			got := leaveItem.Review
+			if got == nil || got.Action != wantAction || got.ReviewerID != tt.request.ReviewerID || got.Comment != tt.request.Comment {
+				t.Fatalf("review = %+v, want %s by %s", got, wantAction, tt.request.ReviewerID)
+			}
+			if got.CapacityOverridden != (tt.request.OverrideCapacity && !tt.reject) {
+				t.Errorf("capacity overridden = %v", got.CapacityOverridden)
+			}
+			if got.ReviewedAt.IsZero() {
+				t.Error("review has no time")
+			}
+
+			var charged float64
+			for _, transaction := range repository.transactions {
+				charged += transaction.Amount
+			}
+			if charged != tt.wantCharged {
+				t.Errorf("charged %v days, want %v", charged, tt.wantCharged)
+			}
+
+		})
+	}
+
+}
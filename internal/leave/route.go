@@ -13,16 +13,75 @@ func RegisterRoutes(r *gin.Engine, handler *LeaveHandler) {
 		leaveGroup.POST("", handler.CreateRequestLeave)
 		leaveGroup.DELETE("/delete-request", handler.DeleteRequestLeave)
 		leaveGroup.GET("/my-request", handler.GetMyRequest)
+		leaveGroup.GET("/notifications", handler.GetMyNotifications)
+		leaveGroup.PUT("/notifications/:id/read", handler.MarkNotificationRead)
 		leaveGroup.GET("pending-request", handler.GetPendingRequest)
 		leaveGroup.PUT("/:id", handler.UpdateRequestLeave)
+		leaveGroup.POST("/:id/approve", handler.ApproveRequestLeave)
+		leaveGroup.POST("/:id/reject", handler.RejectRequestLeave)
+		leaveGroup.POST("/:id/cancel", handler.CancelRequestLeave)
+		leaveGroup.POST("/:id/cancel/approve", handler.ApproveCancellation)
+		leaveGroup.POST("/:id/cancel/reject", handler.RejectCancellation)
+		leaveGroup.POST("/:id/reschedule", handler.RescheduleLeave)
+		leaveGroup.GET("/:id", handler.GetLeaveDetail)
+		leaveGroup.GET("/:id/audit", handler.GetLeaveAudits)
+		leaveGroup.POST("/:id/substitutes", handler.ProposeSubstitute)
+		leaveGroup.PUT("/:id/substitutes/:substitute-id", handler.UpdateSubstituteStatus)
+		leaveGroup.DELETE("/:id/substitutes/:substitute-id", handler.RemoveSubstitute)
+		leaveGroup.POST("/:id/attachments", handler.UploadAttachments)
+		leaveGroup.GET("/:id/attachments/:attachment-id", handler.DownloadAttachment)
+		leaveGroup.DELETE("/:id/attachments/:attachment-id", handler.DeleteAttachment)
 		leaveGroup.GET("/statistical", handler.GetStatistical)
+		leaveGroup.GET("/analytics", handler.GetLeaveAnalytics)
+		leaveGroup.GET("/uncovered", handler.GetUncoveredLeaves)
 
-		// leaveGroup.GET("leave-balance/:user-id", handler.GetLeaveBalanceUser)
+		leaveGroup.GET("/balance/:user-id", handler.GetLeaveBalanceUser)
+		leaveGroup.PUT("/balance/:user-id/start-date", handler.SetEmploymentStartDate)
+		leaveGroup.GET("/entitlement-table", handler.GetEntitlementTable)
+		leaveGroup.PUT("/entitlement-table", handler.UpdateEntitlementTable)
+		leaveGroup.GET("/comp-off/claims", handler.GetCompOffClaims)
+		leaveGroup.POST("/comp-off/claims", handler.ClaimCompOff)
+		leaveGroup.POST("/comp-off/claims/:id/approve", handler.ApproveCompOffClaim)
+		leaveGroup.POST("/comp-off/claims/:id/reject", handler.RejectCompOffClaim)
+		leaveGroup.GET("/comp-off/balance/:user-id", handler.GetCompOffBalance)
 		leaveGroup.GET("/calendar", handler.GetAllLeaveCalendar)
 		leaveGroup.GET("/calendar/:id", handler.GetDetailLeaveCalendar)
 		leaveGroup.PUT("/calendar/:id", handler.EditMaxSlot)
 
+		leaveGroup.GET("/types", handler.GetLeaveTypes)
+		leaveGroup.POST("/types", handler.CreateLeaveType)
+		leaveGroup.PUT("/types/:id", handler.UpdateLeaveType)
+
+		leaveGroup.GET("/capacity-rules", handler.GetCapacityRules)
+		leaveGroup.POST("/capacity-rules", handler.CreateCapacityRule)
+		leaveGroup.PUT("/capacity-rules/:id", handler.UpdateCapacityRule)
+		leaveGroup.DELETE("/capacity-rules/:id", handler.DeleteCapacityRule)
+
+		leaveGroup.GET("/capacity-pools", handler.GetCapacityPools)
+		leaveGroup.POST("/capacity-pools", handler.CreateCapacityPool)
+		leaveGroup.PUT("/capacity-pools/:id", handler.UpdateCapacityPool)
+		leaveGroup.DELETE("/capacity-pools/:id", handler.DeleteCapacityPool)
+
+		leaveGroup.GET("/closures", handler.GetClosures)
+		leaveGroup.POST("/closures", handler.CreateClosure)
+
+		leaveGroup.GET("/blackouts", handler.GetBlackoutPeriods)
+		leaveGroup.POST("/blackouts", handler.CreateBlackoutPeriod)
+		leaveGroup.PUT("/blackouts/:id", handler.UpdateBlackoutPeriod)
+		leaveGroup.DELETE("/blackouts/:id", handler.DeleteBlackoutPeriod)
+
 		leaveGroup.GET("/setting", handler.GetSetting)
+		leaveGroup.PUT("/setting", handler.UpdateSetting)
+		// Settings are per organization now, so the id is ignored; the path
+		// stays for clients built against the old API.
 		leaveGroup.PUT("/setting/:id", handler.UpdateSetting)
+
+		leaveGroup.GET("/feeds", handler.GetCalendarFeeds)
+		leaveGroup.POST("/feeds", handler.CreateCalendarFeed)
+		leaveGroup.DELETE("/feeds/:id", handler.RevokeCalendarFeed)
 	}
+
+	// Calendar clients cannot send a Bearer token; the feed token is the
+	// credential.
+	r.GET("/api/v1/leave/ics/:token", handler.GetCalendarFeedICS)
 }	
\ No newline at end of file
@@ -4,7 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime/multipart"
 	"time"
+	"worktime-service/internal/gateway"
+	"worktime-service/internal/shared"
 	"worktime-service/internal/user"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,29 +18,85 @@ import (
 
 type LeaveService interface {
 	CreateRequestLeave(ctx context.Context, req *CreateLeaveRequest) error
+	CancelRequestLeave(ctx context.Context, req *CancelLeaveRequest, id string) (*LeaveRequests, error)
+	ApproveCancellation(ctx context.Context, req *ReviewLeaveRequest, id string) (*LeaveRequests, error)
+	RejectCancellation(ctx context.Context, req *ReviewLeaveRequest, id string) (*LeaveRequests, error)
+	RescheduleLeave(ctx context.Context, req *RescheduleLeaveRequest, id string) (*LeaveRequests, error)
+	GetLeaveDetail(ctx context.Context, id string) (*LeaveRequests, error)
+	ProposeSubstitute(ctx context.Context, req *SubstituteRequest, id string) (*LeaveRequests, error)
+	UpdateSubstituteStatus(ctx context.Context, req *SubstituteStatusRequest, id string, substituteID string) (*LeaveRequests, error)
+	RemoveSubstitute(ctx context.Context, id string, substituteID string) (*LeaveRequests, error)
+	GetUncoveredLeaves(ctx context.Context, dateFrom string, dateTo string) ([]*UncoveredDay, error)
+	UploadAttachments(ctx context.Context, id string, files []*multipart.FileHeader, uploadedBy string) (*LeaveRequests, error)
+	OpenAttachment(ctx context.Context, id string, attachmentID string) (*Attachment, io.ReadCloser, error)
+	DeleteAttachment(ctx context.Context, id string, attachmentID string) error
 	GetAllLeaveCalendar(ctx context.Context, date string) ([]*DailyLeaveSolt, error)
 	GetDetailLeaveCalendar(ctx context.Context, id string) (*DailyLeaveSolt, error)
-	GetSettings(ctx context.Context) *Setting
-	UpdateSetting(ctx context.Context, req *SettingRequest, id string) (*Setting, error)
+	GetSettings(ctx context.Context) (*Setting, error)
+	UpdateSetting(ctx context.Context, req *SettingRequest) (*Setting, error)
 	GetMyRequest(ctx context.Context, userID string) (map[string][]*LeaveRequests, error)
 	EditMaxSlot(ctx context.Context, req *EditSlotRequest, id string) error
 	GetPendingRequest(ctx context.Context) ([]*LeaveRequests, error)
 	DeleteRequestLeave(ctx context.Context, req *DeleteLeaveRequest) error
 	UpdateRequestLeave(ctx context.Context, req *UpdateRequest, id string) error
+	ApproveRequestLeave(ctx context.Context, req *ReviewLeaveRequest, id string) error
+	RejectRequestLeave(ctx context.Context, req *ReviewLeaveRequest, id string) error
 	GetStatistical(ctx context.Context, dateFrom string, dateTo string) (*LeaveStatistical, error)
-	// AddCronLeavesBalance(ctx context.Context) error
-	// GetLeaveBalanceUser(ctx context.Context, userID string) (interface{}, error)
+	GetLeaveAnalytics(ctx context.Context, dateFrom string, dateTo string, groupBy string, top int) (*LeaveAnalytics, error)
+	AddCronLeavesBalance(ctx context.Context) error
+	GetLeaveBalanceUser(ctx context.Context, userID string) (*LeaveBalanceResponse, error)
+	GetLeaveTypes(ctx context.Context) ([]*LeaveType, error)
+	CreateLeaveType(ctx context.Context, req *LeaveTypeRequest) (*LeaveType, error)
+	UpdateLeaveType(ctx context.Context, req *LeaveTypeRequest, id string) (*LeaveType, error)
+	GetCapacityRules(ctx context.Context) ([]*CapacityRule, error)
+	CreateCapacityRule(ctx context.Context, req *CapacityRuleRequest) (*CapacityRule, error)
+	UpdateCapacityRule(ctx context.Context, req *CapacityRuleRequest, id string) (*CapacityRule, error)
+	DeleteCapacityRule(ctx context.Context, id string) error
+	GetCapacityPools(ctx context.Context) ([]*CapacityPool, error)
+	CreateCapacityPool(ctx context.Context, req *CapacityPoolRequest) (*CapacityPool, error)
+	UpdateCapacityPool(ctx context.Context, req *CapacityPoolRequest, id string) (*CapacityPool, error)
+	DeleteCapacityPool(ctx context.Context, id string) error
+	GetBlackoutPeriods(ctx context.Context) ([]*BlackoutPeriod, error)
+	CreateBlackoutPeriod(ctx context.Context, req *BlackoutPeriodRequest) (*BlackoutPeriod, error)
+	UpdateBlackoutPeriod(ctx context.Context, req *BlackoutPeriodRequest, id string) (*BlackoutPeriod, error)
+	DeleteBlackoutPeriod(ctx context.Context, id string) error
+	CreateCalendarFeed(ctx context.Context, req *CalendarFeedRequest) (*CalendarFeedResponse, error)
+	GetCalendarFeeds(ctx context.Context) ([]*CalendarFeed, error)
+	RevokeCalendarFeed(ctx context.Context, id string) error
+	RenderCalendarFeed(ctx context.Context, token string) (string, error)
+	GetEntitlementTable(ctx context.Context) (*EntitlementTable, error)
+	UpdateEntitlementTable(ctx context.Context, req *EntitlementTableRequest) (*EntitlementTable, error)
+	SetEmploymentStartDate(ctx context.Context, req *EmploymentStartDateRequest, userID string) (*EmploymentStartDate, error)
+	ClaimCompOff(ctx context.Context, req *CompOffClaimRequest) ([]*CompOffClaim, error)
+	GetCompOffClaims(ctx context.Context, userID string, status string) ([]*CompOffClaim, error)
+	ApproveCompOffClaim(ctx context.Context, req *ReviewLeaveRequest, id string) (*CompOffClaim, error)
+	RejectCompOffClaim(ctx context.Context, req *ReviewLeaveRequest, id string) (*CompOffClaim, error)
+	GetCompOffBalance(ctx context.Context, userID string) (*CompOffBalance, error)
+	ExpireCompOffCredits(ctx context.Context) error
+	ExpireStalePendingRequests(ctx context.Context) error
+	GetLeaveAudits(ctx context.Context, id string) ([]*LeaveAudit, error)
+	GetMyNotifications(ctx context.Context) ([]*LeaveNotification, error)
+	MarkNotificationRead(ctx context.Context, id string) error
+	CreateClosure(ctx context.Context, req *ClosureRequest) (*ClosureReport, error)
+	GetClosures(ctx context.Context) ([]*LeaveClosure, error)
+	SyncHolidaySlots(ctx context.Context, organizationID string) error
 }
 
 type leaveService struct {
 	leaveRepository LeaveRepository
 	userService     user.UserService
+	holidayCalendar shared.HolidayCalendar
+	termGateway     gateway.TermGateway
+	overtimeSource  shared.OvertimeSource
 }
 
-func NewLeaveService(leaveRepository LeaveRepository, userService user.UserService) LeaveService {
+func NewLeaveService(leaveRepository LeaveRepository, userService user.UserService, holidayCalendar shared.HolidayCalendar, termGateway gateway.TermGateway, overtimeSource shared.OvertimeSource) LeaveService {
 	return &leaveService{
 		leaveRepository: leaveRepository,
 		userService:     userService,
+		holidayCalendar: holidayCalendar,
+		termGateway:     termGateway,
+		overtimeSource:  overtimeSource,
 	}
 }
 
@@ -44,7 +106,16 @@ func (s *leaveService) CreateRequestLeave(ctx context.Context, req *CreateLeaveR
 		return errors.New("user ID is empty")
 	}
 
-	if req.LeaveDate == "" {
+	// A single-day request only sends leave_date.
+	if req.StartDate == "" {
+		req.StartDate = req.LeaveDate
+	}
+
+	if req.EndDate == "" {
+		req.EndDate = req.StartDate
+	}
+
+	if req.StartDate == "" {
 		return errors.New("leave date is empty")
 	}
 
@@ -53,17 +124,157 @@ func (s *leaveService) CreateRequestLeave(ctx context.Context, req *CreateLeaveR
 		return err
 	}
 
-	dateParse, err := time.Parse("2006-01-02", req.LeaveDate)
+	startDate, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		return err
+	}
+
+	endDate, err := time.Parse("2006-01-02", req.EndDate)
+	if err != nil {
+		return err
+	}
+
+	err = checkLeaveRange(startDate, endDate)
+	if err != nil {
+		return err
+	}
+
+	if req.Portion == "" {
+		req.Portion = PortionFull
+	}
+
+	if req.LeaveType == "" {
+		req.LeaveType = LeaveTypeAnnual
+	}
+
+	if req.LeaveType == LeaveTypeClosure {
+		return errors.New("closure leave is booked by the organization and cannot be requested")
+	}
+
+	switch req.Portion {
+	case PortionFull:
+	case PortionAM, PortionPM:
+		if !endDate.Equal(startDate) {
+			return errors.New("half-day leave must be a single day")
+		}
+	default:
+		return fmt.Errorf("invalid portion, must be %s, %s or %s", PortionFull, PortionAM, PortionPM)
+	}
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+
+	if currentUser.OrganizationIdActive == "" {
+		return errors.New("user has no active organization")
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	s.rememberStartDate(ctx, currentUser)
+
+	leaveDates, err := s.getWorkingDays(ctx, organizationID, startDate, endDate)
+	if err != nil {
+		return err
+	}
+
+	if len(leaveDates) == 0 {
+		return errors.New("leave range has no working days")
+	}
+
+	leaveType, err := s.leaveRepository.GetLeaveTypeByCode(ctx, organizationID, req.LeaveType)
+	if err != nil {
+		return err
+	}
+
+	if !leaveType.IsActive {
+		return fmt.Errorf("leave type %s is not active", leaveType.Code)
+	}
+
+	if !leaveType.requestable() {
+		return fmt.Errorf("%s leave is booked by the organization and cannot be requested", leaveType.Code)
+	}
+
+	setting, err := s.leaveRepository.GetSettings(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	policy := leaveType.Policy
+
+	window := bookingWindow(setting, policy)
+	policyErr := evaluateBookingWindow(window, leaveType.Code, startDate, endDate, leaveToday(time.Now()))
+	if policyErr == nil {
+		policyErr = &PolicyError{}
+	}
+
+	blackoutWindows, err := s.getBlackoutWindows(ctx, organizationID)
+	if err != nil {
+		return err
+	}
+
+	violations, approvalWindow := checkBlackout(blackoutWindows, leaveType.Code, leaveDates)
+	policyErr.Violations = append(policyErr.Violations, violations...)
+
+	if policy.UsesCompOff {
+		violation, err := s.checkCompOff(ctx, organizationID, req.UserID, leaveType.Code, float64(len(leaveDates))*portionDays(req.Portion))
+		if err != nil {
+			return err
+		}
+		if violation != nil {
+			policyErr.Violations = append(policyErr.Violations, *violation)
+		}
+	}
+
+	if len(policyErr.Violations) > 0 {
+		return policyErr
+	}
+
+	// Documents can only be uploaded once the request exists, so a type
+	// that needs one waits for a reviewer instead of confirming without it.
+	if policy.RequiresAttachment {
+		policy.RequiresApproval = true
+	}
+
+	var blackoutID *primitive.ObjectID
+	if approvalWindow != nil {
+		policy.RequiresApproval = true
+		blackoutID = &approvalWindow.period.ID
+	}
+
+	var poolID *primitive.ObjectID
+	if policy.ConsumesSlot {
+		pool, err := s.resolvePool(ctx, organizationID, req.UserID)
+		if err != nil {
+			return err
+		}
+		if pool != nil {
+			poolID = &pool.ID
+		}
+	}
+
+	// Teachers' classes need cover while they are away.
+	requiresCover, _, err := s.isTeacher(ctx, organizationID, req.UserID)
 	if err != nil {
 		return err
 	}
 
 	leaveItem := LeaveRequests{
-		LeaveDate:   dateParse,
-		UserID:      req.UserID,
-		UserName:    user.UserName,
-		Reason:      req.Reason,
-		RequestedAt: time.Now(),
+		OrganizationID: organizationID,
+		StartDate:      startDate,
+		EndDate:        endDate,
+		LeaveDates:     leaveDates,
+		Portion:        req.Portion,
+		LeaveType:      leaveType.Code,
+		Policy:         &policy,
+		UserID:         req.UserID,
+		PoolID:         poolID,
+		RequiresCover:  requiresCover,
+		UserName:       user.UserName,
+		Reason:         req.Reason,
+		RequestedAt:    time.Now(),
+		BlackoutID:     blackoutID,
 	}
 
 	err = s.leaveRepository.CreateLeave(ctx, &leaveItem)
@@ -71,9 +282,46 @@ func (s *leaveService) CreateRequestLeave(ctx context.Context, req *CreateLeaveR
 		return err
 	}
 
+	if leaveItem.Status == "confirmed" {
+		if err := s.debitLeaveBalance(ctx, &leaveItem); err != nil {
+			return s.undoLeave(ctx, &leaveItem, err)
+		}
+	}
+
 	return nil
 }
 
+// getWorkingDays lists the days between start and end, inclusive, that can
+// actually be taken as leave: weekends and the organization's public
+// holidays are skipped.
+func (s *leaveService) getWorkingDays(ctx context.Context, organizationID string, start time.Time, end time.Time) ([]time.Time, error) {
+
+	holidays := map[string]string{}
+
+	if s.holidayCalendar != nil {
+		var err error
+		holidays, err = s.holidayCalendar.GetHolidaysInRange(ctx, organizationID, start, end)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var days []time.Time
+
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		if _, ok := holidays[day.Format("2006-01-02")]; ok {
+			continue
+		}
+		days = append(days, day)
+	}
+
+	return days, nil
+
+}
+
 func (s *leaveService) GetAllLeaveCalendar(ctx context.Context, date string) ([]*DailyLeaveSolt, error) {
 
 	var dateFilter *time.Time
@@ -86,11 +334,30 @@ func (s *leaveService) GetAllLeaveCalendar(ctx context.Context, date string) ([]
 		dateFilter = &parsed
 	}
 
-	data, err := s.leaveRepository.GetDailyLeaveSlots(ctx, dateFilter)
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.leaveRepository.GetDailyLeaveSlots(ctx, organizationID, dateFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	blackoutWindows, err := s.getBlackoutWindows(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	markBlackoutDays(blackoutWindows, data)
+
+	pools, err := s.leaveRepository.GetCapacityPools(ctx, organizationID)
 	if err != nil {
 		return nil, err
 	}
 
+	markPoolAvailability(pools, data)
+
 	return data, nil
 }
 
@@ -105,43 +372,132 @@ func (s *leaveService) GetDetailLeaveCalendar(ctx context.Context, id string) (*
 		return nil, err
 	}
 
-	data, err := s.leaveRepository.GetDetailLeaveSlots(ctx, objectID)
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.leaveRepository.GetDetailLeaveSlots(ctx, organizationID, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	pools, err := s.leaveRepository.GetCapacityPools(ctx, organizationID)
 	if err != nil {
 		return nil, err
 	}
 
+	markPoolAvailability(pools, []*DailyLeaveSolt{data})
+
 	return data, nil
 }
 
-func (s *leaveService) GetSettings(ctx context.Context) *Setting {
-	return s.leaveRepository.GetSettings(ctx)
+func (s *leaveService) GetSettings(ctx context.Context) (*Setting, error) {
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetSettings(ctx, organizationID)
+
 }
 
-func (s *leaveService) UpdateSetting(ctx context.Context, req *SettingRequest, id string) (*Setting, error) {
+func (s *leaveService) UpdateSetting(ctx context.Context, req *SettingRequest) (*Setting, error) {
 
-	objectID, err := primitive.ObjectIDFromHex(id)
+	currentUser, err := s.getOrganizationAdmin(ctx, "change leave settings")
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	current, err := s.leaveRepository.GetSettings(ctx, organizationID)
 	if err != nil {
 		return nil, err
 	}
 
-	setting := Setting{
-		MaxEmployeesPerDay: req.MaxEmployeesPerDay,
-		AdvanceBookingDays: req.AdvanceBookingDays,
-		UpdatedAt:          time.Now(),
+	setting := *current
+	setting.UpdatedAt = time.Now()
+
+	setInt := func(field *int, value *int) {
+		if value != nil {
+			*field = *value
+		}
+	}
+
+	setInt(&setting.MaxEmployeesPerDay, req.MaxEmployeesPerDay)
+	setInt(&setting.AdvanceBookingDays, req.AdvanceBookingDays)
+	setInt(&setting.MaxBookingDays, req.MaxBookingDays)
+	setInt(&setting.CancelNoticeDays, req.CancelNoticeDays)
+	setInt(&setting.CarryOverExpiryMonths, req.CarryOverExpiryMonths)
+	setInt(&setting.CompOffExpiryDays, req.CompOffExpiryDays)
+	setInt(&setting.PendingExpiryDays, req.PendingExpiryDays)
+
+	if req.CarryOverMaxDays != nil {
+		setting.CarryOverMaxDays = *req.CarryOverMaxDays
+	}
+
+	if setting.AdvanceBookingDays < 0 || setting.MaxBookingDays < 0 || setting.CancelNoticeDays < 0 {
+		return nil, fmt.Errorf("booking and cancel notice days must not be negative")
+	}
+
+	if setting.MaxBookingDays > 0 && setting.MaxBookingDays < setting.AdvanceBookingDays {
+		return nil, fmt.Errorf("max booking days must not be less than advance booking days")
+	}
+
+	if setting.CarryOverMaxDays < 0 {
+		return nil, fmt.Errorf("carry over max days must not be negative")
+	}
+
+	if setting.CarryOverExpiryMonths < 0 || setting.CarryOverExpiryMonths > 12 {
+		return nil, fmt.Errorf("carry over expiry months must be between 0 and 12")
+	}
+
+	if setting.CompOffExpiryDays < 0 {
+		return nil, fmt.Errorf("comp-off expiry days must not be negative")
+	}
+
+	if setting.PendingExpiryDays < 0 {
+		return nil, fmt.Errorf("pending expiry days must not be negative")
+	}
+
+	switch req.YearBoundary {
+	case "":
+	case YearBoundaryCalendar:
+		setting.YearBoundary = YearBoundaryCalendar
+		setting.YearStartMonth = int(time.January)
+		setting.YearStartDay = 1
+	case YearBoundarySchool:
+		start, err := s.schoolYearStart(ctx, organizationID, req.TermID)
+		if err != nil {
+			return nil, err
+		}
+		setting.YearBoundary = YearBoundarySchool
+		setting.YearStartMonth = int(start.Month())
+		setting.YearStartDay = start.Day()
+	default:
+		return nil, fmt.Errorf("invalid year boundary: %s", req.YearBoundary)
 	}
 
-	settingResult, err := s.leaveRepository.UpdateSetting(ctx, &setting, objectID)
+	settingResult, err := s.leaveRepository.UpdateSetting(ctx, &setting, organizationID)
 	if err != nil {
 		return nil, err
 	}
 
-	return settingResult, nil
+	// MaxEmployeesPerDay is the capacity of every day no rule covers.
+	return settingResult, s.recomputeCapacity(ctx, organizationID)
 
 }
 
 func (s *leaveService) GetMyRequest(ctx context.Context, userID string) (map[string][]*LeaveRequests, error) {
 
-	data, err := s.leaveRepository.GetMyRequest(ctx, userID)
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.leaveRepository.GetMyRequest(ctx, organizationID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -159,301 +515,935 @@ func (s *leaveService) EditMaxSlot(ctx context.Context, req *EditSlotRequest, id
 		return err
 	}
 
-	detailLeaveSlots, err := s.leaveRepository.GetDetailLeaveSlots(ctx, objectID)
+	currentUser, err := s.getOrganizationAdmin(ctx, "change daily leave capacity")
 	if err != nil {
 		return err
 	}
 
-	var AvailableSlot int
+	organizationID := currentUser.OrganizationIdActive
 
-	if req.MaxSlot > len(detailLeaveSlots.ConfirmedLeaves) {
-		AvailableSlot = req.MaxSlot - len(detailLeaveSlots.ConfirmedLeaves)
-	} else {
-		AvailableSlot = 0
+	detailLeaveSlots, err := s.leaveRepository.GetDetailLeaveSlots(ctx, organizationID, objectID)
+	if err != nil {
+		return err
 	}
 
 	if req.MaxSlot <= 0 {
 		return fmt.Errorf("max slot is required")
 	}
 
-	return s.leaveRepository.EditMaxSlot(ctx, req.MaxSlot, AvailableSlot, objectID)
-
-}
-
-func (s *leaveService) groupedRequestType(leaveRequest []*LeaveRequests) map[string][]*LeaveRequests {
+	usedAM, usedPM := sharedSlotUsage(detailLeaveSlots.ConfirmedLeaves)
 
-	grouped := make(map[string][]*LeaveRequests)
+	availableAMSlot := max(req.MaxSlot-usedAM, 0)
+	availablePMSlot := max(req.MaxSlot-usedPM, 0)
 
-	for _, item := range leaveRequest {
-		grouped[item.RequestType] = append(grouped[item.RequestType], item)
+	err = s.leaveRepository.EditMaxSlot(ctx, req.MaxSlot, availableAMSlot, availablePMSlot, objectID)
+	if err != nil {
+		return err
 	}
 
-	return grouped
+	return s.promoteWishlist(ctx, organizationID, []time.Time{detailLeaveSlots.Date})
 
 }
 
-func (s *leaveService) GetPendingRequest(ctx context.Context) ([]*LeaveRequests, error) {
+// promoteWishlist moves wait-listed requests into the freed capacity of the
+// given days and debits their balance like any other confirmed leave.
+// Queued reschedules go first, since each one hands back a day elsewhere.
+func (s *leaveService) promoteWishlist(ctx context.Context, organizationID string, dates []time.Time) error {
 
-	return s.leaveRepository.GetPendingRequest(ctx)
+	for _, date := range dates {
 
-}
+		moved, err := s.leaveRepository.PromoteReschedules(ctx, organizationID, date)
+		if err != nil {
+			return err
+		}
 
-func (s *leaveService) DeleteRequestLeave(ctx context.Context, req *DeleteLeaveRequest) error {
+		// Each promoted request is settled on its own: one member's balance
+		// failing must not hold up the others or the change that freed the
+		// capacity.
+		for _, leaveItem := range moved {
+			if err := s.settleReschedule(ctx, leaveItem, "confirmed"); err != nil {
+				log.Printf("[leave] settle promoted reschedule of leave %s: %v", leaveItem.ID.Hex(), err)
+			}
+		}
 
-	if req.LeaveDate == "" {
-		return fmt.Errorf("leave date is required")
-	}
+		promoted, err := s.leaveRepository.PromoteWishlist(ctx, organizationID, date)
+		if err != nil {
+			return err
+		}
 
-	if req.UserID == "" {
-		return fmt.Errorf("user id is required")
-	}
+		for _, leaveItem := range promoted {
+			err := s.debitLeaveBalance(ctx, leaveItem)
+			if err == nil {
+				continue
+			}
 
-	dateParse, err := time.Parse("2006-01-02", req.LeaveDate)
-	if err != nil {
-		return err
+			log.Printf("[leave] debit promoted leave %s: %v, returning it to the wish list", leaveItem.ID.Hex(), err)
+
+			if err := s.leaveRepository.ReturnToWishlist(ctx, leaveItem); err != nil {
+				log.Printf("[leave] return leave %s to the wish list: %v", leaveItem.ID.Hex(), err)
+			}
+		}
 	}
 
-	return s.leaveRepository.DeleteRequestLeave(ctx, &dateParse, req.UserID)
+	return nil
 
 }
 
-func (s *leaveService) UpdateRequestLeave(ctx context.Context, req *UpdateRequest, id string) error {
+func (s *leaveService) groupedRequestType(leaveRequest []*LeaveRequests) map[string][]*LeaveRequests {
 
-	if req.Types == "" {
-		return fmt.Errorf("types is required")
-	}
+	grouped := make(map[string][]*LeaveRequests)
 
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return err
+	for _, item := range leaveRequest {
+		grouped[item.RequestType] = append(grouped[item.RequestType], item)
 	}
 
-	return s.leaveRepository.UpdateRequestLeave(ctx, req.Types, objectID)
+	return grouped
 
 }
 
-func (s *leaveService) GetStatistical(ctx context.Context, dateFrom string, dateTo string) (*LeaveStatistical, error) {
-
-	if dateFrom == "" {
-		return nil, fmt.Errorf("date from is empty")
-	}
-
-	if dateTo == "" {
-		return nil, fmt.Errorf("date to is empty")
-	}
+func (s *leaveService) GetPendingRequest(ctx context.Context) ([]*LeaveRequests, error) {
 
-	dateFromParse, err := time.Parse("2006-01-02", dateFrom)
+	organizationID, err := s.getOrganizationID(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	dateToParse, err := time.Parse("2006-01-02", dateTo)
-	if err != nil {
-		return nil, err
-	}
+	return s.leaveRepository.GetPendingRequest(ctx, organizationID)
 
-	leaves, err := s.leaveRepository.GetAllLeaves(ctx, &dateFromParse, &dateToParse)
-	if err != nil {
-		return nil, err
+}
+
+func (s *leaveService) DeleteRequestLeave(ctx context.Context, req *DeleteLeaveRequest) error {
+
+	if req.UserID == "" {
+		return fmt.Errorf("user id is required")
 	}
 
-	return caculateStatistical(leaves), nil
+	var leaveItem *LeaveRequests
 
-}
+	if req.ID != "" {
 
-func caculateStatistical(leaves []*LeaveRequests) *LeaveStatistical {
+		item, _, err := s.getLeaveForUser(ctx, req.ID)
+		if err != nil {
+			return err
+		}
 
-	stats := &LeaveStatistical{}
+		if item.UserID != req.UserID {
+			return fmt.Errorf("you can only delete your own leave requests")
+		}
 
-	stats.TotalRequested = len(leaves)
+		leaveItem = item
 
-	statusCount := make(map[string]int)
-	requestTypeCount := make(map[string]int)
-	userCount := make(map[string]*UserStats)
-	monthCount := make(map[string]*MonthlyStats)
-	weekdayCount := make(map[string]int)
+	} else {
 
-	for _, item := range leaves {
+		if req.LeaveDate == "" {
+			return fmt.Errorf("leave date or id is required")
+		}
 
-		statusCount[item.Status]++
-		requestTypeCount[item.RequestType]++
+		dateParse, err := time.Parse("2006-01-02", req.LeaveDate)
+		if err != nil {
+			return err
+		}
 
-		if userStat, ok := userCount[item.UserID]; ok {
-			userStat.TotalRequests++
-			if item.Status == "approved"  || item.Status == "confirmed" {
-				userStat.ApprovedRequests++
-			}
-		} else {
-			var approvedRequests int
-			if item.Status == "approved" || item.Status == "confirmed" {
-				approvedRequests = 1
-			} else {
-				approvedRequests = 0
-			}
-			userStat := &UserStats{
-				UserID:           item.UserID,
-				UserName:         item.UserName,
-				TotalRequests:    1,
-				ApprovedRequests: approvedRequests,
-			}
-			userCount[item.UserID] = userStat
+		organizationID, err := s.getOrganizationID(ctx)
+		if err != nil {
+			return err
 		}
 
-		monthKey := item.LeaveDate.Format("2006-01")
+		leaves, err := s.leaveRepository.GetLeavesByDate(ctx, organizationID, &dateParse, req.UserID)
+		if err != nil {
+			return err
+		}
 
-		if monthStat, ok := monthCount[monthKey]; ok {
-			monthStat.Count++
-			if item.Status == "approved" {
-				monthStat.Approved++
-			} else if item.Status == "rejected" {
-				monthStat.Rejected++
-			}
-		} else {
-			monthStat := &MonthlyStats{
-				Month:    monthKey,
-				Count:    1,
-				Approved: 0,
-				Rejected: 0,
-			}
-			if item.Status == "approved" {
-				monthStat.Approved++
-			} else if item.Status == "rejected" {
-				monthStat.Rejected++
-			}
-			monthCount[monthKey] = monthStat
+		leaveItem, err = pickLeaveOnDate(leaves, req.Portion)
+		if err != nil {
+			return err
 		}
 
-		weekdayCount[item.LeaveDate.Weekday().String()]++
 	}
 
-	stats.TotalConfirmed = statusCount["approved"]
-	stats.TotalPending = statusCount["pending"]
-	stats.TotalRejected = statusCount["rejected"]
-	stats.ImmediateRequests = requestTypeCount["immediate"]
+	// Deleting is a cancellation by the owner, so the record and its
+	// history stay in place.
+	_, err := s.cancelLeave(ctx, leaveItem, &CancelLeaveRequest{RequestedBy: req.UserID})
 
-	if stats.TotalRequested > 0 {
-		stats.ApproveRate = float64(stats.TotalConfirmed) / float64(stats.TotalRequested) * 100
-	}
+	return err
 
-	for _, monthStat := range monthCount {
-		stats.RequestsByMonth = append(stats.RequestsByMonth, *monthStat)
-	}
+}
 
-	for weekday, count := range weekdayCount {
-		stats.RequestByWeekDay = append(stats.RequestByWeekDay, WeeklyStats{
-			Weekday: weekday,
-			Count:   count,
-		})
-	}
+// pickLeaveOnDate chooses the request to delete among those on one day. The
+// portion is only needed when the day carries more than one request.
+func pickLeaveOnDate(leaves []*LeaveRequests, portion string) (*LeaveRequests, error) {
 
-	for _, userStat := range userCount {
-		if userStat.TotalRequests > 0 {
-			userStat.ApprovalRate = float64(userStat.ApprovedRequests) / float64(userStat.TotalRequests) * 100
+	var matches []*LeaveRequests
+	for _, leaveItem := range leaves {
+		itemPortion := leaveItem.Portion
+		if itemPortion == "" {
+			itemPortion = PortionFull
+		}
+		if portion == "" || itemPortion == portion {
+			matches = append(matches, leaveItem)
 		}
-		stats.TopRequestUsers = append(stats.TopRequestUsers, *userStat)
 	}
 
-	if len(userCount) > 0 {
-		stats.AverageRequestsPerUser = float64(stats.TotalRequested) / float64(len(userCount))
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no leave request on this date")
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("more than one leave request on this date, give the portion or the request id")
 	}
 
-	return stats
 }
 
-// func (s *leaveService) GetLeaveBalanceUser(ctx context.Context, userID string) (interface{}, error) {
-	
-// 	data, err := s.userService.GetAllUser()
-// 	if err != nil {
-// 		return nil, err
-// 	}
+func (s *leaveService) UpdateRequestLeave(ctx context.Context, req *UpdateRequest, id string) error {
 
-// 	return data, nil
-// }
+	if req.Types == "" {
+		return fmt.Errorf("types is required")
+	}
 
-// func (s *leaveService) AddCronLeavesBalance(ctx context.Context) error {
+	review := ReviewLeaveRequest{
+		Comment:    req.Comment,
+		ReviewerID: req.ReviewerID,
+	}
 
-// 	dataUser, err := s.userService.GetAllUser()
-// 	if err != nil {
-// 		return err
-// 	}
+	switch req.Types {
+	case "approved", "confirmed":
+		return s.ApproveRequestLeave(ctx, &review, id)
+	case "rejected":
+		return s.RejectRequestLeave(ctx, &review, id)
+	default:
+		return fmt.Errorf("invalid types, must be approved or rejected")
+	}
 
-// 	dataLeavesBanlance, err := s.leaveRepository.GetAllLeaveBalance(ctx)
-// 	if err != nil {
-// 		return err
-// 	}
+}
 
-// 	balanceMap := make(map[string]*UserLeaveBalance)
-// 	currentYear := time.Now().Year()
+func (s *leaveService) ApproveRequestLeave(ctx context.Context, req *ReviewLeaveRequest, id string) error {
 
-// 	for i := range dataLeavesBanlance {
-// 		key := fmt.Sprintf("%s_%d", dataLeavesBanlance[i].UserID, currentYear)
-// 		balanceMap[key] = dataLeavesBanlance[i]
-// 	}
+	leaveItem, err := s.getPendingLeave(ctx, req, id)
+	if err != nil {
+		return err
+	}
 
-// 	var newBalance []*UserLeaveBalance
-// 	var updateBalance []UserLeaveBalance
-// 	var transaction []LeaveTransaction
+	if leaveItem.LeavePolicy().RequiresAttachment && len(leaveItem.Attachments) == 0 {
+		return fmt.Errorf("%s leave needs a supporting document before it can be approved", leaveItem.LeaveType)
+	}
 
-// 	for _, item := range dataUser {
+	review := LeaveReview{
+		Action:             ReviewApproved,
+		ReviewerID:         req.ReviewerID,
+		Comment:            req.Comment,
+		CapacityOverridden: req.OverrideCapacity,
+		ReviewedAt:         time.Now(),
+	}
 
-// 		key := fmt.Sprintf("%s_%d", item.UserID, currentYear)
+	err = s.leaveRepository.ApproveLeave(ctx, leaveItem, &review)
+	if err != nil {
+		return err
+	}
 
-// 		if existingBalance, ok := balanceMap[key]; ok {
+	return s.debitLeaveBalance(ctx, leaveItem)
 
-// 			existingBalance.TotalLeaveBanlance += 1
-// 			existingBalance.RemaindingLeave += 1
-// 			existingBalance.LastUpdated = time.Now()
+}
 
-// 			updateBalance = append(updateBalance, *existingBalance)
+func (s *leaveService) RejectRequestLeave(ctx context.Context, req *ReviewLeaveRequest, id string) error {
 
-// 		} else {
+	leaveItem, err := s.getPendingLeave(ctx, req, id)
+	if err != nil {
+		return err
+	}
+
+	review := LeaveReview{
+		Action:     ReviewRejected,
+		ReviewerID: req.ReviewerID,
+		Comment:    req.Comment,
+		ReviewedAt: time.Now(),
+	}
+
+	return s.leaveRepository.RejectLeave(ctx, leaveItem, &review)
+
+}
+
+func (s *leaveService) getPendingLeave(ctx context.Context, req *ReviewLeaveRequest, id string) (*LeaveRequests, error) {
+
+	if req.ReviewerID == "" {
+		return nil, fmt.Errorf("reviewer id is required")
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "review leave requests")
+	if err != nil {
+		return nil, err
+	}
+
+	leaveItem, err := s.leaveRepository.GetLeaveByID(ctx, currentUser.OrganizationIdActive, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if leaveItem.Status != "pending" {
+		return nil, fmt.Errorf("leave request is %s, only pending requests can be reviewed", leaveItem.Status)
+	}
+
+	// Approval is a second pair of eyes, so nobody reviews their own leave.
+	if leaveItem.UserID == req.ReviewerID || leaveItem.UserID == currentUser.ID {
+		return nil, fmt.Errorf("you cannot review your own leave request")
+	}
+
+	return leaveItem, nil
+
+}
+
+func (s *leaveService) GetStatistical(ctx context.Context, dateFrom string, dateTo string) (*LeaveStatistical, error) {
+
+	dateFromParse, dateToParse, err := parseAnalyticsRange(dateFrom, dateTo)
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets, err := s.leaveRepository.GetLeaveAnalytics(ctx, organizationID, dateFromParse, dateToParse, []AnalyticsGrouping{
+		{By: GroupByAll},
+		{By: GroupByMonth},
+		{By: GroupByWeekday},
+		{By: GroupByType},
+		{By: GroupByUser, Top: topRequestUsers},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return caculateStatistical(buckets[0], buckets[1], buckets[2], buckets[3], buckets[4]), nil
+
+}
+
+func (s *leaveService) GetLeaveBalanceUser(ctx context.Context, userID string) (*LeaveBalanceResponse, error) {
+
+	if userID == "" {
+		return nil, fmt.Errorf("user id is required")
+	}
+
+	now := time.Now()
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentUser.OrganizationIdActive == "" {
+		return nil, fmt.Errorf("user has no active organization")
+	}
+
+	if currentUser.ID != userID && !isOrganizationAdmin(currentUser) {
+		return nil, fmt.Errorf("you are not allowed to view this user's leave balance")
+	}
+
+	if currentUser.ID == userID {
+		s.rememberStartDate(ctx, currentUser)
+	}
+
+	// Balances are kept per organization; the caller sees the one of the
+	// organization they are working in.
+	organizationID := currentUser.OrganizationIdActive
+
+	setting, err := s.balanceSetting(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := s.accrueStartedBalance(ctx, organizationID, userID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if balance == nil {
+		balance, err = s.leaveRepository.GetLeaveBalance(ctx, organizationID, userID, setting.leaveYearOf(now).Year)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entitlement, err := s.userEntitlement(ctx, userID, setting, setting.leaveYearOf(now))
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := s.leaveRepository.GetLeaveTransactions(ctx, organizationID, userID, balance.Year)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LeaveBalanceResponse{
+		Balance:      balance,
+		Entitlement:  entitlement,
+		Transactions: transactions,
+	}, nil
+}
+
+// AddCronLeavesBalance accrues, in each organization using leave, the
+// balance of every user it knows of: anyone with a start date or leave on
+// record there. The main service publishes no member listing, so a new
+// member is picked up once their start date is stored, which happens when
+// they first open their balance or HR sets it. A member of two
+// organizations accrues a balance in each.
+func (s *leaveService) AddCronLeavesBalance(ctx context.Context) error {
+
+	organizationIDs, err := s.leaveRepository.GetOrganizationIDs(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	var errs []error
+
+	for _, organizationID := range organizationIDs {
+
+		userIDs, err := s.leaveRepository.GetOrganizationUserIDs(ctx, organizationID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("list users of organization %s: %w", organizationID, err))
+			continue
+		}
+
+		for _, userID := range userIDs {
+			if _, err := s.accrueStartedBalance(ctx, organizationID, userID, now); err != nil {
+				errs = append(errs, fmt.Errorf("accrue leave balance for user %s in organization %s: %w", userID, organizationID, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+
+}
+
+// accrueStartedBalance accrues the user's balance once their start date is
+// known, since it prorates the first year. Until then it returns nil and
+// the months are caught up in full later.
+func (s *leaveService) accrueStartedBalance(ctx context.Context, organizationID string, userID string, now time.Time) (*UserLeaveBalance, error) {
+
+	known, err := s.hasStartDate(ctx, organizationID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !known {
+		return nil, nil
+	}
+
+	return s.accrueLeaveBalance(ctx, organizationID, userID, now)
+
+}
+
+// accrueLeaveBalance credits every month of the current year that has not
+// been earned yet, so a missed cron run is caught up on the next read.
+func (s *leaveService) accrueLeaveBalance(ctx context.Context, organizationID string, userID string, now time.Time) (*UserLeaveBalance, error) {
+
+	setting, err := s.balanceSetting(ctx, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	period := setting.leaveYearOf(now)
+
+	err = s.carryOverLeaveBalance(ctx, userID, setting, period, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.accrueLeaveYear(ctx, userID, setting, period, now)
+
+}
+
+// accrueLeaveYear credits the leave of period earned up to the month now
+// falls in, at the rate the user's entitlement sets.
+func (s *leaveService) accrueLeaveYear(ctx context.Context, userID string, setting *Setting, period leaveYear, now time.Time) (*UserLeaveBalance, error) {
+
+	year := period.Year
+
+	balance, err := s.leaveRepository.GetLeaveBalance(ctx, setting.OrganizationID, userID, year)
+	if err != nil {
+		return nil, err
+	}
+
+	currentMonth := period.month(now)
+	if balance.AccruedMonths >= currentMonth {
+		return balance, nil
+	}
+
+	entitlement, err := s.userEntitlement(ctx, userID, setting, period)
+	if err != nil {
+		return nil, err
+	}
+
+	amount := entitlement.earnedThrough(currentMonth) - entitlement.earnedThrough(balance.AccruedMonths)
+
+	accrued, err := s.leaveRepository.AccrueLeaveBalance(ctx, setting.OrganizationID, userID, year, balance.AccruedMonths, currentMonth, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if accrued {
+		var transactions []*LeaveTransaction
+		for month := balance.AccruedMonths + 1; month <= currentMonth; month++ {
+			// Months before the employee started earn nothing.
+			earned := entitlement.earnedThrough(month) - entitlement.earnedThrough(month-1)
+			if earned <= 0 {
+				continue
+			}
+			date := period.Start.AddDate(0, month-1, 0)
+			reason := fmt.Sprintf("Monthly earned leave - %s", date.Format("2006-01"))
+			transactions = append(transactions, &LeaveTransaction{
+				ID:              primitive.NewObjectID(),
+				OrganizationID:  setting.OrganizationID,
+				UserID:          userID,
+				Year:            year,
+				TransactionType: TransactionEarned,
+				Amount:          math.Round(earned*100) / 100,
+				Date:            date,
+				Reason:          &reason,
+				CreatedAt:       now,
+				UpdatedAt:       now,
+			})
+		}
+
+		if len(transactions) > 0 {
+			err = s.leaveRepository.CreateLeaveTransaction(ctx, transactions)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return s.leaveRepository.GetLeaveBalance(ctx, setting.OrganizationID, userID, year)
+
+}
+
+func (s *leaveService) debitLeaveBalance(ctx context.Context, leaveItem *LeaveRequests) error {
+
+	if leaveItem.LeavePolicy().UsesCompOff {
+		return s.spendCompOff(ctx, leaveItem)
+	}
+
+	if !leaveItem.LeavePolicy().DeductsBalance {
+		return nil
+	}
+
+	reason := fmt.Sprintf("Leave used - %s", formatLeaveRange(leaveItem))
+
+	return s.recordLeaveTransaction(ctx, leaveItem, TransactionUsed, leaveItem.Dates(), reason)
+
+}
+
+// refundLeaveBalance gives back the days of leaveItem on dates, to the leave
+// years they were charged to.
+func (s *leaveService) refundLeaveBalance(ctx context.Context, leaveItem *LeaveRequests, dates []time.Time) error {
+
+	if leaveItem.LeavePolicy().UsesCompOff && len(dates) > 0 {
+		return s.refundCompOff(ctx, leaveItem, dates)
+	}
+
+	if !leaveItem.LeavePolicy().DeductsBalance || len(dates) == 0 {
+		return nil
+	}
+
+	reason := fmt.Sprintf("Leave refunded - %s", formatLeaveRange(leaveItem))
+
+	return s.recordLeaveTransaction(ctx, leaveItem, TransactionRefund, dates, reason)
+
+}
+
+func formatLeaveRange(leaveItem *LeaveRequests) string {
+
+	dates := leaveItem.Dates()
+	first := dates[0].Format("2006-01-02")
+	last := dates[len(dates)-1].Format("2006-01-02")
+
+	if first == last {
+		if leaveItem.Portion == PortionAM || leaveItem.Portion == PortionPM {
+			return fmt.Sprintf("%s (%s)", first, leaveItem.Portion)
+		}
+		return first
+	}
+
+	return fmt.Sprintf("%s to %s", first, last)
+
+}
+
+// recordLeaveTransaction charges or refunds the days of leaveItem on dates,
+// with one balance update and transaction per leave year they fall in.
+func (s *leaveService) recordLeaveTransaction(ctx context.Context, leaveItem *LeaveRequests, transactionType string, dates []time.Time, reason string) error {
+
+	setting, err := s.balanceSetting(ctx, leaveItem.OrganizationID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	leaveID := leaveItem.ID
+
+	for _, share := range setting.splitByLeaveYear(dates, leaveItem.dayLength()) {
+
+		if _, err := s.leaveRepository.GetLeaveBalance(ctx, leaveItem.OrganizationID, leaveItem.UserID, share.Year); err != nil {
+			return err
+		}
+
+		used := share.Days
+		if transactionType == TransactionRefund {
+			used = -share.Days
+		}
 
-// 			newBalance = append(newBalance, &UserLeaveBalance{
-// 				ID:                 primitive.NewObjectID(),
-// 				UserID:             item.UserID,
-// 				Year:               currentYear,
-// 				TotalLeaveBanlance: 1,
-// 				UsedLeave:          0,
-// 				RemaindingLeave:    1,
-// 				LastUpdated:        time.Now(),
-// 			})
+		err = s.leaveRepository.UpdateLeaveBalance(ctx, leaveItem.OrganizationID, leaveItem.UserID, share.Year, 0, used)
+		if err != nil {
+			return err
+		}
+
+		err = s.leaveRepository.CreateLeaveTransaction(ctx, []*LeaveTransaction{
+			{
+				ID:              primitive.NewObjectID(),
+				OrganizationID:  leaveItem.OrganizationID,
+				UserID:          leaveItem.UserID,
+				Year:            share.Year,
+				TransactionType: transactionType,
+				Amount:          share.Days,
+				LeaveID:         &leaveID,
+				Date:            share.Date,
+				Reason:          &reason,
+				CreatedAt:       now,
+				UpdatedAt:       now,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+	}
+
+	return nil
+
+}
+
+func (s *leaveService) GetLeaveTypes(ctx context.Context) ([]*LeaveType, error) {
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetLeaveTypes(ctx, organizationID)
+
+}
+
+func (s *leaveService) CreateLeaveType(ctx context.Context, req *LeaveTypeRequest) (*LeaveType, error) {
+
+	if req.Code == "" {
+		return nil, fmt.Errorf("code is required")
+	}
+
+	if req.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	if err := validateBookingWindowException(req.BookingWindow); err != nil {
+		return nil, err
+	}
+
+	if req.DeductsBalance && req.UsesCompOff {
+		return nil, fmt.Errorf("a leave type cannot both deduct the annual balance and use comp-off")
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "manage leave types")
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	// Make sure the defaults exist before a custom type is added, otherwise
+	// the catalogue would never be seeded for this organization.
+	if _, err := s.leaveRepository.GetLeaveTypes(ctx, organizationID); err != nil {
+		return nil, err
+	}
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	leaveType := LeaveType{
+		ID:             primitive.NewObjectID(),
+		OrganizationID: organizationID,
+		Code:           req.Code,
+		Name:           req.Name,
+		Policy: LeavePolicy{
+			ConsumesSlot:       req.ConsumesSlot,
+			RequiresApproval:   req.RequiresApproval,
+			DeductsBalance:     req.DeductsBalance,
+			RequiresAttachment: req.RequiresAttachment,
+			UsesCompOff:        req.UsesCompOff,
+			BookingWindow:      req.BookingWindow,
+		},
+		IsActive:  isActive,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	err = s.leaveRepository.CreateLeaveType(ctx, &leaveType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &leaveType, nil
+
+}
+
+func (s *leaveService) UpdateLeaveType(ctx context.Context, req *LeaveTypeRequest, id string) (*LeaveType, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	if err := validateBookingWindowException(req.BookingWindow); err != nil {
+		return nil, err
+	}
+
+	if req.DeductsBalance && req.UsesCompOff {
+		return nil, fmt.Errorf("a leave type cannot both deduct the annual balance and use comp-off")
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "manage leave types")
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	isActive := true
+	if req.IsActive != nil {
+		isActive = *req.IsActive
+	}
+
+	leaveType := LeaveType{
+		OrganizationID: organizationID,
+		Name:           req.Name,
+		Policy: LeavePolicy{
+			ConsumesSlot:       req.ConsumesSlot,
+			RequiresApproval:   req.RequiresApproval,
+			DeductsBalance:     req.DeductsBalance,
+			RequiresAttachment: req.RequiresAttachment,
+			UsesCompOff:        req.UsesCompOff,
+			BookingWindow:      req.BookingWindow,
+		},
+		IsActive: isActive,
+	}
+
+	return s.leaveRepository.UpdateLeaveType(ctx, &leaveType, objectID)
 
-// 			reason := fmt.Sprintf("Monthly earned leave - %s", time.Now().Format("2006-01"))
+}
+
+func validateBookingWindowException(exception *BookingWindowException) error {
+
+	if exception == nil {
+		return nil
+	}
+
+	if exception.AdvanceBookingDays != nil && *exception.AdvanceBookingDays < 0 {
+		return fmt.Errorf("booking window advance booking days must not be negative")
+	}
+
+	if exception.MaxBookingDays != nil && *exception.MaxBookingDays < 0 {
+		return fmt.Errorf("booking window max booking days must not be negative")
+	}
+
+	return nil
+
+}
 
-// 			transaction = append(transaction, LeaveTransaction{
-// 				ID:              primitive.NewObjectID(),
-// 				UserID:          item.UserID,
-// 				TransactionType: "EARNED",
-// 				Date:            time.Now(),
-// 				Reason:          &reason,
-// 				CreatedAt:       time.Now(),
-// 				UpdatedAt:       time.Now(),
-// 			})
+func (s *leaveService) GetCapacityRules(ctx context.Context) ([]*CapacityRule, error) {
 
-// 		}
-// 	}
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.leaveRepository.GetCapacityRules(ctx, organizationID)
+
+}
 
-// 	if len(newBalance) > 0 {
-// 		err = s.leaveRepository.CreateLeaveBalance(ctx, newBalance)
-// 		if err != nil {
-// 			return err
-// 		}
-// 	}
+func (s *leaveService) CreateCapacityRule(ctx context.Context, req *CapacityRuleRequest) (*CapacityRule, error) {
 
-// 	if len(updateBalance) > 0 {
-// 		err = s.leaveRepository.UpdateLeaveBalance(ctx, updateBalance)
-// 		if err != nil {
-// 			return err
-// 		}
-// 	}
+	currentUser, err := s.getOrganizationAdmin(ctx, "manage capacity rules")
+	if err != nil {
+		return nil, err
+	}
 
-// 	if len(transaction) > 0 {
-// 		err = s.leaveRepository.CreateLeaveTransaction(ctx, transaction)
-// 		if err != nil {
-// 			return err
-// 		}
-// 	}
+	organizationID := currentUser.OrganizationIdActive
 
-// 	return nil
+	rule, err := buildCapacityRule(req)
+	if err != nil {
+		return nil, err
+	}
 
-// }
\ No newline at end of file
+	rule.ID = primitive.NewObjectID()
+	rule.OrganizationID = organizationID
+	rule.CreatedAt = time.Now()
+	rule.UpdatedAt = time.Now()
+
+	err = s.leaveRepository.CreateCapacityRule(ctx, rule)
+	if err != nil {
+		return nil, err
+	}
+
+	return rule, s.recomputeCapacity(ctx, organizationID)
+
+}
+
+func (s *leaveService) UpdateCapacityRule(ctx context.Context, req *CapacityRuleRequest, id string) (*CapacityRule, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "manage capacity rules")
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	rule, err := buildCapacityRule(req)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.OrganizationID = organizationID
+
+	ruleUpdated, err := s.leaveRepository.UpdateCapacityRule(ctx, rule, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	return ruleUpdated, s.recomputeCapacity(ctx, organizationID)
+
+}
+
+func (s *leaveService) DeleteCapacityRule(ctx context.Context, id string) error {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "manage capacity rules")
+	if err != nil {
+		return err
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	err = s.leaveRepository.DeleteCapacityRule(ctx, organizationID, objectID)
+	if err != nil {
+		return err
+	}
+
+	return s.recomputeCapacity(ctx, organizationID)
+
+}
+
+// recomputeCapacity brings the slots from today on in line with the rules
+// and lets wait-listed requests into any capacity that opened up.
+func (s *leaveService) recomputeCapacity(ctx context.Context, organizationID string) error {
+
+	increased, err := s.leaveRepository.RecomputeDailyLeaveSlots(ctx, organizationID, leaveToday(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	return s.promoteWishlist(ctx, organizationID, increased)
+
+}
+
+// SyncHolidaySlots closes the tracked days that became holidays and reopens,
+// with their wish lists, the ones that stopped being holidays.
+func (s *leaveService) SyncHolidaySlots(ctx context.Context, organizationID string) error {
+
+	reopened, err := s.leaveRepository.SyncHolidaySlots(ctx, organizationID, leaveToday(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	return s.promoteWishlist(ctx, organizationID, reopened)
+
+}
+
+func buildCapacityRule(req *CapacityRuleRequest) (*CapacityRule, error) {
+
+	if req.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	if req.MaxSlot < 0 {
+		return nil, fmt.Errorf("max slot must not be negative")
+	}
+
+	for _, weekday := range req.Weekdays {
+		if weekday < time.Sunday || weekday > time.Saturday {
+			return nil, fmt.Errorf("invalid weekday %d, must be 0 (Sunday) to 6 (Saturday)", weekday)
+		}
+	}
+
+	rule := CapacityRule{
+		Name:     req.Name,
+		Weekdays: req.Weekdays,
+		MaxSlot:  req.MaxSlot,
+		Priority: req.Priority,
+		IsActive: true,
+	}
+
+	if rule.Weekdays == nil {
+		rule.Weekdays = []time.Weekday{}
+	}
+
+	if req.IsActive != nil {
+		rule.IsActive = *req.IsActive
+	}
+
+	if req.StartDate != "" {
+		startDate, err := time.Parse("2006-01-02", req.StartDate)
+		if err != nil {
+			return nil, err
+		}
+		rule.StartDate = &startDate
+	}
+
+	if req.EndDate != "" {
+		endDate, err := time.Parse("2006-01-02", req.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		rule.EndDate = &endDate
+	}
+
+	if rule.StartDate != nil && rule.EndDate != nil && rule.EndDate.Before(*rule.StartDate) {
+		return nil, fmt.Errorf("end date must not be before start date")
+	}
+
+	if len(rule.Weekdays) == 0 && rule.StartDate == nil && rule.EndDate == nil {
+		return nil, fmt.Errorf("rule needs weekdays or a date range")
+	}
+
+	return &rule, nil
+
+}
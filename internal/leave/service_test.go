@@ -0,0 +1,162 @@
+package leave
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPickLeaveOnDate(t *testing.T) {
+
+	morning := &LeaveRequests{ID: primitive.NewObjectID(), Portion: PortionAM}
+	afternoon := &LeaveRequests{ID: primitive.NewObjectID(), Portion: PortionPM}
+	legacy := &LeaveRequests{ID: primitive.NewObjectID()}
+
+	tests := []struct {
+		name    string
+		leaves  []*LeaveRequests
+		portion string
+		want    *LeaveRequests
+		wantErr bool
+	}{
+		{name: "single request needs no portion", leaves: []*LeaveRequests{morning}, want: morning},
+		{name: "shared day without portion is ambiguous", leaves: []*LeaveRequests{morning, afternoon}, wantErr: true},
+		{name: "portion picks the afternoon", leaves: []*LeaveRequests{morning, afternoon}, portion: PortionPM, want: afternoon},
+		{name: "request without portion is a full day", leaves: []*LeaveRequests{legacy}, portion: PortionFull, want: legacy},
+		{name: "portion matches nothing", leaves: []*LeaveRequests{morning}, portion: PortionPM, wantErr: true},
+		{name: "no requests", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			got, err := pickLeaveOnDate(tt.leaves, tt.portion)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pickLeaveOnDate error = %v, want error %v", err, tt.wantErr)
+			}
+
+			if got != tt.want {
+				t.Errorf("pickLeaveOnDate picked %v, want %v", got, tt.want)
+			}
+
+		})
+	}
+
+}
+
+func TestCreateRequestLeaveRejectsSystemTypes(t *testing.T) {
+
+	service := &leaveService{
+		leaveRepository: &fakeLeaveRepository{
+			leaveTypes: []*LeaveType{
+				{Code: "shutdown", Name: "Shutdown", IsActive: true, System: true},
+				{Code: LeaveTypeClosure, Name: "Organization closure", IsActive: true},
+			},
+		},
+		userService: &fakeUserService{
+			currentUser: &user.CurrentUser{ID: "member", OrganizationIdActive: "org"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		leaveType string
+	}{
+		{name: "closure code", leaveType: LeaveTypeClosure},
+		{name: "custom system type", leaveType: "shutdown"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := service.CreateRequestLeave(context.Background(), &CreateLeaveRequest{
+				UserID:    "member",
+				LeaveDate: "2030-03-04",
+				LeaveType: tt.leaveType,
+			})
+
+			if err == nil || !strings.Contains(err.Error(), "cannot be requested") {
+				t.Errorf("CreateRequestLeave(%s) error = %v, want a refusal", tt.leaveType, err)
+			}
+
+		})
+	}
+
+}
+
+func TestPromoteWishlistReturnsFailedDebits(t *testing.T) {
+
+	date := parseDay("2030-04-01")
+
+	wish := func(userID string) *LeaveRequests {
+		return &LeaveRequests{
+			ID:             primitive.NewObjectID(),
+			OrganizationID: "org-1",
+			UserID:         userID,
+			StartDate:      date,
+			EndDate:        date,
+			LeaveDates:     []time.Time{date},
+			Portion:        PortionFull,
+			LeaveType:      LeaveTypeAnnual,
+			Policy:         &LeavePolicy{ConsumesSlot: true, DeductsBalance: true},
+			Status:         "pending",
+			RequestType:    "wishlist",
+		}
+	}
+
+	first, broke, last := wish("first"), wish("broke"), wish("last")
+
+	repository := &fakeLeaveRepository{
+		waitlist:      []*LeaveRequests{first, broke, last},
+		balanceErrors: map[string]error{"broke": errors.New("balance store unavailable")},
+	}
+
+	service := &leaveService{leaveRepository: repository}
+
+	if err := service.promoteWishlist(context.Background(), "org-1", []time.Time{date}); err != nil {
+		t.Fatalf("promoteWishlist: %v", err)
+	}
+
+	var debited []string
+	for _, transaction := range repository.transactions {
+		debited = append(debited, transaction.UserID)
+	}
+	if !slices.Equal(debited, []string{"first", "last"}) {
+		t.Errorf("debited %v, want [first last]", debited)
+	}
+
+	if len(repository.returned) != 1 || repository.returned[0] != broke {
+		t.Fatalf("returned to the wish list: %d requests, want only broke's", len(repository.returned))
+	}
+
+	if broke.Status != "pending" || broke.RequestType != "wishlist" {
+		t.Errorf("broke's request is %s/%s, want pending/wishlist", broke.Status, broke.RequestType)
+	}
+
+	if last.Status != "confirmed" {
+		t.Errorf("last's request is %s, want confirmed", last.Status)
+	}
+
+}
+
+func TestEditMaxSlotNeedsAnAdmin(t *testing.T) {
+
+	service := &leaveService{
+		leaveRepository: &fakeLeaveRepository{},
+		userService: &fakeUserService{
+			currentUser: &user.CurrentUser{ID: "member", OrganizationIdActive: "org"},
+		},
+	}
+
+	err := service.EditMaxSlot(context.Background(), &EditSlotRequest{MaxSlot: 10}, primitive.NewObjectID().Hex())
+	if err == nil || !strings.Contains(err.Error(), "only organization admins") {
+		t.Errorf("EditMaxSlot by a member error = %v, want a refusal", err)
+	}
+
+}
@@ -0,0 +1,473 @@
+package leave
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const uncoveredReportDays = 30
+
+// coverage works out which days of the leave have a substitute who has not
+// declined. Days are only counted while they are part of the leave.
+func (l *LeaveRequests) coverage() *LeaveCoverage {
+
+	coverage := LeaveCoverage{
+		CoveredDates:   []time.Time{},
+		UncoveredDates: []time.Time{},
+	}
+
+	for _, date := range l.Dates() {
+		covered := slices.ContainsFunc(l.Substitutes, func(substitute Substitute) bool {
+			return substitute.Date.Equal(date) && substitute.Status != SubstituteDeclined
+		})
+		if covered {
+			coverage.CoveredDates = append(coverage.CoveredDates, date)
+		} else {
+			coverage.UncoveredDates = append(coverage.UncoveredDates, date)
+		}
+	}
+
+	switch {
+	case !l.RequiresCover && len(l.Substitutes) == 0:
+		coverage.Status = CoverageNotRequired
+	case len(coverage.UncoveredDates) == 0:
+		coverage.Status = CoverageCovered
+	case len(coverage.CoveredDates) == 0:
+		coverage.Status = CoverageUncovered
+	default:
+		coverage.Status = CoveragePartial
+	}
+
+	return &coverage
+
+}
+
+// isTeacher reports whether the user is a teacher in the organization. The
+// main service answers with no data for anyone else; an error means it
+// could not be asked, not that the user is no teacher.
+func (s *leaveService) isTeacher(ctx context.Context, organizationID string, userID string) (bool, string, error) {
+
+	teacher, err := s.userService.GetTeacherInforByOrg(ctx, userID, organizationID)
+	if err != nil {
+		return false, "", err
+	}
+
+	if teacher == nil || teacher.UserID == "" {
+		return false, "", nil
+	}
+
+	return true, teacher.UserName, nil
+
+}
+
+func (s *leaveService) GetLeaveDetail(ctx context.Context, id string) (*LeaveRequests, error) {
+
+	leaveItem, err := s.getLeave(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	leaveItem.Coverage = leaveItem.coverage()
+
+	return leaveItem, nil
+
+}
+
+// ProposeSubstitute asks a teacher to cover one day of a confirmed leave.
+// The teacher has to belong to the organization and be free that day: not
+// on leave and not already covering someone else.
+func (s *leaveService) ProposeSubstitute(ctx context.Context, req *SubstituteRequest, id string) (*LeaveRequests, error) {
+
+	if req.TeacherID == "" {
+		return nil, fmt.Errorf("teacher id is required")
+	}
+
+	if req.Date == "" {
+		return nil, fmt.Errorf("date is required")
+	}
+
+	date, err := time.Parse("2006-01-02", req.Date)
+	if err != nil {
+		return nil, err
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUser, err := s.getOrganizationAdmin(ctx, "assign substitutes")
+	if err != nil {
+		return nil, err
+	}
+
+	leaveItem, err := s.leaveRepository.GetLeaveByID(ctx, currentUser.OrganizationIdActive, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if leaveItem.Status != "confirmed" {
+		return nil, fmt.Errorf("leave request is %s, substitutes can only cover confirmed leave", leaveItem.Status)
+	}
+
+	if !slices.ContainsFunc(leaveItem.Dates(), date.Equal) {
+		return nil, fmt.Errorf("%s is not a day of this leave", req.Date)
+	}
+
+	if req.TeacherID == leaveItem.UserID {
+		return nil, fmt.Errorf("teacher cannot cover their own leave")
+	}
+
+	isTeacher, teacherName, err := s.isTeacher(ctx, leaveItem.OrganizationID, req.TeacherID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isTeacher {
+		return nil, fmt.Errorf("user %s is not a teacher in this organization", req.TeacherID)
+	}
+
+	_, err = s.leaveRepository.GetLeaveByDate(ctx, leaveItem.OrganizationID, &date, req.TeacherID)
+	if err == nil {
+		return nil, fmt.Errorf("teacher is on leave on %s", req.Date)
+	}
+	if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+
+	substitute := Substitute{
+		ID:          primitive.NewObjectID(),
+		Date:        date,
+		TeacherID:   req.TeacherID,
+		TeacherName: teacherName,
+		Status:      SubstituteProposed,
+		Note:        req.Note,
+		AssignedBy:  req.AssignedBy,
+		AssignedAt:  time.Now(),
+	}
+
+	err = s.leaveRepository.AddSubstitute(ctx, leaveItem, &substitute)
+	if err != nil {
+		return nil, err
+	}
+
+	leaveItem.Coverage = leaveItem.coverage()
+
+	return leaveItem, nil
+
+}
+
+func (s *leaveService) UpdateSubstituteStatus(ctx context.Context, req *SubstituteStatusRequest, id string, substituteID string) (*LeaveRequests, error) {
+
+	if req.Status != SubstituteAccepted && req.Status != SubstituteDeclined {
+		return nil, fmt.Errorf("invalid status, must be %s or %s", SubstituteAccepted, SubstituteDeclined)
+	}
+
+	leaveID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	substituteObjectID, err := primitive.ObjectIDFromHex(substituteID)
+	if err != nil {
+		return nil, err
+	}
+
+	currentUser, err := s.userService.GetCurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentUser.OrganizationIdActive == "" {
+		return nil, errors.New("user has no active organization")
+	}
+
+	organizationID := currentUser.OrganizationIdActive
+
+	leaveItem, err := s.leaveRepository.GetLeaveByID(ctx, organizationID, leaveID)
+	if err != nil {
+		return nil, err
+	}
+
+	index := slices.IndexFunc(leaveItem.Substitutes, func(substitute Substitute) bool {
+		return substitute.ID == substituteObjectID
+	})
+	if index < 0 {
+		return nil, fmt.Errorf("substitute not found")
+	}
+
+	// Only the proposed teacher can take on or turn down the cover.
+	teacherID := leaveItem.Substitutes[index].TeacherID
+
+	if req.RespondedBy == "" {
+		req.RespondedBy = currentUser.ID
+	}
+
+	if currentUser.ID != teacherID || req.RespondedBy != teacherID {
+		return nil, fmt.Errorf("only the proposed teacher can respond to this substitution")
+	}
+
+	leaveItem, err = s.leaveRepository.UpdateSubstituteStatus(ctx, organizationID, leaveID, substituteObjectID, req.Status, req.RespondedBy)
+	if err != nil {
+		return nil, err
+	}
+
+	leaveItem.Coverage = leaveItem.coverage()
+
+	return leaveItem, nil
+
+}
+
+func (s *leaveService) RemoveSubstitute(ctx context.Context, id string, substituteID string) (*LeaveRequests, error) {
+
+	leaveID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+
+	substituteObjectID, err := primitive.ObjectIDFromHex(substituteID)
+	if err != nil {
+		return nil, err
+	}
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	leaveItem, err := s.leaveRepository.RemoveSubstitute(ctx, organizationID, leaveID, substituteObjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	leaveItem.Coverage = leaveItem.coverage()
+
+	return leaveItem, nil
+
+}
+
+// GetUncoveredLeaves lists the upcoming days of confirmed teacher leave that
+// nobody covers yet, from today for the next 30 days unless a range is
+// given.
+func (s *leaveService) GetUncoveredLeaves(ctx context.Context, dateFrom string, dateTo string) ([]*UncoveredDay, error) {
+
+	from := leaveToday(time.Now())
+	if dateFrom != "" {
+		parsed, err := time.Parse("2006-01-02", dateFrom)
+		if err != nil {
+			return nil, err
+		}
+		from = parsed
+	}
+
+	to := from.AddDate(0, 0, uncoveredReportDays)
+	if dateTo != "" {
+		parsed, err := time.Parse("2006-01-02", dateTo)
+		if err != nil {
+			return nil, err
+		}
+		to = parsed
+	}
+
+	if to.Before(from) {
+		return nil, fmt.Errorf("date to must not be before date from")
+	}
+
+	organizationID, err := s.getOrganizationID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	leaves, err := s.leaveRepository.GetLeavesRequiringCover(ctx, organizationID, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	uncovered := []*UncoveredDay{}
+
+	for _, leaveItem := range leaves {
+		for _, date := range leaveItem.coverage().UncoveredDates {
+			if date.Before(from) || date.After(to) {
+				continue
+			}
+			uncovered = append(uncovered, &UncoveredDay{
+				Date:      date.Format("2006-01-02"),
+				LeaveID:   leaveItem.ID.Hex(),
+				UserID:    leaveItem.UserID,
+				UserName:  leaveItem.UserName,
+				Portion:   leaveItem.Portion,
+				LeaveType: leaveItem.LeaveType,
+			})
+		}
+	}
+
+	sort.SliceStable(uncovered, func(i, j int) bool {
+		return uncovered[i].Date < uncovered[j].Date
+	})
+
+	return uncovered, nil
+
+}
+
+// activeSubstitute matches an assignment of the teacher on the day that has
+// not been declined.
+func activeSubstitute(teacherID string, date time.Time) bson.M {
+	return bson.M{"$elemMatch": bson.M{
+		"teacher_id": teacherID,
+		"date":       date,
+		"status":     bson.M{"$ne": SubstituteDeclined},
+	}}
+}
+
+// AddSubstitute assigns cover for one day of a confirmed leave. The push is
+// conditional on the teacher not already covering this leave that day; a
+// clash with another leave is checked right after and undone, since the
+// two assignments live in different documents.
+func (r *leaveRepository) AddSubstitute(ctx context.Context, leaveItem *LeaveRequests, substitute *Substitute) error {
+
+	result, err := r.collectionLeave.UpdateOne(ctx, bson.M{
+		"_id":             leaveItem.ID,
+		"organization_id": leaveItem.OrganizationID,
+		"status":          "confirmed",
+		"substitutes":     bson.M{"$not": activeSubstitute(substitute.TeacherID, substitute.Date)},
+	}, bson.M{
+		"$push": bson.M{"substitutes": substitute},
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.ModifiedCount == 0 {
+		return fmt.Errorf("teacher is already assigned on %s or the leave is no longer confirmed", substitute.Date.Format("2006-01-02"))
+	}
+
+	count, err := r.collectionLeave.CountDocuments(ctx, bson.M{
+		"organization_id": leaveItem.OrganizationID,
+		"status":          "confirmed",
+		"substitutes":     activeSubstitute(substitute.TeacherID, substitute.Date),
+	})
+	if err == nil && count <= 1 {
+		leaveItem.Substitutes = append(leaveItem.Substitutes, *substitute)
+		return nil
+	}
+
+	r.collectionLeave.UpdateOne(ctx, bson.M{"_id": leaveItem.ID}, bson.M{
+		"$pull": bson.M{"substitutes": bson.M{"_id": substitute.ID}},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("teacher is already covering another leave on %s", substitute.Date.Format("2006-01-02"))
+
+}
+
+// UpdateSubstituteStatus records the answer to a proposal. Only a proposed
+// assignment can be answered, so a declined teacher is not silently brought
+// back.
+func (r *leaveRepository) UpdateSubstituteStatus(ctx context.Context, organizationID string, leaveID primitive.ObjectID, substituteID primitive.ObjectID, status string, respondedBy string) (*LeaveRequests, error) {
+
+	filter := bson.M{
+		"_id":             leaveID,
+		"organization_id": organizationID,
+		"substitutes": bson.M{"$elemMatch": bson.M{
+			"_id":    substituteID,
+			"status": SubstituteProposed,
+		}},
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"substitutes.$.status":       status,
+			"substitutes.$.responded_by": respondedBy,
+			"substitutes.$.responded_at": time.Now(),
+		},
+	}
+
+	var leaveItem LeaveRequests
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := r.collectionLeave.FindOneAndUpdate(ctx, filter, update, opts).Decode(&leaveItem)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("no proposed substitute found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &leaveItem, nil
+
+}
+
+func (r *leaveRepository) RemoveSubstitute(ctx context.Context, organizationID string, leaveID primitive.ObjectID, substituteID primitive.ObjectID) (*LeaveRequests, error) {
+
+	filter := bson.M{
+		"_id":             leaveID,
+		"organization_id": organizationID,
+		"substitutes._id": substituteID,
+	}
+
+	update := bson.M{
+		"$pull": bson.M{"substitutes": bson.M{"_id": substituteID}},
+	}
+
+	var leaveItem LeaveRequests
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	err := r.collectionLeave.FindOneAndUpdate(ctx, filter, update, opts).Decode(&leaveItem)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("substitute not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &leaveItem, nil
+
+}
+
+func (r *leaveRepository) GetLeavesRequiringCover(ctx context.Context, organizationID string, dateFrom time.Time, dateTo time.Time) ([]*LeaveRequests, error) {
+
+	var leaveRequests []*LeaveRequests
+
+	dateRange := bson.M{"$gte": dateFrom, "$lte": dateTo}
+
+	filter := bson.M{
+		"organization_id": organizationID,
+		"status":          "confirmed",
+		"requires_cover":  true,
+		"$or": []bson.M{
+			{"leave_dates": dateRange},
+			{"leave_date": dateRange},
+		},
+	}
+
+	findOptions := options.Find()
+	findOptions.SetSort(bson.D{{Key: "leave_date", Value: 1}})
+
+	cursor, err := r.collectionLeave.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	err = cursor.All(ctx, &leaveRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	return leaveRequests, nil
+
+}
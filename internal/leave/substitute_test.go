@@ -0,0 +1,132 @@
+package leave
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"worktime-service/internal/user"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestLeaveCoverage(t *testing.T) {
+
+	monday, tuesday := parseDay("2030-03-04"), parseDay("2030-03-05")
+
+	cover := func(date time.Time, status string) Substitute {
+		return Substitute{ID: primitive.NewObjectID(), Date: date, TeacherID: "sub", Status: status}
+	}
+
+	tests := []struct {
+		name          string
+		requiresCover bool
+		substitutes   []Substitute
+		want          string
+		wantUncovered int
+	}{
+		{name: "no cover asked for", want: CoverageNotRequired, wantUncovered: 2},
+		{name: "nobody found yet", requiresCover: true, want: CoverageUncovered, wantUncovered: 2},
+		{name: "one day covered", requiresCover: true, substitutes: []Substitute{cover(monday, SubstituteAccepted)}, want: CoveragePartial, wantUncovered: 1},
+		{name: "proposal counts until declined", requiresCover: true, substitutes: []Substitute{cover(monday, SubstituteProposed), cover(tuesday, SubstituteAccepted)}, want: CoverageCovered},
+		{name: "declined proposal leaves the day open", requiresCover: true, substitutes: []Substitute{cover(monday, SubstituteDeclined), cover(tuesday, SubstituteAccepted)}, want: CoveragePartial, wantUncovered: 1},
+		{name: "cover offered without being asked", substitutes: []Substitute{cover(tuesday, SubstituteAccepted)}, want: CoveragePartial, wantUncovered: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			leaveItem := LeaveRequests{
+				LeaveDates:    []time.Time{monday, tuesday},
+				RequiresCover: tt.requiresCover,
+				Substitutes:   tt.substitutes,
+			}
+
+			coverage := leaveItem.coverage()
+
+			if coverage.Status != tt.want {
+				t.Errorf("status = %s, want %s", coverage.Status, tt.want)
+			}
+			if len(coverage.UncoveredDates) != tt.wantUncovered {
+				t.Errorf("uncovered days = %v, want %d", coverage.UncoveredDates, tt.wantUncovered)
+			}
+
+		})
+	}
+
+}
+
+func TestProposeSubstitute(t *testing.T) {
+
+	day := parseDay("2030-03-04")
+
+	absent := &LeaveRequests{
+		ID: primitive.NewObjectID(), OrganizationID: "org-1", UserID: "absent",
+		LeaveDates: []time.Time{day}, Status: "confirmed", RequiresCover: true,
+	}
+	waiting := &LeaveRequests{
+		ID: primitive.NewObjectID(), OrganizationID: "org-1", UserID: "waiting",
+		LeaveDates: []time.Time{day}, Status: "pending",
+	}
+	alsoAbsent := &LeaveRequests{
+		ID: primitive.NewObjectID(), OrganizationID: "org-1", UserID: "also-absent",
+		LeaveDates: []time.Time{day}, Status: "confirmed",
+		Substitutes: []Substitute{{ID: primitive.NewObjectID(), Date: day, TeacherID: "busy", Status: SubstituteAccepted}},
+	}
+
+	tests := []struct {
+		name    string
+		leave   *LeaveRequests
+		teacher string
+		date    string
+		wantErr string
+	}{
+		{name: "free teacher is proposed", leave: absent, teacher: "free", date: "2030-03-04"},
+		{name: "pending leave needs no cover yet", leave: waiting, teacher: "free", date: "2030-03-04", wantErr: "only cover confirmed leave"},
+		{name: "day outside the leave", leave: absent, teacher: "free", date: "2030-03-05", wantErr: "not a day of this leave"},
+		{name: "teacher on leave themselves", leave: absent, teacher: "also-absent", date: "2030-03-04", wantErr: "on leave"},
+		{name: "teacher covering someone else", leave: absent, teacher: "busy", date: "2030-03-04", wantErr: "already covering"},
+		{name: "absent teacher cannot cover", leave: absent, teacher: "absent", date: "2030-03-04", wantErr: "their own leave"},
+		{name: "user who is no teacher", leave: absent, teacher: "parent", date: "2030-03-04", wantErr: "not a teacher"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			absent.Substitutes = nil
+
+			service := &leaveService{
+				leaveRepository: &fakeLeaveRepository{leaves: map[primitive.ObjectID]*LeaveRequests{
+					absent.ID:     absent,
+					waiting.ID:    waiting,
+					alsoAbsent.ID: alsoAbsent,
+				}},
+				userService: &fakeUserService{
+					currentUser: &user.CurrentUser{ID: "head", OrganizationIdActive: "org-1", IsSuperAdmin: true},
+					nonTeachers: []string{"parent"},
+				},
+			}
+
+			got, err := service.ProposeSubstitute(context.Background(), &SubstituteRequest{TeacherID: tt.teacher, Date: tt.date, AssignedBy: "head"}, tt.leave.ID.Hex())
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one about %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProposeSubstitute: %v", err)
+			}
+
+			if got.Coverage.Status != CoverageCovered {
+				t.Errorf("coverage = %s, want %s", got.Coverage.Status, CoverageCovered)
+			}
+			if len(got.Substitutes) != 1 || got.Substitutes[0].Status != SubstituteProposed {
+				t.Errorf("substitutes = %+v, want one proposal", got.Substitutes)
+			}
+
+		})
+	}
+
+}
@@ -8,3 +8,21 @@ import (
 type AttendanceRepository interface {
 	GetStudentAttendanceInDateRange(c context.Context, studentID string, startDate time.Time, endDate time.Time) ([]*AttendanceStudent, error)
 }
+
+// HolidayCalendar resolves an organization's public holidays, keyed by
+// "2006-01-02", so attendance and leave can skip them.
+type HolidayCalendar interface {
+	GetHolidaysInRange(ctx context.Context, organizationID string, startDate time.Time, endDate time.Time) (map[string]string, error)
+}
+
+// HolidayListener is told when an organization's holidays change, so leave
+// can close or reopen the days it already tracks.
+type HolidayListener interface {
+	SyncHolidaySlots(ctx context.Context, organizationID string) error
+}
+
+// OvertimeSource reads the days a user worked beyond StandardWorkingHours
+// from daily attendance, so leave can turn them into comp-off credits.
+type OvertimeSource interface {
+	GetOvertimeDays(ctx context.Context, userID string, startDate time.Time, endDate time.Time) ([]*OvertimeDay, error)
+}
@@ -20,3 +20,15 @@ type AttendanceStudent struct {
 	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
 	UpdatedAt    time.Time          `json:"updated_at" bson:"updated_at"`
 }
+
+// StandardWorkingHours is a full working day; check-out reports the share
+// of it worked, and anything beyond it is overtime.
+const StandardWorkingHours = 8.0
+
+type OvertimeDay struct {
+	AttendanceID      primitive.ObjectID `json:"attendance_id"`
+	UserID            string             `json:"user_id"`
+	Date              time.Time          `json:"date"`
+	TotalWorkingHours float64            `json:"total_working_hours"`
+	OvertimeHours     float64            `json:"overtime_hours"`
+}